@@ -1,16 +1,182 @@
 package types
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 )
 
+const (
+	MB = 1024 * 1024
+	KB = 1024
+	GB = 1024 * MB
+	TB = 1024 * GB
+
+	BandwidthLimitModeClient = "client"
+	BandwidthLimitModeServer = "server"
+)
+
 type BandwidthWithQuantity struct {
 	s string // MB or KB
 	i int64  // bytes
 }
 
+func NewBandwidthQuantity(s string) (BandwidthWithQuantity, error) {
+	q := BandwidthWithQuantity{}
+	err := q.UnmarshalString(s)
+	if err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+func (q *BandwidthWithQuantity) Equal(u *BandwidthWithQuantity) bool {
+	if q == nil && u == nil {
+		return true
+	}
+	if q != nil && u != nil {
+		return q.i == u.i
+	}
+	return false
+}
+
+func (q *BandwidthWithQuantity) String() string {
+	return q.s
+}
+
+func (q *BandwidthWithQuantity) UnmarshalString(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+
+	var (
+		base int64
+		f    float64
+		err  error
+	)
+	switch {
+	case strings.HasSuffix(s, "MB"):
+		base = MB
+		fstr := strings.TrimSuffix(s, "MB")
+		f, err = strconv.ParseFloat(fstr, 64)
+		if err != nil {
+			return err
+		}
+	case strings.HasSuffix(s, "KB"):
+		base = KB
+		fstr := strings.TrimSuffix(s, "KB")
+		f, err = strconv.ParseFloat(fstr, 64)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("unit not support")
+	}
+
+	q.s = s
+	q.i = int64(f * float64(base))
+	return nil
+}
+
+func (q *BandwidthWithQuantity) UnmarshalJSON(b []byte) error {
+	if len(b) == 4 && string(b) == "null" {
+		return nil
+	}
+
+	var str string
+	err := json.Unmarshal(b, &str)
+	if err != nil {
+		return err
+	}
+	return q.UnmarshalString(str)
+}
+
+func (q *BandwidthWithQuantity) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + q.s + "\""), nil
+}
+
+// Bytes 返回每秒的字节数。
+func (q *BandwidthWithQuantity) Bytes() int64 {
+	return q.i
+}
+
+// TrafficQuantity 表示一段流量的大小，例如 "500MB"、"10GB"、"1TB"。
+type TrafficQuantity struct {
+	s string
+	i int64 // bytes
+}
+
+func NewTrafficQuantity(s string) (TrafficQuantity, error) {
+	q := TrafficQuantity{}
+	err := q.UnmarshalString(s)
+	if err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+func (q *TrafficQuantity) String() string {
+	return q.s
+}
+
+func (q *TrafficQuantity) UnmarshalString(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+
+	units := []struct {
+		suffix string
+		base   int64
+	}{
+		{"TB", TB},
+		{"GB", GB},
+		{"MB", MB},
+		{"KB", KB},
+	}
+	for _, u := range units {
+		if !strings.HasSuffix(s, u.suffix) {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSuffix(s, u.suffix), 64)
+		if err != nil {
+			return err
+		}
+		if f < 0 {
+			return fmt.Errorf("traffic quantity %s must not be negative", s)
+		}
+		q.s = s
+		q.i = int64(f * float64(u.base))
+		return nil
+	}
+	return errors.New("unit not support")
+}
+
+func (q *TrafficQuantity) UnmarshalJSON(b []byte) error {
+	if len(b) == 4 && string(b) == "null" {
+		return nil
+	}
+
+	var str string
+	err := json.Unmarshal(b, &str)
+	if err != nil {
+		return err
+	}
+	return q.UnmarshalString(str)
+}
+
+func (q *TrafficQuantity) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + q.s + "\""), nil
+}
+
+// Bytes 返回流量的字节数，0 表示不限制。
+func (q *TrafficQuantity) Bytes() int64 {
+	return q.i
+}
+
 type PortsRange struct {
 	Start  int `json:"start,omitempty"`
 	End    int `json:"end,omitempty"`
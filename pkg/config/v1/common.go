@@ -94,15 +94,142 @@ func (c *LogConfig) Complete() {
 	c.MaxDays = util.EmptyOr(c.MaxDays, 3)
 }
 
+const (
+	PluginFailurePolicyFailClosed = "fail-closed"
+	PluginFailurePolicyFailOpen   = "fail-open"
+)
+
 type HTTPPluginOptions struct {
 	Name      string   `json:"name"`
 	Addr      string   `json:"addr"`
 	Path      string   `json:"path"`
 	Ops       []string `json:"ops"`
 	TLSVerify bool     `json:"tlsVerify,omitempty"`
+	// Priority 指定插件的调用优先级，优先级高的插件先被调用，优先级相同的插件按配置顺序调用。
+	Priority int `json:"priority,omitempty"`
+	// TimeoutMilliseconds 指定每次请求的超时时间（以毫秒为单位）。如果此值为 0，则不限制。
+	TimeoutMilliseconds int64 `json:"timeoutMilliseconds,omitempty"`
+	// MaxRetries 指定请求失败后的最大重试次数。默认情况下，此值为 0。
+	MaxRetries int `json:"maxRetries,omitempty"`
+	// RetryBackoffMilliseconds 指定第一次重试前的等待时间（以毫秒为单位），之后每次重试翻倍。
+	// 默认情况下，此值为 100。
+	RetryBackoffMilliseconds int64 `json:"retryBackoffMilliseconds,omitempty"`
+	// FailurePolicy 指定插件最终失败时的处理方式。有效值为 "fail-closed" 和 "fail-open"。
+	// "fail-closed" 会拒绝请求，"fail-open" 会忽略该插件并放行请求。默认情况下，此值为 "fail-closed"。
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+	// 如果 CircuitBreaker 不是 nil，则在连续失败后暂停调用该插件。
+	CircuitBreaker *CircuitBreakerConfig `json:"circuitBreaker,omitempty"`
+}
+
+func (c *HTTPPluginOptions) Complete() {
+	c.RetryBackoffMilliseconds = util.EmptyOr(c.RetryBackoffMilliseconds, 100)
+	c.FailurePolicy = util.EmptyOr(c.FailurePolicy, PluginFailurePolicyFailClosed)
+	if c.CircuitBreaker != nil {
+		c.CircuitBreaker.Complete()
+	}
+}
+
+type CircuitBreakerConfig struct {
+	// FailureThreshold 指定断路器打开前允许的连续失败次数。默认情况下，此值为 5。
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+	// OpenSeconds 指定断路器打开后经过多少秒放行一次试探请求。默认情况下，此值为 30。
+	OpenSeconds int64 `json:"openSeconds,omitempty"`
+}
+
+func (c *CircuitBreakerConfig) Complete() {
+	c.FailureThreshold = util.EmptyOr(c.FailureThreshold, 5)
+	c.OpenSeconds = util.EmptyOr(c.OpenSeconds, 30)
+}
+
+type GRPCPluginOptions struct {
+	Name string `json:"name"`
+	// Addr 指定插件 gRPC 服务的地址，例如 "127.0.0.1:9001" 或 "unix:///var/run/frp-plugin.sock"。
+	Addr string   `json:"addr"`
+	Ops  []string `json:"ops"`
+	// Priority 指定插件的调用优先级，与 HTTPPluginOptions 中的含义相同。
+	Priority int `json:"priority,omitempty"`
+	// 如果 TLS 不是 nil，则使用 TLS 连接插件服务。
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+type WASMPluginOptions struct {
+	Name string `json:"name"`
+	// Path 指定 WebAssembly 模块文件的路径。
+	Path string   `json:"path"`
+	Ops  []string `json:"ops"`
+	// Priority 指定插件的调用优先级，与 HTTPPluginOptions 中的含义相同。
+	Priority int `json:"priority,omitempty"`
+	// MemoryLimitMB 指定每个模块实例可使用的最大内存（以 MB 为单位）。默认情况下，此值为 16。
+	MemoryLimitMB int `json:"memoryLimitMB,omitempty"`
+	// TimeoutMilliseconds 指定每次调用的超时时间（以毫秒为单位）。默认情况下，此值为 100。
+	TimeoutMilliseconds int64 `json:"timeoutMilliseconds,omitempty"`
+	// ReloadInterval 指定检查模块文件是否变化的间隔（以秒为单位），文件变化后将重新加载模块。
+	// 如果此值为负数，则禁用热加载。默认情况下，此值为 5。
+	ReloadInterval int64 `json:"reloadInterval,omitempty"`
+}
+
+func (c *WASMPluginOptions) Complete() {
+	c.MemoryLimitMB = util.EmptyOr(c.MemoryLimitMB, 16)
+	c.TimeoutMilliseconds = util.EmptyOr(c.TimeoutMilliseconds, 100)
+	c.ReloadInterval = util.EmptyOr(c.ReloadInterval, 5)
+}
+
+const (
+	RuleActionReject = "reject"
+	RuleActionAllow  = "allow"
+	RuleActionSet    = "set"
+)
+
+// RulePluginOptions 是内置的规则插件，在 frps 进程内对操作内容求值表达式，不需要部署额外的插件服务。
+type RulePluginOptions struct {
+	Name string   `json:"name"`
+	Ops  []string `json:"ops"`
+	// Priority 指定插件的调用优先级，与 HTTPPluginOptions 中的含义相同。
+	Priority int `json:"priority,omitempty"`
+	// Rules 按顺序求值，命中 "reject" 或 "allow" 规则后停止。
+	Rules []RuleConfig `json:"rules"`
+}
+
+func (c *RulePluginOptions) Complete() {
+	for i := range c.Rules {
+		c.Rules[i].Complete()
+	}
+}
+
+// RuleConfig 中的表达式可以使用变量 op（操作名称）和 content（操作内容），
+// content 的字段与 HTTP 插件请求体中的 content 相同，例如 content.user.user == "guest" && content.proxy_type == "tcp"。
+type RuleConfig struct {
+	// Op 指定规则生效的操作，为空时对插件的所有操作生效。
+	Op string `json:"op,omitempty"`
+	// When 是布尔表达式，为空时规则总是命中。
+	When string `json:"when,omitempty"`
+	// Action 指定命中后的动作，可选值为 "reject"、"allow" 和 "set"。默认情况下，此值为 "reject"。
+	Action string `json:"action,omitempty"`
+	// RejectReason 是 Action 为 "reject" 时返回给客户端的原因。
+	RejectReason string `json:"rejectReason,omitempty"`
+	// Set 是 Action 为 "set" 时要修改的字段，键为以 "." 分隔的字段路径，例如 "metas.region"，值为表达式。
+	Set map[string]string `json:"set,omitempty"`
+}
+
+func (c *RuleConfig) Complete() {
+	c.Action = util.EmptyOr(c.Action, RuleActionReject)
 }
 
 type HTTPHeader struct {
 	Name  string `json:"name"`
 	Value string `json:"value"`
 }
+
+// IPACLConfig 是来源 IP 的允许和拒绝列表，元素为 CIDR 或单个 IP，例如 "10.0.0.0/8" 和 "192.168.1.1"。
+// 拒绝列表优先于允许列表，允许列表为空时允许所有未被拒绝的 IP。
+type IPACLConfig struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// CountryACLConfig 是来源国家的允许和拒绝列表，元素为 ISO 3166-1 两位国家代码，例如 "CN" 和 "US"。
+// 拒绝列表优先于允许列表，允许列表为空时允许所有未被拒绝的国家；允许列表不为空时，查不到国家的地址会被拒绝。
+type CountryACLConfig struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
@@ -1,8 +1,23 @@
 package v1
 
 import (
+	"fmt"
+	"github.com/samber/lo"
 	"github.com/sunyihoo/frp/pkg/config/types"
 	"github.com/sunyihoo/frp/pkg/msg"
+	"github.com/sunyihoo/frp/pkg/util/util"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type ProxyType string
+
+const (
+	ProxyTypeTCP   ProxyType = "tcp"
+	ProxyTypeHTTP  ProxyType = "http"
+	ProxyTypeHTTPS ProxyType = "https"
+	ProxyTypeSTCP  ProxyType = "stcp"
 )
 
 type ProxyTransport struct {
@@ -22,12 +37,66 @@ type ProxyTransport struct {
 	ProxyProtocolVersion string `json:"proxyProtocolVersion,omitempty"`
 }
 
+type TCPMultiplexerType string
+
+const (
+	TCPMultiplexerHTTPConnect TCPMultiplexerType = "httpconnect"
+	// TCPMultiplexerSNI 按 TLS ClientHello 中的 SNI 路由
+	TCPMultiplexerSNI TCPMultiplexerType = "sni"
+	// TCPMultiplexerSOCKS5 按 SOCKS5 CONNECT 请求的目标主机名路由
+	TCPMultiplexerSOCKS5 TCPMultiplexerType = "socks5"
+)
+
+const (
+	LoadBalanceAlgorithmRoundRobin   = "roundRobin"
+	LoadBalanceAlgorithmLeastConn    = "leastConn"
+	LoadBalanceAlgorithmSourceIPHash = "sourceIPHash"
+	LoadBalanceAlgorithmFailover     = "failover"
+)
+
 type LoadBalanceConfig struct {
 	// Group 指定所属的组。服务器将使用此信息对同一组中的代理进行负载平衡。
 	// 如果值为 ""，则该值将不在组中。
 	Group string `json:"group"`
 	// GroupKey 指定一个组密钥，该密钥在同一组的代理之间应相同。
 	GroupKey string `json:"groupKey"`
+	// Algorithm 指定 TCP、TCPMux 和 UDP 组分配连接的算法，同一组的代理应相同。UDP 组按会话分配，
+	// "leastConn" 选择活动会话数最少的代理。
+	// 有效值包括 "roundRobin"、"leastConn"（活动连接数最少）和 "sourceIPHash"（按来源 IP 一致性哈希，
+	// 同一个来源 IP 的连接总是发往同一个代理，成员变化时只有少部分来源 IP 会改变代理）。默认为 "roundRobin"。
+	// "failover" 为主备模式，可用于 TCP、TCPMux 和 HTTP 组，所有流量都发往 Priority 最高的存活代理，
+	// 它离线后切换到下一个。HTTP 组的其他值都按 Weight 分配。
+	Algorithm string `json:"algorithm,omitempty"`
+	// Priority 指定代理在主备模式中的优先级，值越大越优先，优先级相同时先加入的代理优先。
+	Priority int `json:"priority,omitempty"`
+	// FailbackDelaySeconds 指定主备模式中更高优先级的代理恢复后，等待多少秒再切换回去。
+	// 0 表示立即切换，小于 0 表示不自动切换回去，同一组的代理应相同。
+	FailbackDelaySeconds int `json:"failbackDelaySeconds,omitempty"`
+	// SessionIdleTimeoutSeconds 指定 UDP 组中会话的空闲超时时间，会话期间同一来源地址的数据包都发往同一个代理。
+	// 默认为 60，同一组的代理应相同。
+	SessionIdleTimeoutSeconds int `json:"sessionIdleTimeoutSeconds,omitempty"`
+	// Weight 指定代理在 HTTP 组中的权重，组内的请求按权重比例分配，可用于灰度发布。
+	// 0 表示使用默认值 1。
+	Weight int `json:"weight,omitempty"`
+	// PinHeader 和 PinCookie 指定请求头和 cookie 的名称，请求中带有它们时，其值为组内的代理名称，
+	// 请求将固定发往该代理而不按权重分配。请求头优先于 cookie。仅对 HTTP 组有效，同一组的代理应相同。
+	PinHeader string `json:"pinHeader,omitempty"`
+	PinCookie string `json:"pinCookie,omitempty"`
+	// StickySession 为 HTTP 组启用会话保持，同一组的代理应相同。
+	StickySession StickySessionConfig `json:"stickySession,omitempty"`
+	// HealthCheck 由 frps 通过工作连接检查组内代理的后端，连续失败 MaxFailed 次后暂时不再向该代理分配连接和请求，
+	// 检查成功后恢复。仅对 TCP、TCPMux 和 HTTP 组有效，与客户端的健康检查相互独立。
+	HealthCheck HealthCheckConfig `json:"healthCheck,omitempty"`
+}
+
+type StickySessionConfig struct {
+	// Enable 为 true 时，frps 在响应中设置签名的 cookie 记录选中的代理，之后带有该 cookie 的请求都发往同一个代理，
+	// 直到 cookie 过期或该代理离开组。
+	Enable bool `json:"enable,omitempty"`
+	// CookieName 默认为 "frp_sticky"。
+	CookieName string `json:"cookieName,omitempty"`
+	// TTLSeconds 是 cookie 的有效期，默认为 3600。
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
 }
 
 type ProxyBackend struct {
@@ -68,6 +137,35 @@ type HealthCheckConfig struct {
 	HTTPHeaders []HTTPHeader `json:"HTTPHeaders,omitempty"`
 }
 
+// RequestRateLimitConfig 是令牌桶请求限速，每个路由上的每个来源 IP 使用独立的令牌桶。
+type RequestRateLimitConfig struct {
+	// RequestsPerSecond 指定每秒补充的令牌数，即长期允许的平均请求速率。如果此值为 0，则不限速。
+	RequestsPerSecond float64 `json:"requestsPerSecond,omitempty"`
+	// Burst 指定令牌桶的容量，即允许的突发请求数。如果此值为 0，则使用 RequestsPerSecond 向上取整。
+	Burst int `json:"burst,omitempty"`
+}
+
+// HTTPOIDCConfig 限制可以访问 http 代理的用户，满足任意一个条件即可，所有列表都为空时允许所有登录的用户。
+// 登录用户的信息通过 X-Forwarded-User、X-Forwarded-Email 和 X-Forwarded-Groups 请求头传递给后端。
+type HTTPOIDCConfig struct {
+	Enable              bool     `json:"enable,omitempty"`
+	AllowedEmailDomains []string `json:"allowedEmailDomains,omitempty"`
+	AllowedEmails       []string `json:"allowedEmails,omitempty"`
+	AllowedGroups       []string `json:"allowedGroups,omitempty"`
+}
+
+// HTTPRouteMatch 是 http 代理的路由匹配条件。
+type HTTPRouteMatch struct {
+	// Type 为 "header"、"query" 或 "method"。
+	Type string `json:"type"`
+	// Name 是请求头或查询参数的名称，Type 为 "method" 时忽略。
+	Name string `json:"name,omitempty"`
+	// Value 是需要完全相等的值，Regex 为 true 时是正则表达式。
+	// 两个正则表达式条件总是被视为可能匹配同一个请求。
+	Value string `json:"value,omitempty"`
+	Regex bool   `json:"regex,omitempty"`
+}
+
 type ProxyBaseConfig struct {
 	Name        string            `json:"name"`
 	Type        string            `json:"type"`
@@ -77,11 +175,171 @@ type ProxyBaseConfig struct {
 	Metadatas    map[string]string `json:"metadatas,omitempty"`
 	LoadBalancer LoadBalanceConfig `json:"loadBalancer,omitempty"`
 	HealthCheck  HealthCheckConfig `json:"healthCheck,omitempty"`
+	// SourceIPACL 限制可以访问该代理的来源 IP，对 tcp、udp、http、https 和 tcpmux 代理有效。
+	// frps 的 proxySourceIPACL 总是先于此配置检查，此配置只能进一步收紧访问范围。
+	SourceIPACL IPACLConfig `json:"sourceIPACL,omitempty"`
+	// CountryACL 按来源 IP 所属国家限制访问该代理，需要 frps 配置 geoIP.dbFile，frps 的 proxyCountryACL 总是先检查。
+	CountryACL CountryACLConfig `json:"countryACL,omitempty"`
+	// RateLimit 按来源 IP 限制 http 代理每个路由的请求速率，frps 的 vhostHTTPRateLimits 是它的上限。
+	RateLimit RequestRateLimitConfig `json:"rateLimit,omitempty"`
+	// OIDC 要求 http 代理的用户先通过 frps 配置的 vhostHTTPOIDC 身份提供方登录。
+	OIDC HTTPOIDCConfig `json:"oidc,omitempty"`
+	// HTPasswd 引用 frps htpasswd.files 中的一个文件，http 代理使用其中的用户进行 Basic 认证，httpUser 和 httpPassword 不再生效。
+	// 认证通过的用户名可以与 routeByHTTPUser 一起使用，并通过 X-Forwarded-User 请求头传递给后端。
+	HTPasswd string `json:"htpasswd,omitempty"`
+	// ErrorPages 引用 frps vhostHTTPErrorPages.templates 中的一组模板，http 代理的错误页面优先使用这组模板。
+	ErrorPages string `json:"errorPages,omitempty"`
+	// BackendHTTP2 为 true 时，frps 以 h2c（明文 HTTP/2）的方式向 http 代理的后端发送请求，用于 gRPC 等需要 HTTP/2 的服务。
+	// 用户使用 HTTP/1.1 或 HTTP/2 访问都可以。
+	BackendHTTP2 bool `json:"backendHTTP2,omitempty"`
+	// RouteMatches 是 http 代理在 customDomains、locations 和 routeByHTTPUser 之外的匹配条件，全部满足时才使用此代理。
+	// 同一个 location 上可以有多个带匹配条件的代理，它们必须使用不同的 RoutePriority 或不可能匹配同一个请求。
+	RouteMatches []HTTPRouteMatch `json:"routeMatches,omitempty"`
+	// RoutePriority 是带匹配条件的代理在同一个 location 上的优先级，值越大越先匹配。
+	RoutePriority int `json:"routePriority,omitempty"`
 	ProxyBackend
 }
 
+func (c *ProxyBaseConfig) GetBaseConfig() *ProxyBaseConfig {
+	return c
+}
+
+func (c *ProxyBaseConfig) Complete(namePrefix string) {
+	c.Name = lo.Ternary(namePrefix == "", "", namePrefix+".") + c.Name
+	c.LocalIP = util.EmptyOr(c.LocalIP, "127.0.0.1")
+	c.Transport.BandwidthLimitMode = util.EmptyOr(c.Transport.BandwidthLimitMode, types.BandwidthLimitModeClient)
+}
+
+func (c *ProxyBaseConfig) MarshalToMsg(m *msg.NewProxy) {
+	m.ProxyName = c.Name
+	m.ProxyType = c.Type
+	m.UserEncryption = c.Transport.UseEncryption
+	m.UseCompression = c.Transport.UseCompression
+	m.BandWidthLimit = c.Transport.BandwidthLimit.String()
+	// 在客户端限速时 frps 不需要知道限速方式
+	if c.Transport.BandwidthLimitMode != types.BandwidthLimitModeClient {
+		m.BandWidthLimitMode = c.Transport.BandwidthLimitMode
+	}
+
+	lb := c.LoadBalancer
+	m.Group = lb.Group
+	m.GroupKey = lb.GroupKey
+	m.GroupAlgorithm = lb.Algorithm
+	m.GroupIdleTimeout = lb.SessionIdleTimeoutSeconds
+	m.GroupPriority = lb.Priority
+	m.GroupFailbackDelay = lb.FailbackDelaySeconds
+	m.GroupWeight = lb.Weight
+	m.GroupPinHeader = lb.PinHeader
+	m.GroupPinCookie = lb.PinCookie
+	m.GroupSticky = lb.StickySession.Enable
+	m.GroupStickyCookie = lb.StickySession.CookieName
+	m.GroupStickyTTL = lb.StickySession.TTLSeconds
+	if lb.HealthCheck.Type != "" {
+		hc := &msg.GroupHealthCheck{
+			Type:            lb.HealthCheck.Type,
+			TimeoutSeconds:  lb.HealthCheck.TimeoutSeconds,
+			MaxFailed:       lb.HealthCheck.MaxFailed,
+			IntervalSeconds: lb.HealthCheck.IntervalSeconds,
+			Path:            lb.HealthCheck.Path,
+		}
+		for _, h := range lb.HealthCheck.HTTPHeaders {
+			if hc.Headers == nil {
+				hc.Headers = make(map[string]string)
+			}
+			hc.Headers[h.Name] = h.Value
+		}
+		m.GroupHealthCheck = hc
+	}
+
+	m.Metas = c.Metadatas
+	m.Annotations = c.Annotations
+	m.AllowSourceIPs = c.SourceIPACL.Allow
+	m.DenySourceIPs = c.SourceIPACL.Deny
+	m.AllowCountries = c.CountryACL.Allow
+	m.DenyCountries = c.CountryACL.Deny
+	m.RateLimitRPS = c.RateLimit.RequestsPerSecond
+	m.RateLimitBurst = c.RateLimit.Burst
+	if c.OIDC.Enable {
+		m.OIDC = &msg.HTTPOIDC{
+			AllowedEmailDomains: c.OIDC.AllowedEmailDomains,
+			AllowedEmails:       c.OIDC.AllowedEmails,
+			AllowedGroups:       c.OIDC.AllowedGroups,
+		}
+	}
+	m.HTPasswd = c.HTPasswd
+	m.ErrorPages = c.ErrorPages
+	m.BackendHTTP2 = c.BackendHTTP2
+	for _, rm := range c.RouteMatches {
+		m.RouteMatches = append(m.RouteMatches, msg.HTTPRouteMatch{Type: rm.Type, Name: rm.Name, Value: rm.Value, Regex: rm.Regex})
+	}
+	m.RoutePriority = c.RoutePriority
+}
+
+func (c *ProxyBaseConfig) UnmarshalFromMsg(m *msg.NewProxy) {
+	c.Name = m.ProxyName
+	c.Type = m.ProxyType
+	c.Transport.UseEncryption = m.UserEncryption
+	c.Transport.UseCompression = m.UseCompression
+	if m.BandWidthLimit != "" {
+		c.Transport.BandwidthLimit, _ = types.NewBandwidthQuantity(m.BandWidthLimit)
+	}
+	if m.BandWidthLimitMode != "" {
+		c.Transport.BandwidthLimitMode = m.BandWidthLimitMode
+	}
+
+	c.LoadBalancer = LoadBalanceConfig{
+		Group:                     m.Group,
+		GroupKey:                  m.GroupKey,
+		Algorithm:                 m.GroupAlgorithm,
+		Priority:                  m.GroupPriority,
+		FailbackDelaySeconds:      m.GroupFailbackDelay,
+		SessionIdleTimeoutSeconds: m.GroupIdleTimeout,
+		Weight:                    m.GroupWeight,
+		PinHeader:                 m.GroupPinHeader,
+		PinCookie:                 m.GroupPinCookie,
+		StickySession: StickySessionConfig{
+			Enable:     m.GroupSticky,
+			CookieName: m.GroupStickyCookie,
+			TTLSeconds: m.GroupStickyTTL,
+		},
+	}
+	if hc := m.GroupHealthCheck; hc != nil {
+		c.LoadBalancer.HealthCheck = HealthCheckConfig{
+			Type:            hc.Type,
+			TimeoutSeconds:  hc.TimeoutSeconds,
+			MaxFailed:       hc.MaxFailed,
+			IntervalSeconds: hc.IntervalSeconds,
+			Path:            hc.Path,
+		}
+		for k, v := range hc.Headers {
+			c.LoadBalancer.HealthCheck.HTTPHeaders = append(c.LoadBalancer.HealthCheck.HTTPHeaders, HTTPHeader{Name: k, Value: v})
+		}
+	}
+
+	c.Metadatas = m.Metas
+	c.Annotations = m.Annotations
+	c.SourceIPACL = IPACLConfig{Allow: m.AllowSourceIPs, Deny: m.DenySourceIPs}
+	c.CountryACL = CountryACLConfig{Allow: m.AllowCountries, Deny: m.DenyCountries}
+	c.RateLimit = RequestRateLimitConfig{RequestsPerSecond: m.RateLimitRPS, Burst: m.RateLimitBurst}
+	if m.OIDC != nil {
+		c.OIDC = HTTPOIDCConfig{
+			Enable:              true,
+			AllowedEmailDomains: m.OIDC.AllowedEmailDomains,
+			AllowedEmails:       m.OIDC.AllowedEmails,
+			AllowedGroups:       m.OIDC.AllowedGroups,
+		}
+	}
+	c.HTPasswd = m.HTPasswd
+	c.ErrorPages = m.ErrorPages
+	c.BackendHTTP2 = m.BackendHTTP2
+	for _, rm := range m.RouteMatches {
+		c.RouteMatches = append(c.RouteMatches, HTTPRouteMatch{Type: rm.Type, Name: rm.Name, Value: rm.Value, Regex: rm.Regex})
+	}
+	c.RoutePriority = m.RoutePriority
+}
+
 type ProxyConfigurer interface {
-	Complete() (namePrefix string)
+	Complete(namePrefix string)
 	GetBaseConfig() *ProxyBaseConfig
 	// MarshalToMsg 将此配置序列化成 msg.NewProxy 消息。
 	// 此函数将在 frpc 端调用。
@@ -90,3 +348,151 @@ type ProxyConfigurer interface {
 	// 此函数将在 frps 端调用。
 	UnmarshalFromMsg(*msg.NewProxy)
 }
+
+var proxyConfigTypeMap = map[ProxyType]reflect.Type{
+	ProxyTypeTCP:   reflect.TypeOf(TCPProxyConfig{}),
+	ProxyTypeHTTP:  reflect.TypeOf(HTTPProxyConfig{}),
+	ProxyTypeHTTPS: reflect.TypeOf(HTTPSProxyConfig{}),
+	ProxyTypeSTCP:  reflect.TypeOf(STCPProxyConfig{}),
+}
+
+func NewProxyConfigurerByType(proxyType ProxyType) ProxyConfigurer {
+	v, ok := proxyConfigTypeMap[proxyType]
+	if !ok {
+		return nil
+	}
+	pc := reflect.New(v).Interface().(ProxyConfigurer)
+	pc.GetBaseConfig().Type = string(proxyType)
+	return pc
+}
+
+// NewProxyConfigurerFromMsg 在 frps 端根据客户端发送的 NewProxy 消息创建代理配置，未指定类型时为 tcp。
+func NewProxyConfigurerFromMsg(m *msg.NewProxy) (ProxyConfigurer, error) {
+	m.ProxyType = util.EmptyOr(m.ProxyType, string(ProxyTypeTCP))
+	configurer := NewProxyConfigurerByType(ProxyType(m.ProxyType))
+	if configurer == nil {
+		return nil, fmt.Errorf("unknown proxy type: %s", m.ProxyType)
+	}
+	configurer.UnmarshalFromMsg(m)
+	configurer.Complete("")
+	return configurer, nil
+}
+
+var _ ProxyConfigurer = &TCPProxyConfig{}
+
+type TCPProxyConfig struct {
+	ProxyBaseConfig
+
+	RemotePort int `json:"remotePort,omitempty"`
+}
+
+func (c *TCPProxyConfig) MarshalToMsg(m *msg.NewProxy) {
+	c.ProxyBaseConfig.MarshalToMsg(m)
+
+	m.RemotePort = strconv.Itoa(c.RemotePort)
+}
+
+func (c *TCPProxyConfig) UnmarshalFromMsg(m *msg.NewProxy) {
+	c.ProxyBaseConfig.UnmarshalFromMsg(m)
+
+	// RemotePort 为空或不是数字时按 0 处理，由 frps 分配端口
+	c.RemotePort, _ = strconv.Atoi(m.RemotePort)
+}
+
+type DomainConfig struct {
+	CustomDomains []string `json:"customDomains,omitempty"`
+	SubDomain     string   `json:"subdomain,omitempty"`
+}
+
+type HeaderOperations struct {
+	Set map[string]string `json:"set,omitempty"`
+}
+
+var _ ProxyConfigurer = &HTTPProxyConfig{}
+
+type HTTPProxyConfig struct {
+	ProxyBaseConfig
+	DomainConfig
+
+	Locations         []string         `json:"locations,omitempty"`
+	HTTPUser          string           `json:"httpUser,omitempty"`
+	HTTPPassword      string           `json:"httpPassword,omitempty"`
+	HostHeaderRewrite string           `json:"hostHeaderRewrite,omitempty"`
+	RequestHeaders    HeaderOperations `json:"requestHeaders,omitempty"`
+	ResponseHeaders   HeaderOperations `json:"responseHeaders,omitempty"`
+	RouteByHTTPUser   string           `json:"routeByHTTPUser,omitempty"`
+}
+
+func (c *HTTPProxyConfig) MarshalToMsg(m *msg.NewProxy) {
+	c.ProxyBaseConfig.MarshalToMsg(m)
+
+	m.CustomDomains = c.CustomDomains
+	m.SubDomain = c.SubDomain
+	m.Locations = strings.Join(c.Locations, ",")
+	m.HostHeaderRewrite = c.HostHeaderRewrite
+	m.HTTPUser = c.HTTPUser
+	m.HTTPPwd = c.HTTPPassword
+	m.Headers = c.RequestHeaders.Set
+	m.ResponseHeaders = c.ResponseHeaders.Set
+	m.RouteByHTTPUser = c.RouteByHTTPUser
+}
+
+func (c *HTTPProxyConfig) UnmarshalFromMsg(m *msg.NewProxy) {
+	c.ProxyBaseConfig.UnmarshalFromMsg(m)
+
+	c.CustomDomains = m.CustomDomains
+	c.SubDomain = m.SubDomain
+	if m.Locations != "" {
+		c.Locations = strings.Split(m.Locations, ",")
+	}
+	c.HostHeaderRewrite = m.HostHeaderRewrite
+	c.HTTPUser = m.HTTPUser
+	c.HTTPPassword = m.HTTPPwd
+	c.RequestHeaders.Set = m.Headers
+	c.ResponseHeaders.Set = m.ResponseHeaders
+	c.RouteByHTTPUser = m.RouteByHTTPUser
+}
+
+var _ ProxyConfigurer = &HTTPSProxyConfig{}
+
+type HTTPSProxyConfig struct {
+	ProxyBaseConfig
+	DomainConfig
+}
+
+func (c *HTTPSProxyConfig) MarshalToMsg(m *msg.NewProxy) {
+	c.ProxyBaseConfig.MarshalToMsg(m)
+
+	m.CustomDomains = c.CustomDomains
+	m.SubDomain = c.SubDomain
+}
+
+func (c *HTTPSProxyConfig) UnmarshalFromMsg(m *msg.NewProxy) {
+	c.ProxyBaseConfig.UnmarshalFromMsg(m)
+
+	c.CustomDomains = m.CustomDomains
+	c.SubDomain = m.SubDomain
+}
+
+var _ ProxyConfigurer = &STCPProxyConfig{}
+
+type STCPProxyConfig struct {
+	ProxyBaseConfig
+
+	Secretkey  string   `json:"secretKey,omitempty"`
+	AllowUsers []string `json:"allowUsers,omitempty"`
+}
+
+func (c *STCPProxyConfig) MarshalToMsg(m *msg.NewProxy) {
+	c.ProxyBaseConfig.MarshalToMsg(m)
+
+	m.Sk = c.Secretkey
+	m.AllowUsers = c.AllowUsers
+}
+
+func (c *STCPProxyConfig) UnmarshalFromMsg(m *msg.NewProxy) {
+	c.ProxyBaseConfig.UnmarshalFromMsg(m)
+
+	c.Secretkey = m.Sk
+	c.AllowUsers = m.AllowUsers
+}
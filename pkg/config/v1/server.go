@@ -4,6 +4,7 @@ import (
 	"github.com/samber/lo"
 	"github.com/sunyihoo/frp/pkg/config/types"
 	"github.com/sunyihoo/frp/pkg/util/util"
+	"path/filepath"
 )
 
 type ServerConfig struct {
@@ -22,19 +23,41 @@ type ServerConfig struct {
 	ProxyBindAddr string `json:"proxyBindAddr,omitempty"`
 	// VhostHTTPPort 指定服务器侦听HTTP Vhost请求的端口。如果此值为0，则服务器将不会侦听HTTP请求。
 	VhostHTTPPort int `json:"vhostHTTPPort,omitempty"`
+	// VhostHTTPRateLimits 按域名限制 vhost HTTP 请求的速率，同时是代理 rateLimit 的上限。
+	VhostHTTPRateLimits []VhostHTTPRateLimitConfig `json:"vhostHTTPRateLimits,omitempty"`
+	// VhostHTTPOIDC 指定 http 代理 OIDC 登录使用的身份提供方，代理通过 oidc.enable 启用登录。
+	VhostHTTPOIDC VhostHTTPOIDCConfig `json:"vhostHTTPOIDC,omitempty"`
+	// HTPasswd 指定 http 代理可以引用的 htpasswd 文件。
+	HTPasswd HTPasswdConfig `json:"htpasswd,omitempty"`
+	// VhostHTTPErrorPages 指定 vhost HTTP 请求的 404、502、503 和 504 错误页面模板，以及为哪些域名使用哪组模板。
+	VhostHTTPErrorPages VhostHTTPErrorPagesConfig `json:"vhostHTTPErrorPages,omitempty"`
 	// VhostHTTPTimeout 指定Vhost HTTP服务器的响应标头超时（以秒为单位）。默认情况下，此值为60。
 	VhostHTTPTimeout int64 `json:"vhostHTTPTimeout,omitempty"`
+	// EnableVhostHTTP2 指定是否在 VhostHTTPPort 上接受 h2c 请求，并在终止 TLS 时通过 ALPN 协商 HTTP/2。
+	// 只有开启了 backendHTTP2 的代理才会以 HTTP/2 的方式访问后端。
+	EnableVhostHTTP2 bool `json:"enableVhostHTTP2,omitempty"`
 	// VhostHTTPSPort 指定服务器侦听HTTPS Vhost请求的端口。如果此值为0，则服务器将不会侦听HTTPS请求。
 	VhostHTTPSPort int `json:"vhostHTTPSPort,omitempty"`
+	// VhostHTTPSTermination 指定 frps 为 https 代理终止 TLS 的方式。默认情况下，frps 只读取 SNI 并将 TLS 流量透传给客户端。
+	VhostHTTPSTermination HTTPSTerminationConfig `json:"vhostHTTPSTermination,omitempty"`
+	// ACME 指定为 vhost 域名自动申请和续期证书的方式，申请到的证书用于在 frps 上终止 TLS。
+	ACME ACMEConfig `json:"acme,omitempty"`
 	// TCPMuxHTTPConnectPort 指定服务器侦听TCP HTTP CONNECT请求的端口。
 	// 如果该值为0，服务器将不会在一个端口上多路传输TCP请求。如果不是，它将侦听该值以获取HTTP CONNECT请求。
 	TCPMuxHTTPConnectPort int `json:"tcpmuxHTTPConnectPort,omitempty"`
+	// TCPMuxSNIPort 指定服务器侦听 TLS 连接的端口，multiplexer 为 "sni" 的 tcpmux 代理按 SNI 共享该端口。
+	// 如果该值为0，则不会侦听。
+	TCPMuxSNIPort int `json:"tcpmuxSNIPort,omitempty"`
+	// TCPMuxSOCKS5Port 指定服务器侦听 SOCKS5 连接的端口，multiplexer 为 "socks5" 的 tcpmux 代理按 CONNECT 请求的目标主机名共享该端口。
+	// 如果该值为0，则不会侦听。
+	TCPMuxSOCKS5Port int `json:"tcpmuxSOCKS5Port,omitempty"`
 	// 如果 TCPMuxPassthrough 为true，则frps不会对流量进行任何更新。
 	TCPMuxPassthrough bool `json:"tcpmuxPassthrough,omitempty"`
 	// SubDomainHost 指定在使用Vhost代理时将附加到客户端请求的子域的域。
 	// 例如，如果此值设置为“frps.com”，并且客户端请求子域“test”，则生成的URL将为“test.frps.com”。
 	SubDomainHost string `json:"subDomainHost,omitempty"`
 	// Custom404Page 指定要显示的自定义404页面的路径。如果此值为“”，将显示默认页面。
+	// vhostHTTPErrorPages 中选中的 404 模板优先于此页面。
 	Custom404Page string `json:"custom404Page,omitempty"`
 
 	SSHTunnelGateway SSHTunnelGateway `json:"SSHTunnelGateway,omitempty"`
@@ -62,6 +85,27 @@ type ServerConfig struct {
 	AllowPorts []types.PortsRange `json:"allowPorts,omitempty"`
 
 	HTTPPlugins []HTTPPluginOptions `json:"HTTPPlugins,omitempty"`
+	// GRPCPlugins 与 HTTPPlugins 支持相同的操作，但通过 gRPC 调用插件。
+	GRPCPlugins []GRPCPluginOptions `json:"GRPCPlugins,omitempty"`
+	// WASMPlugins 与 HTTPPlugins 支持相同的操作，但在 frps 进程内的沙箱中运行 WebAssembly 模块。
+	WASMPlugins []WASMPluginOptions `json:"WASMPlugins,omitempty"`
+	// RulePlugins 与 HTTPPlugins 支持相同的操作，规则在加载配置时编译和校验。
+	RulePlugins []RulePluginOptions `json:"RulePlugins,omitempty"`
+
+	// Quota 指定按用户和按代理的流量配额。
+	Quota QuotaConfig `json:"quota,omitempty"`
+
+	// Webhooks 指定接收 frps 事件通知的 webhook，事件会异步投递，不会阻塞连接的建立。
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+
+	// AccessLog 指定 vhost HTTP、HTTPS 和 tcpmux 流量的访问日志。
+	AccessLog AccessLogsConfig `json:"accessLog,omitempty"`
+
+	// ProxySourceIPACL 限制所有代理的来源 IP，先于代理自身的 sourceIPACL 检查，客户端无法放宽此限制。
+	ProxySourceIPACL IPACLConfig `json:"proxySourceIPACL,omitempty"`
+
+	// GeoIP 指定按来源 IP 所属国家限制访问代理时使用的数据库。
+	GeoIP GeoIPConfig `json:"geoIP,omitempty"`
 }
 
 func (c *ServerConfig) Complete() {
@@ -70,6 +114,25 @@ func (c *ServerConfig) Complete() {
 	c.Transport.Complete()
 	c.WebServer.Complete()
 	c.SSHTunnelGateway.Complete()
+	c.VhostHTTPSTermination.Complete()
+	c.ACME.Complete()
+	c.Quota.Complete()
+	c.AccessLog.Complete()
+	c.GeoIP.Complete()
+	c.VhostHTTPOIDC.Complete()
+	c.HTPasswd.Complete()
+	for i := range c.HTTPPlugins {
+		c.HTTPPlugins[i].Complete()
+	}
+	for i := range c.WASMPlugins {
+		c.WASMPlugins[i].Complete()
+	}
+	for i := range c.RulePlugins {
+		c.RulePlugins[i].Complete()
+	}
+	for i := range c.Webhooks {
+		c.Webhooks[i].Complete()
+	}
 
 	c.BindAddr = util.EmptyOr(c.BindAddr, "0.0.0.0")
 	c.BindPort = util.EmptyOr(c.KCPBindPort, 7000)
@@ -89,6 +152,56 @@ func (c *ServerConfig) Complete() {
 
 }
 
+const (
+	HTTPSUpstreamProtocolHTTP  = "http"
+	HTTPSUpstreamProtocolHTTPS = "https"
+)
+
+type HTTPSTerminationConfig struct {
+	// CertDir 指定证书目录，目录中的每对 {name}.crt 和 {name}.key 文件为一个证书，
+	// 证书中的 DNS 名称（可以是 *.example.com 形式的通配符）决定它用于哪些域名。
+	// 如果此值为 ""，则不终止 TLS。对于证书库中没有证书的域名，仍然透传 TLS 流量。
+	CertDir string `json:"certDir,omitempty"`
+	// ReloadInterval 指定检查证书目录变化的间隔（以秒为单位）。
+	// 如果此值为负数，则禁用热加载。默认情况下，此值为 10。
+	ReloadInterval int64 `json:"reloadInterval,omitempty"`
+	// UpstreamProtocol 指定终止 TLS 后转发给客户端的协议，可选值为 "http" 和 "https"。
+	// "https" 表示使用 TLS 重新加密后再转发。默认情况下，此值为 "http"。
+	UpstreamProtocol string `json:"upstreamProtocol,omitempty"`
+	// UpstreamTLSVerify 指定重新加密时是否校验客户端后端的证书。
+	UpstreamTLSVerify bool `json:"upstreamTLSVerify,omitempty"`
+}
+
+func (c *HTTPSTerminationConfig) Complete() {
+	c.ReloadInterval = util.EmptyOr(c.ReloadInterval, 10)
+	c.UpstreamProtocol = util.EmptyOr(c.UpstreamProtocol, HTTPSUpstreamProtocolHTTP)
+}
+
+type ACMEConfig struct {
+	// Enable 指定是否启用 ACME。HTTP-01 验证通过 vhostHTTPPort 完成，TLS-ALPN-01 验证通过 vhostHTTPSPort 完成。
+	Enable bool `json:"enable,omitempty"`
+	// Email 指定 ACME 账户的联系邮箱，CA 会向它发送证书即将过期等通知。
+	Email string `json:"email,omitempty"`
+	// DirectoryURL 指定 ACME 服务的目录地址，可以指向本地的 ACME 测试服务。
+	// 默认情况下，此值为 Let's Encrypt 的正式地址。
+	DirectoryURL string `json:"directoryURL,omitempty"`
+	// DirectoryCAFile 指定用于校验 ACME 服务证书的 CA 文件，用于使用自签名证书的测试服务。
+	DirectoryCAFile string `json:"directoryCAFile,omitempty"`
+	// CacheDir 指定保存账户密钥和证书的目录。默认情况下，此值为 "./acme"。
+	CacheDir string `json:"cacheDir,omitempty"`
+	// Domains 是允许申请证书的域名列表，"*.example.com" 表示 example.com 的所有子域名。
+	// 只有在列表中且已被代理使用的域名才会申请证书。
+	Domains []string `json:"domains,omitempty"`
+	// RenewBeforeDays 指定在证书过期前多少天续期。默认情况下，此值为 30。
+	RenewBeforeDays int `json:"renewBeforeDays,omitempty"`
+}
+
+func (c *ACMEConfig) Complete() {
+	c.DirectoryURL = util.EmptyOr(c.DirectoryURL, "https://acme-v02.api.letsencrypt.org/directory")
+	c.CacheDir = util.EmptyOr(c.CacheDir, "./acme")
+	c.RenewBeforeDays = util.EmptyOr(c.RenewBeforeDays, 30)
+}
+
 type AuthServerConfig struct {
 	Method           AuthMethod           `json:"method,omitempty"`
 	AdditionalScopes []AuthScope          `json:"additionalScopes,omitempty"`
@@ -167,3 +280,216 @@ type SSHTunnelGateway struct {
 func (c *SSHTunnelGateway) Complete() {
 	c.AutoGenPrivateKeyPath = util.EmptyOr(c.AutoGenPrivateKeyPath, "./.autogen_ssh_key")
 }
+
+const (
+	QuotaActionReject   = "reject"
+	QuotaActionThrottle = "throttle"
+)
+
+type QuotaConfig struct {
+	// StoreFile 指定流量用量持久化文件的路径，用于在 frps 重启后恢复用量。
+	// 如果此值为 ""，则用量只保存在内存中。
+	StoreFile string `json:"storeFile,omitempty"`
+	// SyncInterval 指定将用量写入 StoreFile 的间隔（以秒为单位）。默认情况下，此值为 60。
+	SyncInterval int64 `json:"syncInterval,omitempty"`
+	// Users 指定每个用户（msg.Login 中的 User）的配额。
+	Users []UserQuotaConfig `json:"users,omitempty"`
+	// Proxies 指定每个代理的配额，Name 为 frps 上带有用户前缀的完整代理名称。
+	Proxies []ProxyQuotaConfig `json:"proxies,omitempty"`
+}
+
+func (c *QuotaConfig) Complete() {
+	c.SyncInterval = util.EmptyOr(c.SyncInterval, 60)
+	for i := range c.Users {
+		c.Users[i].TrafficQuotaConfig.Complete()
+	}
+	for i := range c.Proxies {
+		c.Proxies[i].TrafficQuotaConfig.Complete()
+	}
+}
+
+type TrafficQuotaConfig struct {
+	// Daily 指定每天（按服务器本地时间）允许的入站加出站流量，例如 "10GB"。为空表示不限制。
+	Daily types.TrafficQuantity `json:"daily,omitempty"`
+	// Monthly 指定每个自然月允许的入站加出站流量，例如 "300GB"。为空表示不限制。
+	Monthly types.TrafficQuantity `json:"monthly,omitempty"`
+	// Action 指定超出配额后如何处理新的用户连接。有效值为 "reject" 和 "throttle"。
+	// 默认情况下，此值为 "reject"。
+	Action string `json:"action,omitempty"`
+	// FallbackBandwidth 指定 Action 为 "throttle" 时，超出配额后新用户连接的带宽上限。
+	FallbackBandwidth types.BandwidthWithQuantity `json:"fallbackBandwidth,omitempty"`
+}
+
+func (c *TrafficQuotaConfig) Complete() {
+	c.Action = util.EmptyOr(c.Action, QuotaActionReject)
+}
+
+type UserQuotaConfig struct {
+	User string `json:"user"`
+	TrafficQuotaConfig
+}
+
+type ProxyQuotaConfig struct {
+	Name string `json:"name"`
+	TrafficQuotaConfig
+}
+
+type WebhookConfig struct {
+	Name string `json:"name"`
+	// URL 指定接收事件的地址，frps 以 POST 方式发送 JSON 格式的事件。
+	URL string `json:"url"`
+	// Events 指定订阅的事件类型，为空时订阅所有事件。
+	Events []string `json:"events,omitempty"`
+	// Secret 不为空时，frps 使用它对请求签名，签名放在 X-Frp-Signature 请求头中。
+	Secret string `json:"secret,omitempty"`
+	// TLSVerify 指定访问 https 地址时是否校验服务端证书。
+	TLSVerify bool `json:"tlsVerify,omitempty"`
+
+	// QueueDir 指定保存待投递事件的目录，frps 重启后会继续投递其中的事件。
+	// 默认情况下，此值为 "./webhooks/{name}"。
+	QueueDir string `json:"queueDir,omitempty"`
+	// MaxQueueSize 指定队列中最多保存的事件数，超出后丢弃最旧的事件。默认情况下，此值为 10000。
+	MaxQueueSize int `json:"maxQueueSize,omitempty"`
+	// MaxRetries 指定投递失败后的最大重试次数，设置为 0 表示不重试。默认情况下，此值为 5。
+	MaxRetries *int `json:"maxRetries,omitempty"`
+	// RetryBackoffMilliseconds 指定第一次重试前的等待时间（以毫秒为单位），之后每次翻倍。
+	// 默认情况下，此值为 1000。
+	RetryBackoffMilliseconds int `json:"retryBackoffMilliseconds,omitempty"`
+	// TimeoutMilliseconds 指定每次投递的超时时间（以毫秒为单位）。默认情况下，此值为 5000。
+	TimeoutMilliseconds int `json:"timeoutMilliseconds,omitempty"`
+}
+
+func (c *WebhookConfig) Complete() {
+	c.QueueDir = util.EmptyOr(c.QueueDir, filepath.Join("webhooks", c.Name))
+	c.MaxQueueSize = util.EmptyOr(c.MaxQueueSize, 10000)
+	c.MaxRetries = util.EmptyOr(c.MaxRetries, lo.ToPtr(5))
+	c.RetryBackoffMilliseconds = util.EmptyOr(c.RetryBackoffMilliseconds, 1000)
+	c.TimeoutMilliseconds = util.EmptyOr(c.TimeoutMilliseconds, 5000)
+}
+
+const (
+	AccessLogFormatCombined = "combined"
+	AccessLogFormatJSON     = "json"
+
+	AccessLogRotateDaily = "daily"
+	AccessLogRotateNone  = "none"
+)
+
+type AccessLogsConfig struct {
+	// HTTP 记录 vhostHTTPPort 上的每个请求。
+	HTTP AccessLogConfig `json:"http,omitempty"`
+	// HTTPS 记录 vhostHTTPSPort 上的每个连接。
+	HTTPS AccessLogConfig `json:"https,omitempty"`
+	// TCPMux 记录 tcpmux 端口上的每个连接。
+	TCPMux AccessLogConfig `json:"tcpmux,omitempty"`
+}
+
+func (c *AccessLogsConfig) Complete() {
+	c.HTTP.Complete()
+	c.HTTPS.Complete()
+	c.TCPMux.Complete()
+}
+
+type AccessLogConfig struct {
+	// To 指定访问日志的输出文件，"console" 表示输出到 stdout。如果此值为 ""，则不记录访问日志。
+	To string `json:"to,omitempty"`
+	// Format 指定日志格式，有效值为 "combined" 和 "json"。默认为 "combined"，
+	// 即 Apache Combined 格式之后依次附加代理名称、客户端 run ID、Host、TLS SNI、来源国家和耗时（毫秒）。
+	// 只有配置了 geoIP.dbFile 时才记录来源国家。
+	Format string `json:"format,omitempty"`
+	// Rotate 指定日志文件的轮转方式，有效值为 "daily" 和 "none"。默认为 "daily"。
+	Rotate string `json:"rotate,omitempty"`
+	// MaxDays 指定按天轮转时保留的天数，默认为 3。
+	MaxDays int `json:"maxDays,omitempty"`
+	// SampleRate 指定记录的比例，取值范围为 (0, 1]，默认为 1，即全部记录。
+	SampleRate float64 `json:"sampleRate,omitempty"`
+}
+
+func (c *AccessLogConfig) Complete() {
+	c.Format = util.EmptyOr(c.Format, AccessLogFormatCombined)
+	c.Rotate = util.EmptyOr(c.Rotate, AccessLogRotateDaily)
+	c.MaxDays = util.EmptyOr(c.MaxDays, 3)
+	c.SampleRate = util.EmptyOr(c.SampleRate, 1)
+}
+
+type GeoIPConfig struct {
+	// DBFile 指定 MaxMind 格式（.mmdb）的国家或城市数据库文件，例如 GeoLite2-Country.mmdb。
+	// 如果此值为 ""，则不启用 GeoIP，代理的 countryACL 也不会生效。
+	DBFile string `json:"dbFile,omitempty"`
+	// ReloadInterval 指定检查数据库文件是否变化的间隔秒数，文件变化后会重新加载。默认为 60。
+	ReloadInterval int64 `json:"reloadInterval,omitempty"`
+	// ProxyCountryACL 限制所有代理的来源国家，先于代理自身的 countryACL 检查，客户端无法放宽此限制。
+	ProxyCountryACL CountryACLConfig `json:"proxyCountryACL,omitempty"`
+}
+
+func (c *GeoIPConfig) Complete() {
+	c.ReloadInterval = util.EmptyOr(c.ReloadInterval, 60)
+}
+
+type VhostHTTPRateLimitConfig struct {
+	// Domain 为完整域名、"*.example.com" 形式的通配符域名或 "*"，多条配置匹配时使用最具体的一条。
+	Domain string `json:"domain"`
+	RequestRateLimitConfig
+}
+
+// VhostHTTPOIDCConfig 中的回调地址为 scheme://domain/.frp/oidc/callback，需要为每个启用 OIDC 的域名在身份提供方注册。
+type VhostHTTPOIDCConfig struct {
+	// Issuer 指定身份提供方的 issuer，frps 通过 Issuer + "/.well-known/openid-configuration" 获取端点信息。
+	// 如果此值为 ""，则不启用 OIDC 登录。
+	Issuer       string `json:"issuer,omitempty"`
+	ClientID     string `json:"clientID,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	// Scopes 指定登录时请求的 scope，默认为 ["openid", "email", "profile"]。
+	Scopes []string `json:"scopes,omitempty"`
+	// GroupsClaim 指定 ID Token 中用户组的声明名称，默认为 "groups"。
+	GroupsClaim string `json:"groupsClaim,omitempty"`
+	// CookieSecret 指定签名会话 cookie 的密钥，至少 32 个字符。如果此值为 ""，则每次启动时随机生成，
+	// frps 重启后用户需要重新登录。
+	CookieSecret string `json:"cookieSecret,omitempty"`
+	// SessionTTL 指定登录会话的有效期（以秒为单位）。默认情况下，此值为 86400。
+	SessionTTL int64 `json:"sessionTTL,omitempty"`
+}
+
+func (c *VhostHTTPOIDCConfig) Complete() {
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "email", "profile"}
+	}
+	c.GroupsClaim = util.EmptyOr(c.GroupsClaim, "groups")
+	c.SessionTTL = util.EmptyOr(c.SessionTTL, 86400)
+}
+
+type HTPasswdConfig struct {
+	// Files 的键是代理 htpasswd 配置引用的名称，值是文件路径。文件中每行为 "用户名:bcrypt 哈希"，
+	// 可以使用 htpasswd -B 生成。文件在 frps 启动时加载。
+	Files map[string]string `json:"files,omitempty"`
+	// AuthFailDelay 指定认证失败后返回 401 之前等待的毫秒数，用于减慢暴力破解。默认情况下，此值为 1000。
+	AuthFailDelay int64 `json:"authFailDelay,omitempty"`
+}
+
+func (c *HTPasswdConfig) Complete() {
+	c.AuthFailDelay = util.EmptyOr(c.AuthFailDelay, 1000)
+}
+
+type VhostHTTPErrorPagesConfig struct {
+	// Templates 的键是模板组的名称，代理通过 errorPages 引用。文件在 frps 启动时加载。
+	Templates map[string]ErrorPageTemplatesConfig `json:"templates,omitempty"`
+	// Default 指定没有按代理或按域名选择时使用的模板组。如果此值为 ""，则使用内置页面。
+	Default string `json:"default,omitempty"`
+	// Domains 按域名选择模板组，代理的 errorPages 优先于此配置。
+	Domains []VhostHTTPErrorPagesDomainConfig `json:"domains,omitempty"`
+}
+
+// ErrorPageTemplatesConfig 中的每个值都是 html/template 格式的模板文件，可以使用 {{.StatusCode}}、{{.StatusText}}、
+// {{.Host}}、{{.RequestID}}、{{.ProxyName}} 和 {{.Maintenance}} 变量。没有配置的状态码使用下一级的页面。
+type ErrorPageTemplatesConfig struct {
+	NotFound           string `json:"notFound,omitempty"`
+	BadGateway         string `json:"badGateway,omitempty"`
+	ServiceUnavailable string `json:"serviceUnavailable,omitempty"`
+	GatewayTimeout     string `json:"gatewayTimeout,omitempty"`
+}
+
+type VhostHTTPErrorPagesDomainConfig struct {
+	// Domain 为完整域名、"*.example.com" 形式的通配符域名或 "*"，多条配置匹配时使用最具体的一条。
+	Domain    string `json:"domain"`
+	Templates string `json:"templates"`
+}
@@ -3,6 +3,7 @@ package validation
 import (
 	"fmt"
 	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	netpkg "github.com/sunyihoo/frp/pkg/util/net"
 	"slices"
 )
 
@@ -32,3 +33,19 @@ func validateLogConfig(c *v1.LogConfig) error {
 	}
 	return nil
 }
+
+func validateIPACLConfig(c *v1.IPACLConfig, fieldPath string) error {
+	if _, err := netpkg.NewIPACL(c.Allow, c.Deny, nil); err != nil {
+		return fmt.Errorf("%s: %v", fieldPath, err)
+	}
+	return nil
+}
+
+func validateCountryACLConfig(c *v1.CountryACLConfig, fieldPath string) error {
+	for _, code := range append(slices.Clone(c.Allow), c.Deny...) {
+		if len(code) != 2 {
+			return fmt.Errorf("%s: invalid country code [%s], it should be an ISO 3166-1 alpha-2 code", fieldPath, code)
+		}
+	}
+	return nil
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"github.com/samber/lo"
 	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	splugin "github.com/sunyihoo/frp/pkg/plugin/server"
 	"slices"
+	"strings"
 )
 
 func ValidateServerConfig(c *v1.ServerConfig) (Warning, error) {
@@ -33,11 +35,247 @@ func ValidateServerConfig(c *v1.ServerConfig) (Warning, error) {
 	errs = AppendError(errs, ValidatePort(c.VhostHTTPPort, "vhostHTTPPort"))
 	errs = AppendError(errs, ValidatePort(c.VhostHTTPSPort, "vhostHTTPSPort"))
 	errs = AppendError(errs, ValidatePort(c.TCPMuxHTTPConnectPort, "tcpMuxHTTPConnectPort"))
+	errs = AppendError(errs, ValidatePort(c.TCPMuxSNIPort, "tcpMuxSNIPort"))
+	errs = AppendError(errs, ValidatePort(c.TCPMuxSOCKS5Port, "tcpMuxSOCKS5Port"))
+
+	if c.VhostHTTPSTermination.CertDir != "" {
+		if !slices.Contains(SupportedHTTPSUpstreamProtocols, c.VhostHTTPSTermination.UpstreamProtocol) {
+			errs = AppendError(errs, fmt.Errorf("vhostHTTPSTermination: invalid upstreamProtocol, optional values are %v",
+				SupportedHTTPSUpstreamProtocols))
+		}
+		if c.VhostHTTPSPort == 0 {
+			warnings = AppendError(warnings, fmt.Errorf("vhostHTTPSTermination is ignored because vhostHTTPSPort is not set"))
+		}
+	}
+	if c.ACME.Enable {
+		if len(c.ACME.Domains) == 0 {
+			errs = AppendError(errs, fmt.Errorf("acme.domains should not be empty when acme is enabled"))
+		}
+		if c.VhostHTTPSPort == 0 {
+			errs = AppendError(errs, fmt.Errorf("acme requires vhostHTTPSPort to be set"))
+		}
+		if c.ACME.RenewBeforeDays <= 0 {
+			errs = AppendError(errs, fmt.Errorf("acme.renewBeforeDays should be positive"))
+		}
+	}
 
 	for _, p := range c.HTTPPlugins {
 		if !lo.Every(SupportedHTTPPlugins, p.Ops) {
 			errs = AppendError(errs, fmt.Errorf("invalid http plugin ops, optional values are %v", SupportedHTTPPlugins))
 		}
+		if !slices.Contains(SupportedPluginFailurePolicies, p.FailurePolicy) {
+			errs = AppendError(errs, fmt.Errorf("http plugin [%s]: invalid failurePolicy, optional values are %v", p.Name, SupportedPluginFailurePolicies))
+		}
+		if p.TimeoutMilliseconds < 0 || p.MaxRetries < 0 || p.RetryBackoffMilliseconds < 0 {
+			errs = AppendError(errs, fmt.Errorf("http plugin [%s]: timeoutMilliseconds, maxRetries and retryBackoffMilliseconds should not be negative", p.Name))
+		}
+		if p.CircuitBreaker != nil && (p.CircuitBreaker.FailureThreshold <= 0 || p.CircuitBreaker.OpenSeconds <= 0) {
+			errs = AppendError(errs, fmt.Errorf("http plugin [%s]: circuitBreaker.failureThreshold and circuitBreaker.openSeconds should be positive", p.Name))
+		}
+	}
+	for _, p := range c.GRPCPlugins {
+		if p.Addr == "" {
+			errs = AppendError(errs, fmt.Errorf("grpc plugin [%s]: addr should not be empty", p.Name))
+		}
+		if !lo.Every(SupportedHTTPPlugins, p.Ops) {
+			errs = AppendError(errs, fmt.Errorf("invalid grpc plugin ops, optional values are %v", SupportedHTTPPlugins))
+		}
+	}
+	for _, p := range c.WASMPlugins {
+		if p.Path == "" {
+			errs = AppendError(errs, fmt.Errorf("wasm plugin [%s]: path should not be empty", p.Name))
+		}
+		if p.MemoryLimitMB <= 0 || p.MemoryLimitMB > 4096 {
+			errs = AppendError(errs, fmt.Errorf("wasm plugin [%s]: memoryLimitMB must be in the range 1..4096", p.Name))
+		}
+		if !lo.Every(SupportedHTTPPlugins, p.Ops) {
+			errs = AppendError(errs, fmt.Errorf("invalid wasm plugin ops, optional values are %v", SupportedHTTPPlugins))
+		}
+	}
+	for _, p := range c.RulePlugins {
+		if !lo.Every(SupportedHTTPPlugins, p.Ops) {
+			errs = AppendError(errs, fmt.Errorf("invalid rule plugin ops, optional values are %v", SupportedHTTPPlugins))
+			continue
+		}
+		if _, err := splugin.NewRulePluginOptions(p); err != nil {
+			errs = AppendError(errs, err)
+		}
+	}
+
+	if err := validateQuotaConfig(&c.Quota); err != nil {
+		errs = AppendError(errs, err)
+	}
+	if err := validateWebhooks(c.Webhooks); err != nil {
+		errs = AppendError(errs, err)
+	}
+	errs = AppendError(errs, validateAccessLogConfig(&c.AccessLog.HTTP, "accessLog.http"))
+	errs = AppendError(errs, validateAccessLogConfig(&c.AccessLog.HTTPS, "accessLog.https"))
+	errs = AppendError(errs, validateAccessLogConfig(&c.AccessLog.TCPMux, "accessLog.tcpmux"))
+	errs = AppendError(errs, validateVhostHTTPRateLimits(c.VhostHTTPRateLimits))
+	for name, path := range c.HTPasswd.Files {
+		if name == "" || path == "" {
+			errs = AppendError(errs, fmt.Errorf("htpasswd.files: name and path should not be empty"))
+		}
+	}
+	if c.HTPasswd.AuthFailDelay < 0 {
+		errs = AppendError(errs, fmt.Errorf("htpasswd.authFailDelay should not be negative"))
+	}
+	errs = AppendError(errs, validateVhostHTTPErrorPages(&c.VhostHTTPErrorPages))
+	if c.VhostHTTPOIDC.Issuer != "" {
+		if c.VhostHTTPOIDC.ClientID == "" {
+			errs = AppendError(errs, fmt.Errorf("vhostHTTPOIDC.clientID should not be empty"))
+		}
+		if c.VhostHTTPOIDC.CookieSecret != "" && len(c.VhostHTTPOIDC.CookieSecret) < 32 {
+			errs = AppendError(errs, fmt.Errorf("vhostHTTPOIDC.cookieSecret should be at least 32 characters"))
+		}
+		if c.VhostHTTPOIDC.SessionTTL <= 0 {
+			errs = AppendError(errs, fmt.Errorf("vhostHTTPOIDC.sessionTTL should be positive"))
+		}
+		if c.VhostHTTPPort == 0 {
+			warnings = AppendError(warnings, fmt.Errorf("vhostHTTPOIDC is ignored because vhostHTTPPort is not set"))
+		}
+	}
+	errs = AppendError(errs, validateIPACLConfig(&c.ProxySourceIPACL, "proxySourceIPACL"))
+	errs = AppendError(errs, validateCountryACLConfig(&c.GeoIP.ProxyCountryACL, "geoIP.proxyCountryACL"))
+	if c.GeoIP.DBFile == "" {
+		if len(c.GeoIP.ProxyCountryACL.Allow) > 0 || len(c.GeoIP.ProxyCountryACL.Deny) > 0 {
+			warnings = AppendError(warnings, fmt.Errorf("geoIP.proxyCountryACL is ignored because geoIP.dbFile is not set"))
+		}
+	} else if c.GeoIP.ReloadInterval <= 0 {
+		errs = AppendError(errs, fmt.Errorf("geoIP.reloadInterval should be positive"))
 	}
 	return warnings, errs
 }
+
+func validateAccessLogConfig(c *v1.AccessLogConfig, fieldPath string) error {
+	if c.To == "" {
+		return nil
+	}
+	if !slices.Contains(SupportedAccessLogFormats, c.Format) {
+		return fmt.Errorf("%s: invalid format, optional values are %v", fieldPath, SupportedAccessLogFormats)
+	}
+	if !slices.Contains(SupportedAccessLogRotates, c.Rotate) {
+		return fmt.Errorf("%s: invalid rotate, optional values are %v", fieldPath, SupportedAccessLogRotates)
+	}
+	if c.SampleRate <= 0 || c.SampleRate > 1 {
+		return fmt.Errorf("%s: sampleRate must be in (0, 1]", fieldPath)
+	}
+	return nil
+}
+
+func validateVhostHTTPRateLimits(limits []v1.VhostHTTPRateLimitConfig) error {
+	var errs error
+	domains := make(map[string]struct{})
+	for _, l := range limits {
+		if l.Domain == "" {
+			errs = AppendError(errs, fmt.Errorf("vhostHTTPRateLimits: domain should not be empty"))
+			continue
+		}
+		if _, ok := domains[l.Domain]; ok {
+			errs = AppendError(errs, fmt.Errorf("vhostHTTPRateLimits: duplicate domain [%s]", l.Domain))
+		}
+		domains[l.Domain] = struct{}{}
+		if l.RequestsPerSecond <= 0 || l.Burst < 0 {
+			errs = AppendError(errs, fmt.Errorf("vhostHTTPRateLimits[%s]: requestsPerSecond should be positive and burst should not be negative", l.Domain))
+		}
+	}
+	return errs
+}
+
+func validateVhostHTTPErrorPages(c *v1.VhostHTTPErrorPagesConfig) error {
+	var errs error
+	for name, t := range c.Templates {
+		if name == "" {
+			errs = AppendError(errs, fmt.Errorf("vhostHTTPErrorPages.templates: name should not be empty"))
+		}
+		if t.NotFound == "" && t.BadGateway == "" && t.ServiceUnavailable == "" && t.GatewayTimeout == "" {
+			errs = AppendError(errs, fmt.Errorf("vhostHTTPErrorPages.templates[%s]: at least one template file should be set", name))
+		}
+	}
+	if _, ok := c.Templates[c.Default]; c.Default != "" && !ok {
+		errs = AppendError(errs, fmt.Errorf("vhostHTTPErrorPages.default: templates [%s] not found", c.Default))
+	}
+	domains := make(map[string]struct{})
+	for _, d := range c.Domains {
+		if d.Domain == "" {
+			errs = AppendError(errs, fmt.Errorf("vhostHTTPErrorPages.domains: domain should not be empty"))
+			continue
+		}
+		if _, ok := domains[d.Domain]; ok {
+			errs = AppendError(errs, fmt.Errorf("vhostHTTPErrorPages.domains: duplicate domain [%s]", d.Domain))
+		}
+		domains[d.Domain] = struct{}{}
+		if _, ok := c.Templates[d.Templates]; !ok {
+			errs = AppendError(errs, fmt.Errorf("vhostHTTPErrorPages.domains[%s]: templates [%s] not found", d.Domain, d.Templates))
+		}
+	}
+	return errs
+}
+
+func validateWebhooks(webhooks []v1.WebhookConfig) error {
+	var errs error
+	names := make(map[string]struct{})
+	for _, w := range webhooks {
+		if w.Name == "" {
+			errs = AppendError(errs, fmt.Errorf("webhooks: name should not be empty"))
+			continue
+		}
+		if _, ok := names[w.Name]; ok {
+			errs = AppendError(errs, fmt.Errorf("webhooks: duplicate name [%s]", w.Name))
+		}
+		names[w.Name] = struct{}{}
+		if !strings.HasPrefix(w.URL, "http://") && !strings.HasPrefix(w.URL, "https://") {
+			errs = AppendError(errs, fmt.Errorf("webhook [%s]: url should start with http:// or https://", w.Name))
+		}
+		if !lo.Every(SupportedWebhookEvents, w.Events) {
+			errs = AppendError(errs, fmt.Errorf("webhook [%s]: invalid events, optional values are %v", w.Name, SupportedWebhookEvents))
+		}
+		if w.MaxQueueSize <= 0 || lo.FromPtr(w.MaxRetries) < 0 || w.RetryBackoffMilliseconds < 0 || w.TimeoutMilliseconds <= 0 {
+			errs = AppendError(errs, fmt.Errorf("webhook [%s]: maxQueueSize and timeoutMilliseconds should be positive, "+
+				"maxRetries and retryBackoffMilliseconds should not be negative", w.Name))
+		}
+	}
+	return errs
+}
+
+func validateQuotaConfig(c *v1.QuotaConfig) error {
+	var errs error
+	if c.StoreFile != "" && c.SyncInterval <= 0 {
+		errs = AppendError(errs, fmt.Errorf("quota.syncInterval should be positive"))
+	}
+	users := make(map[string]struct{})
+	for _, q := range c.Users {
+		if q.User == "" {
+			errs = AppendError(errs, fmt.Errorf("quota.users: user should not be empty"))
+			continue
+		}
+		if _, ok := users[q.User]; ok {
+			errs = AppendError(errs, fmt.Errorf("quota.users: duplicate user [%s]", q.User))
+		}
+		users[q.User] = struct{}{}
+		errs = AppendError(errs, validateTrafficQuotaConfig(&q.TrafficQuotaConfig, "quota.users["+q.User+"]"))
+	}
+	proxies := make(map[string]struct{})
+	for _, q := range c.Proxies {
+		if q.Name == "" {
+			errs = AppendError(errs, fmt.Errorf("quota.proxies: name should not be empty"))
+			continue
+		}
+		if _, ok := proxies[q.Name]; ok {
+			errs = AppendError(errs, fmt.Errorf("quota.proxies: duplicate proxy [%s]", q.Name))
+		}
+		proxies[q.Name] = struct{}{}
+		errs = AppendError(errs, validateTrafficQuotaConfig(&q.TrafficQuotaConfig, "quota.proxies["+q.Name+"]"))
+	}
+	return errs
+}
+
+func validateTrafficQuotaConfig(c *v1.TrafficQuotaConfig, fieldPath string) error {
+	if !slices.Contains(SupportedQuotaActions, c.Action) {
+		return fmt.Errorf("%s: invalid action, optional values are %v", fieldPath, SupportedQuotaActions)
+	}
+	if c.Action == v1.QuotaActionThrottle && c.FallbackBandwidth.Bytes() <= 0 {
+		return fmt.Errorf("%s: fallbackBandwidth must be specified when action is %s", fieldPath, v1.QuotaActionThrottle)
+	}
+	return nil
+}
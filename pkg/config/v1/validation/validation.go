@@ -4,6 +4,7 @@ import (
 	"errors"
 	v1 "github.com/sunyihoo/frp/pkg/config/v1"
 	splugin "github.com/sunyihoo/frp/pkg/plugin/server"
+	"github.com/sunyihoo/frp/pkg/webhook"
 )
 
 var (
@@ -28,6 +29,39 @@ var (
 		"error",
 	}
 
+	// SupportedQuotaActions 支持的超出配额处理方式
+	SupportedQuotaActions = []string{
+		v1.QuotaActionReject,
+		v1.QuotaActionThrottle,
+	}
+
+	// SupportedHTTPSUpstreamProtocols 终止 TLS 后支持的转发协议
+	SupportedHTTPSUpstreamProtocols = []string{
+		v1.HTTPSUpstreamProtocolHTTP,
+		v1.HTTPSUpstreamProtocolHTTPS,
+	}
+
+	// SupportedAccessLogFormats 支持的访问日志格式
+	SupportedAccessLogFormats = []string{
+		v1.AccessLogFormatCombined,
+		v1.AccessLogFormatJSON,
+	}
+
+	// SupportedAccessLogRotates 支持的访问日志轮转方式
+	SupportedAccessLogRotates = []string{
+		v1.AccessLogRotateDaily,
+		v1.AccessLogRotateNone,
+	}
+
+	// SupportedWebhookEvents 支持订阅的 webhook 事件
+	SupportedWebhookEvents = webhook.SupportedEvents
+
+	// SupportedPluginFailurePolicies 支持的插件失败策略
+	SupportedPluginFailurePolicies = []string{
+		v1.PluginFailurePolicyFailClosed,
+		v1.PluginFailurePolicyFailOpen,
+	}
+
 	SupportedHTTPPlugins = []string{
 		splugin.OpLogin,
 		splugin.OpNewProxy,
@@ -35,6 +69,12 @@ var (
 		splugin.OpPing,
 		splugin.OpNewWorkConn,
 		splugin.OpNewUserConn,
+		splugin.OpQuotaExceeded,
+		splugin.OpGroupFailover,
+		splugin.OpNewVisitorConn,
+		splugin.OpNatHoleVisitor,
+		splugin.OpCloseUserConn,
+		splugin.OpCloseClient,
 	}
 )
 
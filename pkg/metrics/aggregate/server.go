@@ -30,6 +30,11 @@ func EnablePrometheus() {
 	sm.Add(prometheus.ServerMetrics)
 }
 
+// AddServerMetrics 将其他指标接收者（例如流量配额统计）加入聚合。
+func AddServerMetrics(m metrics.ServerMetrics) {
+	sm.Add(m)
+}
+
 var sm = &serverMetrics{}
 
 func init() {
@@ -91,3 +96,15 @@ func (m *serverMetrics) AddTrafficOut(name string, proxyType string, trafficByte
 		v.AddTrafficOut(name, proxyType, trafficBytes)
 	}
 }
+
+func (m *serverMetrics) RejectConnection(name string, proxyType string, reason string) {
+	for _, v := range m.ms {
+		v.RejectConnection(name, proxyType, reason)
+	}
+}
+
+func (m *serverMetrics) AddCountryConnection(name string, proxyType string, country string) {
+	for _, v := range m.ms {
+		v.AddCountryConnection(name, proxyType, country)
+	}
+}
@@ -22,6 +22,8 @@ func newServerMetrics() *serverMetrics {
 			TotalTrafficIn:  metric.NewDateCounter(ReserveDays),
 			TotalTrafficOut: metric.NewDateCounter(ReserveDays),
 			CurConns:        metric.NewCounter(),
+			RejectedConns:   metric.NewCounter(),
+			CountryConns:    make(map[string]metric.Counter),
 
 			ClientCounts:    metric.NewCounter(),
 			ProxyTypeCounts: make(map[string]metric.Counter),
@@ -30,3 +32,18 @@ func newServerMetrics() *serverMetrics {
 		},
 	}
 }
+
+func (m *serverMetrics) RejectConnection(string, string, string) {
+	m.info.RejectedConns.Inc(1)
+}
+
+func (m *serverMetrics) AddCountryConnection(_ string, _ string, country string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counter, ok := m.info.CountryConns[country]
+	if !ok {
+		counter = metric.NewCounter()
+		m.info.CountryConns[country] = counter
+	}
+	counter.Inc(1)
+}
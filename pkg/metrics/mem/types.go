@@ -23,6 +23,11 @@ type ServerStatics struct {
 	TotalTrafficIn  metric.DateCounter
 	TotalTrafficOut metric.DateCounter
 	CurConns        metric.Counter
+	// RejectedConns 是在请求工作连接之前被拒绝的用户连接总数
+	RejectedConns metric.Counter
+	// CountryConns 是按来源国家统计的用户连接数，仅在启用 GeoIP 时记录
+	// key 键名是国家代码，未知国家为空字符串
+	CountryConns map[string]metric.Counter
 
 	// 客户计数器 counter for clients
 	ClientCounts metric.Counter
@@ -21,4 +21,5 @@ import (
 var (
 	EnableMem        = aggregate.EnableMem
 	EnablePrometheus = aggregate.EnablePrometheus
+	AddServerMetrics = aggregate.AddServerMetrics
 )
@@ -19,6 +19,8 @@ type serverMetrics struct {
 	connectionCount *prometheus.GaugeVec
 	trafficIn       *prometheus.GaugeVec
 	trafficOut      *prometheus.GaugeVec
+	rejectedConns   *prometheus.CounterVec
+	countryConns    *prometheus.CounterVec
 }
 
 func (m *serverMetrics) NewClient() {
@@ -53,6 +55,14 @@ func (m *serverMetrics) AddTrafficOut(name string, proxyType string, trafficByte
 	m.trafficOut.WithLabelValues(name, proxyType).Add(float64(trafficBytes))
 }
 
+func (m *serverMetrics) RejectConnection(name string, proxyType string, reason string) {
+	m.rejectedConns.WithLabelValues(name, proxyType, reason).Inc()
+}
+
+func (m *serverMetrics) AddCountryConnection(name string, proxyType string, country string) {
+	m.countryConns.WithLabelValues(name, proxyType, country).Inc()
+}
+
 func newServerMetrics() *serverMetrics {
 	m := &serverMetrics{
 		clientCount: prometheus.NewGauge(prometheus.GaugeOpts{
@@ -85,11 +95,25 @@ func newServerMetrics() *serverMetrics {
 			Name:      "traffic_out",
 			Help:      "The total out traffic",
 		}, []string{"name", "type"}),
+		rejectedConns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: serverSubsystem,
+			Name:      "rejected_connections",
+			Help:      "The total rejected connections",
+		}, []string{"name", "type", "reason"}),
+		countryConns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: serverSubsystem,
+			Name:      "country_connections",
+			Help:      "The total connections by source country",
+		}, []string{"name", "type", "country"}),
 	}
 	prometheus.MustRegister(m.clientCount)
 	prometheus.MustRegister(m.proxyCount)
 	prometheus.MustRegister(m.connectionCount)
 	prometheus.MustRegister(m.trafficIn)
 	prometheus.MustRegister(m.trafficOut)
+	prometheus.MustRegister(m.rejectedConns)
+	prometheus.MustRegister(m.countryConns)
 	return m
 }
@@ -1,6 +1,9 @@
 package msg
 
-import jsonMsg "github.com/fatedier/golib/msg/json"
+import (
+	jsonMsg "github.com/fatedier/golib/msg/json"
+	"io"
+)
 
 type Message = jsonMsg.Message
 
@@ -12,3 +15,15 @@ func init() {
 		msgCtl.RegisterMsg(typeByte, msg)
 	}
 }
+
+func ReadMsg(c io.Reader) (msg Message, err error) {
+	return msgCtl.ReadMsg(c)
+}
+
+func ReadMsgInto(c io.Reader, msg Message) (err error) {
+	return msgCtl.ReadMsgInto(c, msg)
+}
+
+func WriteMsg(c io.Writer, msg interface{}) (err error) {
+	return msgCtl.WriteMsg(c, msg)
+}
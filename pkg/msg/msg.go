@@ -44,7 +44,7 @@ var msgTypeMap = map[byte]interface{}{
 	TypeLogin:              Login{},
 	TypeLoginResp:          LoginResp{},
 	TypeNewProxy:           NewProxy{},
-	TypeNewProxyResp:       NewProxy{},
+	TypeNewProxyResp:       NewProxyResp{},
 	TypeCloseProxy:         CloseProxy{},
 	TypeNewWorkConn:        NewWorkConn{},
 	TypeReqWorkConn:        ReqWorkConn{},
@@ -105,6 +105,17 @@ type NewProxy struct {
 	BandWidthLimitMode string            `json:"band_width_limit_mode,omitempty"`
 	Group              string            `json:"group,omitempty"`
 	GroupKey           string            `json:"group_key,omitempty"`
+	GroupAlgorithm     string            `json:"group_algorithm,omitempty"`
+	GroupIdleTimeout   int               `json:"group_idle_timeout,omitempty"`
+	GroupPriority      int               `json:"group_priority,omitempty"`
+	GroupFailbackDelay int               `json:"group_failback_delay,omitempty"`
+	GroupWeight        int               `json:"group_weight,omitempty"`
+	GroupPinHeader     string            `json:"group_pin_header,omitempty"`
+	GroupPinCookie     string            `json:"group_pin_cookie,omitempty"`
+	GroupSticky        bool              `json:"group_sticky,omitempty"`
+	GroupStickyCookie  string            `json:"group_sticky_cookie,omitempty"`
+	GroupStickyTTL     int               `json:"group_sticky_ttl,omitempty"`
+	GroupHealthCheck   *GroupHealthCheck `json:"group_health_check,omitempty"`
 	Metas              map[string]string `json:"metas,omitempty"`
 	Annotations        map[string]string `json:"annotations,omitempty"`
 
@@ -121,6 +132,22 @@ type NewProxy struct {
 	Headers           map[string]string `json:"headers,omitempty"`
 	ResponseHeaders   map[string]string `json:"response_headers,omitempty"`
 	RouteByHTTPUser   string            `json:"route_by_http_user,omitempty"`
+	BackendHTTP2      bool              `json:"backend_http2,omitempty"`
+	RouteMatches      []HTTPRouteMatch  `json:"route_matches,omitempty"`
+	RoutePriority     int               `json:"route_priority,omitempty"`
+
+	// tcp, udp, http, https, tcpmux
+	AllowSourceIPs []string `json:"allow_source_ips,omitempty"`
+	DenySourceIPs  []string `json:"deny_source_ips,omitempty"`
+	AllowCountries []string `json:"allow_countries,omitempty"`
+	DenyCountries  []string `json:"deny_countries,omitempty"`
+
+	// 仅http
+	RateLimitRPS   float64   `json:"rate_limit_rps,omitempty"`
+	RateLimitBurst int       `json:"rate_limit_burst,omitempty"`
+	OIDC           *HTTPOIDC `json:"oidc,omitempty"`
+	HTPasswd       string    `json:"htpasswd,omitempty"`
+	ErrorPages     string    `json:"error_pages,omitempty"`
 
 	// stcp, sudp, xtcp
 	Sk         string   `json:"sk,omitempty"`
@@ -130,6 +157,31 @@ type NewProxy struct {
 	Multiplexer string `json:"multiplexer,omitempty"`
 }
 
+// GroupHealthCheck 是 frps 对组内代理执行的健康检查，Type 为 "tcp" 或 "http"。
+type GroupHealthCheck struct {
+	Type            string            `json:"type,omitempty"`
+	TimeoutSeconds  int               `json:"timeout_seconds,omitempty"`
+	MaxFailed       int               `json:"max_failed,omitempty"`
+	IntervalSeconds int               `json:"interval_seconds,omitempty"`
+	Path            string            `json:"path,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+}
+
+// HTTPOIDC 是 http 代理 OIDC 登录允许的用户，所有列表都为空时允许所有登录的用户。
+type HTTPOIDC struct {
+	AllowedEmailDomains []string `json:"allowed_email_domains,omitempty"`
+	AllowedEmails       []string `json:"allowed_emails,omitempty"`
+	AllowedGroups       []string `json:"allowed_groups,omitempty"`
+}
+
+// HTTPRouteMatch 是 http 代理的路由匹配条件，Type 为 "header"、"query" 或 "method"。
+type HTTPRouteMatch struct {
+	Type  string `json:"type,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value,omitempty"`
+	Regex bool   `json:"regex,omitempty"`
+}
+
 type NewProxyResp struct {
 	ProxyName  string `json:"proxy_name,omitempty"`
 	RemoteAddr string `json:"remote_addr,omitempty"`
@@ -169,6 +221,7 @@ type NewVisitorConn struct {
 type NewVisitorConnResp struct {
 	ProxyName string `json:"proxy_name,omitempty"`
 	Timestamp int64  `json:"timestamp,omitempty"`
+	Error     string `json:"error,omitempty"`
 }
 
 type Ping struct {
@@ -228,7 +281,7 @@ type NatHoleResp struct {
 	CandidateAddrs []string              `json:"candidate_addrs,omitempty"`
 	AssistedAddrs  []string              `json:"assisted_addrs,omitempty"`
 	DetectBehavior NatHoleDetectBehavior `json:"detect_behavior,omitempty"`
-	Error          error                 `json:"error"`
+	Error          string                `json:"error,omitempty"`
 }
 
 type NatHoleSid struct {
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/plugin/server/pluginpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"os"
+	"reflect"
+	"slices"
+	"time"
+)
+
+type grpcPlugin struct {
+	options v1.GRPCPluginOptions
+
+	conn grpc.ClientConnInterface
+}
+
+// NewGRPCPluginOptions 创建一个 gRPC 插件。
+// 底层连接是持久的，会在第一次调用时建立并在断开后自动重连。
+func NewGRPCPluginOptions(options v1.GRPCPluginOptions) (Plugin, error) {
+	creds := insecure.NewCredentials()
+	if options.TLS != nil {
+		tlsConfig, err := newGRPCPluginTLSConfig(options.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("plugin [%s]: %v", options.Name, err)
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	// Addr 可以是 "host:port"，也可以是 "unix:///path/to/plugin.sock" 形式的 unix socket 地址。
+	// gRPC 服务端默认要求 ping 间隔不小于 5 分钟且不接受没有活动流时的 ping，否则会以 too_many_pings 断开连接，
+	// 因此 keepalive 只在有调用进行时发送，间隔与服务端的默认限制相同。
+	conn, err := grpc.NewClient(options.Addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    5 * time.Minute,
+			Timeout: 20 * time.Second,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("plugin [%s]: create grpc client error: %v", options.Name, err)
+	}
+	return NewGRPCPlugin(options, conn), nil
+}
+
+// NewGRPCPlugin 使用已有的连接创建 gRPC 插件，例如连接到进程内的 gRPC 服务。
+func NewGRPCPlugin(options v1.GRPCPluginOptions, conn grpc.ClientConnInterface) Plugin {
+	return &grpcPlugin{
+		options: options,
+		conn:    conn,
+	}
+}
+
+func (p *grpcPlugin) Name() string {
+	return p.options.Name
+}
+
+func (p *grpcPlugin) Priority() int {
+	return p.options.Priority
+}
+
+func (p *grpcPlugin) IsSupport(op string) bool {
+	return slices.Contains(p.options.Ops, op)
+}
+
+func (p *grpcPlugin) Handle(ctx context.Context, op string, content interface{}) (*Response, interface{}, error) {
+	buf, err := json.Marshal(content)
+	if err != nil {
+		return nil, nil, err
+	}
+	req := &pluginpb.Request{
+		Version: APIVersion,
+		Op:      op,
+		Content: buf,
+	}
+
+	// 每个操作对应服务中的同名方法
+	resp := &pluginpb.Response{}
+	method := "/" + pluginpb.ServerPlugin_ServiceDesc.ServiceName + "/" + op
+	if err := p.conn.Invoke(ctx, method, req, resp); err != nil {
+		return nil, nil, err
+	}
+
+	res := &Response{
+		Reject:       resp.Reject,
+		RejectReason: resp.RejectReason,
+		Unchange:     resp.Unchange,
+	}
+	res.Content = reflect.New(reflect.TypeOf(content)).Interface()
+	if len(resp.Content) > 0 {
+		if err := json.Unmarshal(resp.Content, res.Content); err != nil {
+			return nil, nil, fmt.Errorf("unmarshal %s response content error: %v", op, err)
+		}
+	}
+	return res, res.Content, nil
+}
+
+func newGRPCPluginTLSConfig(c *v1.TLSConfig) (*tls.Config, error) {
+	base := &tls.Config{
+		ServerName: c.ServerName,
+	}
+	if c.CertFile != "" && c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		base.Certificates = []tls.Certificate{cert}
+	}
+	if c.TrustedCaFile != "" {
+		caCrt, err := os.ReadFile(c.TrustedCaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(caCrt)
+		base.RootCAs = pool
+	}
+	return base, nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/msg"
+	"github.com/sunyihoo/frp/pkg/plugin/server/pluginpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+)
+
+type testVisitorPlugin struct {
+	pluginpb.UnimplementedServerPluginServer
+}
+
+func (testVisitorPlugin) NewVisitorConn(_ context.Context, req *pluginpb.Request) (*pluginpb.Response, error) {
+	var content NewVisitorConnContent
+	if err := json.Unmarshal(req.Content, &content); err != nil {
+		return nil, err
+	}
+	if req.Version != APIVersion || req.Op != OpNewVisitorConn {
+		return &pluginpb.Response{Reject: true, RejectReason: "unexpected request"}, nil
+	}
+	if content.User.User == "blocked" {
+		return &pluginpb.Response{Reject: true, RejectReason: "user blocked"}, nil
+	}
+	content.UseEncryption = true
+	buf, _ := json.Marshal(&content)
+	return &pluginpb.Response{Content: buf}, nil
+}
+
+func newTestGRPCPlugin(t *testing.T, impl pluginpb.ServerPluginServer, ops []string) Plugin {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pluginpb.RegisterServerPluginServer(s, impl)
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewGRPCPlugin(v1.GRPCPluginOptions{Name: "test", Ops: ops}, conn)
+}
+
+func TestGRPCPluginHandle(t *testing.T) {
+	m := NewManager()
+	m.Register(newTestGRPCPlugin(t, testVisitorPlugin{}, []string{OpNewVisitorConn}))
+
+	content := &NewVisitorConnContent{
+		User:           UserInfo{User: "alice"},
+		NewVisitorConn: msg.NewVisitorConn{ProxyName: "alice.stcp"},
+	}
+	ret, err := m.NewVisitorConn(content)
+	if err != nil {
+		t.Fatalf("NewVisitorConn error: %v", err)
+	}
+	if ret.ProxyName != "alice.stcp" || !ret.UseEncryption {
+		t.Fatalf("content is not modified by plugin: %+v", ret)
+	}
+
+	content.User.User = "blocked"
+	if _, err := m.NewVisitorConn(content); err == nil || err.Error() != "user blocked" {
+		t.Fatalf("expected reject reason [user blocked], got %v", err)
+	}
+}
+
+func TestGRPCPluginUnimplementedOp(t *testing.T) {
+	p := newTestGRPCPlugin(t, testVisitorPlugin{}, []string{OpNatHoleVisitor})
+	_, _, err := p.Handle(context.Background(), OpNatHoleVisitor, NatHoleVisitorContent{})
+	if err == nil {
+		t.Fatal("expected error for op not implemented by the plugin")
+	}
+}
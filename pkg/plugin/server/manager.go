@@ -1,21 +1,272 @@
 package server
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"slices"
+	"strings"
+)
+
 type Manager struct {
-	loginPlugins       []Plugin
-	newProxyPlugins    []Plugin
-	closeProxyPlugins  []Plugin
-	pingPlugins        []Plugin
-	newWorkConnPlugins []Plugin
-	newUserConnPlugins []Plugin
+	// 所有已注册的插件，按注册顺序排列
+	plugins []Plugin
+
+	loginPlugins         []Plugin
+	newProxyPlugins      []Plugin
+	closeProxyPlugins    []Plugin
+	pingPlugins          []Plugin
+	newWorkConnPlugins   []Plugin
+	newUserConnPlugins   []Plugin
+	quotaExceededPlugins []Plugin
+	groupFailoverPlugins []Plugin
+
+	newVisitorConnPlugins []Plugin
+	natHoleVisitorPlugins []Plugin
+	closeUserConnPlugins  []Plugin
+	closeClientPlugins    []Plugin
 }
 
 func NewManager() *Manager {
 	return &Manager{
-		loginPlugins:       make([]Plugin, 0),
-		newProxyPlugins:    make([]Plugin, 0),
-		closeProxyPlugins:  make([]Plugin, 0),
-		pingPlugins:        make([]Plugin, 0),
-		newWorkConnPlugins: make([]Plugin, 0),
-		newUserConnPlugins: make([]Plugin, 0),
+		loginPlugins:         make([]Plugin, 0),
+		newProxyPlugins:      make([]Plugin, 0),
+		closeProxyPlugins:    make([]Plugin, 0),
+		pingPlugins:          make([]Plugin, 0),
+		newWorkConnPlugins:   make([]Plugin, 0),
+		newUserConnPlugins:   make([]Plugin, 0),
+		quotaExceededPlugins: make([]Plugin, 0),
+		groupFailoverPlugins: make([]Plugin, 0),
+
+		newVisitorConnPlugins: make([]Plugin, 0),
+		natHoleVisitorPlugins: make([]Plugin, 0),
+		closeUserConnPlugins:  make([]Plugin, 0),
+		closeClientPlugins:    make([]Plugin, 0),
+	}
+}
+
+// Register 注册插件。每个操作的插件按优先级从高到低调用，优先级相同的插件按注册顺序调用。
+func (m *Manager) Register(p Plugin) {
+	m.plugins = append(m.plugins, p)
+	if p.IsSupport(OpLogin) {
+		m.loginPlugins = insertByPriority(m.loginPlugins, p)
+	}
+	if p.IsSupport(OpNewProxy) {
+		m.newProxyPlugins = insertByPriority(m.newProxyPlugins, p)
+	}
+	if p.IsSupport(OpCloseProxy) {
+		m.closeProxyPlugins = insertByPriority(m.closeProxyPlugins, p)
+	}
+	if p.IsSupport(OpPing) {
+		m.pingPlugins = insertByPriority(m.pingPlugins, p)
+	}
+	if p.IsSupport(OpNewWorkConn) {
+		m.newWorkConnPlugins = insertByPriority(m.newWorkConnPlugins, p)
+	}
+	if p.IsSupport(OpNewUserConn) {
+		m.newUserConnPlugins = insertByPriority(m.newUserConnPlugins, p)
+	}
+	if p.IsSupport(OpQuotaExceeded) {
+		m.quotaExceededPlugins = insertByPriority(m.quotaExceededPlugins, p)
+	}
+	if p.IsSupport(OpGroupFailover) {
+		m.groupFailoverPlugins = insertByPriority(m.groupFailoverPlugins, p)
+	}
+	if p.IsSupport(OpNewVisitorConn) {
+		m.newVisitorConnPlugins = insertByPriority(m.newVisitorConnPlugins, p)
+	}
+	if p.IsSupport(OpNatHoleVisitor) {
+		m.natHoleVisitorPlugins = insertByPriority(m.natHoleVisitorPlugins, p)
+	}
+	if p.IsSupport(OpCloseUserConn) {
+		m.closeUserConnPlugins = insertByPriority(m.closeUserConnPlugins, p)
+	}
+	if p.IsSupport(OpCloseClient) {
+		m.closeClientPlugins = insertByPriority(m.closeClientPlugins, p)
+	}
+}
+
+func priorityOf(p Plugin) int {
+	if pp, ok := p.(PrioritizedPlugin); ok {
+		return pp.Priority()
+	}
+	return 0
+}
+
+// insertByPriority 将插件插入到所有优先级不低于它的插件之后。
+func insertByPriority(plugins []Plugin, p Plugin) []Plugin {
+	priority := priorityOf(p)
+	idx := len(plugins)
+	for i, v := range plugins {
+		if priorityOf(v) < priority {
+			idx = i
+			break
+		}
+	}
+	return slices.Insert(plugins, idx, p)
+}
+
+// GetStatus 返回所有已注册插件的状态，用于仪表板 API。
+func (m *Manager) GetStatus() []PluginStatus {
+	out := make([]PluginStatus, 0, len(m.plugins))
+	for _, p := range m.plugins {
+		if sp, ok := p.(StatusPlugin); ok {
+			out = append(out, sp.Status())
+			continue
+		}
+		out = append(out, PluginStatus{
+			Name:     p.Name(),
+			Priority: priorityOf(p),
+		})
+	}
+	return out
+}
+
+// QuotaExceeded 通知插件流量配额已超出，插件的返回内容会被忽略。
+func (m *Manager) QuotaExceeded(content *QuotaExceededContent) error {
+	if len(m.quotaExceededPlugins) == 0 {
+		return nil
+	}
+
+	errs := make([]string, 0)
+	ctx := context.Background()
+	for _, p := range m.quotaExceededPlugins {
+		_, _, err := p.Handle(ctx, OpQuotaExceeded, *content)
+		if err != nil {
+			log.Warnf("send QuotaExceeded request to plugin [%s] error: %v", p.Name(), err)
+			errs = append(errs, fmt.Sprintf("[%s]: %v", p.Name(), err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("send QuotaExceeded request to plugin errors: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// GroupFailover 通知插件负载均衡组已切换活动代理，插件的返回内容会被忽略。
+func (m *Manager) GroupFailover(content *GroupFailoverContent) error {
+	if len(m.groupFailoverPlugins) == 0 {
+		return nil
+	}
+
+	errs := make([]string, 0)
+	ctx := context.Background()
+	for _, p := range m.groupFailoverPlugins {
+		_, _, err := p.Handle(ctx, OpGroupFailover, *content)
+		if err != nil {
+			log.Warnf("send GroupFailover request to plugin [%s] error: %v", p.Name(), err)
+			errs = append(errs, fmt.Sprintf("[%s]: %v", p.Name(), err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("send GroupFailover request to plugin errors: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (m *Manager) NewVisitorConn(content *NewVisitorConnContent) (*NewVisitorConnContent, error) {
+	if len(m.newVisitorConnPlugins) == 0 {
+		return content, nil
+	}
+
+	var (
+		res = &Response{
+			Reject:   false,
+			Unchange: true,
+		}
+		retContent interface{}
+		err        error
+	)
+	ctx := context.Background()
+	for _, p := range m.newVisitorConnPlugins {
+		res, retContent, err = p.Handle(ctx, OpNewVisitorConn, *content)
+		if err != nil {
+			log.Infof("send NewVisitorConn request to plugin [%s] error: %v", p.Name(), err)
+			return nil, errors.New("send NewVisitorConn request to plugin error")
+		}
+		if res.Reject {
+			return nil, fmt.Errorf("%s", res.RejectReason)
+		}
+		if !res.Unchange {
+			content = retContent.(*NewVisitorConnContent)
+		}
+	}
+	return content, nil
+}
+
+func (m *Manager) NatHoleVisitor(content *NatHoleVisitorContent) (*NatHoleVisitorContent, error) {
+	if len(m.natHoleVisitorPlugins) == 0 {
+		return content, nil
+	}
+
+	var (
+		res = &Response{
+			Reject:   false,
+			Unchange: true,
+		}
+		retContent interface{}
+		err        error
+	)
+	ctx := context.Background()
+	for _, p := range m.natHoleVisitorPlugins {
+		res, retContent, err = p.Handle(ctx, OpNatHoleVisitor, *content)
+		if err != nil {
+			log.Infof("send NatHoleVisitor request to plugin [%s] error: %v", p.Name(), err)
+			return nil, errors.New("send NatHoleVisitor request to plugin error")
+		}
+		if res.Reject {
+			return nil, fmt.Errorf("%s", res.RejectReason)
+		}
+		if !res.Unchange {
+			content = retContent.(*NatHoleVisitorContent)
+		}
+	}
+	return content, nil
+}
+
+// CloseUserConn 通知插件用户连接已关闭，插件的返回内容会被忽略。
+func (m *Manager) CloseUserConn(content *CloseUserConnContent) error {
+	if len(m.closeUserConnPlugins) == 0 {
+		return nil
+	}
+
+	errs := make([]string, 0)
+	ctx := context.Background()
+	for _, p := range m.closeUserConnPlugins {
+		_, _, err := p.Handle(ctx, OpCloseUserConn, *content)
+		if err != nil {
+			log.Warnf("send CloseUserConn request to plugin [%s] error: %v", p.Name(), err)
+			errs = append(errs, fmt.Sprintf("[%s]: %v", p.Name(), err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("send CloseUserConn request to plugin errors: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// CloseClient 通知插件客户端已断开，插件的返回内容会被忽略。
+func (m *Manager) CloseClient(content *CloseClientContent) error {
+	if len(m.closeClientPlugins) == 0 {
+		return nil
+	}
+
+	errs := make([]string, 0)
+	ctx := context.Background()
+	for _, p := range m.closeClientPlugins {
+		_, _, err := p.Handle(ctx, OpCloseClient, *content)
+		if err != nil {
+			log.Warnf("send CloseClient request to plugin [%s] error: %v", p.Name(), err)
+			errs = append(errs, fmt.Sprintf("[%s]: %v", p.Name(), err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("send CloseClient request to plugin errors: %s", strings.Join(errs, "; "))
 	}
+	return nil
 }
@@ -3,12 +3,21 @@ package server
 import "context"
 
 const (
-	OpLogin       = "Login"
-	OpNewProxy    = "NewProxy"
-	OpCloseProxy  = "CloseProxy"
-	OpPing        = "Ping"
-	OpNewWorkConn = "NewWorkConn"
-	OpNewUserConn = "NewUserConn"
+	APIVersion = "0.1.0"
+
+	OpLogin         = "Login"
+	OpNewProxy      = "NewProxy"
+	OpCloseProxy    = "CloseProxy"
+	OpPing          = "Ping"
+	OpNewWorkConn   = "NewWorkConn"
+	OpNewUserConn   = "NewUserConn"
+	OpQuotaExceeded = "QuotaExceeded"
+	OpGroupFailover = "GroupFailover"
+
+	OpNewVisitorConn = "NewVisitorConn"
+	OpNatHoleVisitor = "NatHoleVisitor"
+	OpCloseUserConn  = "CloseUserConn"
+	OpCloseClient    = "CloseClient"
 )
 
 type Plugin interface {
@@ -0,0 +1,538 @@
+// frps 服务端插件的 gRPC 服务定义。
+//
+// 生成代码：
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative plugin.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             v4.25.3
+// source: plugin.proto
+
+package pluginpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	ServerPlugin_Login_FullMethodName          = "/frp.plugin.server.ServerPlugin/Login"
+	ServerPlugin_NewProxy_FullMethodName       = "/frp.plugin.server.ServerPlugin/NewProxy"
+	ServerPlugin_CloseProxy_FullMethodName     = "/frp.plugin.server.ServerPlugin/CloseProxy"
+	ServerPlugin_Ping_FullMethodName           = "/frp.plugin.server.ServerPlugin/Ping"
+	ServerPlugin_NewWorkConn_FullMethodName    = "/frp.plugin.server.ServerPlugin/NewWorkConn"
+	ServerPlugin_NewUserConn_FullMethodName    = "/frp.plugin.server.ServerPlugin/NewUserConn"
+	ServerPlugin_QuotaExceeded_FullMethodName  = "/frp.plugin.server.ServerPlugin/QuotaExceeded"
+	ServerPlugin_GroupFailover_FullMethodName  = "/frp.plugin.server.ServerPlugin/GroupFailover"
+	ServerPlugin_NewVisitorConn_FullMethodName = "/frp.plugin.server.ServerPlugin/NewVisitorConn"
+	ServerPlugin_NatHoleVisitor_FullMethodName = "/frp.plugin.server.ServerPlugin/NatHoleVisitor"
+	ServerPlugin_CloseUserConn_FullMethodName  = "/frp.plugin.server.ServerPlugin/CloseUserConn"
+	ServerPlugin_CloseClient_FullMethodName    = "/frp.plugin.server.ServerPlugin/CloseClient"
+)
+
+// ServerPluginClient is the client API for ServerPlugin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ServerPlugin 为每个插件操作提供一个方法，方法名与操作名相同。
+type ServerPluginClient interface {
+	Login(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	NewProxy(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	CloseProxy(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	Ping(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	NewWorkConn(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	NewUserConn(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	QuotaExceeded(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	GroupFailover(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	NewVisitorConn(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	NatHoleVisitor(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	CloseUserConn(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	CloseClient(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+}
+
+type serverPluginClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewServerPluginClient(cc grpc.ClientConnInterface) ServerPluginClient {
+	return &serverPluginClient{cc}
+}
+
+func (c *serverPluginClient) Login(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Response)
+	err := c.cc.Invoke(ctx, ServerPlugin_Login_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serverPluginClient) NewProxy(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Response)
+	err := c.cc.Invoke(ctx, ServerPlugin_NewProxy_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serverPluginClient) CloseProxy(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Response)
+	err := c.cc.Invoke(ctx, ServerPlugin_CloseProxy_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serverPluginClient) Ping(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Response)
+	err := c.cc.Invoke(ctx, ServerPlugin_Ping_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serverPluginClient) NewWorkConn(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Response)
+	err := c.cc.Invoke(ctx, ServerPlugin_NewWorkConn_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serverPluginClient) NewUserConn(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Response)
+	err := c.cc.Invoke(ctx, ServerPlugin_NewUserConn_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serverPluginClient) QuotaExceeded(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Response)
+	err := c.cc.Invoke(ctx, ServerPlugin_QuotaExceeded_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serverPluginClient) GroupFailover(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Response)
+	err := c.cc.Invoke(ctx, ServerPlugin_GroupFailover_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serverPluginClient) NewVisitorConn(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Response)
+	err := c.cc.Invoke(ctx, ServerPlugin_NewVisitorConn_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serverPluginClient) NatHoleVisitor(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Response)
+	err := c.cc.Invoke(ctx, ServerPlugin_NatHoleVisitor_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serverPluginClient) CloseUserConn(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Response)
+	err := c.cc.Invoke(ctx, ServerPlugin_CloseUserConn_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serverPluginClient) CloseClient(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Response)
+	err := c.cc.Invoke(ctx, ServerPlugin_CloseClient_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ServerPluginServer is the server API for ServerPlugin service.
+// All implementations must embed UnimplementedServerPluginServer
+// for forward compatibility
+//
+// ServerPlugin 为每个插件操作提供一个方法，方法名与操作名相同。
+type ServerPluginServer interface {
+	Login(context.Context, *Request) (*Response, error)
+	NewProxy(context.Context, *Request) (*Response, error)
+	CloseProxy(context.Context, *Request) (*Response, error)
+	Ping(context.Context, *Request) (*Response, error)
+	NewWorkConn(context.Context, *Request) (*Response, error)
+	NewUserConn(context.Context, *Request) (*Response, error)
+	QuotaExceeded(context.Context, *Request) (*Response, error)
+	GroupFailover(context.Context, *Request) (*Response, error)
+	NewVisitorConn(context.Context, *Request) (*Response, error)
+	NatHoleVisitor(context.Context, *Request) (*Response, error)
+	CloseUserConn(context.Context, *Request) (*Response, error)
+	CloseClient(context.Context, *Request) (*Response, error)
+	mustEmbedUnimplementedServerPluginServer()
+}
+
+// UnimplementedServerPluginServer must be embedded to have forward compatible implementations.
+type UnimplementedServerPluginServer struct {
+}
+
+func (UnimplementedServerPluginServer) Login(context.Context, *Request) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Login not implemented")
+}
+func (UnimplementedServerPluginServer) NewProxy(context.Context, *Request) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NewProxy not implemented")
+}
+func (UnimplementedServerPluginServer) CloseProxy(context.Context, *Request) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloseProxy not implemented")
+}
+func (UnimplementedServerPluginServer) Ping(context.Context, *Request) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
+}
+func (UnimplementedServerPluginServer) NewWorkConn(context.Context, *Request) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NewWorkConn not implemented")
+}
+func (UnimplementedServerPluginServer) NewUserConn(context.Context, *Request) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NewUserConn not implemented")
+}
+func (UnimplementedServerPluginServer) QuotaExceeded(context.Context, *Request) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QuotaExceeded not implemented")
+}
+func (UnimplementedServerPluginServer) GroupFailover(context.Context, *Request) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GroupFailover not implemented")
+}
+func (UnimplementedServerPluginServer) NewVisitorConn(context.Context, *Request) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NewVisitorConn not implemented")
+}
+func (UnimplementedServerPluginServer) NatHoleVisitor(context.Context, *Request) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NatHoleVisitor not implemented")
+}
+func (UnimplementedServerPluginServer) CloseUserConn(context.Context, *Request) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloseUserConn not implemented")
+}
+func (UnimplementedServerPluginServer) CloseClient(context.Context, *Request) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloseClient not implemented")
+}
+func (UnimplementedServerPluginServer) mustEmbedUnimplementedServerPluginServer() {}
+
+// UnsafeServerPluginServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ServerPluginServer will
+// result in compilation errors.
+type UnsafeServerPluginServer interface {
+	mustEmbedUnimplementedServerPluginServer()
+}
+
+func RegisterServerPluginServer(s grpc.ServiceRegistrar, srv ServerPluginServer) {
+	s.RegisterService(&ServerPlugin_ServiceDesc, srv)
+}
+
+func _ServerPlugin_Login_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerPluginServer).Login(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ServerPlugin_Login_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerPluginServer).Login(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ServerPlugin_NewProxy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerPluginServer).NewProxy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ServerPlugin_NewProxy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerPluginServer).NewProxy(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ServerPlugin_CloseProxy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerPluginServer).CloseProxy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ServerPlugin_CloseProxy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerPluginServer).CloseProxy(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ServerPlugin_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerPluginServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ServerPlugin_Ping_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerPluginServer).Ping(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ServerPlugin_NewWorkConn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerPluginServer).NewWorkConn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ServerPlugin_NewWorkConn_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerPluginServer).NewWorkConn(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ServerPlugin_NewUserConn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerPluginServer).NewUserConn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ServerPlugin_NewUserConn_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerPluginServer).NewUserConn(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ServerPlugin_QuotaExceeded_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerPluginServer).QuotaExceeded(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ServerPlugin_QuotaExceeded_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerPluginServer).QuotaExceeded(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ServerPlugin_GroupFailover_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerPluginServer).GroupFailover(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ServerPlugin_GroupFailover_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerPluginServer).GroupFailover(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ServerPlugin_NewVisitorConn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerPluginServer).NewVisitorConn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ServerPlugin_NewVisitorConn_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerPluginServer).NewVisitorConn(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ServerPlugin_NatHoleVisitor_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerPluginServer).NatHoleVisitor(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ServerPlugin_NatHoleVisitor_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerPluginServer).NatHoleVisitor(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ServerPlugin_CloseUserConn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerPluginServer).CloseUserConn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ServerPlugin_CloseUserConn_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerPluginServer).CloseUserConn(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ServerPlugin_CloseClient_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerPluginServer).CloseClient(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ServerPlugin_CloseClient_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerPluginServer).CloseClient(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ServerPlugin_ServiceDesc is the grpc.ServiceDesc for ServerPlugin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ServerPlugin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "frp.plugin.server.ServerPlugin",
+	HandlerType: (*ServerPluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Login",
+			Handler:    _ServerPlugin_Login_Handler,
+		},
+		{
+			MethodName: "NewProxy",
+			Handler:    _ServerPlugin_NewProxy_Handler,
+		},
+		{
+			MethodName: "CloseProxy",
+			Handler:    _ServerPlugin_CloseProxy_Handler,
+		},
+		{
+			MethodName: "Ping",
+			Handler:    _ServerPlugin_Ping_Handler,
+		},
+		{
+			MethodName: "NewWorkConn",
+			Handler:    _ServerPlugin_NewWorkConn_Handler,
+		},
+		{
+			MethodName: "NewUserConn",
+			Handler:    _ServerPlugin_NewUserConn_Handler,
+		},
+		{
+			MethodName: "QuotaExceeded",
+			Handler:    _ServerPlugin_QuotaExceeded_Handler,
+		},
+		{
+			MethodName: "GroupFailover",
+			Handler:    _ServerPlugin_GroupFailover_Handler,
+		},
+		{
+			MethodName: "NewVisitorConn",
+			Handler:    _ServerPlugin_NewVisitorConn_Handler,
+		},
+		{
+			MethodName: "NatHoleVisitor",
+			Handler:    _ServerPlugin_NatHoleVisitor_Handler,
+		},
+		{
+			MethodName: "CloseUserConn",
+			Handler:    _ServerPlugin_CloseUserConn_Handler,
+		},
+		{
+			MethodName: "CloseClient",
+			Handler:    _ServerPlugin_CloseClient_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin.proto",
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitStateClosed   = "closed"
+	CircuitStateOpen     = "open"
+	CircuitStateHalfOpen = "half-open"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// PrioritizedPlugin 可以由插件实现以指定调用顺序，优先级高的插件先被调用。
+type PrioritizedPlugin interface {
+	Priority() int
+}
+
+// StatusPlugin 可以由插件实现以在仪表板中展示运行状态。
+type StatusPlugin interface {
+	Status() PluginStatus
+}
+
+type CircuitBreakerStatus struct {
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	OpenedAt            time.Time `json:"openedAt,omitempty"`
+}
+
+type PluginStatus struct {
+	Name           string                `json:"name"`
+	Priority       int                   `json:"priority"`
+	FailurePolicy  string                `json:"failurePolicy,omitempty"`
+	CircuitBreaker *CircuitBreakerStatus `json:"circuitBreaker,omitempty"`
+}
+
+// circuitBreaker 在连续失败达到阈值后打开，打开期间直接拒绝请求；
+// 超过 openTimeout 后进入半开状态，只放行一个试探请求，成功则关闭，失败则重新打开。
+type circuitBreaker struct {
+	threshold   int
+	openTimeout time.Duration
+
+	state            string
+	failures         int
+	openedAt         time.Time
+	halfOpenInflight bool
+	mu               sync.Mutex
+}
+
+func newCircuitBreaker(c *v1.CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{
+		threshold:   c.FailureThreshold,
+		openTimeout: time.Duration(c.OpenSeconds) * time.Second,
+		state:       CircuitStateClosed,
+	}
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitStateOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.state = CircuitStateHalfOpen
+		b.halfOpenInflight = true
+		return true
+	case CircuitStateHalfOpen:
+		if b.halfOpenInflight {
+			return false
+		}
+		b.halfOpenInflight = true
+		return true
+	}
+	return true
+}
+
+func (b *circuitBreaker) onSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = CircuitStateClosed
+	b.failures = 0
+	b.halfOpenInflight = false
+}
+
+// onFailure 返回断路器是否因本次失败而打开。
+func (b *circuitBreaker) onFailure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.halfOpenInflight = false
+	if b.state == CircuitStateHalfOpen || b.failures >= b.threshold {
+		opened := b.state != CircuitStateOpen
+		b.state = CircuitStateOpen
+		b.openedAt = time.Now()
+		return opened
+	}
+	return false
+}
+
+func (b *circuitBreaker) status() *CircuitBreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &CircuitBreakerStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+	}
+	if b.state != CircuitStateClosed {
+		s.OpenedAt = b.openedAt
+	}
+	return s
+}
+
+// policyPlugin 为插件增加超时、重试、失败策略和断路器。
+type policyPlugin struct {
+	Plugin
+
+	timeout       time.Duration
+	maxRetries    int
+	backoff       time.Duration
+	failurePolicy string
+	priority      int
+	breaker       *circuitBreaker
+}
+
+func newPolicyPlugin(p Plugin, options v1.HTTPPluginOptions) Plugin {
+	pp := &policyPlugin{
+		Plugin:        p,
+		timeout:       time.Duration(options.TimeoutMilliseconds) * time.Millisecond,
+		maxRetries:    options.MaxRetries,
+		backoff:       time.Duration(options.RetryBackoffMilliseconds) * time.Millisecond,
+		failurePolicy: options.FailurePolicy,
+		priority:      options.Priority,
+	}
+	if options.CircuitBreaker != nil {
+		pp.breaker = newCircuitBreaker(options.CircuitBreaker)
+	}
+	return pp
+}
+
+func (p *policyPlugin) Priority() int {
+	return p.priority
+}
+
+func (p *policyPlugin) Status() PluginStatus {
+	s := PluginStatus{
+		Name:          p.Name(),
+		Priority:      p.priority,
+		FailurePolicy: p.failurePolicy,
+	}
+	if p.breaker != nil {
+		s.CircuitBreaker = p.breaker.status()
+	}
+	return s
+}
+
+func (p *policyPlugin) Handle(ctx context.Context, op string, content interface{}) (*Response, interface{}, error) {
+	if p.breaker != nil && !p.breaker.allow() {
+		return p.onError(op, content, ErrCircuitOpen)
+	}
+
+	var err error
+	for i := 0; i <= p.maxRetries; i++ {
+		if i > 0 {
+			// 指数退避：backoff, 2*backoff, 4*backoff ...
+			select {
+			case <-time.After(p.backoff << (i - 1)):
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		var (
+			res        *Response
+			retContent interface{}
+		)
+		res, retContent, err = p.handleOnce(ctx, op, content)
+		if err == nil {
+			if p.breaker != nil {
+				p.breaker.onSuccess()
+			}
+			return res, retContent, nil
+		}
+	}
+
+	if p.breaker != nil && p.breaker.onFailure() {
+		log.Warnf("plugin [%s] circuit breaker opened after error: %v", p.Name(), err)
+	}
+	return p.onError(op, content, err)
+}
+
+func (p *policyPlugin) handleOnce(ctx context.Context, op string, content interface{}) (*Response, interface{}, error) {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	return p.Plugin.Handle(ctx, op, content)
+}
+
+// onError 根据失败策略处理插件错误。
+// fail-open 时视为插件未修改内容并放行，fail-closed 时返回错误，调用方会因此拒绝请求。
+func (p *policyPlugin) onError(op string, content interface{}, err error) (*Response, interface{}, error) {
+	if p.failurePolicy == v1.PluginFailurePolicyFailOpen {
+		log.Warnf("plugin [%s] %s error, fail open: %v", p.Name(), op, err)
+		return &Response{Unchange: true}, content, nil
+	}
+	return nil, nil, fmt.Errorf("plugin [%s] %s error: %v", p.Name(), op, err)
+}
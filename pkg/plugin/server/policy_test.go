@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"errors"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testFlakyPlugin 在前 failures 次调用时返回错误，之后返回成功
+type testFlakyPlugin struct {
+	name     string
+	priority int
+	failures int
+
+	calls []time.Time
+	mu    sync.Mutex
+}
+
+func (p *testFlakyPlugin) Name() string             { return p.name }
+func (p *testFlakyPlugin) IsSupport(op string) bool { return op == OpNewVisitorConn }
+func (p *testFlakyPlugin) Priority() int            { return p.priority }
+
+func (p *testFlakyPlugin) Handle(ctx context.Context, op string, content interface{}) (*Response, interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, time.Now())
+	if len(p.calls) <= p.failures {
+		return nil, nil, errors.New("backend unavailable")
+	}
+	return &Response{Unchange: true}, content, nil
+}
+
+func (p *testFlakyPlugin) callTimes() []time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]time.Time(nil), p.calls...)
+}
+
+func TestPolicyPluginRetryBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		failures  int
+		retries   int
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", 0, 3, 1, false},
+		{"success after retries", 2, 3, 3, false},
+		{"retries exhausted", 10, 3, 4, true},
+		{"no retry", 10, 0, 1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fp := &testFlakyPlugin{name: "flaky", failures: tc.failures}
+			p := newPolicyPlugin(fp, v1.HTTPPluginOptions{
+				MaxRetries:               tc.retries,
+				RetryBackoffMilliseconds: 20,
+				FailurePolicy:            v1.PluginFailurePolicyFailClosed,
+			})
+			_, _, err := p.Handle(context.Background(), OpNewVisitorConn, NewVisitorConnContent{})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tc.wantErr)
+			}
+			calls := fp.callTimes()
+			if len(calls) != tc.wantCalls {
+				t.Fatalf("got %d calls, want %d", len(calls), tc.wantCalls)
+			}
+			// 第 i 次重试前等待 backoff << (i-1)
+			for i := 1; i < len(calls); i++ {
+				want := 20 * time.Millisecond << (i - 1)
+				if gap := calls[i].Sub(calls[i-1]); gap < want {
+					t.Errorf("retry %d after %v, want at least %v", i, gap, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyPluginRetryCanceled(t *testing.T) {
+	fp := &testFlakyPlugin{name: "flaky", failures: 10}
+	p := newPolicyPlugin(fp, v1.HTTPPluginOptions{MaxRetries: 5, RetryBackoffMilliseconds: 1000})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, _, err := p.Handle(ctx, OpNewVisitorConn, NewVisitorConnContent{}); err == nil {
+		t.Fatal("canceled request should fail")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("retries continued for %v after the context was canceled", elapsed)
+	}
+	if calls := len(fp.callTimes()); calls != 1 {
+		t.Fatalf("got %d calls, want 1", calls)
+	}
+}
+
+func TestPolicyPluginFailurePolicy(t *testing.T) {
+	content := NewVisitorConnContent{User: UserInfo{User: "alice"}}
+
+	p := newPolicyPlugin(&testFlakyPlugin{name: "flaky", failures: 10},
+		v1.HTTPPluginOptions{FailurePolicy: v1.PluginFailurePolicyFailOpen})
+	res, out, err := p.Handle(context.Background(), OpNewVisitorConn, content)
+	if err != nil {
+		t.Fatalf("fail open should not return error: %v", err)
+	}
+	if res.Reject || !res.Unchange || out.(NewVisitorConnContent).User.User != "alice" {
+		t.Fatalf("fail open got %+v %+v, want unchanged content", res, out)
+	}
+
+	p = newPolicyPlugin(&testFlakyPlugin{name: "flaky", failures: 10},
+		v1.HTTPPluginOptions{FailurePolicy: v1.PluginFailurePolicyFailClosed})
+	if res, _, err := p.Handle(context.Background(), OpNewVisitorConn, content); err == nil || res != nil {
+		t.Fatalf("fail closed got %+v %v, want error", res, err)
+	}
+}
+
+func TestPolicyPluginCircuitBreaker(t *testing.T) {
+	fp := &testFlakyPlugin{name: "flaky", failures: 3}
+	p := newPolicyPlugin(fp, v1.HTTPPluginOptions{
+		FailurePolicy:  v1.PluginFailurePolicyFailClosed,
+		CircuitBreaker: &v1.CircuitBreakerConfig{FailureThreshold: 2, OpenSeconds: 30},
+	}).(*policyPlugin)
+	handle := func() error {
+		_, _, err := p.Handle(context.Background(), OpNewVisitorConn, NewVisitorConnContent{})
+		return err
+	}
+	state := func() string { return p.Status().CircuitBreaker.State }
+
+	// 连续失败达到阈值后打开
+	_ = handle()
+	if state() != CircuitStateClosed {
+		t.Fatalf("got state %s after one failure, want closed", state())
+	}
+	_ = handle()
+	if state() != CircuitStateOpen {
+		t.Fatalf("got state %s after two failures, want open", state())
+	}
+
+	// 打开期间不调用插件
+	if err := handle(); err == nil || !strings.Contains(err.Error(), ErrCircuitOpen.Error()) {
+		t.Fatalf("got error %v while open, want circuit open", err)
+	}
+	if calls := len(fp.callTimes()); calls != 2 {
+		t.Fatalf("got %d calls while open, want 2", calls)
+	}
+
+	// 超时后进入半开状态，试探请求失败则重新打开
+	expire := func() {
+		p.breaker.mu.Lock()
+		p.breaker.openedAt = time.Now().Add(-time.Minute)
+		p.breaker.mu.Unlock()
+	}
+	expire()
+	if err := handle(); err == nil {
+		t.Fatal("failed probe should return error")
+	}
+	if state() != CircuitStateOpen || len(fp.callTimes()) != 3 {
+		t.Fatalf("got state %s with %d calls after a failed probe, want open with 3", state(), len(fp.callTimes()))
+	}
+
+	// 试探请求成功后关闭
+	expire()
+	if err := handle(); err != nil {
+		t.Fatalf("got error %v for a successful probe", err)
+	}
+	if s := p.Status().CircuitBreaker; s.State != CircuitStateClosed || s.ConsecutiveFailures != 0 {
+		t.Fatalf("got %+v after a successful probe, want closed without failures", s)
+	}
+}
+
+func TestCircuitBreakerHalfOpenSingleProbe(t *testing.T) {
+	b := newCircuitBreaker(&v1.CircuitBreakerConfig{FailureThreshold: 1, OpenSeconds: 30})
+	if !b.onFailure() {
+		t.Fatal("breaker should open at the threshold")
+	}
+	if b.onFailure() {
+		t.Fatal("breaker already open should not report opening again")
+	}
+	if b.allow() {
+		t.Fatal("open breaker should reject requests before the open timeout")
+	}
+
+	b.openedAt = time.Now().Add(-time.Minute)
+	if !b.allow() {
+		t.Fatal("first request after the open timeout should be allowed")
+	}
+	if b.status().State != CircuitStateHalfOpen {
+		t.Fatalf("got state %s, want half-open", b.status().State)
+	}
+	if b.allow() {
+		t.Fatal("half-open breaker should allow only one probe")
+	}
+	b.onSuccess()
+	if !b.allow() || !b.allow() {
+		t.Fatal("closed breaker should allow all requests")
+	}
+}
+
+func TestInsertByPriority(t *testing.T) {
+	var plugins []Plugin
+	for _, p := range []*testFlakyPlugin{
+		{name: "a", priority: 0},
+		{name: "b", priority: 10},
+		{name: "c", priority: 0},
+		{name: "d", priority: 5},
+		{name: "e", priority: 10},
+		{name: "f", priority: -1},
+	} {
+		plugins = insertByPriority(plugins, p)
+	}
+	// 优先级高的在前，相同优先级按注册顺序
+	want := []string{"b", "e", "d", "a", "c", "f"}
+	for i, p := range plugins {
+		if p.Name() != want[i] {
+			t.Fatalf("got order %v, want %v", pluginNames(plugins), want)
+		}
+	}
+
+	m := NewManager()
+	for _, p := range []*testFlakyPlugin{{name: "low", priority: 1}, {name: "high", priority: 2}} {
+		m.Register(p)
+	}
+	if got := pluginNames(m.newVisitorConnPlugins); len(got) != 2 || got[0] != "high" || got[1] != "low" {
+		t.Fatalf("got manager order %v, want [high low]", got)
+	}
+}
+
+func pluginNames(plugins []Plugin) []string {
+	names := make([]string, 0, len(plugins))
+	for _, p := range plugins {
+		names = append(names, p.Name())
+	}
+	return names
+}
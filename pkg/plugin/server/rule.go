@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// notifyOps 中的操作只用于通知，插件返回的内容会被忽略，因此规则不能修改它们的字段。
+var notifyOps = []string{
+	OpCloseProxy,
+	OpQuotaExceeded,
+	OpGroupFailover,
+	OpCloseUserConn,
+	OpCloseClient,
+}
+
+type fieldSetter struct {
+	path    []string
+	program *vm.Program
+}
+
+type compiledRule struct {
+	cfg     v1.RuleConfig
+	when    *vm.Program
+	setters []fieldSetter
+}
+
+type rulePlugin struct {
+	options v1.RulePluginOptions
+	rules   []*compiledRule
+}
+
+// NewRulePluginOptions 编译插件中的所有规则，任何一条规则无法编译都会返回错误，
+// 因此配置中的错误在加载配置时就能发现，而不是在处理请求时。
+func NewRulePluginOptions(options v1.RulePluginOptions) (Plugin, error) {
+	p := &rulePlugin{
+		options: options,
+	}
+	for i, cfg := range options.Rules {
+		r, err := p.compile(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("plugin [%s] rule %d: %v", options.Name, i, err)
+		}
+		p.rules = append(p.rules, r)
+	}
+	return p, nil
+}
+
+// ruleEnv 描述表达式可以使用的变量，编译时用于检查未定义的变量。
+func ruleEnv() map[string]interface{} {
+	return map[string]interface{}{
+		"op":      "",
+		"content": map[string]interface{}{},
+	}
+}
+
+func (p *rulePlugin) compile(cfg v1.RuleConfig) (*compiledRule, error) {
+	ops := p.options.Ops
+	if cfg.Op != "" {
+		if !slices.Contains(p.options.Ops, cfg.Op) {
+			return nil, fmt.Errorf("op [%s] is not in the ops of plugin", cfg.Op)
+		}
+		ops = []string{cfg.Op}
+	}
+
+	r := &compiledRule{cfg: cfg}
+	if cfg.When != "" {
+		program, err := expr.Compile(cfg.When, expr.Env(ruleEnv()), expr.AsBool())
+		if err != nil {
+			return nil, fmt.Errorf("compile when expression error: %v", err)
+		}
+		r.when = program
+	}
+
+	switch cfg.Action {
+	case v1.RuleActionReject, v1.RuleActionAllow:
+		if len(cfg.Set) > 0 {
+			return nil, fmt.Errorf("set is only allowed when action is %s", v1.RuleActionSet)
+		}
+	case v1.RuleActionSet:
+		if len(cfg.Set) == 0 {
+			return nil, fmt.Errorf("set should not be empty when action is %s", v1.RuleActionSet)
+		}
+		for _, op := range ops {
+			if slices.Contains(notifyOps, op) {
+				return nil, fmt.Errorf("op [%s] does not support modifying content", op)
+			}
+		}
+		// 按字段路径排序，保证修改的顺序是确定的
+		paths := make([]string, 0, len(cfg.Set))
+		for path := range cfg.Set {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			fields := strings.Split(path, ".")
+			if slices.Contains(fields, "") {
+				return nil, fmt.Errorf("invalid field path [%s]", path)
+			}
+			program, err := expr.Compile(cfg.Set[path], expr.Env(ruleEnv()))
+			if err != nil {
+				return nil, fmt.Errorf("compile set expression of [%s] error: %v", path, err)
+			}
+			r.setters = append(r.setters, fieldSetter{path: fields, program: program})
+		}
+	default:
+		return nil, fmt.Errorf("invalid action [%s]", cfg.Action)
+	}
+	return r, nil
+}
+
+func (p *rulePlugin) Name() string {
+	return p.options.Name
+}
+
+func (p *rulePlugin) Priority() int {
+	return p.options.Priority
+}
+
+func (p *rulePlugin) IsSupport(op string) bool {
+	return slices.Contains(p.options.Ops, op)
+}
+
+func (p *rulePlugin) Handle(_ context.Context, op string, content interface{}) (*Response, interface{}, error) {
+	// 与 HTTP 插件一样，表达式看到的是操作内容的 JSON 形式
+	buf, err := json.Marshal(content)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := make(map[string]interface{})
+	if err := json.Unmarshal(buf, &data); err != nil {
+		return nil, nil, err
+	}
+	env := map[string]interface{}{
+		"op":      op,
+		"content": data,
+	}
+
+	changed := false
+loop:
+	for i, r := range p.rules {
+		if r.cfg.Op != "" && r.cfg.Op != op {
+			continue
+		}
+		if r.when != nil {
+			out, err := expr.Run(r.when, env)
+			if err != nil {
+				return nil, nil, fmt.Errorf("rule %d: evaluate when expression error: %v", i, err)
+			}
+			if matched, _ := out.(bool); !matched {
+				continue
+			}
+		}
+
+		switch r.cfg.Action {
+		case v1.RuleActionReject:
+			reason := r.cfg.RejectReason
+			if reason == "" {
+				reason = fmt.Sprintf("rejected by plugin [%s]", p.options.Name)
+			}
+			return &Response{Reject: true, RejectReason: reason}, nil, nil
+		case v1.RuleActionAllow:
+			break loop
+		case v1.RuleActionSet:
+			for _, s := range r.setters {
+				v, err := expr.Run(s.program, env)
+				if err != nil {
+					return nil, nil, fmt.Errorf("rule %d: evaluate set expression of [%s] error: %v",
+						i, strings.Join(s.path, "."), err)
+				}
+				setField(data, s.path, v)
+			}
+			changed = true
+		}
+	}
+
+	if !changed {
+		return &Response{Unchange: true}, nil, nil
+	}
+	buf, err = json.Marshal(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	retContent := reflect.New(reflect.TypeOf(content)).Interface()
+	if err := json.Unmarshal(buf, retContent); err != nil {
+		return nil, nil, fmt.Errorf("apply changes to %s content error: %v", op, err)
+	}
+	return &Response{Content: retContent}, retContent, nil
+}
+
+// setField 按路径设置字段的值，路径中不存在的对象会被创建。
+func setField(data map[string]interface{}, path []string, v interface{}) {
+	for _, field := range path[:len(path)-1] {
+		next, ok := data[field].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			data[field] = next
+		}
+		data = next
+	}
+	data[path[len(path)-1]] = v
+}
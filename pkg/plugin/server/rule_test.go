@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/msg"
+	"strings"
+	"testing"
+)
+
+func newTestRulePlugin(t *testing.T, ops []string, rules ...v1.RuleConfig) Plugin {
+	t.Helper()
+	options := v1.RulePluginOptions{Name: "rules", Ops: ops, Rules: rules}
+	options.Complete()
+	p, err := NewRulePluginOptions(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRulePluginCompileError(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		ops     []string
+		rule    v1.RuleConfig
+		wantErr string
+	}{
+		{"syntax error", []string{OpLogin}, v1.RuleConfig{When: "content.user =="}, "compile when expression"},
+		{"undefined variable", []string{OpLogin}, v1.RuleConfig{When: `user == "alice"`}, "compile when expression"},
+		{"when not bool", []string{OpLogin}, v1.RuleConfig{When: `op + "-suffix"`}, "compile when expression"},
+		{"op not in plugin ops", []string{OpLogin}, v1.RuleConfig{Op: OpNewProxy}, "is not in the ops"},
+		{"invalid action", []string{OpLogin}, v1.RuleConfig{Action: "drop"}, "invalid action"},
+		{"set without fields", []string{OpLogin}, v1.RuleConfig{Action: v1.RuleActionSet}, "set should not be empty"},
+		{"set with reject", []string{OpLogin}, v1.RuleConfig{Set: map[string]string{"user": `"bob"`}}, "set is only allowed"},
+		{"set on notify op", []string{OpLogin, OpCloseProxy},
+			v1.RuleConfig{Action: v1.RuleActionSet, Set: map[string]string{"user": `"bob"`}}, "does not support modifying"},
+		{"invalid field path", []string{OpLogin},
+			v1.RuleConfig{Action: v1.RuleActionSet, Set: map[string]string{"metas..region": `"eu"`}}, "invalid field path"},
+		{"set syntax error", []string{OpLogin},
+			v1.RuleConfig{Action: v1.RuleActionSet, Set: map[string]string{"user": `"bob`}}, "compile set expression"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			options := v1.RulePluginOptions{
+				Name:  "rules",
+				Ops:   tc.ops,
+				Rules: []v1.RuleConfig{{Action: v1.RuleActionAllow}, tc.rule},
+			}
+			options.Complete()
+			_, err := NewRulePluginOptions(options)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tc.wantErr)
+			}
+			// 错误信息指出出错的规则
+			if !strings.Contains(err.Error(), "rule 1") {
+				t.Fatalf("got error %v, want it to name rule 1", err)
+			}
+		})
+	}
+}
+
+func TestRulePluginReject(t *testing.T) {
+	p := newTestRulePlugin(t, []string{OpLogin, OpNewProxy},
+		v1.RuleConfig{When: `content.user == "admin"`, Action: v1.RuleActionAllow},
+		v1.RuleConfig{Op: OpLogin, When: `content.metas.team != "ops"`, RejectReason: "only ops can login"},
+		v1.RuleConfig{Op: OpNewProxy, When: `content.proxy_type == "tcp"`},
+	)
+
+	for _, tc := range []struct {
+		name       string
+		op         string
+		content    interface{}
+		wantReject bool
+		wantReason string
+	}{
+		{"rejected with reason", OpLogin, msg.Login{User: "alice", Metas: map[string]string{"team": "dev"}}, true, "only ops can login"},
+		{"not matched", OpLogin, msg.Login{User: "alice", Metas: map[string]string{"team": "ops"}}, false, ""},
+		{"allow stops evaluation", OpLogin, msg.Login{User: "admin"}, false, ""},
+		{"default reason", OpNewProxy, msg.NewProxy{ProxyName: "a", ProxyType: "tcp"}, true, "rejected by plugin [rules]"},
+		{"rule for other op", OpNewProxy, msg.NewProxy{ProxyName: "a", ProxyType: "http"}, false, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res, _, err := p.Handle(context.Background(), tc.op, tc.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Reject != tc.wantReject || res.RejectReason != tc.wantReason {
+				t.Fatalf("got reject %v %q, want %v %q", res.Reject, res.RejectReason, tc.wantReject, tc.wantReason)
+			}
+			if !res.Reject && !res.Unchange {
+				t.Fatal("rules without set should not change the content")
+			}
+		})
+	}
+}
+
+func TestRulePluginSet(t *testing.T) {
+	p := newTestRulePlugin(t, []string{OpLogin, OpNewProxy},
+		v1.RuleConfig{
+			Op:     OpLogin,
+			When:   `content.user startsWith "eu-"`,
+			Action: v1.RuleActionSet,
+			Set:    map[string]string{"metas.region": `"eu"`, "pool_count": `content.pool_count * 2`},
+		},
+		v1.RuleConfig{
+			Op:     OpNewProxy,
+			Action: v1.RuleActionSet,
+			Set: map[string]string{
+				"use_compression":   `true`,
+				"bandwidth_limit":   `content.proxy_type == "tcp" ? "1MB" : "10MB"`,
+				"annotations.owner": `split(content.proxy_name, ".")[0]`,
+			},
+		},
+	)
+
+	res, out, err := p.Handle(context.Background(), OpLogin, msg.Login{User: "eu-alice", PoolCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, ok := out.(*msg.Login)
+	if res.Unchange || !ok {
+		t.Fatalf("got %+v %T, want changed *msg.Login", res, out)
+	}
+	if login.User != "eu-alice" || login.Metas["region"] != "eu" || login.PoolCount != 4 {
+		t.Fatalf("got %+v, want region eu and pool count 4", login)
+	}
+	if res, _, _ := p.Handle(context.Background(), OpLogin, msg.Login{User: "us-bob"}); !res.Unchange {
+		t.Fatal("login not matching the rule should be unchanged")
+	}
+
+	_, out, err = p.Handle(context.Background(), OpNewProxy, msg.NewProxy{ProxyName: "alice.ssh", ProxyType: "tcp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pxy := out.(*msg.NewProxy)
+	if !pxy.UseCompression || pxy.BandWidthLimit != "1MB" || pxy.Annotations["owner"] != "alice" || pxy.ProxyName != "alice.ssh" {
+		t.Fatalf("got %+v, want compression, 1MB and owner alice", pxy)
+	}
+
+	// 表达式的结果类型与字段不符时返回错误
+	p = newTestRulePlugin(t, []string{OpLogin}, v1.RuleConfig{Action: v1.RuleActionSet, Set: map[string]string{"pool_count": `"many"`}})
+	if _, _, err := p.Handle(context.Background(), OpLogin, msg.Login{}); err == nil {
+		t.Fatal("set with a mismatched type should fail")
+	}
+}
+
+func TestRulePluginThroughManager(t *testing.T) {
+	m := NewManager()
+	m.Register(newTestRulePlugin(t, []string{OpNewVisitorConn},
+		v1.RuleConfig{When: `content.user.user == "mallory"`, RejectReason: "visitor blocked"},
+		v1.RuleConfig{Action: v1.RuleActionSet, Set: map[string]string{"use_encryption": `true`}},
+	))
+
+	content := &NewVisitorConnContent{User: UserInfo{User: "alice"}}
+	content.ProxyName = "owner.secret"
+	got, err := m.NewVisitorConn(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.UseEncryption || got.ProxyName != "owner.secret" || got.User.User != "alice" {
+		t.Fatalf("got %+v, want encryption enabled", got)
+	}
+
+	content = &NewVisitorConnContent{User: UserInfo{User: "mallory"}}
+	if _, err := m.NewVisitorConn(content); err == nil || err.Error() != "visitor blocked" {
+		t.Fatalf("got error %v, want the reject reason", err)
+	}
+}
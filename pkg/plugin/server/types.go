@@ -1,5 +1,13 @@
 package server
 
+import "github.com/sunyihoo/frp/pkg/msg"
+
+type Request struct {
+	Version string      `json:"version"`
+	Op      string      `json:"op"`
+	Content interface{} `json:"content"`
+}
+
 type Response struct {
 	Reject       bool        `json:"reject"`
 	RejectReason string      `json:"reject_reason"`
@@ -12,3 +20,63 @@ type UserInfo struct {
 	Metas map[string]string `json:"metas"`
 	RunID string            `json:"runID"`
 }
+
+// QuotaExceededContent 在用户或代理的流量用量首次超出某一周期的配额时发送给插件，仅用于通知。
+type QuotaExceededContent struct {
+	// Scope 为 "user" 或 "proxy"
+	Scope string `json:"scope"`
+	// Name 为用户名或代理名称
+	Name string `json:"name"`
+	// Period 为 "daily" 或 "monthly"
+	Period string `json:"period"`
+	Used   int64  `json:"used"`
+	Limit  int64  `json:"limit"`
+	Action string `json:"action"`
+}
+
+// GroupFailoverContent 在主备模式的负载均衡组切换活动代理时发送给插件，仅用于通知。
+type GroupFailoverContent struct {
+	// Type 为 "tcp"、"tcpmux" 或 "http"
+	Type  string `json:"type"`
+	Group string `json:"group"`
+	// From 和 To 为切换前后的代理名称，为空表示没有可用的代理
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Reason 为 "initial"、"memberDown" 或 "failback"
+	Reason    string `json:"reason"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// NewVisitorConnContent 在 stcp、sudp、xtcp 访问者连接到代理时发送给插件，插件可以拒绝该连接。
+type NewVisitorConnContent struct {
+	User UserInfo `json:"user"`
+	msg.NewVisitorConn
+}
+
+// NatHoleVisitorContent 在 xtcp 访问者发起 NAT 打洞时发送给插件，插件可以拒绝该会话。
+type NatHoleVisitorContent struct {
+	User UserInfo `json:"user"`
+	msg.NatHoleVisitor
+}
+
+// CloseUserConnContent 在用户连接关闭时发送给插件，仅用于通知。
+type CloseUserConnContent struct {
+	User       UserInfo `json:"user"`
+	ProxyName  string   `json:"proxy_name"`
+	ProxyType  string   `json:"proxy_type"`
+	RemoteAddr string   `json:"remote_addr"`
+	// 连接建立时间（Unix 时间戳，秒）和持续时间（毫秒）
+	StartTime int64 `json:"start_time"`
+	Duration  int64 `json:"duration"`
+	// 从用户发往代理后端和从代理后端发往用户的字节数
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+}
+
+// CloseClientContent 在客户端控制连接关闭时发送给插件，仅用于通知。
+type CloseClientContent struct {
+	User UserInfo `json:"user"`
+	// 客户端登录时间（Unix 时间戳，秒）和在线时长（秒）
+	LoginTime int64 `json:"login_time"`
+	Duration  int64 `json:"duration"`
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+// WebAssembly 插件模块需要导出以下内容：
+//
+//	memory
+//	frp_malloc(size i32) i32             在模块内存中分配 size 字节并返回地址
+//	frp_handle(ptr i32, len i32) i64     处理位于 ptr 的请求 JSON，返回 (响应地址 << 32) | 响应长度
+//
+// 请求和响应的 JSON 与 HTTP 插件的请求体和响应体完全相同。
+// 模块可以导入 wasi_snapshot_preview1，但没有文件系统和网络访问权限。
+const (
+	wasmExportMalloc = "frp_malloc"
+	wasmExportHandle = "frp_handle"
+
+	wasmPageSize = 64 * 1024
+)
+
+type wasmModule struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	modTime  time.Time
+	// calls 是正在使用该模块的调用，模块被替换后等待它们结束再关闭
+	calls sync.WaitGroup
+}
+
+// Close 等待正在进行的调用结束后关闭模块，调用方需要保证之后不会再有新的调用。
+func (m *wasmModule) Close(ctx context.Context) {
+	m.calls.Wait()
+	_ = m.runtime.Close(ctx)
+}
+
+type wasmPlugin struct {
+	options v1.WASMPluginOptions
+
+	timeout time.Duration
+	module  *wasmModule
+	// 最近一次加载失败的模块文件修改时间，避免重复加载同一个错误的文件
+	failedModTime time.Time
+	mu            sync.RWMutex
+}
+
+// NewWASMPluginOptions 加载 WebAssembly 插件模块，并在 ctx 结束前监视模块文件的变化以便热加载。
+func NewWASMPluginOptions(ctx context.Context, options v1.WASMPluginOptions) (Plugin, error) {
+	p := &wasmPlugin{
+		options: options,
+		timeout: time.Duration(options.TimeoutMilliseconds) * time.Millisecond,
+	}
+	module, err := p.load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("plugin [%s]: %v", options.Name, err)
+	}
+	p.module = module
+	if options.ReloadInterval > 0 {
+		go p.watch(ctx, time.Duration(options.ReloadInterval)*time.Second)
+	}
+	return p, nil
+}
+
+func (p *wasmPlugin) Name() string {
+	return p.options.Name
+}
+
+func (p *wasmPlugin) Priority() int {
+	return p.options.Priority
+}
+
+func (p *wasmPlugin) IsSupport(op string) bool {
+	return slices.Contains(p.options.Ops, op)
+}
+
+func (p *wasmPlugin) Handle(ctx context.Context, op string, content interface{}) (*Response, interface{}, error) {
+	r := &Request{
+		Version: APIVersion,
+		Op:      op,
+		Content: content,
+	}
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out, err := p.call(ctx, buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	var res Response
+	res.Content = reflect.New(reflect.TypeOf(content)).Interface()
+	if err := json.Unmarshal(out, &res); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal %s response error: %v", op, err)
+	}
+	return &res, res.Content, nil
+}
+
+// call 为每个请求创建新的模块实例，请求之间不共享内存和状态。
+func (p *wasmPlugin) call(ctx context.Context, in []byte) ([]byte, error) {
+	// 在读锁内登记调用，保证替换模块之后旧模块不会再有新的调用
+	p.mu.RLock()
+	module := p.module
+	module.calls.Add(1)
+	p.mu.RUnlock()
+	defer module.calls.Done()
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	mod, err := module.runtime.InstantiateModule(ctx, module.compiled,
+		wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, fmt.Errorf("instantiate module error: %v", err)
+	}
+	defer mod.Close(context.Background())
+
+	malloc, handle := mod.ExportedFunction(wasmExportMalloc), mod.ExportedFunction(wasmExportHandle)
+	mem := mod.Memory()
+	if malloc == nil || handle == nil || mem == nil {
+		return nil, fmt.Errorf("module must export memory, %s and %s", wasmExportMalloc, wasmExportHandle)
+	}
+
+	ret, err := malloc.Call(ctx, uint64(len(in)))
+	if err != nil {
+		return nil, fmt.Errorf("call %s error: %v", wasmExportMalloc, err)
+	}
+	ptr := uint32(ret[0])
+	if !mem.Write(ptr, in) {
+		return nil, fmt.Errorf("write request out of memory range")
+	}
+
+	ret, err = handle.Call(ctx, uint64(ptr), uint64(len(in)))
+	if err != nil {
+		return nil, fmt.Errorf("call %s error: %v", wasmExportHandle, err)
+	}
+	outPtr, outLen := uint32(ret[0]>>32), uint32(ret[0])
+	out, ok := mem.Read(outPtr, outLen)
+	if !ok {
+		return nil, fmt.Errorf("read response out of memory range")
+	}
+	// 模块关闭后内存不再可用，需要复制一份
+	return append([]byte(nil), out...), nil
+}
+
+func (p *wasmPlugin) load(ctx context.Context) (*wasmModule, error) {
+	fi, err := os.Stat(p.options.Path)
+	if err != nil {
+		return nil, err
+	}
+	code, err := os.ReadFile(p.options.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// 超时后关闭模块以中断执行，内存上限按 64KB 的页计算
+	cfg := wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(uint32(p.options.MemoryLimitMB * 1024 * 1024 / wasmPageSize))
+	runtime := wazero.NewRuntimeWithConfig(ctx, cfg)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		_ = runtime.Close(ctx)
+		return nil, err
+	}
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("compile module [%s] error: %v", p.options.Path, err)
+	}
+	if err := checkWASMExports(compiled.ExportedFunctions()); err != nil {
+		_ = runtime.Close(ctx)
+		return nil, err
+	}
+	return &wasmModule{
+		runtime:  runtime,
+		compiled: compiled,
+		modTime:  fi.ModTime(),
+	}, nil
+}
+
+func checkWASMExports(fns map[string]api.FunctionDefinition) error {
+	for _, name := range []string{wasmExportMalloc, wasmExportHandle} {
+		if _, ok := fns[name]; !ok {
+			return fmt.Errorf("module must export function %s", name)
+		}
+	}
+	return nil
+}
+
+// watch 定期检查模块文件的修改时间，文件变化后重新编译并替换模块。
+// 如果新模块无法加载，则继续使用旧模块。
+func (p *wasmPlugin) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// 持有写锁直到关闭完成，之后的调用会因模块已关闭而失败
+			p.mu.Lock()
+			p.module.Close(context.Background())
+			p.mu.Unlock()
+			return
+		case <-ticker.C:
+		}
+
+		fi, err := os.Stat(p.options.Path)
+		if err != nil {
+			continue
+		}
+		p.mu.RLock()
+		changed := !fi.ModTime().Equal(p.module.modTime)
+		p.mu.RUnlock()
+		if !changed || fi.ModTime().Equal(p.failedModTime) {
+			continue
+		}
+
+		module, err := p.load(ctx)
+		if err != nil {
+			p.failedModTime = fi.ModTime()
+			log.Warnf("plugin [%s] reload module [%s] error: %v", p.options.Name, p.options.Path, err)
+			continue
+		}
+		p.mu.Lock()
+		old := p.module
+		p.module = module
+		p.mu.Unlock()
+		// 等待使用旧模块的调用结束后再关闭
+		go old.Close(context.Background())
+		log.Infof("plugin [%s] module [%s] reloaded", p.options.Name, p.options.Path)
+	}
+}
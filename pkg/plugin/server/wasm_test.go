@@ -0,0 +1,275 @@
+package server
+
+import (
+	"context"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// WebAssembly 指令的操作码，只包含测试模块用到的指令
+const (
+	wasmOpUnreachable  = 0x00
+	wasmOpLoop         = 0x03
+	wasmOpIf           = 0x04
+	wasmOpEnd          = 0x0b
+	wasmOpBr           = 0x0c
+	wasmOpLocalGet     = 0x20
+	wasmOpLocalSet     = 0x21
+	wasmOpMemorySize   = 0x3f
+	wasmOpMemoryGrow   = 0x40
+	wasmOpI32Const     = 0x41
+	wasmOpI64Const     = 0x42
+	wasmOpI32Eq        = 0x46
+	wasmOpI32Add       = 0x6a
+	wasmOpI32Shl       = 0x74
+	wasmOpI32ShrU      = 0x76
+	wasmOpI64Or        = 0x84
+	wasmOpI64Shl       = 0x86
+	wasmOpI64ExtendU   = 0xad
+	wasmBlockTypeEmpty = 0x40
+)
+
+func wasmULEB(v uint64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func wasmSLEB(v int64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func wasmVec(items ...[]byte) []byte {
+	b := wasmULEB(uint64(len(items)))
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}
+
+func wasmName(s string) []byte {
+	return append(wasmULEB(uint64(len(s))), s...)
+}
+
+func wasmSection(id byte, content []byte) []byte {
+	return append(append([]byte{id}, wasmULEB(uint64(len(content)))...), content...)
+}
+
+// wasmMalloc 将内存扩大到能容纳 size 字节，返回扩大前的内存末尾地址，内存达到上限时 trap
+var wasmMalloc = []byte{
+	1, 1, 0x7f, // 一个 i32 局部变量
+	wasmOpMemorySize, 0, wasmOpI32Const, 16, wasmOpI32Shl, wasmOpLocalSet, 1,
+	wasmOpLocalGet, 0, wasmOpI32Const, 0xff, 0xff, 0x03, wasmOpI32Add, wasmOpI32Const, 16, wasmOpI32ShrU,
+	wasmOpMemoryGrow, 0, wasmOpI32Const, 0x7f, wasmOpI32Eq,
+	wasmOpIf, wasmBlockTypeEmpty, wasmOpUnreachable, wasmOpEnd,
+	wasmOpLocalGet, 1, wasmOpEnd,
+}
+
+// wasmHandleEcho 将请求原样作为响应返回，插件收到的内容与发送的内容相同
+var wasmHandleEcho = []byte{
+	0,
+	wasmOpLocalGet, 0, wasmOpI64ExtendU, wasmOpI64Const, 32, wasmOpI64Shl,
+	wasmOpLocalGet, 1, wasmOpI64ExtendU, wasmOpI64Or, wasmOpEnd,
+}
+
+// wasmHandleLoop 永远不返回，用于测试超时
+var wasmHandleLoop = []byte{
+	0,
+	wasmOpLoop, wasmBlockTypeEmpty, wasmOpBr, 0, wasmOpEnd, wasmOpUnreachable, wasmOpEnd,
+}
+
+// wasmHandleFixed 返回数据段中地址为 0 的 response
+func wasmHandleFixed(response string) []byte {
+	return append(append([]byte{0, wasmOpI64Const}, wasmSLEB(int64(len(response)))...), wasmOpEnd)
+}
+
+// newTestWASMModule 构造导出 memory、frp_malloc 和 frp_handle 的模块，data 放在内存地址 0 处
+func newTestWASMModule(handle []byte, data string) []byte {
+	b := []byte("\x00asm\x01\x00\x00\x00")
+	b = append(b, wasmSection(1, wasmVec(
+		[]byte{0x60, 1, 0x7f, 1, 0x7f},
+		[]byte{0x60, 2, 0x7f, 0x7f, 1, 0x7e},
+	))...)
+	b = append(b, wasmSection(3, wasmVec([]byte{0}, []byte{1}))...)
+	b = append(b, wasmSection(5, wasmVec([]byte{0, 1}))...)
+	b = append(b, wasmSection(7, wasmVec(
+		append(wasmName("memory"), 2, 0),
+		append(wasmName(wasmExportMalloc), 0, 0),
+		append(wasmName(wasmExportHandle), 0, 1),
+	))...)
+	b = append(b, wasmSection(10, wasmVec(
+		append(wasmULEB(uint64(len(wasmMalloc))), wasmMalloc...),
+		append(wasmULEB(uint64(len(handle))), handle...),
+	))...)
+	if data != "" {
+		segment := []byte{0, wasmOpI32Const, 0, wasmOpEnd}
+		b = append(b, wasmSection(11, wasmVec(append(segment, wasmName(data)...)))...)
+	}
+	return b
+}
+
+// writeTestWASMModule 写入模块文件，并将修改时间设置为 modTime 以便触发热加载
+func writeTestWASMModule(t *testing.T, path string, module []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, module, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestWASMPlugin(t *testing.T, ctx context.Context, module []byte, options v1.WASMPluginOptions) (Plugin, string) {
+	t.Helper()
+	options.Name = "wasm"
+	options.Path = filepath.Join(t.TempDir(), "plugin.wasm")
+	options.Ops = []string{OpNewVisitorConn}
+	if options.TimeoutMilliseconds == 0 {
+		options.TimeoutMilliseconds = 1000
+	}
+	if options.MemoryLimitMB == 0 {
+		options.MemoryLimitMB = 16
+	}
+	writeTestWASMModule(t, options.Path, module, time.Now())
+	p, err := NewWASMPluginOptions(ctx, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, options.Path
+}
+
+func TestWASMPluginRoundTrip(t *testing.T) {
+	p, _ := newTestWASMPlugin(t, context.Background(), newTestWASMModule(wasmHandleEcho, ""), v1.WASMPluginOptions{})
+	if !p.IsSupport(OpNewVisitorConn) || p.IsSupport(OpNewProxy) {
+		t.Fatal("plugin should only support the configured ops")
+	}
+
+	content := NewVisitorConnContent{User: UserInfo{User: "alice", Metas: map[string]string{"team": "ops"}}}
+	content.ProxyName = "alice.secret"
+	// 与 Manager 相同，传入内容的值，返回新的指针
+	res, out, err := p.Handle(context.Background(), OpNewVisitorConn, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Reject {
+		t.Fatal("echo response should not reject")
+	}
+	got, ok := out.(*NewVisitorConnContent)
+	if !ok {
+		t.Fatalf("got content %T, want *NewVisitorConnContent", out)
+	}
+	if got.User.User != "alice" || got.User.Metas["team"] != "ops" || got.ProxyName != "alice.secret" {
+		t.Fatalf("got content %+v, want the request content", got)
+	}
+}
+
+func TestWASMPluginMemoryLimit(t *testing.T) {
+	p, _ := newTestWASMPlugin(t, context.Background(), newTestWASMModule(wasmHandleEcho, ""),
+		v1.WASMPluginOptions{MemoryLimitMB: 1})
+
+	small := NewVisitorConnContent{}
+	small.ProxyName = "alice.secret"
+	if _, _, err := p.Handle(context.Background(), OpNewVisitorConn, small); err != nil {
+		t.Fatalf("request within the memory limit: %v", err)
+	}
+
+	// 请求超过 1MB 时 frp_malloc 无法扩大内存
+	large := NewVisitorConnContent{}
+	large.ProxyName = strings.Repeat("x", 2*1024*1024)
+	if _, _, err := p.Handle(context.Background(), OpNewVisitorConn, large); err == nil {
+		t.Fatal("request over the memory limit should fail")
+	}
+	// 每个请求使用新的实例，失败不影响之后的请求
+	if _, _, err := p.Handle(context.Background(), OpNewVisitorConn, small); err != nil {
+		t.Fatalf("request after a failed one: %v", err)
+	}
+}
+
+func TestWASMPluginTimeout(t *testing.T) {
+	p, _ := newTestWASMPlugin(t, context.Background(), newTestWASMModule(wasmHandleLoop, ""),
+		v1.WASMPluginOptions{TimeoutMilliseconds: 50})
+
+	start := time.Now()
+	_, _, err := p.Handle(context.Background(), OpNewVisitorConn, NewVisitorConnContent{})
+	if err == nil {
+		t.Fatal("endless handler should time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("handler was interrupted after %v, want about 50ms", elapsed)
+	}
+}
+
+func TestWASMPluginHotReload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, path := newTestWASMPlugin(t, ctx, newTestWASMModule(wasmHandleEcho, ""), v1.WASMPluginOptions{ReloadInterval: 1})
+
+	reason := func() string {
+		t.Helper()
+		res, _, err := p.Handle(context.Background(), OpNewVisitorConn, NewVisitorConnContent{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.RejectReason
+	}
+	waitReason := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for reason() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("module was not reloaded, want reject reason %q", want)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	const v2 = `{"reject":true,"reject_reason":"v2"}`
+	writeTestWASMModule(t, path, newTestWASMModule(wasmHandleFixed(v2), v2), time.Now().Add(time.Minute))
+	waitReason("v2")
+
+	// 无法加载的模块不会替换当前模块
+	writeTestWASMModule(t, path, []byte("not a wasm module"), time.Now().Add(2*time.Minute))
+	time.Sleep(1500 * time.Millisecond)
+	if got := reason(); got != "v2" {
+		t.Fatalf("got reject reason %q after an invalid reload, want v2", got)
+	}
+
+	const v3 = `{"reject":true,"reject_reason":"v3"}`
+	writeTestWASMModule(t, path, newTestWASMModule(wasmHandleFixed(v3), v3), time.Now().Add(3*time.Minute))
+	waitReason("v3")
+}
+
+func TestWASMPluginInvalidModule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugin.wasm")
+	options := v1.WASMPluginOptions{Name: "wasm", Path: path, TimeoutMilliseconds: 1000, MemoryLimitMB: 16}
+	if _, err := NewWASMPluginOptions(context.Background(), options); err == nil {
+		t.Error("missing module file should be rejected")
+	}
+
+	// 缺少 frp_handle 导出的模块
+	module := newTestWASMModule(wasmHandleEcho, "")
+	module = []byte(strings.Replace(string(module), wasmExportHandle, "frp_other_", 1))
+	writeTestWASMModule(t, path, module, time.Now())
+	if _, err := NewWASMPluginOptions(context.Background(), options); err == nil ||
+		!strings.Contains(err.Error(), wasmExportHandle) {
+		t.Errorf("got error %v, want missing %s export", err, wasmExportHandle)
+	}
+}
@@ -0,0 +1,220 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fatedier/golib/log"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry 是一条访问日志，HTTP 请求和 HTTPS、tcpmux 连接共用，连接没有的字段为空。
+type Entry struct {
+	Time      time.Time
+	ProxyName string
+	RunID     string
+	// RemoteAddr 是用户的地址，日志中只记录 IP
+	RemoteAddr string
+	User       string
+	Host       string
+	SNI        string
+	// Country 是来源 IP 所属的国家代码，为空时由 Logger 的 countryFn 查询
+	Country string
+	Method  string
+	Path    string
+	Proto   string
+	// Status 为 0 表示没有 HTTP 状态码
+	Status    int
+	BytesSent int64
+	// BytesReceived 仅用于连接
+	BytesReceived int64
+	Referer       string
+	UserAgent     string
+	Latency       time.Duration
+}
+
+// Logger 按配置的格式和采样率将访问日志写入文件或 stdout，nil 的 Logger 不记录任何内容。
+type Logger struct {
+	format     string
+	sampleRate float64
+	countryFn  func(remoteAddr string) string
+
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// New 在 cfg.To 为空时返回 nil。
+func New(cfg v1.AccessLogConfig) (*Logger, error) {
+	if cfg.To == "" {
+		return nil, nil
+	}
+
+	var w io.Writer
+	if cfg.To == "console" {
+		w = os.Stdout
+	} else {
+		mode := log.RotateFileModeDaily
+		if cfg.Rotate == v1.AccessLogRotateNone {
+			mode = log.RotateFileModeNone
+		}
+		writer := log.NewRotateFileWriter(log.RotateFileConfig{
+			FileName: cfg.To,
+			Mode:     mode,
+			MaxDays:  cfg.MaxDays,
+		})
+		writer.Init()
+		w = writer
+	}
+	return newLogger(w, cfg.Format, cfg.SampleRate)
+}
+
+func newLogger(w io.Writer, format string, sampleRate float64) (*Logger, error) {
+	switch format {
+	case v1.AccessLogFormatCombined, v1.AccessLogFormatJSON:
+	default:
+		return nil, fmt.Errorf("unsupported access log format [%s]", format)
+	}
+	return &Logger{
+		format:     format,
+		sampleRate: sampleRate,
+		w:          w,
+	}, nil
+}
+
+// SetCountryFunc 设置查询来源 IP 所属国家的函数，启用 GeoIP 时日志会记录国家代码。
+func (l *Logger) SetCountryFunc(fn func(remoteAddr string) string) {
+	if l == nil {
+		return
+	}
+	l.countryFn = fn
+}
+
+// Sampled 返回本次请求或连接是否需要记录，调用方应在收集日志字段之前调用它。
+func (l *Logger) Sampled() bool {
+	if l == nil {
+		return false
+	}
+	return l.sampleRate >= 1 || rand.Float64() < l.sampleRate
+}
+
+// Log 写入一条日志，采样由调用方通过 Sampled 决定。
+func (l *Logger) Log(e *Entry) {
+	if l == nil {
+		return
+	}
+	if e.Country == "" && l.countryFn != nil {
+		e.Country = l.countryFn(e.RemoteAddr)
+	}
+	var line []byte
+	if l.format == v1.AccessLogFormatJSON {
+		line = formatJSON(e)
+	} else {
+		line = formatCombined(e)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(line)
+}
+
+type jsonEntry struct {
+	Time          string `json:"time"`
+	ProxyName     string `json:"proxyName"`
+	RunID         string `json:"runID"`
+	SourceIP      string `json:"sourceIP"`
+	User          string `json:"user,omitempty"`
+	Host          string `json:"host"`
+	SNI           string `json:"sni,omitempty"`
+	Country       string `json:"country,omitempty"`
+	Method        string `json:"method,omitempty"`
+	Path          string `json:"path,omitempty"`
+	Proto         string `json:"proto,omitempty"`
+	Status        int    `json:"status,omitempty"`
+	BytesSent     int64  `json:"bytesSent"`
+	BytesReceived int64  `json:"bytesReceived,omitempty"`
+	Referer       string `json:"referer,omitempty"`
+	UserAgent     string `json:"userAgent,omitempty"`
+	LatencyMS     int64  `json:"latencyMs"`
+}
+
+func formatJSON(e *Entry) []byte {
+	buf, _ := json.Marshal(&jsonEntry{
+		Time:          e.Time.Format(time.RFC3339Nano),
+		ProxyName:     e.ProxyName,
+		RunID:         e.RunID,
+		SourceIP:      sourceIP(e.RemoteAddr),
+		User:          e.User,
+		Host:          e.Host,
+		SNI:           e.SNI,
+		Country:       e.Country,
+		Method:        e.Method,
+		Path:          e.Path,
+		Proto:         e.Proto,
+		Status:        e.Status,
+		BytesSent:     e.BytesSent,
+		BytesReceived: e.BytesReceived,
+		Referer:       e.Referer,
+		UserAgent:     e.UserAgent,
+		LatencyMS:     e.Latency.Milliseconds(),
+	})
+	return append(buf, '\n')
+}
+
+// formatCombined 按 Apache Combined 格式输出，之后依次附加代理名称、run ID、Host、SNI、国家和耗时（毫秒）。
+// 连接没有请求行和状态码，分别记录为 "-"。
+func formatCombined(e *Entry) []byte {
+	var sb strings.Builder
+	sb.WriteString(orDash(sourceIP(e.RemoteAddr)))
+	sb.WriteString(" - ")
+	sb.WriteString(orDash(e.User))
+	sb.WriteString(" [")
+	sb.WriteString(e.Time.Format("02/Jan/2006:15:04:05 -0700"))
+	sb.WriteString("] ")
+	if e.Method != "" {
+		sb.WriteString(quote(e.Method + " " + e.Path + " " + e.Proto))
+	} else {
+		sb.WriteString(`"-"`)
+	}
+	sb.WriteByte(' ')
+	if e.Status > 0 {
+		sb.WriteString(strconv.Itoa(e.Status))
+	} else {
+		sb.WriteByte('-')
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatInt(e.BytesSent, 10))
+	for _, v := range []string{e.Referer, e.UserAgent, e.ProxyName, e.RunID, e.Host, e.SNI, e.Country} {
+		sb.WriteByte(' ')
+		sb.WriteString(quote(orDash(v)))
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatInt(e.Latency.Milliseconds(), 10))
+	sb.WriteByte('\n')
+	return []byte(sb.String())
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func sourceIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,189 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"testing"
+	"time"
+)
+
+var testLogTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 8*3600))
+
+// testHTTPEntry 填写了 HTTP 请求的所有字段
+func testHTTPEntry() *Entry {
+	return &Entry{
+		Time:       testLogTime,
+		ProxyName:  "web",
+		RunID:      "run-1",
+		RemoteAddr: "1.2.3.4:5678",
+		User:       "alice",
+		Host:       "example.test",
+		Country:    "CN",
+		Method:     "GET",
+		Path:       "/a?b=1",
+		Proto:      "HTTP/1.1",
+		Status:     200,
+		BytesSent:  512,
+		Referer:    "https://ref.test/",
+		UserAgent:  `curl "8.0"`,
+		Latency:    15 * time.Millisecond,
+	}
+}
+
+// testConnEntry 是 HTTPS 连接的日志，没有请求行和状态码
+func testConnEntry() *Entry {
+	return &Entry{
+		Time:          testLogTime,
+		ProxyName:     "secure",
+		RunID:         "run-2",
+		RemoteAddr:    "[::1]:443",
+		Host:          "secure.test",
+		SNI:           "secure.test",
+		BytesSent:     100,
+		BytesReceived: 40,
+		Latency:       2 * time.Second,
+	}
+}
+
+func TestFormatCombined(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		entry *Entry
+		want  string
+	}{
+		{"http request", testHTTPEntry(),
+			`1.2.3.4 - alice [02/Jan/2024:03:04:05 +0800] "GET /a?b=1 HTTP/1.1" 200 512 "https://ref.test/" "curl \"8.0\"" ` +
+				`"web" "run-1" "example.test" "-" "CN" 15` + "\n"},
+		{"connection", testConnEntry(),
+			`::1 - - [02/Jan/2024:03:04:05 +0800] "-" - 100 "-" "-" "secure" "run-2" "secure.test" "secure.test" "-" 2000` + "\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(formatCombined(tc.entry)); got != tc.want {
+				t.Fatalf("got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		entry *Entry
+		want  map[string]interface{}
+	}{
+		{"http request", testHTTPEntry(), map[string]interface{}{
+			"time":      "2024-01-02T03:04:05+08:00",
+			"proxyName": "web",
+			"runID":     "run-1",
+			"sourceIP":  "1.2.3.4",
+			"user":      "alice",
+			"host":      "example.test",
+			"country":   "CN",
+			"method":    "GET",
+			"path":      "/a?b=1",
+			"proto":     "HTTP/1.1",
+			"status":    float64(200),
+			"bytesSent": float64(512),
+			"referer":   "https://ref.test/",
+			"userAgent": `curl "8.0"`,
+			"latencyMs": float64(15),
+		}},
+		// 连接没有的字段不输出
+		{"connection", testConnEntry(), map[string]interface{}{
+			"time":          "2024-01-02T03:04:05+08:00",
+			"proxyName":     "secure",
+			"runID":         "run-2",
+			"sourceIP":      "::1",
+			"host":          "secure.test",
+			"sni":           "secure.test",
+			"bytesSent":     float64(100),
+			"bytesReceived": float64(40),
+			"latencyMs":     float64(2000),
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			line := formatJSON(tc.entry)
+			if !bytes.HasSuffix(line, []byte("\n")) {
+				t.Fatal("json line should end with a newline")
+			}
+			got := make(map[string]interface{})
+			if err := json.Unmarshal(line, &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got fields %v, want %v", got, tc.want)
+			}
+			for k, v := range tc.want {
+				if got[k] != v {
+					t.Errorf("field %s: got %v, want %v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestLoggerSampling(t *testing.T) {
+	var nilLogger *Logger
+	if nilLogger.Sampled() {
+		t.Fatal("nil logger should not sample")
+	}
+	nilLogger.Log(testHTTPEntry())
+
+	for _, tc := range []struct {
+		rate     float64
+		min, max int
+	}{
+		{0, 0, 0},
+		{1, 1000, 1000},
+		{0.5, 350, 650},
+	} {
+		l, err := newLogger(&bytes.Buffer{}, v1.AccessLogFormatCombined, tc.rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if l.Sampled() {
+				n++
+			}
+		}
+		if n < tc.min || n > tc.max {
+			t.Errorf("rate %v: sampled %d of 1000, want in [%d, %d]", tc.rate, n, tc.min, tc.max)
+		}
+	}
+}
+
+func TestLoggerCountry(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := newLogger(&buf, v1.AccessLogFormatJSON, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lookedUp string
+	l.SetCountryFunc(func(remoteAddr string) string {
+		lookedUp = remoteAddr
+		return "DE"
+	})
+
+	// 已经有国家代码的日志不再查询
+	l.Log(testHTTPEntry())
+	e := testConnEntry()
+	l.Log(e)
+	if lookedUp != e.RemoteAddr || e.Country != "DE" {
+		t.Fatalf("looked up %q and got country %q, want %q and DE", lookedUp, e.Country, e.RemoteAddr)
+	}
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 || !bytes.Contains(lines[0], []byte(`"country":"CN"`)) || !bytes.Contains(lines[1], []byte(`"country":"DE"`)) {
+		t.Fatalf("got log\n%s", buf.String())
+	}
+}
+
+func TestNew(t *testing.T) {
+	if l, err := New(v1.AccessLogConfig{}); l != nil || err != nil {
+		t.Fatalf("got %v %v, want nil logger without error", l, err)
+	}
+	if _, err := New(v1.AccessLogConfig{To: "console", Format: "xml"}); err == nil {
+		t.Fatal("unsupported format should be rejected")
+	}
+}
@@ -0,0 +1,50 @@
+package accesslog
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Conn 统计连接的收发字节数，并在连接关闭时写入访问日志。
+type Conn struct {
+	net.Conn
+
+	logger        *Logger
+	entry         *Entry
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+	closeOnce     sync.Once
+}
+
+// NewConn 的 entry.Time 应为连接建立的时间，耗时和字节数在关闭时填写。
+func NewConn(c net.Conn, logger *Logger, entry *Entry) *Conn {
+	return &Conn{
+		Conn:   c,
+		logger: logger,
+		entry:  entry,
+	}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.bytesReceived.Add(int64(n))
+	return n, err
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.bytesSent.Add(int64(n))
+	return n, err
+}
+
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.entry.BytesSent = c.bytesSent.Load()
+		c.entry.BytesReceived = c.bytesReceived.Load()
+		c.entry.Latency = time.Since(c.entry.Time)
+		c.logger.Log(c.entry)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,52 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnLogOnClose(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := newLogger(&buf, v1.AccessLogFormatJSON, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	entry := &Entry{Time: time.Now(), ProxyName: "secure", RunID: "run-1", RemoteAddr: "1.2.3.4:5678", SNI: "secure.test"}
+	conn := NewConn(c1, l, entry)
+
+	go func() {
+		_, _ = c2.Write([]byte("ping"))
+		_, _ = io.ReadFull(c2, make([]byte, 5))
+	}()
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatal("connection should be logged on close")
+	}
+
+	// 重复关闭只记录一次
+	conn.Close()
+	conn.Close()
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1", len(lines))
+	}
+	var got jsonEntry
+	if err := json.Unmarshal(lines[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.BytesSent != 5 || got.BytesReceived != 4 || got.SNI != "secure.test" || got.RunID != "run-1" || got.SourceIP != "1.2.3.4" {
+		t.Fatalf("got %+v, want 5 bytes sent and 4 received", got)
+	}
+}
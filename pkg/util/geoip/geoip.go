@@ -0,0 +1,150 @@
+package geoip
+
+import (
+	"context"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Database 按 IP 查询国家代码，并在数据库文件的修改时间或大小变化后重新加载。
+// nil 的 Database 对所有地址返回空字符串。
+type Database struct {
+	path           string
+	reloadInterval time.Duration
+
+	reader  atomic.Pointer[Reader]
+	modTime time.Time
+	size    int64
+}
+
+// NewDatabase 立即加载数据库文件，加载失败时返回错误。
+func NewDatabase(path string, reloadInterval time.Duration) (*Database, error) {
+	db := &Database{
+		path:           path,
+		reloadInterval: reloadInterval,
+	}
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *Database) load() error {
+	fi, err := os.Stat(db.path)
+	if err != nil {
+		return err
+	}
+	r, err := Open(db.path)
+	if err != nil {
+		return err
+	}
+	db.reader.Store(r)
+	db.modTime = fi.ModTime()
+	db.size = fi.Size()
+	return nil
+}
+
+// Run 定期检查数据库文件，直到 ctx 结束。重新加载失败时继续使用旧的数据库。
+func (db *Database) Run(ctx context.Context) {
+	ticker := time.NewTicker(db.reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fi, err := os.Stat(db.path)
+		if err != nil {
+			log.Warnf("stat geoip database [%s] error: %v", db.path, err)
+			continue
+		}
+		if fi.ModTime().Equal(db.modTime) && fi.Size() == db.size {
+			continue
+		}
+		if err := db.load(); err != nil {
+			log.Warnf("reload geoip database [%s] error: %v, keep using the old one", db.path, err)
+			// 记录新的文件状态，避免文件写入完成之前反复报错
+			db.modTime, db.size = fi.ModTime(), fi.Size()
+			continue
+		}
+		log.Infof("geoip database [%s] reloaded", db.path)
+	}
+}
+
+// Country 返回地址所属国家的 ISO 3166-1 代码，addr 可以是 "ip:port" 或 "ip"。
+// 无法解析的地址、私有地址等数据库中没有的地址返回空字符串。
+func (db *Database) Country(addr string) string {
+	if db == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return ""
+	}
+	country, err := db.reader.Load().Country(ip)
+	if err != nil {
+		log.Debugf("lookup geoip country for [%s] error: %v", host, err)
+		return ""
+	}
+	return country
+}
+
+// CountryACL 按国家代码的允许和拒绝列表控制访问，代码不区分大小写。
+// 拒绝列表优先于允许列表，允许列表为空时允许所有未被拒绝的国家。
+// 允许列表不为空时，数据库中查不到国家的地址会被拒绝。
+// parent 拒绝的国家总是被拒绝，因此子列表只能进一步收紧 parent 的范围。
+type CountryACL struct {
+	allow  map[string]struct{}
+	deny   map[string]struct{}
+	parent *CountryACL
+}
+
+// NewCountryACL 在两个列表都为空时返回 parent，parent 可以为 nil。
+func NewCountryACL(allow, deny []string, parent *CountryACL) *CountryACL {
+	if len(allow) == 0 && len(deny) == 0 {
+		return parent
+	}
+	return &CountryACL{
+		allow:  toCountrySet(allow),
+		deny:   toCountrySet(deny),
+		parent: parent,
+	}
+}
+
+func toCountrySet(codes []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(codes))
+	for _, code := range codes {
+		set[strings.ToUpper(strings.TrimSpace(code))] = struct{}{}
+	}
+	return set
+}
+
+// Allowed 返回是否允许来自 country 的访问，country 为空表示未知国家。nil 的 CountryACL 允许所有国家。
+func (acl *CountryACL) Allowed(country string) bool {
+	if acl == nil {
+		return true
+	}
+	if !acl.parent.Allowed(country) {
+		return false
+	}
+	country = strings.ToUpper(country)
+	if _, ok := acl.deny[country]; ok && country != "" {
+		return false
+	}
+	if len(acl.allow) == 0 {
+		return true
+	}
+	_, ok := acl.allow[country]
+	return ok && country != ""
+}
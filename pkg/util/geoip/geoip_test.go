@@ -0,0 +1,101 @@
+package geoip
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCountryACL(t *testing.T) {
+	parent := NewCountryACL(nil, []string{"cn"}, nil)
+	// 子列表显式允许 parent 拒绝的国家
+	child := NewCountryACL([]string{"CN", "us", "FR"}, []string{" fr "}, parent)
+	if NewCountryACL(nil, nil, parent) != parent {
+		t.Error("empty lists should return the parent")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		acl     *CountryACL
+		country string
+		want    bool
+	}{
+		{"nil allows all", nil, "CN", true},
+		{"nil allows unknown", nil, "", true},
+		{"parent denies", parent, "CN", false},
+		{"parent denies lower case", parent, "cn", false},
+		{"parent allows others", parent, "US", true},
+		{"parent allows unknown", parent, "", true},
+		{"child cannot allow parent denied", child, "CN", false},
+		{"child allows", child, "US", true},
+		{"child deny over allow", child, "FR", false},
+		{"child not in allow list", child, "DE", false},
+		{"child allow list rejects unknown", child, "", false},
+	} {
+		if got := tc.acl.Allowed(tc.country); got != tc.want {
+			t.Errorf("%s: Allowed(%q) got %v, want %v", tc.name, tc.country, got, tc.want)
+		}
+	}
+}
+
+// writeTestDB 写入将 1.0.0.0/8 映射到 code 的数据库文件
+func writeTestDB(t *testing.T, path string, code string) {
+	t.Helper()
+	db := newTestMMDB()
+	offset, _ := db.encCountryRecord("country", code)
+	db.addData(1, offset)
+	if err := os.WriteFile(path, db.bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatabaseReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	if _, err := NewDatabase(path, time.Second); err == nil {
+		t.Fatal("missing database file should be rejected")
+	}
+	writeTestDB(t, path, "AU")
+
+	db, err := NewDatabase(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]string{
+		"1.2.3.4:80": "AU",
+		"1.2.3.4":    "AU",
+		"2.2.3.4:80": "",
+		"bad-addr":   "",
+	} {
+		if got := db.Country(addr); got != want {
+			t.Errorf("Country(%q) got %q, want %q", addr, got, want)
+		}
+	}
+	var nilDB *Database
+	if got := nilDB.Country("1.2.3.4"); got != "" {
+		t.Errorf("nil database got %q, want empty", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go db.Run(ctx)
+
+	// 无效的文件不会替换已加载的数据库
+	if err := os.WriteFile(path, []byte("not a MaxMind DB"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := db.Country("1.2.3.4"); got != "AU" {
+		t.Fatalf("got %q after invalid reload, want AU", got)
+	}
+
+	writeTestDB(t, path, "NZ")
+	deadline := time.Now().Add(2 * time.Second)
+	for db.Country("1.2.3.4") != "NZ" {
+		if time.Now().After(deadline) {
+			t.Fatal("database was not reloaded after the file changed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
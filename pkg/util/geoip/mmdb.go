@@ -0,0 +1,381 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"sync"
+)
+
+// metadataStartMarker 位于 MaxMind DB 文件末尾的元数据之前，见 https://maxmind.github.io/MaxMind-DB/
+var metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEnd       = 13
+	typeBool      = 14
+	typeFloat     = 15
+
+	// dataSectionSeparatorSize 是搜索树和数据区之间的 16 个零字节
+	dataSectionSeparatorSize = 16
+)
+
+// Reader 读取 MaxMind DB（.mmdb）格式的数据库，只实现按 IP 查询国家代码所需的部分。
+type Reader struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dbType     string
+	// dataStart 是数据区在 buf 中的偏移
+	dataStart uint
+	// ipv4Start 是 IPv6 数据库中 ::/96 子树的节点，IPv4 地址从这里开始查找
+	ipv4Start uint
+
+	// countries 缓存数据区偏移对应的国家代码，同一个国家的记录通常共享同一个偏移
+	countries sync.Map
+}
+
+// Open 将整个数据库文件读入内存。
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(buf)
+}
+
+func FromBytes(buf []byte) (*Reader, error) {
+	idx := bytes.LastIndex(buf, metadataStartMarker)
+	if idx < 0 {
+		return nil, errors.New("invalid MaxMind DB: metadata not found")
+	}
+	metaStart := uint(idx + len(metadataStartMarker))
+	d := decoder{buf: buf[metaStart:]}
+	v, _, err := d.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind DB metadata: %v", err)
+	}
+	meta, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid MaxMind DB metadata: not a map")
+	}
+
+	r := &Reader{
+		buf:        buf,
+		nodeCount:  uint(toUint(meta["node_count"])),
+		recordSize: uint(toUint(meta["record_size"])),
+		ipVersion:  uint(toUint(meta["ip_version"])),
+	}
+	r.dbType, _ = meta["database_type"].(string)
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported MaxMind DB record size [%d]", r.recordSize)
+	}
+	// 每个节点至少占用 6 个字节，先检查节点数，避免计算搜索树大小时溢出
+	if r.nodeCount > uint(len(buf)) {
+		return nil, errors.New("invalid MaxMind DB: search tree exceeds file size")
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	r.dataStart = treeSize + dataSectionSeparatorSize
+	if r.dataStart > metaStart {
+		return nil, errors.New("invalid MaxMind DB: search tree exceeds file size")
+	}
+
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.readNode(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// DatabaseType 返回元数据中的 database_type，例如 "GeoLite2-Country"。
+func (r *Reader) DatabaseType() string {
+	return r.dbType
+}
+
+// Country 返回 IP 所属国家的 ISO 3166-1 代码，数据库中没有该 IP 时返回空字符串。
+func (r *Reader) Country(ip netip.Addr) (string, error) {
+	offset, ok, err := r.lookup(ip)
+	if err != nil || !ok {
+		return "", err
+	}
+	if v, ok := r.countries.Load(offset); ok {
+		return v.(string), nil
+	}
+
+	d := decoder{buf: r.buf[r.dataStart:]}
+	v, _, err := d.decode(offset, 0)
+	if err != nil {
+		return "", err
+	}
+	country := ""
+	if record, ok := v.(map[string]any); ok {
+		country = isoCode(record["country"])
+		if country == "" {
+			country = isoCode(record["registered_country"])
+		}
+	}
+	r.countries.Store(offset, country)
+	return country, nil
+}
+
+func isoCode(v any) string {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return ""
+	}
+	code, _ := m["iso_code"].(string)
+	return code
+}
+
+// lookup 返回 IP 对应记录在数据区中的偏移。
+func (r *Reader) lookup(ip netip.Addr) (uint, bool, error) {
+	ip = ip.Unmap()
+	node := uint(0)
+	if ip.Is4() && r.ipVersion == 6 {
+		node = r.ipv4Start
+	} else if ip.Is6() && r.ipVersion == 4 {
+		return 0, false, nil
+	}
+
+	raw := ip.AsSlice()
+	for i := 0; i < len(raw)*8 && node < r.nodeCount; i++ {
+		bit := uint(raw[i>>3]>>(7-uint(i&7))) & 1
+		node = r.readNode(node, bit)
+	}
+	switch {
+	case node == r.nodeCount:
+		return 0, false, nil
+	case node > r.nodeCount:
+		// 指向分隔符的记录在正常的文件中不会出现，直接相减会下溢
+		if node < r.nodeCount+dataSectionSeparatorSize {
+			return 0, false, errors.New("invalid MaxMind DB: data pointer points into separator")
+		}
+		offset := node - r.nodeCount - dataSectionSeparatorSize
+		if r.dataStart+offset >= uint(len(r.buf)) {
+			return 0, false, errors.New("invalid MaxMind DB: data pointer out of range")
+		}
+		return offset, true, nil
+	default:
+		return 0, false, errors.New("invalid MaxMind DB: search tree is too shallow")
+	}
+}
+
+// readNode 返回节点的左（bit 为 0）或右记录。
+func (r *Reader) readNode(node, bit uint) uint {
+	b := r.buf[node*r.recordSize/4:]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// decoder 解码数据区中的值，指针相对于 buf 的起始位置。
+type decoder struct {
+	buf []byte
+}
+
+// maxDecodeDepth 限制嵌套深度，避免损坏的文件导致无限递归
+const maxDecodeDepth = 32
+
+// decode 返回 offset 处的值以及下一个值的偏移。
+func (d *decoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, errors.New("maximum data structure depth exceeded")
+	}
+	typeNum, size, offset, err := d.decodeCtrl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typeNum == typePointer {
+		ptr, next, err := d.decodePointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.decode(ptr, depth+1)
+		return v, next, err
+	}
+
+	// map 和数组的每个元素至少占用一个字节，大小超过剩余数据的容器来自损坏的文件，不能按它分配内存
+	if (typeNum == typeMap || typeNum == typeArray) && size > uint(len(d.buf))-offset {
+		return nil, 0, fmt.Errorf("container size [%d] exceeds data size", size)
+	}
+	if typeNum == typeMap {
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			var v any
+			if v, offset, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+		}
+		return m, offset, nil
+	}
+	if typeNum == typeArray {
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			var v any
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, offset, nil
+	}
+	if typeNum == typeBool {
+		return size != 0, offset, nil
+	}
+
+	end := offset + size
+	if end > uint(len(d.buf)) || end < offset {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	b := d.buf[offset:end]
+	switch typeNum {
+	case typeString:
+		return string(b), end, nil
+	case typeBytes:
+		return append([]byte(nil), b...), end, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size [%d]", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), end, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size [%d]", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), end, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("invalid uint size [%d]", size)
+		}
+		return uintFromBytes(b), end, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("invalid int32 size [%d]", size)
+		}
+		return int32(uint32(uintFromBytes(b))), end, nil
+	case typeUint128:
+		// 国家查询用不到 uint128，保留原始字节
+		return append([]byte(nil), b...), end, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported data type [%d]", typeNum)
+	}
+}
+
+// decodeCtrl 解析控制字节，返回类型、大小和数据的偏移。
+func (d *decoder) decodeCtrl(offset uint) (typeNum, size, next uint, err error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, errors.New("unexpected end of data")
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typeNum = uint(ctrl >> 5)
+	if typeNum == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, errors.New("unexpected end of data")
+		}
+		typeNum = 7 + uint(d.buf[offset])
+		offset++
+	}
+	if typeNum == typeContainer || typeNum == typeEnd {
+		return 0, 0, 0, fmt.Errorf("unsupported data type [%d]", typeNum)
+	}
+
+	size = uint(ctrl & 0x1f)
+	if typeNum == typePointer || size < 29 {
+		return typeNum, size, offset, nil
+	}
+	n := size - 28
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, 0, errors.New("unexpected end of data")
+	}
+	extra := uintFromBytes(d.buf[offset : offset+n])
+	switch n {
+	case 1:
+		size = 29 + uint(extra)
+	case 2:
+		size = 285 + uint(extra)
+	default:
+		size = 65821 + uint(extra)
+	}
+	return typeNum, size, offset + n, nil
+}
+
+// decodePointer 的 size 是控制字节的低 5 位，其中高 2 位为指针的长度，低 3 位为指针值的高位。
+func (d *decoder) decodePointer(size, offset uint) (uint, uint, error) {
+	n := (size>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	b := d.buf[offset : offset+n]
+	var ptr uint
+	switch n {
+	case 1:
+		ptr = (size&0x7)<<8 | uint(b[0])
+	case 2:
+		ptr = ((size&0x7)<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+	case 3:
+		ptr = ((size&0x7)<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+	default:
+		ptr = uint(binary.BigEndian.Uint32(b))
+	}
+	return ptr, offset + n, nil
+}
+
+func uintFromBytes(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func toUint(v any) uint64 {
+	switch x := v.(type) {
+	case uint64:
+		return x
+	case int32:
+		return uint64(x)
+	}
+	return 0
+}
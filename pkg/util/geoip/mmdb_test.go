@@ -0,0 +1,227 @@
+package geoip
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+)
+
+// testMMDB 构造只包含 IPv4 /8 网段的最小 MaxMind DB 文件
+type testMMDB struct {
+	recordSize int
+	// nodes 中为 0 的记录表示没有数据，根节点不会是其他节点的子节点
+	nodes [][2]uint
+	// leaves 记录指向数据区的记录，值需要在节点数确定后才能计算
+	leaves map[[2]int]func(nodeCount uint) uint
+	data   []byte
+}
+
+func newTestMMDB() *testMMDB {
+	return &testMMDB{
+		recordSize: 24,
+		nodes:      make([][2]uint, 1),
+		leaves:     make(map[[2]int]func(uint) uint),
+	}
+}
+
+// add 将 octet.0.0.0/8 指向 record 返回的记录值
+func (db *testMMDB) add(octet byte, record func(nodeCount uint) uint) {
+	node := 0
+	for i := 0; i < 8; i++ {
+		bit := int(octet>>(7-i)) & 1
+		if i == 7 {
+			db.leaves[[2]int{node, bit}] = record
+			return
+		}
+		if db.nodes[node][bit] == 0 {
+			db.nodes = append(db.nodes, [2]uint{})
+			db.nodes[node][bit] = uint(len(db.nodes) - 1)
+		}
+		node = int(db.nodes[node][bit])
+	}
+}
+
+// addData 将 octet.0.0.0/8 指向数据区中 offset 处的记录
+func (db *testMMDB) addData(octet byte, offset int) {
+	db.add(octet, func(nodeCount uint) uint {
+		return nodeCount + dataSectionSeparatorSize + uint(offset)
+	})
+}
+
+func (db *testMMDB) bytes() []byte {
+	nodeCount := uint(len(db.nodes))
+	var buf []byte
+	for i, n := range db.nodes {
+		for bit, v := range n {
+			if v == 0 {
+				v = nodeCount
+			}
+			if record, ok := db.leaves[[2]int{i, bit}]; ok {
+				v = record(nodeCount)
+			}
+			buf = append(buf, byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+	buf = append(buf, make([]byte, dataSectionSeparatorSize)...)
+	buf = append(buf, db.data...)
+	buf = append(buf, metadataStartMarker...)
+	buf = append(buf, encMap(4)...)
+	buf = append(buf, encString("node_count")...)
+	buf = append(buf, encUint(typeUint32, uint64(nodeCount))...)
+	buf = append(buf, encString("record_size")...)
+	buf = append(buf, encUint(typeUint16, uint64(db.recordSize))...)
+	buf = append(buf, encString("ip_version")...)
+	buf = append(buf, encUint(typeUint16, 4)...)
+	buf = append(buf, encString("database_type")...)
+	buf = append(buf, encString("Test-Country")...)
+	return buf
+}
+
+func encString(s string) []byte {
+	return append([]byte{byte(typeString<<5 | len(s))}, s...)
+}
+
+func encMap(size int) []byte {
+	return []byte{byte(typeMap<<5 | size)}
+}
+
+func encUint(typeNum int, v uint64) []byte {
+	var b []byte
+	for ; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	return append([]byte{byte(typeNum<<5 | len(b))}, b...)
+}
+
+// encPointer 编码小于 2048 的指针
+func encPointer(ptr int) []byte {
+	return []byte{byte(typePointer<<5 | ptr>>8), byte(ptr)}
+}
+
+// encCountryRecord 编码 {key: {"iso_code": code}}，返回内层 map 的偏移
+func (db *testMMDB) encCountryRecord(key, code string) (int, int) {
+	start := len(db.data)
+	db.data = append(db.data, encMap(1)...)
+	db.data = append(db.data, encString(key)...)
+	inner := len(db.data)
+	db.data = append(db.data, encMap(1)...)
+	db.data = append(db.data, encString("iso_code")...)
+	db.data = append(db.data, encString(code)...)
+	return start, inner
+}
+
+func TestReaderCountry(t *testing.T) {
+	db := newTestMMDB()
+	au, auCountry := db.encCountryRecord("country", "AU")
+	db.addData(1, au)
+	fr, _ := db.encCountryRecord("registered_country", "FR")
+	db.addData(2, fr)
+	ptr := len(db.data)
+	db.data = append(db.data, encMap(1)...)
+	db.data = append(db.data, encString("country")...)
+	db.data = append(db.data, encPointer(auCountry)...)
+	db.addData(3, ptr)
+
+	r, err := FromBytes(db.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.DatabaseType() != "Test-Country" {
+		t.Errorf("got database type %q, want Test-Country", r.DatabaseType())
+	}
+	for _, tc := range []struct {
+		ip   string
+		want string
+	}{
+		{"1.2.3.4", "AU"},
+		{"::ffff:1.2.3.4", "AU"},
+		{"2.2.3.4", "FR"},
+		{"3.2.3.4", "AU"},
+		{"4.2.3.4", ""},
+		{"2001:db8::1", ""},
+	} {
+		// 第二次查询命中缓存，结果应相同
+		for i := 0; i < 2; i++ {
+			got, err := r.Country(netip.MustParseAddr(tc.ip))
+			if err != nil {
+				t.Fatalf("%s: %v", tc.ip, err)
+			}
+			if got != tc.want {
+				t.Fatalf("%s: got %q, want %q", tc.ip, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestReaderInvalid(t *testing.T) {
+	if _, err := FromBytes([]byte("not a MaxMind DB")); err == nil {
+		t.Error("file without metadata should be rejected")
+	}
+
+	db := newTestMMDB()
+	db.recordSize = 20
+	if _, err := FromBytes(db.bytes()); err == nil {
+		t.Error("unsupported record size should be rejected")
+	}
+
+	db = newTestMMDB()
+	buf := db.bytes()
+	// 元数据中的节点数远大于文件
+	huge := append(buf[:bytes.LastIndex(buf, metadataStartMarker)], metadataStartMarker...)
+	huge = append(huge, encMap(2)...)
+	huge = append(huge, encString("node_count")...)
+	huge = append(huge, encUint(typeUint32, 0xFFFFFFFF)...)
+	huge = append(huge, encString("record_size")...)
+	huge = append(huge, encUint(typeUint16, 32)...)
+	if _, err := FromBytes(huge); err == nil {
+		t.Error("search tree larger than file should be rejected")
+	}
+
+	// 指向分隔符的记录
+	db = newTestMMDB()
+	db.add(1, func(nodeCount uint) uint { return nodeCount + 1 })
+	r, err := FromBytes(db.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Country(netip.MustParseAddr("1.2.3.4")); err == nil {
+		t.Error("record pointing into separator should return error")
+	}
+
+	// 超出数据区的记录
+	db = newTestMMDB()
+	db.addData(1, 1<<20)
+	r, err = FromBytes(db.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Country(netip.MustParseAddr("1.2.3.4")); err == nil {
+		t.Error("record pointing past data section should return error")
+	}
+}
+
+func TestDecoderInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		buf  []byte
+	}{
+		{"empty", nil},
+		// 控制字节 0xFF 为 map，后 3 个字节给出的大小约为 16M
+		{"huge map", []byte{0xFF, 0xFF, 0xFF, 0xFF}},
+		// 扩展类型 4 + 7 为数组
+		{"huge array", []byte{0x1F, 0x04, 0xFF, 0xFF, 0xFF}},
+		{"map larger than data", []byte{byte(typeMap<<5 | 5), 0x41, 'a'}},
+		{"truncated string", []byte{byte(typeString<<5 | 10), 'a'}},
+		{"non-string map key", append(encMap(1), append(encUint(typeUint16, 1), encUint(typeUint16, 1)...)...)},
+		{"pointer loop", encPointer(0)},
+		{"unsupported type", []byte{0x00, byte(typeEnd - 7)}},
+		{"invalid double", []byte{byte(typeDouble<<5 | 4), 0, 0, 0, 0}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := decoder{buf: tc.buf}
+			if v, _, err := d.decode(0, 0); err == nil {
+				t.Fatalf("got %v, want error", v)
+			}
+		})
+	}
+}
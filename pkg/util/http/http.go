@@ -0,0 +1,38 @@
+package http
+
+import (
+	"encoding/base64"
+	"net"
+	"strings"
+)
+
+// CanonicalHost 去掉 host 中的端口并转换为小写。
+func CanonicalHost(host string) (string, error) {
+	if strings.HasPrefix(host, "[") || strings.Count(host, ":") == 1 {
+		h, _, err := net.SplitHostPort(host)
+		if err != nil {
+			return "", err
+		}
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, ".")), nil
+}
+
+// ParseBasicAuth 解析 Authorization 或 Proxy-Authorization 请求头中的 Basic 认证信息。
+func ParseBasicAuth(auth string) (username, password string, ok bool) {
+	const prefix = "Basic "
+	// Case insensitive prefix match. See Issue 22736.
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return
+	}
+	c, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return
+	}
+	cs := string(c)
+	s := strings.IndexByte(cs, ':')
+	if s < 0 {
+		return
+	}
+	return cs[:s], cs[s+1:], true
+}
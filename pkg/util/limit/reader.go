@@ -0,0 +1,34 @@
+package limit
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"io"
+)
+
+// Reader 按 limiter 限制读取速率，单位为字节每秒。
+type Reader struct {
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func NewReader(r io.Reader, limiter *rate.Limiter) *Reader {
+	return &Reader{
+		r:       r,
+		limiter: limiter,
+	}
+}
+
+func (r *Reader) Read(p []byte) (n int, err error) {
+	// 每次最多读取 burst 字节，否则 WaitN 会返回错误
+	b := r.limiter.Burst()
+	if b < len(p) {
+		p = p[:b]
+	}
+	n, err = r.r.Read(p)
+	if err != nil {
+		return
+	}
+	err = r.limiter.WaitN(context.Background(), n)
+	return
+}
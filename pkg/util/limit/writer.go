@@ -0,0 +1,41 @@
+package limit
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"io"
+)
+
+// Writer 按 limiter 限制写入速率，单位为字节每秒。
+type Writer struct {
+	w       io.Writer
+	limiter *rate.Limiter
+}
+
+func NewWriter(w io.Writer, limiter *rate.Limiter) *Writer {
+	return &Writer{
+		w:       w,
+		limiter: limiter,
+	}
+}
+
+func (w *Writer) Write(p []byte) (n int, err error) {
+	var nn int
+	b := w.limiter.Burst()
+	for len(p) > 0 {
+		end := len(p)
+		if b < end {
+			end = b
+		}
+		if err = w.limiter.WaitN(context.Background(), end); err != nil {
+			return
+		}
+		nn, err = w.w.Write(p[:end])
+		n += nn
+		if err != nil {
+			return
+		}
+		p = p[end:]
+	}
+	return
+}
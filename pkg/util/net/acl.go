@@ -0,0 +1,160 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// IPACL 按来源 IP 的允许和拒绝列表控制访问，列表元素为 CIDR 或单个 IP。
+// 拒绝列表优先于允许列表，允许列表为空时允许所有未被拒绝的 IP。
+// parent 拒绝的 IP 总是被拒绝，因此子列表只能进一步收紧 parent 的范围。
+type IPACL struct {
+	allow  []netip.Prefix
+	deny   []netip.Prefix
+	parent *IPACL
+}
+
+// NewIPACL 在两个列表都为空时返回 parent，parent 可以为 nil。
+func NewIPACL(allow, deny []string, parent *IPACL) (*IPACL, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return parent, nil
+	}
+	acl := &IPACL{parent: parent}
+	var err error
+	if acl.allow, err = parsePrefixes(allow); err != nil {
+		return nil, err
+	}
+	if acl.deny, err = parsePrefixes(deny); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+func parsePrefixes(items []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if strings.Contains(item, "/") {
+			p, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR [%s]: %v", item, err)
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP [%s]: %v", item, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// Allowed 返回是否允许来源 IP 访问，nil 的 IPACL 允许所有 IP。
+func (acl *IPACL) Allowed(ip netip.Addr) bool {
+	if acl == nil {
+		return true
+	}
+	if !acl.parent.Allowed(ip) {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range acl.deny {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	if len(acl.allow) == 0 {
+		return true
+	}
+	for _, p := range acl.allow {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedAddr 与 Allowed 相同，但接受 "ip:port" 或 "ip" 形式的地址，无法解析的地址被拒绝。
+func (acl *IPACL) AllowedAddr(addr string) bool {
+	if acl == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	return acl.Allowed(ip)
+}
+
+// SourceIPFilterFunc 返回是否允许来源地址访问。
+type SourceIPFilterFunc func(remoteAddr string) bool
+
+// FilterListener 关闭来源地址不被允许的连接，Accept 只返回允许的连接。
+type FilterListener struct {
+	net.Listener
+
+	filter SourceIPFilterFunc
+}
+
+// NewFilterListener 在 filter 为 nil 时直接返回 l。
+func NewFilterListener(l net.Listener, filter SourceIPFilterFunc) net.Listener {
+	if filter == nil {
+		return l
+	}
+	return &FilterListener{
+		Listener: l,
+		filter:   filter,
+	}
+}
+
+func (l *FilterListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.filter(c.RemoteAddr().String()) {
+			return c, nil
+		}
+		_ = c.Close()
+	}
+}
+
+// FilterPacketConn 丢弃来源地址不被允许的数据包。
+type FilterPacketConn struct {
+	net.PacketConn
+
+	filter SourceIPFilterFunc
+}
+
+// NewFilterPacketConn 在 filter 为 nil 时直接返回 c。
+func NewFilterPacketConn(c net.PacketConn, filter SourceIPFilterFunc) net.PacketConn {
+	if filter == nil {
+		return c
+	}
+	return &FilterPacketConn{
+		PacketConn: c,
+		filter:     filter,
+	}
+}
+
+func (c *FilterPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil {
+			return n, addr, err
+		}
+		if c.filter(addr.String()) {
+			return n, addr, nil
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package net
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIPACLParentPrecedence(t *testing.T) {
+	parent, err := NewIPACL(nil, []string{"10.0.0.0/8"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 子列表显式允许 parent 拒绝的网段
+	child, err := NewIPACL([]string{"10.1.0.0/16", "192.168.1.1"}, []string{"192.168.1.1"}, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := NewIPACL(nil, nil, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty != parent {
+		t.Error("empty lists should return the parent")
+	}
+
+	for _, tc := range []struct {
+		addr string
+		acl  *IPACL
+		want bool
+	}{
+		{"10.1.2.3:80", child, false},
+		{"10.1.2.3:80", parent, false},
+		{"192.168.1.1:80", child, false},
+		{"172.16.0.1:80", child, false},
+		{"172.16.0.1:80", parent, true},
+		{"[::ffff:10.1.2.3]:80", child, false},
+		{"[::ffff:172.16.0.1]:80", parent, true},
+		{"172.16.0.1", parent, true},
+		{"bad-addr", parent, false},
+		{"bad-addr", nil, true},
+	} {
+		if got := tc.acl.AllowedAddr(tc.addr); got != tc.want {
+			t.Errorf("AllowedAddr(%q) on %p: got %v, want %v", tc.addr, tc.acl, got, tc.want)
+		}
+	}
+}
+
+func TestNewIPACLInvalid(t *testing.T) {
+	for _, items := range [][]string{{"10.0.0.0/33"}, {"not-an-ip"}} {
+		if _, err := NewIPACL(items, nil, nil); err == nil {
+			t.Errorf("NewIPACL(%v) should fail", items)
+		}
+	}
+}
+
+func TestFilterListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if NewFilterListener(l, nil) != l {
+		t.Error("nil filter should return the listener itself")
+	}
+
+	allowed := make(chan bool, 1)
+	fl := NewFilterListener(l, func(string) bool { return <-allowed })
+	acceptCh := make(chan net.Conn, 1)
+	go func() {
+		c, err := fl.Accept()
+		if err == nil {
+			acceptCh <- c
+		}
+	}()
+
+	// 被拒绝的连接直接关闭，Accept 继续等待下一个连接
+	allowed <- false
+	denied, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer denied.Close()
+	_ = denied.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := denied.Read(make([]byte, 1)); err == nil {
+		t.Error("denied connection should be closed")
+	}
+	select {
+	case <-acceptCh:
+		t.Fatal("denied connection should not be accepted")
+	default:
+	}
+
+	allowed <- true
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	select {
+	case ac := <-acceptCh:
+		ac.Close()
+	case <-time.After(time.Second):
+		t.Fatal("allowed connection should be accepted")
+	}
+}
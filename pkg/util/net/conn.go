@@ -0,0 +1,96 @@
+package net
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// WrapReadWriteCloserConn 将 io.ReadWriteCloser 包装为 net.Conn，地址和超时设置使用底层连接的。
+type WrapReadWriteCloserConn struct {
+	io.ReadWriteCloser
+
+	underConn net.Conn
+}
+
+func WrapReadWriteCloserToConn(rwc io.ReadWriteCloser, underConn net.Conn) *WrapReadWriteCloserConn {
+	return &WrapReadWriteCloserConn{
+		ReadWriteCloser: rwc,
+		underConn:       underConn,
+	}
+}
+
+func (conn *WrapReadWriteCloserConn) LocalAddr() net.Addr {
+	if conn.underConn != nil {
+		return conn.underConn.LocalAddr()
+	}
+	return (*net.TCPAddr)(nil)
+}
+
+func (conn *WrapReadWriteCloserConn) RemoteAddr() net.Addr {
+	if conn.underConn != nil {
+		return conn.underConn.RemoteAddr()
+	}
+	return (*net.TCPAddr)(nil)
+}
+
+func (conn *WrapReadWriteCloserConn) SetDeadline(t time.Time) error {
+	if conn.underConn != nil {
+		return conn.underConn.SetDeadline(t)
+	}
+	return nil
+}
+
+func (conn *WrapReadWriteCloserConn) SetReadDeadline(t time.Time) error {
+	if conn.underConn != nil {
+		return conn.underConn.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (conn *WrapReadWriteCloserConn) SetWriteDeadline(t time.Time) error {
+	if conn.underConn != nil {
+		return conn.underConn.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+// StatsConn 在连接第一次关闭时通过 statsFunc 报告读取和写入的总字节数。
+type StatsConn struct {
+	net.Conn
+
+	closed     int64
+	totalRead  int64
+	totalWrite int64
+	statsFunc  func(totalRead, totalWrite int64)
+}
+
+func WrapStatsConn(conn net.Conn, statsFunc func(totalRead, totalWrite int64)) *StatsConn {
+	return &StatsConn{
+		Conn:      conn,
+		statsFunc: statsFunc,
+	}
+}
+
+func (statsConn *StatsConn) Read(p []byte) (n int, err error) {
+	n, err = statsConn.Conn.Read(p)
+	atomic.AddInt64(&statsConn.totalRead, int64(n))
+	return
+}
+
+func (statsConn *StatsConn) Write(p []byte) (n int, err error) {
+	n, err = statsConn.Conn.Write(p)
+	atomic.AddInt64(&statsConn.totalWrite, int64(n))
+	return
+}
+
+func (statsConn *StatsConn) Close() (err error) {
+	if atomic.CompareAndSwapInt64(&statsConn.closed, 0, 1) {
+		err = statsConn.Conn.Close()
+		if statsConn.statsFunc != nil {
+			statsConn.statsFunc(atomic.LoadInt64(&statsConn.totalRead), atomic.LoadInt64(&statsConn.totalWrite))
+		}
+	}
+	return
+}
@@ -0,0 +1,106 @@
+package net
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dummyBcryptHash 用于不存在的用户，使校验耗时与存在的用户相同。它是固定字符串的 bcrypt 哈希，
+// 预先计算以避免在包初始化时执行 bcrypt。
+var dummyBcryptHash = []byte("$2a$10$uPU5EM3Mrca.rQq4tb4zHe75TNJrI1bAgofJ6tUIJZGiX2sPBSdca")
+
+// Htpasswd 是 htpasswd 格式的用户列表，每行为 "用户名:bcrypt 哈希"，只支持 bcrypt（$2a$、$2b$ 和 $2y$）。
+// bcrypt 校验很慢，而浏览器会在每个请求中发送 Basic 认证，因此校验成功后会缓存用户最近一次密码的 SHA-256。
+type Htpasswd struct {
+	users         map[string][]byte
+	authFailDelay time.Duration
+
+	// verified 是校验成功的用户最近一次密码的 SHA-256
+	verified map[string][sha256.Size]byte
+	mu       sync.RWMutex
+}
+
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h, err := ParseHtpasswd(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return h, nil
+}
+
+// ParseHtpasswd 忽略空行和以 "#" 开头的注释行。
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	h := &Htpasswd{
+		users:    make(map[string][]byte),
+		verified: make(map[string][sha256.Size]byte),
+	}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: invalid htpasswd entry", lineNo)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("line %d: user [%s] is not using a bcrypt hash", lineNo, user)
+		}
+		h.users[user] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// SetAuthFailDelay 设置校验失败后返回之前等待的时间，用于减慢暴力破解，与 HTTPAuthMiddleware 相同。
+func (h *Htpasswd) SetAuthFailDelay(delay time.Duration) *Htpasswd {
+	h.authFailDelay = delay
+	return h
+}
+
+// Verify 校验用户名和密码，失败时等待 authFailDelay 后返回。没有提供用户名和密码时立即返回 false。
+func (h *Htpasswd) Verify(user, passwd string) bool {
+	if user == "" && passwd == "" {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(passwd))
+	h.mu.RLock()
+	cached, ok := h.verified[user]
+	h.mu.RUnlock()
+	if ok && subtle.ConstantTimeCompare(cached[:], sum[:]) == 1 {
+		return true
+	}
+
+	hash, ok := h.users[user]
+	if !ok {
+		hash = dummyBcryptHash
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(passwd)) != nil || !ok {
+		if h.authFailDelay > 0 {
+			time.Sleep(h.authFailDelay)
+		}
+		return false
+	}
+
+	h.mu.Lock()
+	h.verified[user] = sum
+	h.mu.Unlock()
+	return true
+}
@@ -0,0 +1,21 @@
+package tcpmux
+
+import (
+	"github.com/sunyihoo/frp/pkg/util/vhost"
+	"net"
+	"time"
+)
+
+// SNITCPMuxer 按 TLS ClientHello 中的 SNI 将连接路由到 tcpmux 代理，TLS 流量原样转发给客户端，
+// 因此多个 TLS 服务可以共享一个公网端口。它不支持 httpUser 认证。
+type SNITCPMuxer struct {
+	*vhost.Muxer
+}
+
+func NewSNITCPMuxer(listener net.Listener, timeout time.Duration) (*SNITCPMuxer, error) {
+	mux, err := vhost.NewHTTPSMuxer(listener, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &SNITCPMuxer{Muxer: mux.Muxer}, nil
+}
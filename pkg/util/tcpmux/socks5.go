@@ -0,0 +1,195 @@
+package tcpmux
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sunyihoo/frp/pkg/util/vhost"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xff
+	// 用户名密码认证子协商的版本，见 RFC 1929
+	socks5PasswordVersion = 0x01
+
+	socks5CmdConnect = 0x01
+
+	socks5AtypIPv4   = 0x01
+	socks5AtypDomain = 0x03
+	socks5AtypIPv6   = 0x04
+
+	socks5RepSucceeded        = 0x00
+	socks5RepNotAllowed       = 0x02
+	socks5RepHostUnreachable  = 0x04
+	socks5RepCmdNotSupported  = 0x07
+	socks5RepAtypNotSupported = 0x08
+)
+
+// SOCKS5TCPMuxer 按 SOCKS5 CONNECT 请求的目标主机名将连接路由到 tcpmux 代理，CONNECT 成功后的流量原样转发给客户端。
+// 客户端使用用户名密码认证时，用户名用于 routeByHTTPUser 路由，用户名和密码用于 httpUser 和 httpPassword 认证。
+type SOCKS5TCPMuxer struct {
+	*vhost.Muxer
+}
+
+func NewSOCKS5TCPMuxer(listener net.Listener, timeout time.Duration) (*SOCKS5TCPMuxer, error) {
+	mux, err := vhost.NewMuxer(listener, getHostFromSOCKS5Handshake, timeout)
+	if err != nil {
+		return nil, err
+	}
+	mux.SetCheckAuthFunc(socks5Auth).
+		SetSuccessHookFunc(socks5Succeeded).
+		SetFailHookFunc(socks5Failed)
+	return &SOCKS5TCPMuxer{Muxer: mux}, nil
+}
+
+// getHostFromSOCKS5Handshake 完成认证方式协商并读取 CONNECT 请求，CONNECT 的响应在找到路由后发送。
+// 密码同样在找到路由后才校验，因此认证子协商总是返回成功，校验失败时拒绝 CONNECT 请求。
+func getHostFromSOCKS5Handshake(c net.Conn) (net.Conn, map[string]string, error) {
+	reqInfoMap := make(map[string]string, 0)
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return nil, reqInfoMap, err
+	}
+	if buf[0] != socks5Version {
+		return nil, reqInfoMap, fmt.Errorf("unsupported socks version [%d]", buf[0])
+	}
+	methods := make([]byte, buf[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return nil, reqInfoMap, err
+	}
+
+	method := byte(socks5AuthNoAccept)
+	if bytes.IndexByte(methods, socks5AuthPassword) >= 0 {
+		method = socks5AuthPassword
+	} else if bytes.IndexByte(methods, socks5AuthNone) >= 0 {
+		method = socks5AuthNone
+	}
+	if _, err := c.Write([]byte{socks5Version, method}); err != nil {
+		return nil, reqInfoMap, err
+	}
+	if method == socks5AuthNoAccept {
+		return nil, reqInfoMap, fmt.Errorf("no acceptable socks5 auth method")
+	}
+
+	if method == socks5AuthPassword {
+		user, passwd, err := readSOCKS5Password(c)
+		if err != nil {
+			return nil, reqInfoMap, err
+		}
+		reqInfoMap["HTTPUser"] = user
+		reqInfoMap["HTTPPwd"] = passwd
+		if _, err := c.Write([]byte{socks5PasswordVersion, 0x00}); err != nil {
+			return nil, reqInfoMap, err
+		}
+	}
+
+	host, err := readSOCKS5ConnectRequest(c)
+	if err != nil {
+		return nil, reqInfoMap, err
+	}
+	reqInfoMap["Host"] = host
+	reqInfoMap["Scheme"] = "socks5"
+	return c, reqInfoMap, nil
+}
+
+func readSOCKS5Password(c net.Conn) (user string, passwd string, err error) {
+	buf := make([]byte, 2)
+	if _, err = io.ReadFull(c, buf); err != nil {
+		return
+	}
+	if buf[0] != socks5PasswordVersion {
+		err = fmt.Errorf("unsupported socks5 password auth version [%d]", buf[0])
+		return
+	}
+	userBuf := make([]byte, buf[1])
+	if _, err = io.ReadFull(c, userBuf); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(c, buf[:1]); err != nil {
+		return
+	}
+	passwdBuf := make([]byte, buf[0])
+	if _, err = io.ReadFull(c, passwdBuf); err != nil {
+		return
+	}
+	return string(userBuf), string(passwdBuf), nil
+}
+
+// readSOCKS5ConnectRequest 返回 CONNECT 请求的目标主机，不包括端口。
+func readSOCKS5ConnectRequest(c net.Conn) (string, error) {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return "", err
+	}
+	if buf[0] != socks5Version {
+		return "", fmt.Errorf("unsupported socks version [%d]", buf[0])
+	}
+	if buf[1] != socks5CmdConnect {
+		_ = writeSOCKS5Reply(c, socks5RepCmdNotSupported)
+		return "", fmt.Errorf("unsupported socks5 command [%d]", buf[1])
+	}
+
+	var host string
+	switch buf[3] {
+	case socks5AtypIPv4, socks5AtypIPv6:
+		ip := make([]byte, net.IPv4len)
+		if buf[3] == socks5AtypIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(c, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AtypDomain:
+		if _, err := io.ReadFull(c, buf[:1]); err != nil {
+			return "", err
+		}
+		domain := make([]byte, buf[0])
+		if _, err := io.ReadFull(c, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		_ = writeSOCKS5Reply(c, socks5RepAtypNotSupported)
+		return "", fmt.Errorf("unsupported socks5 address type [%d]", buf[3])
+	}
+
+	// 目标端口不参与路由
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return "", err
+	}
+	if host == "" {
+		return "", fmt.Errorf("empty socks5 target host")
+	}
+	return host, nil
+}
+
+func writeSOCKS5Reply(c net.Conn, rep byte) error {
+	// 绑定地址对 tcpmux 没有意义，总是返回 0.0.0.0:0
+	_, err := c.Write([]byte{socks5Version, rep, 0x00, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func socks5Auth(c net.Conn, username, passwd string, reqInfoMap map[string]string) (bool, error) {
+	if reqInfoMap["HTTPUser"] == username && reqInfoMap["HTTPPwd"] == passwd {
+		return true, nil
+	}
+	_ = writeSOCKS5Reply(c, socks5RepNotAllowed)
+	return false, nil
+}
+
+func socks5Succeeded(c net.Conn, _ map[string]string) error {
+	return writeSOCKS5Reply(c, socks5RepSucceeded)
+}
+
+func socks5Failed(c net.Conn) {
+	_ = writeSOCKS5Reply(c, socks5RepHostUnreachable)
+	_ = c.Close()
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"crypto/md5"
+	"crypto/rand"
 	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
@@ -9,6 +10,31 @@ import (
 	"strings"
 )
 
+// RandID 返回 16 个字符的随机十六进制 ID。
+func RandID() (id string, err error) {
+	return RandIDWithLen(16)
+}
+
+func RandIDWithLen(idLen int) (id string, err error) {
+	if idLen <= 0 {
+		return "", nil
+	}
+	b := make([]byte, idLen/2+1)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	id = fmt.Sprintf("%x", b)
+	return id[:idLen], nil
+}
+
+// GenerateResponseErrorString 在 detailed 为 false 时只返回摘要，避免将内部错误暴露给客户端。
+func GenerateResponseErrorString(summary string, err error, detailed bool) string {
+	if detailed {
+		return err.Error()
+	}
+	return summary
+}
+
 func GetAuthKey(token string, timestamp int64) (key string) {
 	md5Ctx := md5.New()
 	md5Ctx.Write([]byte(token))
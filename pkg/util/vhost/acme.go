@@ -0,0 +1,109 @@
+package vhost
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type ACMEOptions struct {
+	Email        string
+	DirectoryURL string
+	// DirectoryCAFile 不为空时，只信任该 CA 签发的 ACME 服务证书
+	DirectoryCAFile string
+	CacheDir        string
+	// Domains 是允许申请证书的域名，"*.example.com" 匹配 example.com 的所有子域名
+	Domains     []string
+	RenewBefore time.Duration
+	// Routed 返回域名是否已被代理使用，为 nil 时只检查 Domains
+	Routed func(domain string) bool
+}
+
+// ACMEManager 为允许的域名自动申请和续期证书，证书和账户密钥保存在本地目录中。
+type ACMEManager struct {
+	options ACMEOptions
+	m       *autocert.Manager
+}
+
+func NewACMEManager(options ACMEOptions) (*ACMEManager, error) {
+	httpClient := http.DefaultClient
+	if options.DirectoryCAFile != "" {
+		caCrt, err := os.ReadFile(options.DirectoryCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCrt) {
+			return nil, fmt.Errorf("no certificates found in [%s]", options.DirectoryCAFile)
+		}
+		httpClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		}
+	}
+
+	am := &ACMEManager{
+		options: options,
+	}
+	am.m = &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(options.CacheDir),
+		HostPolicy:  am.hostPolicy,
+		RenewBefore: options.RenewBefore,
+		Email:       options.Email,
+		Client: &acme.Client{
+			DirectoryURL: options.DirectoryURL,
+			HTTPClient:   httpClient,
+		},
+	}
+	return am, nil
+}
+
+func (am *ACMEManager) hostPolicy(_ context.Context, host string) error {
+	if !am.Match(host) {
+		return fmt.Errorf("acme: domain [%s] is not allowed", host)
+	}
+	return nil
+}
+
+// Match 返回是否应为该域名申请证书。
+func (am *ACMEManager) Match(domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if !matchDomainPatterns(am.options.Domains, domain) {
+		return false
+	}
+	return am.options.Routed == nil || am.options.Routed(domain)
+}
+
+// GetCertificate 返回域名的证书，证书不存在或即将过期时向 ACME 服务申请。
+// 它同时处理 TLS-ALPN-01 验证的握手。
+func (am *ACMEManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return am.m.GetCertificate(hello)
+}
+
+// HTTPHandler 处理 HTTP-01 验证的请求，其他请求交给 fallback。它应该用于 vhostHTTPPort 上的 HTTP 服务。
+func (am *ACMEManager) HTTPHandler(fallback http.Handler) http.Handler {
+	return am.m.HTTPHandler(fallback)
+}
+
+func matchDomainPatterns(patterns []string, domain string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if p == domain {
+			return true
+		}
+		if strings.HasPrefix(p, "*.") && strings.HasSuffix(domain, p[1:]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,357 @@
+package vhost
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"golang.org/x/crypto/acme"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// idPeACMEIdentifier 是 TLS-ALPN-01 验证证书中保存密钥授权摘要的扩展，见 RFC 8737
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// stubACMEServer 是只支持 TLS-ALPN-01 验证的 ACME 服务，验证时连接 challengeAddr，不校验请求的签名。
+type stubACMEServer struct {
+	*httptest.Server
+
+	domain        string
+	challengeAddr string
+	token         string
+
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+
+	accountKey *ecdsa.PublicKey
+	authzValid bool
+	certPEM    []byte
+	mu         sync.Mutex
+}
+
+func newStubACMEServer(t *testing.T, domain, challengeAddr string) *stubACMEServer {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stub acme ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(der)
+
+	s := &stubACMEServer{
+		domain:        domain,
+		challengeAddr: challengeAddr,
+		token:         "stub-token",
+		caCert:        caCert,
+		caKey:         caKey,
+	}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *stubACMEServer) serveHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+	}
+	var payload []byte
+	if req.Method == http.MethodPost {
+		_ = json.NewDecoder(req.Body).Decode(&jws)
+		payload, _ = base64.RawURLEncoding.DecodeString(jws.Payload)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.URL
+	rw.Header().Set("Content-Type", "application/json")
+	switch req.URL.Path {
+	case "/directory":
+		writeJSON(rw, http.StatusOK, map[string]string{
+			"newNonce":   u + "/nonce",
+			"newAccount": u + "/account",
+			"newOrder":   u + "/order",
+		})
+	case "/nonce":
+	case "/account":
+		if err := s.parseAccountKey(jws.Protected); err != nil {
+			writeJSON(rw, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+			return
+		}
+		rw.Header().Set("Location", u+"/account/1")
+		writeJSON(rw, http.StatusCreated, map[string]string{"status": "valid"})
+	case "/order":
+		rw.Header().Set("Location", u+"/order/1")
+		writeJSON(rw, http.StatusCreated, s.order())
+	case "/order/1":
+		writeJSON(rw, http.StatusOK, s.order())
+	case "/authz/1":
+		status := "pending"
+		if s.authzValid {
+			status = "valid"
+		}
+		writeJSON(rw, http.StatusOK, map[string]interface{}{
+			"status":     status,
+			"identifier": map[string]string{"type": "dns", "value": s.domain},
+			"challenges": []map[string]string{s.challenge()},
+		})
+	case "/challenge/1":
+		// 与真实的 CA 一样，在接受验证请求后连接域名的 443 端口完成 TLS-ALPN-01 验证
+		if err := s.validate(); err != nil {
+			writeJSON(rw, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+			return
+		}
+		s.authzValid = true
+		writeJSON(rw, http.StatusOK, s.challenge())
+	case "/finalize":
+		var csrReq struct {
+			CSR string `json:"csr"`
+		}
+		_ = json.Unmarshal(payload, &csrReq)
+		if err := s.issue(csrReq.CSR); err != nil {
+			writeJSON(rw, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+			return
+		}
+		rw.Header().Set("Location", u+"/order/1")
+		writeJSON(rw, http.StatusOK, s.order())
+	case "/cert":
+		rw.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = rw.Write(s.certPEM)
+	default:
+		rw.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(v)
+}
+
+func (s *stubACMEServer) order() map[string]interface{} {
+	status := "pending"
+	switch {
+	case s.certPEM != nil:
+		status = "valid"
+	case s.authzValid:
+		status = "ready"
+	}
+	o := map[string]interface{}{
+		"status":         status,
+		"identifiers":    []map[string]string{{"type": "dns", "value": s.domain}},
+		"authorizations": []string{s.URL + "/authz/1"},
+		"finalize":       s.URL + "/finalize",
+	}
+	if s.certPEM != nil {
+		o["certificate"] = s.URL + "/cert"
+	}
+	return o
+}
+
+func (s *stubACMEServer) challenge() map[string]string {
+	status := "pending"
+	if s.authzValid {
+		status = "valid"
+	}
+	return map[string]string{
+		"type":   "tls-alpn-01",
+		"url":    s.URL + "/challenge/1",
+		"token":  s.token,
+		"status": status,
+	}
+}
+
+func (s *stubACMEServer) parseAccountKey(protected string) error {
+	buf, err := base64.RawURLEncoding.DecodeString(protected)
+	if err != nil {
+		return err
+	}
+	var header struct {
+		JWK struct {
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"jwk"`
+	}
+	if err := json.Unmarshal(buf, &header); err != nil {
+		return err
+	}
+	if header.JWK.Crv != "P-256" {
+		return fmt.Errorf("unsupported account key curve [%s]", header.JWK.Crv)
+	}
+	x, _ := base64.RawURLEncoding.DecodeString(header.JWK.X)
+	y, _ := base64.RawURLEncoding.DecodeString(header.JWK.Y)
+	s.accountKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	return nil
+}
+
+func (s *stubACMEServer) validate() error {
+	conn, err := tls.Dial("tcp", s.challengeAddr, &tls.Config{
+		ServerName:         s.domain,
+		NextProtos:         []string{acme.ALPNProto},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != acme.ALPNProto {
+		return fmt.Errorf("negotiated protocol [%s]", state.NegotiatedProtocol)
+	}
+
+	thumbprint, err := acme.JWKThumbprint(s.accountKey)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(s.token + "." + thumbprint))
+	for _, ext := range state.PeerCertificates[0].Extensions {
+		if !ext.Id.Equal(idPeACMEIdentifier) {
+			continue
+		}
+		var v []byte
+		if _, err := asn1.Unmarshal(ext.Value, &v); err != nil {
+			return err
+		}
+		if string(v) != string(digest[:]) {
+			return fmt.Errorf("key authorization mismatch")
+		}
+		return nil
+	}
+	return fmt.Errorf("no acmeIdentifier extension in challenge certificate")
+}
+
+func (s *stubACMEServer) issue(csrB64 string) error {
+	der, err := base64.RawURLEncoding.DecodeString(csrB64)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: s.domain},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, tmpl, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		return err
+	}
+	s.certPEM = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})...)
+	return nil
+}
+
+func TestHTTPSMuxerACMEIssue(t *testing.T) {
+	const domain = "acme.example.test"
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ca := newStubACMEServer(t, domain, l.Addr().String())
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	acmeManager, err := NewACMEManager(ACMEOptions{
+		DirectoryURL:    ca.URL + "/directory",
+		DirectoryCAFile: caFile,
+		CacheDir:        t.TempDir(),
+		Domains:         []string{"*.example.test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux, err := NewHTTPSMuxer(l, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.SetTLSTerminator(NewTLSTerminator(nil, acmeManager, false, false, 10*time.Second))
+	routeListener, err := mux.Listen(context.Background(), &RouteConfig{Domain: domain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer routeListener.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.caCert)
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{ServerName: domain, RootCAs: pool})
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer conn.Close()
+		_, err = conn.Write([]byte("ping"))
+		errCh <- err
+		_, _ = io.ReadAll(conn)
+	}()
+
+	// 代理只会收到用户的连接，验证连接不会转发给代理
+	acceptCh := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := routeListener.Accept(); err == nil {
+			acceptCh <- conn
+		}
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("tls handshake with issued certificate error: %v", err)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("certificate not issued")
+	}
+
+	conn := <-acceptCh
+	defer conn.Close()
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+}
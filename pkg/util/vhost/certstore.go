@@ -0,0 +1,175 @@
+package vhost
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	certFileSuffix = ".crt"
+	keyFileSuffix  = ".key"
+)
+
+// CertStore 按域名保存从目录中加载的证书，用于在 frps 上终止 TLS。
+type CertStore struct {
+	dir string
+
+	// 按小写域名编制索引的证书，通配符域名以 "*." 开头
+	certs map[string]*tls.Certificate
+	// 目录中证书文件的名称、大小和修改时间，用于判断是否需要重新加载
+	signature string
+
+	mu sync.RWMutex
+}
+
+func NewCertStore(dir string) (*CertStore, error) {
+	s := &CertStore{
+		dir:   dir,
+		certs: make(map[string]*tls.Certificate),
+	}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Run 定期检查证书目录，文件变化后重新加载，直到 ctx 结束。
+func (s *CertStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		reloaded, err := s.Reload()
+		if err != nil {
+			log.Warnf("reload certificates from [%s] error: %v", s.dir, err)
+			continue
+		}
+		if reloaded {
+			log.Infof("certificates reloaded from [%s], domains: %v", s.dir, s.Domains())
+		}
+	}
+}
+
+// Reload 在证书目录变化时重新加载所有证书。无法加载的证书会被跳过，不影响其他证书。
+func (s *CertStore) Reload() (bool, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return false, err
+	}
+
+	var sig strings.Builder
+	names := make([]string, 0)
+	for _, e := range entries {
+		if e.IsDir() || (!strings.HasSuffix(e.Name(), certFileSuffix) && !strings.HasSuffix(e.Name(), keyFileSuffix)) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&sig, "%s:%d:%d;", e.Name(), fi.Size(), fi.ModTime().UnixNano())
+		if strings.HasSuffix(e.Name(), certFileSuffix) {
+			names = append(names, strings.TrimSuffix(e.Name(), certFileSuffix))
+		}
+	}
+
+	s.mu.RLock()
+	unchanged := s.signature == sig.String()
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	certs := make(map[string]*tls.Certificate)
+	for _, name := range names {
+		cert, domains, err := loadCertificate(
+			filepath.Join(s.dir, name+certFileSuffix),
+			filepath.Join(s.dir, name+keyFileSuffix),
+		)
+		if err != nil {
+			log.Warnf("load certificate [%s] error: %v", name, err)
+			continue
+		}
+		for _, domain := range domains {
+			certs[strings.ToLower(domain)] = cert
+		}
+	}
+
+	s.mu.Lock()
+	s.certs = certs
+	s.signature = sig.String()
+	s.mu.Unlock()
+	return true, nil
+}
+
+func loadCertificate(certFile, keyFile string) (*tls.Certificate, []string, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	cert.Leaf = leaf
+
+	domains := leaf.DNSNames
+	if len(domains) == 0 && leaf.Subject.CommonName != "" {
+		domains = []string{leaf.Subject.CommonName}
+	}
+	if len(domains) == 0 {
+		return nil, nil, fmt.Errorf("no dns names in certificate")
+	}
+	return &cert, domains, nil
+}
+
+// Lookup 返回域名对应的证书，先精确匹配，再匹配同一级的通配符证书，例如 a.example.com 匹配 *.example.com。
+func (s *CertStore) Lookup(domain string) *tls.Certificate {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if cert, ok := s.certs[domain]; ok {
+		return cert
+	}
+	if idx := strings.Index(domain, "."); idx > 0 {
+		if cert, ok := s.certs["*"+domain[idx:]]; ok {
+			return cert
+		}
+	}
+	return nil
+}
+
+// GetCertificate 可用作 tls.Config 的 GetCertificate。
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := s.Lookup(hello.ServerName)
+	if cert == nil {
+		return nil, fmt.Errorf("no certificate for domain [%s]", hello.ServerName)
+	}
+	return cert, nil
+}
+
+func (s *CertStore) Domains() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	domains := make([]string, 0, len(s.certs))
+	for domain := range s.certs {
+		domains = append(domains, domain)
+	}
+	slices.Sort(domains)
+	return domains
+}
@@ -0,0 +1,235 @@
+package vhost
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"github.com/sunyihoo/frp/pkg/util/version"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// RequestIDHeader 是错误页面中请求 ID 的来源，请求没有携带时随机生成，并在错误响应中返回给用户
+const RequestIDHeader = "X-Request-Id"
+
+// ErrorPageStatuses 是可以使用模板的状态码
+var ErrorPageStatuses = []int{
+	http.StatusNotFound,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// defaultErrorPage 用于没有配置模板的 502、503 和 504，404 仍然使用 custom404Page 或内置的页面
+var defaultErrorPage = template.Must(template.New("default").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>{{.StatusText}}</title>
+<style>
+    body {
+        width: 35em;
+        margin: 0 auto;
+        font-family: Tahoma, Verdana, Arial, sans-serif;
+    }
+</style>
+</head>
+<body>
+<h1>{{if .Maintenance}}The site is under maintenance.{{else}}{{.StatusCode}} {{.StatusText}}{{end}}</h1>
+<p>Sorry, the page you are looking for is currently unavailable.<br/>
+Please try again later.</p>
+<p>Request ID: {{.RequestID}}</p>
+<p>The server is powered by <a href="https://github.com/fatedier/frp">frp</a>.</p>
+<p><em>Faithfully yours, frp.</em></p>
+</body>
+</html>
+`))
+
+// ErrorPageData 是渲染错误页面模板时可以使用的变量。
+type ErrorPageData struct {
+	StatusCode int
+	StatusText string
+	Host       string
+	RequestID  string
+	// ProxyName 是请求匹配的代理，没有匹配的路由时为空
+	ProxyName string
+	// Maintenance 为 true 表示页面是因为域名处于维护模式而返回的
+	Maintenance bool
+}
+
+// ErrorPages 是一组按状态码选择的 html/template 模板，没有模板的状态码使用下一级的页面。
+type ErrorPages struct {
+	templates map[int]*template.Template
+}
+
+// NewErrorPages 加载 files 中的模板文件，files 的键为状态码，只支持 ErrorPageStatuses 中的状态码。
+func NewErrorPages(files map[int]string) (*ErrorPages, error) {
+	p := &ErrorPages{templates: make(map[int]*template.Template, len(files))}
+	for status, path := range files {
+		if path == "" {
+			continue
+		}
+		if !isErrorPageStatus(status) {
+			return nil, fmt.Errorf("unsupported error page status [%d]", status)
+		}
+		t, err := template.ParseFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		p.templates[status] = t
+	}
+	return p, nil
+}
+
+func isErrorPageStatus(status int) bool {
+	for _, s := range ErrorPageStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ErrorPages) template(status int) *template.Template {
+	if p == nil {
+		return nil
+	}
+	return p.templates[status]
+}
+
+// ErrorPageRegistry 保存命名的错误页面，并按域名选择使用哪一组。
+type ErrorPageRegistry struct {
+	pages map[string]*ErrorPages
+	// domains 的键为小写的完整域名、通配符域名或 "*"，值为错误页面的名称
+	domains     map[string]string
+	defaultName string
+}
+
+func NewErrorPageRegistry() *ErrorPageRegistry {
+	return &ErrorPageRegistry{
+		pages:   make(map[string]*ErrorPages),
+		domains: make(map[string]string),
+	}
+}
+
+// Add 添加一组命名的错误页面，需要在使用 registry 之前完成。
+func (r *ErrorPageRegistry) Add(name string, pages *ErrorPages) {
+	r.pages[name] = pages
+}
+
+// Get 返回指定名称的错误页面。nil 的 ErrorPageRegistry 中没有任何页面。
+func (r *ErrorPageRegistry) Get(name string) (*ErrorPages, bool) {
+	if r == nil {
+		return nil, false
+	}
+	p, ok := r.pages[name]
+	return p, ok
+}
+
+// SetDefault 设置没有按代理或按域名选择时使用的错误页面。
+func (r *ErrorPageRegistry) SetDefault(name string) error {
+	if _, ok := r.pages[name]; !ok {
+		return fmt.Errorf("error pages [%s] not found", name)
+	}
+	r.defaultName = name
+	return nil
+}
+
+// SetDomain 为域名选择错误页面，domain 可以是 "*.example.com" 形式的通配符域名或 "*"。
+func (r *ErrorPageRegistry) SetDomain(domain, name string) error {
+	if _, ok := r.pages[name]; !ok {
+		return fmt.Errorf("error pages [%s] not found", name)
+	}
+	r.domains[strings.ToLower(domain)] = name
+	return nil
+}
+
+// forDomain 与路由相同，依次查找完整域名、通配符域名和 "*"，都没有时返回默认的错误页面。
+func (r *ErrorPageRegistry) forDomain(host string) *ErrorPages {
+	if r == nil {
+		return nil
+	}
+	host = strings.ToLower(host)
+	if name, ok := r.domains[host]; ok {
+		return r.pages[name]
+	}
+	domainSplit := strings.Split(host, ".")
+	for len(domainSplit) > 1 {
+		domainSplit[0] = "*"
+		if name, ok := r.domains[strings.Join(domainSplit, ".")]; ok {
+			return r.pages[name]
+		}
+		domainSplit = domainSplit[1:]
+	}
+	if name, ok := r.domains["*"]; ok {
+		return r.pages[name]
+	}
+	return r.pages[r.defaultName]
+}
+
+// maintenanceSet 记录处于维护模式的域名，值为维护期间使用的错误页面名称，为空时按域名选择。
+type maintenanceSet struct {
+	domains map[string]string
+	mu      sync.RWMutex
+}
+
+func (s *maintenanceSet) get(host string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	name, ok := s.domains[strings.ToLower(host)]
+	return name, ok
+}
+
+// renderErrorPage 依次使用 pages 中第一组包含该状态码模板的错误页面，都没有时使用内置的页面。
+func renderErrorPage(data *ErrorPageData, pages ...*ErrorPages) []byte {
+	for _, p := range pages {
+		t := p.template(data.StatusCode)
+		if t == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			log.Warnf("render error page [%d] for host [%s] error: %v", data.StatusCode, data.Host, err)
+			break
+		}
+		return buf.Bytes()
+	}
+
+	if data.StatusCode == http.StatusNotFound && !data.Maintenance {
+		return getNotFoundPageContent()
+	}
+	var buf bytes.Buffer
+	_ = defaultErrorPage.Execute(&buf, data)
+	return buf.Bytes()
+}
+
+// requestID 返回请求携带的请求 ID，没有时随机生成一个。
+func requestID(req *http.Request) string {
+	if id := req.Header.Get(RequestIDHeader); id != "" {
+		return id
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func errorPageResponse(status int, content []byte, reqID string) *http.Response {
+	header := make(http.Header)
+	header.Set("server", "frp/"+version.Full())
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	header.Set(RequestIDHeader, reqID)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(content)),
+		ContentLength: int64(len(content)),
+	}
+}
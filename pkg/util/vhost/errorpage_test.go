@@ -0,0 +1,186 @@
+package vhost
+
+import (
+	"fmt"
+	"github.com/samber/lo"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestErrorPages 为 statuses 中的每个状态码生成内容为 "<name> <状态码> <代理名称>" 的模板
+func newTestErrorPages(t *testing.T, name string, statuses ...int) *ErrorPages {
+	t.Helper()
+	files := make(map[int]string, len(statuses))
+	for _, status := range statuses {
+		path := filepath.Join(t.TempDir(), fmt.Sprintf("%s-%d.html", name, status))
+		if err := os.WriteFile(path, []byte(name+" {{.StatusCode}} {{.ProxyName}}"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		files[status] = path
+	}
+	pages, err := NewErrorPages(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pages
+}
+
+func TestNewErrorPagesInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "page.html")
+	if err := os.WriteFile(path, []byte("{{.StatusCode}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewErrorPages(map[int]string{http.StatusInternalServerError: path}); err == nil {
+		t.Error("unsupported status should be rejected")
+	}
+	if _, err := NewErrorPages(map[int]string{http.StatusNotFound: path}); err == nil {
+		t.Error("invalid template should be rejected")
+	}
+	if _, err := NewErrorPages(map[int]string{http.StatusNotFound: filepath.Join(t.TempDir(), "missing.html")}); err == nil {
+		t.Error("missing template file should be rejected")
+	}
+}
+
+func TestErrorPageRegistryForDomain(t *testing.T) {
+	r := NewErrorPageRegistry()
+	pages := make(map[string]*ErrorPages)
+	for _, name := range []string{"exact", "sub", "wild", "star", "default"} {
+		pages[name] = newTestErrorPages(t, name, http.StatusBadGateway)
+		r.Add(name, pages[name])
+	}
+	if err := r.SetDomain("a.example.com", "missing"); err == nil {
+		t.Error("unknown error pages should be rejected")
+	}
+	if err := r.SetDefault("missing"); err == nil {
+		t.Error("unknown default error pages should be rejected")
+	}
+	for domain, name := range map[string]string{
+		"A.Example.com":   "exact",
+		"*.a.example.com": "sub",
+		"*.example.com":   "wild",
+	} {
+		if err := r.SetDomain(domain, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.SetDefault("default"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(host, want string) {
+		t.Helper()
+		if got := r.forDomain(host); got != pages[want] {
+			t.Errorf("forDomain(%q): got a different page set, want %q", host, want)
+		}
+	}
+	check("a.example.com", "exact")
+	check("A.EXAMPLE.COM", "exact")
+	check("x.a.example.com", "sub")
+	check("y.x.a.example.com", "sub")
+	check("b.example.com", "wild")
+	check("example.com", "default")
+	check("other.test", "default")
+
+	if err := r.SetDomain("*", "star"); err != nil {
+		t.Fatal(err)
+	}
+	check("other.test", "star")
+	check("b.example.com", "wild")
+
+	var nilRegistry *ErrorPageRegistry
+	if nilRegistry.forDomain("a.example.com") != nil {
+		t.Error("nil registry should not select any page")
+	}
+	if _, ok := nilRegistry.Get("exact"); ok {
+		t.Error("nil registry should not contain any page")
+	}
+}
+
+func TestHTTPReverseProxyErrorPages(t *testing.T) {
+	r := NewErrorPageRegistry()
+	r.Add("maintenance", newTestErrorPages(t, "maintenance", http.StatusServiceUnavailable))
+	r.Add("domain", newTestErrorPages(t, "domain", http.StatusBadGateway, http.StatusServiceUnavailable))
+	if err := r.SetDomain("*.example.test", "domain"); err != nil {
+		t.Fatal(err)
+	}
+
+	rp := NewHTTPReverseProxy(HTTPReverseProxyOptions{}, NewRouters())
+	rp.SetErrorPages(r)
+	failConn := func(string) (net.Conn, error) {
+		return nil, fmt.Errorf("no work connection")
+	}
+	for _, rc := range []RouteConfig{
+		// 代理的错误页面只有 502 模板，其他状态码使用按域名选择的页面
+		{Domain: "proxy.example.test", Location: "/", ProxyName: "p1", CreateConnFn: failConn,
+			ErrorPages: newTestErrorPages(t, "proxy", http.StatusBadGateway)},
+		{Domain: "plain.example.test", Location: "/", ProxyName: "p2", CreateConnFn: failConn},
+		{Domain: "other.test", Location: "/", ProxyName: "p3", CreateConnFn: failConn},
+	} {
+		if err := rp.Register(rc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(rp)
+	t.Cleanup(srv.Close)
+
+	get := func(host string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL+"/", nil)
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.Header.Get(RequestIDHeader) == "" {
+			t.Errorf("%s: error response without %s", host, RequestIDHeader)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		host        string
+		maintenance *string
+		wantStatus  int
+		wantBody    string
+	}{
+		{name: "proxy pages", host: "proxy.example.test", wantStatus: 502, wantBody: "proxy 502 p1"},
+		{name: "domain pages", host: "plain.example.test", wantStatus: 502, wantBody: "domain 502 p2"},
+		{name: "builtin page", host: "other.test", wantStatus: 502, wantBody: "502 Bad Gateway"},
+		{name: "maintenance pages", host: "proxy.example.test", maintenance: lo.ToPtr("maintenance"),
+			wantStatus: 503, wantBody: "maintenance 503 p1"},
+		{name: "maintenance falls back to domain pages", host: "proxy.example.test", maintenance: lo.ToPtr(""),
+			wantStatus: 503, wantBody: "domain 503 p1"},
+		{name: "maintenance builtin page", host: "other.test", maintenance: lo.ToPtr(""),
+			wantStatus: 503, wantBody: "The site is under maintenance."},
+		{name: "not found without template", host: "missing.example.test", wantStatus: 404},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.maintenance != nil {
+				if err := rp.SetMaintenance(tc.host, *tc.maintenance); err != nil {
+					t.Fatal(err)
+				}
+				defer rp.ClearMaintenance(tc.host)
+			}
+			status, body := get(tc.host)
+			if status != tc.wantStatus || !strings.Contains(body, tc.wantBody) {
+				t.Fatalf("got %d %q, want %d containing %q", status, body, tc.wantStatus, tc.wantBody)
+			}
+		})
+	}
+
+	if err := rp.SetMaintenance("proxy.example.test", "missing"); err == nil {
+		t.Error("maintenance with unknown error pages should be rejected")
+	}
+	if rp.ClearMaintenance("proxy.example.test") {
+		t.Error("domain not in maintenance mode should not be cleared")
+	}
+}
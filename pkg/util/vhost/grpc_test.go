@@ -0,0 +1,112 @@
+package vhost
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testHealthServer 在每次调用上设置 trailer，服务名为 "denied" 时返回错误状态
+type testHealthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+}
+
+func (s *testHealthServer) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	_ = grpc.SetTrailer(ctx, metadata.Pairs("x-call", "unary-"+req.Service))
+	if req.Service == "denied" {
+		return nil, status.Error(codes.PermissionDenied, "service denied")
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func (s *testHealthServer) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	stream.SetTrailer(metadata.Pairs("x-call", "stream-"+req.Service))
+	for i := 0; i < 3; i++ {
+		if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}); err != nil {
+			return err
+		}
+	}
+	return status.Error(codes.ResourceExhausted, "watch finished")
+}
+
+func newTestGRPCBackend(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(s, &testHealthServer{})
+	go func() {
+		_ = s.Serve(l)
+	}()
+	t.Cleanup(s.Stop)
+	return l.Addr().String()
+}
+
+func TestHTTPReverseProxyGRPC(t *testing.T) {
+	srv := newTestHTTPReverseProxy(t, newTestGRPCBackend(t), true)
+	conn, err := grpc.NewClient(strings.TrimPrefix(srv.URL, "http://"),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithAuthority("example.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := grpc_health_v1.NewHealthClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		service  string
+		wantCode codes.Code
+	}{
+		{"ok", codes.OK},
+		{"denied", codes.PermissionDenied},
+	} {
+		var trailer metadata.MD
+		resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: tc.service}, grpc.Trailer(&trailer))
+		if code := status.Code(err); code != tc.wantCode {
+			t.Fatalf("unary %s: got code %v (%v), want %v", tc.service, code, err, tc.wantCode)
+		}
+		if tc.wantCode == codes.OK && resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+			t.Fatalf("unary %s: got status %v", tc.service, resp.Status)
+		}
+		if tc.wantCode != codes.OK && status.Convert(err).Message() != "service denied" {
+			t.Fatalf("unary %s: got message %q", tc.service, status.Convert(err).Message())
+		}
+		if got := trailer.Get("x-call"); len(got) != 1 || got[0] != "unary-"+tc.service {
+			t.Fatalf("unary %s: got trailer %v", tc.service, got)
+		}
+	}
+
+	stream, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{Service: "watch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for {
+		_, err = stream.Recv()
+		if err != nil {
+			break
+		}
+		n++
+	}
+	if n != 3 {
+		t.Fatalf("stream: got %d messages, want 3", n)
+	}
+	if err == io.EOF || status.Code(err) != codes.ResourceExhausted || status.Convert(err).Message() != "watch finished" {
+		t.Fatalf("stream: got error %v, want ResourceExhausted", err)
+	}
+	if got := stream.Trailer().Get("x-call"); len(got) != 1 || got[0] != "stream-watch" {
+		t.Fatalf("stream: got trailer %v", got)
+	}
+}
@@ -1,13 +1,546 @@
 package vhost
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	libio "github.com/fatedier/golib/io"
+	"github.com/fatedier/golib/pool"
+	"github.com/sunyihoo/frp/pkg/util/accesslog"
+	httppkg "github.com/sunyihoo/frp/pkg/util/http"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"io"
+	stdlog "log"
+	"math"
+	"net"
+	"net/http"
 	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	ErrNoRouteFound = errors.New("no route found")
+	ErrNoWorkConn   = errors.New("no work connection available")
+)
+
+type HTTPReverseProxyOptions struct {
+	ResponseHeaderTimeoutS int64
+	// EnableHTTP2 为 true 时，在 vhostHTTPPort 上接受 h2c（明文 HTTP/2）请求
+	EnableHTTP2 bool
+}
+
 type HTTPReverseProxy struct {
 	proxy       *httputil.ReverseProxy
 	vhostRouter *Routers
 
+	// 对外提供服务的处理程序，启用 HTTP/2 时它会先处理 h2c 请求
+	handler http.Handler
+	// 用于为每个注册的路由生成唯一的 ID
+	routeSeq atomic.Uint64
+
 	responseHeaderTimeout time.Duration
+	// 未启用访问日志时为 nil
+	accessLogger *accesslog.Logger
+	// 按域名选择的错误页面，未配置时为 nil
+	errorPages  *ErrorPageRegistry
+	maintenance maintenanceSet
+}
+
+func NewHTTPReverseProxy(option HTTPReverseProxyOptions, vhostRouter *Routers) *HTTPReverseProxy {
+	if option.ResponseHeaderTimeoutS <= 0 {
+		option.ResponseHeaderTimeoutS = 60
+	}
+	rp := &HTTPReverseProxy{
+		responseHeaderTimeout: time.Duration(option.ResponseHeaderTimeoutS) * time.Second,
+		vhostRouter:           vhostRouter,
+		maintenance:           maintenanceSet{domains: make(map[string]string)},
+	}
+	proxy := &httputil.ReverseProxy{
+		// 修改传入请求以转发到目标服务
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.Header["X-Forwarded-For"] = r.In.Header["X-Forwarded-For"]
+			r.SetXForwarded()
+			req := r.Out
+			req.URL.Scheme = "http"
+			reqRouteInfo := req.Context().Value(RouteInfoKey).(*RequestRouteInfo)
+			originalHost, _ := httppkg.CanonicalHost(reqRouteInfo.Host)
+
+			rc := req.Context().Value(RouteConfigKey).(*RouteConfig)
+			if rc != nil {
+				if rc.RewriteHost != "" {
+					req.Host = rc.RewriteHost
+				}
+
+				var endpoint string
+				if rc.ChooseEndpointFn != nil {
+					// ignore error here, it will use CreateConnFn instead later
+					endpoint, _ = rc.ChooseEndpointFn(r.In)
+					reqRouteInfo.Endpoint = endpoint
+					log.Tracef("choose endpoint name [%s] for http request host [%s] path [%s] httpuser [%s]",
+						endpoint, originalHost, reqRouteInfo.URL, reqRouteInfo.HTTPUser)
+				}
+				// 将路由和 endpoint 设置为目标地址的一部分，这样连接池中的连接只会复用到同一个路由的同一个 endpoint
+				req.URL.Host = originalHost
+				if rc.routeID != "" {
+					req.URL.Host += "." + rc.routeID
+				}
+				if endpoint != "" {
+					req.URL.Host = endpoint + "." + req.URL.Host
+				}
+
+				for k, v := range rc.Headers {
+					req.Header.Set(k, v)
+				}
+			} else {
+				req.URL.Host = req.Host
+			}
+		},
+		ModifyResponse: func(r *http.Response) error {
+			rc := r.Request.Context().Value(RouteConfigKey).(*RouteConfig)
+			if rc != nil {
+				for k, v := range rc.ResponseHeaders {
+					r.Header.Set(k, v)
+				}
+				if rc.ModifyResponseFn != nil {
+					return rc.ModifyResponseFn(r)
+				}
+			}
+			return nil
+		},
+		// 按路由选择 HTTP/1.1 或 HTTP/2 的传输方式，两者都通过工作连接访问后端
+		Transport: &routeTransport{
+			h1: &http.Transport{
+				ResponseHeaderTimeout: rp.responseHeaderTimeout,
+				IdleConnTimeout:       60 * time.Second,
+				MaxIdleConnsPerHost:   5,
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return rp.CreateConnection(ctx.Value(RouteInfoKey).(*RequestRouteInfo), ctx.Value(RouteConfigKey).(*RouteConfig), true)
+				},
+				Proxy: func(req *http.Request) (*url.URL, error) {
+					// 如果 HTTP 请求行中包含 host，则使用代理模式
+					reqRouteInfo := req.Context().Value(RouteInfoKey).(*RequestRouteInfo)
+					if reqRouteInfo.URLHost != "" {
+						return req.URL, nil
+					}
+					return nil, nil
+				},
+			},
+			h2: &http2.Transport{
+				// 工作连接上使用 h2c，DialTLSContext 返回的是明文连接
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return rp.CreateConnection(ctx.Value(RouteInfoKey).(*RequestRouteInfo), ctx.Value(RouteConfigKey).(*RouteConfig), true)
+				},
+				ReadIdleTimeout: 30 * time.Second,
+			},
+		},
+		BufferPool: pool.NewBuffer(32 * 1024),
+		ErrorLog:   stdlog.New(log.NewWriterLogger(log.WarnLevel, 2), "", 0),
+		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			log.Logf(log.WarnLevel, 1, "do http proxy request [host: %s] error: %v", req.Host, err)
+			writeResponse(rw, rp.errorResponse(req, errorStatus(err), false, nil))
+		},
+	}
+	rp.proxy = proxy
+
+	rp.handler = http.HandlerFunc(rp.serveHTTP)
+	if option.EnableHTTP2 {
+		rp.handler = h2c.NewHandler(rp.handler, &http2.Server{})
+	}
+	return rp
+}
+
+// SetAccessLogger 设置访问日志，每个请求在处理完成后记录。
+func (rp *HTTPReverseProxy) SetAccessLogger(l *accesslog.Logger) {
+	rp.accessLogger = l
+}
+
+// SetErrorPages 设置按域名选择的错误页面，需要在开始处理请求之前调用。
+func (rp *HTTPReverseProxy) SetErrorPages(r *ErrorPageRegistry) {
+	rp.errorPages = r
+}
+
+// SetMaintenance 使域名进入维护模式，所有请求都返回 503 页面，已注册的路由保持不变。
+// pages 指定维护期间使用的错误页面，为空时与其他 503 页面的选择方式相同。
+func (rp *HTTPReverseProxy) SetMaintenance(domain, pages string) error {
+	if pages != "" {
+		if _, ok := rp.errorPages.Get(pages); !ok {
+			return fmt.Errorf("error pages [%s] not found", pages)
+		}
+	}
+	rp.maintenance.mu.Lock()
+	defer rp.maintenance.mu.Unlock()
+	rp.maintenance.domains[strings.ToLower(domain)] = pages
+	return nil
+}
+
+// ClearMaintenance 使域名退出维护模式，域名不在维护模式时返回 false。
+func (rp *HTTPReverseProxy) ClearMaintenance(domain string) bool {
+	domain = strings.ToLower(domain)
+	rp.maintenance.mu.Lock()
+	defer rp.maintenance.mu.Unlock()
+	_, ok := rp.maintenance.domains[domain]
+	delete(rp.maintenance.domains, domain)
+	return ok
+}
+
+// GetMaintenance 返回处于维护模式的域名及其使用的错误页面名称。
+func (rp *HTTPReverseProxy) GetMaintenance() map[string]string {
+	rp.maintenance.mu.RLock()
+	defer rp.maintenance.mu.RUnlock()
+	domains := make(map[string]string, len(rp.maintenance.domains))
+	for k, v := range rp.maintenance.domains {
+		domains[k] = v
+	}
+	return domains
+}
+
+// errorStatus 返回代理请求失败时的状态码。
+func errorStatus(err error) int {
+	if errors.Is(err, ErrNoRouteFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, ErrNoWorkConn) {
+		return http.StatusServiceUnavailable
+	}
+	// 超时可能被多层包装，例如 http2.Transport 返回的 context.DeadlineExceeded
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// errorResponse 依次使用 pages、代理和域名选择的错误页面，pages 是维护模式指定的页面，可以为 nil。
+func (rp *HTTPReverseProxy) errorResponse(req *http.Request, status int, maintenance bool, pages *ErrorPages) *http.Response {
+	host, _ := httppkg.CanonicalHost(req.Host)
+	data := &ErrorPageData{
+		StatusCode:  status,
+		StatusText:  http.StatusText(status),
+		Host:        host,
+		RequestID:   requestID(req),
+		Maintenance: maintenance,
+	}
+	candidates := []*ErrorPages{pages}
+	if rc, _ := req.Context().Value(RouteConfigKey).(*RouteConfig); rc != nil {
+		data.ProxyName = rc.ProxyName
+		if reqRouteInfo, _ := req.Context().Value(RouteInfoKey).(*RequestRouteInfo); reqRouteInfo != nil && reqRouteInfo.Endpoint != "" {
+			data.ProxyName = reqRouteInfo.Endpoint
+		}
+		candidates = append(candidates, rc.ErrorPages)
+	}
+	candidates = append(candidates, rp.errorPages.forDomain(host))
+	return errorPageResponse(status, renderErrorPage(data, candidates...), data.RequestID)
+}
+
+func writeResponse(rw http.ResponseWriter, res *http.Response) {
+	for k, v := range res.Header {
+		rw.Header()[k] = v
+	}
+	rw.WriteHeader(res.StatusCode)
+	_, _ = io.Copy(rw, res.Body)
+}
+
+type routeTransport struct {
+	h1 *http.Transport
+	h2 *http2.Transport
+}
+
+func (t *routeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rc, _ := req.Context().Value(RouteConfigKey).(*RouteConfig)
+	if rc != nil && rc.BackendHTTP2 {
+		return t.h2.RoundTrip(req)
+	}
+	return t.h1.RoundTrip(req)
+}
+
+// Register 注册路由配置到反向代理
+// 反向代理将使用 CreateConnFn 从 RouteConfig 创建与服务器的连接
+func (rp *HTTPReverseProxy) Register(routeCfg RouteConfig) error {
+	matcher, err := NewRequestMatcher(routeCfg.Matches, routeCfg.Priority)
+	if err != nil {
+		return err
+	}
+	routeCfg.routeID = strconv.FormatUint(rp.routeSeq.Add(1), 10)
+	return rp.vhostRouter.Add(routeCfg.Domain, routeCfg.Location, routeCfg.RouteByHTTPUser, matcher, &routeCfg)
+}
+
+// UnRegister 从反向代理中注销路由配置
+func (rp *HTTPReverseProxy) UnRegister(routeCfg RouteConfig) {
+	// 注册成功的路由配置一定可以再次生成匹配器
+	matcher, _ := NewRequestMatcher(routeCfg.Matches, routeCfg.Priority)
+	rp.vhostRouter.Del(routeCfg.Domain, routeCfg.Location, routeCfg.RouteByHTTPUser, matcher)
+}
+
+// GetRouteConfig 返回请求对应的路由配置，req 为 nil 时只会返回不带匹配条件的路由。
+func (rp *HTTPReverseProxy) GetRouteConfig(domain, location, routeByHTTPUser string, req *http.Request) *RouteConfig {
+	vr, ok := rp.getVhost(domain, location, routeByHTTPUser, req)
+	if ok {
+		log.Debugf("get new HTTP request host [%s] path [%s] httpuser [%s]", domain, location, routeByHTTPUser)
+		return vr.payload.(*RouteConfig)
+	}
+	return nil
+}
+
+// CreateConnection 使用 RouteConfig 中的 CreateConnFn 创建与后端的连接
+func (rp *HTTPReverseProxy) CreateConnection(reqRouteInfo *RequestRouteInfo, rc *RouteConfig, byEndpoint bool) (net.Conn, error) {
+	if rc != nil {
+		if byEndpoint {
+			fn := rc.CreateConnByEndpointFn
+			if fn != nil {
+				return fn(reqRouteInfo.Endpoint, reqRouteInfo.RemoteAddr)
+			}
+		}
+		fn := rc.CreateConnFn
+		if fn != nil {
+			return fn(reqRouteInfo.RemoteAddr)
+		}
+	}
+	host, _ := httppkg.CanonicalHost(reqRouteInfo.Host)
+	return nil, fmt.Errorf("%w: %s %s %s", ErrNoRouteFound, host, reqRouteInfo.URL, reqRouteInfo.HTTPUser)
+}
+
+func checkAuth(rc *RouteConfig, user, passwd string) bool {
+	if rc == nil {
+		return true
+	}
+	if rc.HTPasswd != nil {
+		return rc.HTPasswd.Verify(user, passwd)
+	}
+	if (rc.Username != "" || rc.Password != "") && (rc.Username != user || rc.Password != passwd) {
+		return false
+	}
+	return true
+}
+
+// getVhost 首先尝试获取完整域名的路由，然后依次尝试通配符域名，例如 *.example.com、*.com，
+// 每个域名都先按 routeByHTTPUser 查找，再查找不区分 HTTP 用户的路由。
+func (rp *HTTPReverseProxy) getVhost(domain, location, routeByHTTPUser string, req *http.Request) (*Router, bool) {
+	findRouter := func(inDomain, inLocation, inRouteByHTTPUser string) (*Router, bool) {
+		vr, ok := rp.vhostRouter.Get(inDomain, inLocation, inRouteByHTTPUser, req)
+		if ok {
+			return vr, ok
+		}
+		// 尝试匹配不区分 HTTP 用户的路由
+		if inRouteByHTTPUser != "" {
+			vr, ok = rp.vhostRouter.Get(inDomain, inLocation, "", req)
+			if ok {
+				return vr, ok
+			}
+		}
+		return nil, false
+	}
+
+	// 首先检查完整域名，如果不存在，则检查通配符域名
+	vr, ok := findRouter(domain, location, routeByHTTPUser)
+	if ok {
+		return vr, ok
+	}
+
+	domainSplit := strings.Split(domain, ".")
+	for len(domainSplit) > 1 {
+		domainSplit[0] = "*"
+		vr, ok = findRouter(strings.Join(domainSplit, "."), location, routeByHTTPUser)
+		if ok {
+			return vr, ok
+		}
+		domainSplit = domainSplit[1:]
+	}
+
+	// 最后尝试匹配 "*"，它会匹配所有域名
+	vr, ok = findRouter("*", location, routeByHTTPUser)
+	if ok {
+		return vr, ok
+	}
+	return nil, false
+}
+
+func (rp *HTTPReverseProxy) connectHandler(rw http.ResponseWriter, req *http.Request) {
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	client, _, err := hj.Hijack()
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	remote, err := rp.CreateConnection(req.Context().Value(RouteInfoKey).(*RequestRouteInfo), req.Context().Value(RouteConfigKey).(*RouteConfig), false)
+	if err != nil {
+		_ = rp.errorResponse(req, errorStatus(err), false, nil).Write(client)
+		client.Close()
+		return
+	}
+	_ = req.Write(remote)
+	go libio.Join(remote, client)
+}
+
+func (rp *HTTPReverseProxy) injectRequestInfoToCtx(req *http.Request) *http.Request {
+	user := ""
+	// 如果 URL 中包含 host，则为代理请求，从 Proxy-Authorization 请求头中获取 HTTP 用户
+	if req.URL.Host != "" {
+		proxyAuth := req.Header.Get("Proxy-Authorization")
+		if proxyAuth != "" {
+			user, _, _ = httppkg.ParseBasicAuth(proxyAuth)
+		}
+	}
+	if user == "" {
+		user, _, _ = req.BasicAuth()
+	}
+
+	reqRouteInfo := &RequestRouteInfo{
+		URL:        req.URL.Path,
+		Host:       req.Host,
+		HTTPUser:   user,
+		RemoteAddr: req.RemoteAddr,
+		URLHost:    req.URL.Host,
+	}
+
+	originalHost, _ := httppkg.CanonicalHost(reqRouteInfo.Host)
+	rc := rp.GetRouteConfig(originalHost, req.URL.Path, user, req)
+
+	newctx := req.Context()
+	newctx = context.WithValue(newctx, RouteInfoKey, reqRouteInfo)
+	newctx = context.WithValue(newctx, RouteConfigKey, rc)
+	return req.Clone(newctx)
+}
+
+func (rp *HTTPReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rp.handler.ServeHTTP(rw, req)
+}
+
+func (rp *HTTPReverseProxy) serveHTTP(rw http.ResponseWriter, req *http.Request) {
+	newreq := rp.injectRequestInfoToCtx(req)
+	if rp.accessLogger.Sampled() {
+		lrw := &accessLogResponseWriter{ResponseWriter: rw}
+		defer rp.logAccess(newreq, lrw, time.Now())
+		rw = lrw
+	}
+	rc := newreq.Context().Value(RouteConfigKey).(*RouteConfig)
+	if rc != nil && rc.SourceIPFilterFn != nil && !rc.SourceIPFilterFn(req.RemoteAddr) {
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	host, _ := httppkg.CanonicalHost(req.Host)
+	if name, ok := rp.maintenance.get(host); ok {
+		pages, _ := rp.errorPages.Get(name)
+		writeResponse(rw, rp.errorResponse(newreq, http.StatusServiceUnavailable, true, pages))
+		return
+	}
+	if rc != nil && rc.RequestLimiter != nil {
+		ip, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			ip = req.RemoteAddr
+		}
+		if ok, retryAfter := rc.RequestLimiter.Allow(ip); !ok {
+			rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(rw, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+	}
+	// 身份请求头只能由 frps 在认证通过后设置，无论路由是否启用认证都删除用户请求中的同名请求头
+	newreq.Header.Del(ForwardedUserHeader)
+	newreq.Header.Del(ForwardedEmailHeader)
+	newreq.Header.Del(ForwardedGroupsHeader)
+	if rc != nil && rc.OIDCAuth != nil && !rc.OIDCAuth.Authenticate(rw, newreq) {
+		return
+	}
+	user, passwd, _ := req.BasicAuth()
+	if !checkAuth(rc, user, passwd) {
+		rw.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if rc != nil && rc.HTPasswd != nil {
+		newreq.Header.Set(ForwardedUserHeader, user)
+	}
+
+	if req.Method == http.MethodConnect {
+		rp.connectHandler(rw, newreq)
+	} else {
+		rp.proxy.ServeHTTP(rw, newreq)
+	}
+}
+
+func (rp *HTTPReverseProxy) logAccess(req *http.Request, rw *accessLogResponseWriter, start time.Time) {
+	reqRouteInfo := req.Context().Value(RouteInfoKey).(*RequestRouteInfo)
+	host, _ := httppkg.CanonicalHost(reqRouteInfo.Host)
+	entry := &accesslog.Entry{
+		Time:       start,
+		RemoteAddr: req.RemoteAddr,
+		User:       reqRouteInfo.HTTPUser,
+		Host:       host,
+		Method:     req.Method,
+		Path:       req.URL.RequestURI(),
+		Proto:      req.Proto,
+		Status:     rw.status,
+		BytesSent:  rw.bytes,
+		Referer:    req.Referer(),
+		UserAgent:  req.UserAgent(),
+		Latency:    time.Since(start),
+	}
+	if req.TLS != nil {
+		entry.SNI = req.TLS.ServerName
+	}
+	if rc := req.Context().Value(RouteConfigKey).(*RouteConfig); rc != nil {
+		entry.ProxyName = rc.ProxyName
+		entry.RunID = rc.RunID
+		// 组路由记录实际处理请求的代理
+		if reqRouteInfo.Endpoint != "" {
+			entry.ProxyName = reqRouteInfo.Endpoint
+			if rc.EndpointRunIDFn != nil {
+				entry.RunID = rc.EndpointRunIDFn(reqRouteInfo.Endpoint)
+			}
+		}
+	}
+	rp.accessLogger.Log(entry)
+}
+
+// accessLogResponseWriter 记录响应的状态码和 body 字节数。
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+
+	status int
+	bytes  int64
+}
+
+func (w *accessLogResponseWriter) WriteHeader(code int) {
+	// 忽略 1xx 信息响应
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessLogResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessLogResponseWriter) Flush() {
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack 用于 CONNECT 请求，被接管的连接没有状态码。
+func (w *accessLogResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
@@ -0,0 +1,161 @@
+package vhost
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// newTestH2CBackend 返回一个同时接受 HTTP/1.1 和 h2c 的后端，响应体为请求使用的 HTTP 主版本号
+func newTestH2CBackend(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, strconv.Itoa(req.ProtoMajor))
+	}), &http2.Server{}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestHTTPReverseProxy(t *testing.T, backendAddr string, backendHTTP2 bool) *httptest.Server {
+	rp := NewHTTPReverseProxy(HTTPReverseProxyOptions{EnableHTTP2: true}, NewRouters())
+	err := rp.Register(RouteConfig{
+		Domain:       "example.test",
+		Location:     "/",
+		BackendHTTP2: backendHTTP2,
+		CreateConnFn: func(string) (net.Conn, error) {
+			return net.Dial("tcp", backendAddr)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(rp)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newH2CClient() *http.Client {
+	return &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+func TestHTTPReverseProxyHTTP2(t *testing.T) {
+	backend := newTestH2CBackend(t)
+	backendAddr := backend.Listener.Addr().String()
+
+	for _, tc := range []struct {
+		name             string
+		backendHTTP2     bool
+		h2cClient        bool
+		wantClientProto  int
+		wantBackendProto string
+	}{
+		{"h1 to h1", false, false, 1, "1"},
+		{"h1 to h2c", true, false, 1, "2"},
+		{"h2c to h1", false, true, 2, "1"},
+		{"h2c to h2c", true, true, 2, "2"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestHTTPReverseProxy(t, backendAddr, tc.backendHTTP2)
+			client := http.DefaultClient
+			if tc.h2cClient {
+				client = newH2CClient()
+			}
+			req, _ := http.NewRequest("GET", srv.URL+"/", nil)
+			req.Host = "example.test"
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || resp.ProtoMajor != tc.wantClientProto || string(body) != tc.wantBackendProto {
+				t.Fatalf("got status %d client proto %d backend proto %q, want 200 %d %q",
+					resp.StatusCode, resp.ProtoMajor, body, tc.wantClientProto, tc.wantBackendProto)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("%w: example.test", ErrNoRouteFound), http.StatusNotFound},
+		{fmt.Errorf("%w: proxy offline", ErrNoWorkConn), http.StatusServiceUnavailable},
+		{timeoutError{}, http.StatusGatewayTimeout},
+		{fmt.Errorf("read: %w", os.ErrDeadlineExceeded), http.StatusGatewayTimeout},
+		{fmt.Errorf("http2: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{io.ErrUnexpectedEOF, http.StatusBadGateway},
+	} {
+		if got := errorStatus(tc.err); got != tc.want {
+			t.Errorf("errorStatus(%v) = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestHTTPReverseProxyRemoveForwardedIdentity(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		for _, h := range []string{ForwardedUserHeader, ForwardedEmailHeader, ForwardedGroupsHeader} {
+			if v := req.Header.Get(h); v != "" {
+				_, _ = io.WriteString(rw, h+": "+v+"\n")
+			}
+		}
+	}))
+	defer backend.Close()
+
+	rp := NewHTTPReverseProxy(HTTPReverseProxyOptions{}, NewRouters())
+	for _, rc := range []RouteConfig{
+		{Domain: "public.example.test"},
+		{Domain: "basic.example.test", Username: "user", Password: "passwd"},
+	} {
+		rc.Location = "/"
+		rc.CreateConnFn = func(string) (net.Conn, error) {
+			return net.Dial("tcp", backend.Listener.Addr().String())
+		}
+		if err := rp.Register(rc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(rp)
+	defer srv.Close()
+
+	// 没有认证或使用 Username 和 Password 认证的路由不设置身份请求头，用户伪造的请求头也不能到达后端
+	for _, host := range []string{"public.example.test", "basic.example.test"} {
+		req, _ := http.NewRequest("GET", srv.URL+"/", nil)
+		req.Host = host
+		req.SetBasicAuth("user", "passwd")
+		req.Header.Set(ForwardedUserHeader, "admin")
+		req.Header.Set(ForwardedEmailHeader, "admin@example.test")
+		req.Header.Set(ForwardedGroupsHeader, "admin")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || len(body) != 0 {
+			t.Errorf("%s: got status %d, backend received %q", host, resp.StatusCode, body)
+		}
+	}
+}
@@ -1,5 +1,174 @@
 package vhost
 
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	libnet "github.com/fatedier/golib/net"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/net/http2"
+	"io"
+	"net"
+	"time"
+)
+
+// ErrACMEChallenge 表示连接是 TLS-ALPN-01 验证，握手完成后连接已经关闭，不应再转发。
+var ErrACMEChallenge = errors.New("acme tls-alpn-01 challenge")
+
 type HTTPMuxer struct {
 	*Muxer
 }
+
+// NewHTTPSMuxer 按 TLS ClientHello 中的 SNI 将连接路由到 https 代理。
+func NewHTTPSMuxer(listener net.Listener, timeout time.Duration) (*HTTPMuxer, error) {
+	mux, err := NewMuxer(listener, GetHTTPSHostname, timeout)
+	if err != nil {
+		return nil, err
+	}
+	mux.SetFailHookFunc(vhostFailed)
+	return &HTTPMuxer{Muxer: mux}, nil
+}
+
+// vhostFailed 在没有匹配的代理时以 unrecognized_name 告警结束握手
+func vhostFailed(c net.Conn) {
+	_ = tls.Server(c, &tls.Config{}).Handshake()
+	_ = c.Close()
+}
+
+// GetHTTPSHostname 从 TLS ClientHello 中读取 SNI 作为 Host，返回的连接会重新读到 ClientHello。
+func GetHTTPSHostname(c net.Conn) (_ net.Conn, _ map[string]string, err error) {
+	reqInfoMap := make(map[string]string, 0)
+	sc, rd := libnet.NewSharedConn(c)
+
+	clientHello, err := readClientHello(rd)
+	if err != nil {
+		return nil, reqInfoMap, err
+	}
+	reqInfoMap["Host"] = clientHello.ServerName
+	reqInfoMap["Scheme"] = "https"
+	if isACMEChallengeHello(clientHello) {
+		reqInfoMap["ACMEChallenge"] = "true"
+	}
+	return sc, reqInfoMap, nil
+}
+
+// isACMEChallengeHello 返回 ClientHello 是否来自 TLS-ALPN-01 验证，验证方只会提供 acme-tls/1 一个协议。
+func isACMEChallengeHello(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+func readClientHello(reader io.Reader) (*tls.ClientHelloInfo, error) {
+	var hello *tls.ClientHelloInfo
+
+	// readOnlyConn 不是真正的连接，所以握手总会失败。
+	// 只要成功读取了 ClientHello，失败就发生在 GetConfigForClient 调用之后，因此只有 hello 为空时才关心错误。
+	err := tls.Server(readOnlyConn{reader: reader}, &tls.Config{
+		GetConfigForClient: func(argHello *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = &tls.ClientHelloInfo{}
+			*hello = *argHello
+			return nil, nil
+		},
+	}).Handshake()
+
+	if hello == nil {
+		return nil, err
+	}
+	return hello, nil
+}
+
+type readOnlyConn struct {
+	reader io.Reader
+}
+
+func (conn readOnlyConn) Read(p []byte) (int, error)         { return conn.reader.Read(p) }
+func (conn readOnlyConn) Write(_ []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (conn readOnlyConn) Close() error                       { return nil }
+func (conn readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (conn readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (conn readOnlyConn) SetDeadline(_ time.Time) error      { return nil }
+func (conn readOnlyConn) SetReadDeadline(_ time.Time) error  { return nil }
+func (conn readOnlyConn) SetWriteDeadline(_ time.Time) error { return nil }
+
+// TLSTerminator 为证书库中有证书的域名和 ACME 允许的域名在 frps 上终止 TLS，其余域名仍然将 TLS 流量透传给客户端。
+// 证书库中的证书优先于 ACME 申请的证书。
+type TLSTerminator struct {
+	store *CertStore
+	acme  *ACMEManager
+
+	// 终止 TLS 后是否使用 TLS 重新加密发往客户端的流量
+	reencrypt bool
+	verify    bool
+
+	handshakeTimeout time.Duration
+}
+
+// store 和 acmeManager 可以为 nil，但不能同时为 nil。
+func NewTLSTerminator(store *CertStore, acmeManager *ACMEManager, reencrypt bool, verify bool, handshakeTimeout time.Duration) *TLSTerminator {
+	return &TLSTerminator{
+		store:            store,
+		acme:             acmeManager,
+		reencrypt:        reencrypt,
+		verify:           verify,
+		handshakeTimeout: handshakeTimeout,
+	}
+}
+
+// Match 返回是否应为该域名终止 TLS。
+func (t *TLSTerminator) Match(domain string) bool {
+	if t.store != nil && t.store.Lookup(domain) != nil {
+		return true
+	}
+	return t.acme != nil && t.acme.Match(domain)
+}
+
+func (t *TLSTerminator) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if t.store != nil {
+		if cert := t.store.Lookup(hello.ServerName); cert != nil {
+			return cert, nil
+		}
+	}
+	if t.acme != nil {
+		return t.acme.GetCertificate(hello)
+	}
+	return nil, fmt.Errorf("no certificate for domain [%s]", hello.ServerName)
+}
+
+// Terminate 使用证书库中的证书与用户完成 TLS 握手，返回解密后的连接。
+// conn 应该从 ClientHello 开始读取，因此 Muxer 读取 SNI 时消耗的数据需要重新放回。
+// 只有后端支持 HTTP/2 时 enableHTTP2 才应为 true，因为解密后的数据会原样转发给后端。
+func (t *TLSTerminator) Terminate(conn net.Conn, enableHTTP2 bool) (net.Conn, error) {
+	nextProtos := []string{"http/1.1", acme.ALPNProto}
+	if enableHTTP2 {
+		nextProtos = append([]string{http2.NextProtoTLS}, nextProtos...)
+	}
+	tlsConn := tls.Server(conn, &tls.Config{
+		GetCertificate: t.getCertificate,
+		NextProtos:     nextProtos,
+	})
+	_ = conn.SetDeadline(time.Now().Add(t.handshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	if tlsConn.ConnectionState().NegotiatedProtocol == acme.ALPNProto {
+		tlsConn.Close()
+		return nil, ErrACMEChallenge
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// WrapUpstream 在需要重新加密时使用 TLS 包装发往客户端的工作连接，否则原样返回。
+// userConn 是 Terminate 返回的连接，重新加密时与后端协商和用户相同的应用层协议。
+func (t *TLSTerminator) WrapUpstream(workConn net.Conn, userConn net.Conn, serverName string) net.Conn {
+	if !t.reencrypt {
+		return workConn
+	}
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: !t.verify,
+	}
+	if tlsConn, ok := userConn.(*tls.Conn); ok && tlsConn.ConnectionState().NegotiatedProtocol != "" {
+		cfg.NextProtos = []string{tlsConn.ConnectionState().NegotiatedProtocol}
+	}
+	return tls.Client(workConn, cfg)
+}
@@ -0,0 +1,205 @@
+package vhost
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert 在 dir 中生成域名为 domain 的自签名证书
+func writeTestCert(t *testing.T, dir, domain string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(filepath.Join(dir, domain+certFileSuffix), certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, domain+keyFileSuffix), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestHTTPSMuxer(t *testing.T, terminator *TLSTerminator) (*HTTPMuxer, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	mux, err := NewHTTPSMuxer(l, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.SetTLSTerminator(terminator)
+	return mux, l.Addr().String()
+}
+
+func TestHTTPSMuxerTerminate(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCert(t, dir, "a.example.test")
+	store, err := NewCertStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux, addr := newTestHTTPSMuxer(t, NewTLSTerminator(store, nil, false, false, 5*time.Second))
+
+	for _, tc := range []struct {
+		domain     string
+		terminated bool
+	}{
+		{"a.example.test", true},
+		{"b.example.test", false},
+	} {
+		l, err := mux.Listen(context.Background(), &RouteConfig{Domain: tc.domain})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func() {
+			conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: tc.domain, InsecureSkipVerify: true})
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = conn.Write([]byte("ping"))
+			_, _ = io.ReadAll(conn)
+		}()
+
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, isTLS := conn.(*tls.Conn)
+		if isTLS != tc.terminated {
+			t.Fatalf("domain %s: terminated %v, want %v", tc.domain, isTLS, tc.terminated)
+		}
+		if tc.terminated {
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+				t.Fatalf("domain %s: read %q, %v", tc.domain, buf, err)
+			}
+		}
+		conn.Close()
+		l.Close()
+	}
+}
+
+func TestHTTPSMuxerReencrypt(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCert(t, dir, "a.example.test")
+	store, err := NewCertStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, reencrypt := range []bool{false, true} {
+		terminator := NewTLSTerminator(store, nil, reencrypt, false, 5*time.Second)
+		mux, addr := newTestHTTPSMuxer(t, terminator)
+		l, err := mux.Listen(context.Background(), &RouteConfig{Domain: "a.example.test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func() {
+			conn, err := tls.Dial("tcp", addr, &tls.Config{
+				ServerName:         "a.example.test",
+				InsecureSkipVerify: true,
+				NextProtos:         []string{"http/1.1"},
+			})
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = conn.Write([]byte("ping"))
+			_, _ = io.ReadAll(conn)
+		}()
+
+		userConn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		workConn, backendConn := net.Pipe()
+		upstream := terminator.WrapUpstream(workConn, userConn, "a.example.test")
+
+		// 客户端后端看到的是明文还是与用户相同 SNI 和 ALPN 的 TLS 连接
+		type result struct {
+			data       string
+			serverName string
+			proto      string
+			err        error
+		}
+		resultCh := make(chan result, 1)
+		go func() {
+			var r result
+			var c net.Conn = backendConn
+			if reencrypt {
+				tlsConn := tls.Server(backendConn, &tls.Config{
+					Certificates: []tls.Certificate{*store.Lookup("a.example.test")},
+					NextProtos:   []string{"h2", "http/1.1"},
+				})
+				if err := tlsConn.Handshake(); err != nil {
+					resultCh <- result{err: err}
+					return
+				}
+				r.serverName = tlsConn.ConnectionState().ServerName
+				r.proto = tlsConn.ConnectionState().NegotiatedProtocol
+				c = tlsConn
+			}
+			buf := make([]byte, 4)
+			_, r.err = io.ReadFull(c, buf)
+			r.data = string(buf)
+			resultCh <- r
+		}()
+
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(userConn, buf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := upstream.Write(buf); err != nil {
+			t.Fatalf("reencrypt %v: write upstream: %v", reencrypt, err)
+		}
+		r := <-resultCh
+		if r.err != nil || r.data != "ping" {
+			t.Fatalf("reencrypt %v: backend read %q, %v", reencrypt, r.data, r.err)
+		}
+		if reencrypt && (r.serverName != "a.example.test" || r.proto != "http/1.1") {
+			t.Fatalf("got server name %q and protocol %q, want a.example.test and http/1.1", r.serverName, r.proto)
+		}
+		if _, isTLS := upstream.(*tls.Conn); isTLS != reencrypt {
+			t.Fatalf("reencrypt %v: upstream is tls %v", reencrypt, isTLS)
+		}
+		// 先关闭后端，否则 TLS 连接关闭时发送的 close_notify 会阻塞在 Pipe 上
+		backendConn.Close()
+		upstream.Close()
+		userConn.Close()
+		l.Close()
+	}
+}
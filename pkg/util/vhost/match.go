@@ -0,0 +1,172 @@
+package vhost
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	RouteMatchTypeHeader = "header"
+	RouteMatchTypeQuery  = "query"
+	RouteMatchTypeMethod = "method"
+)
+
+// RouteMatch 是 HTTP 路由的匹配条件。Type 为 "header" 或 "query" 时 Name 为请求头或查询参数的名称，
+// 为 "method" 时忽略 Name。Regex 为 true 时 Value 是正则表达式，否则必须完全相等。
+type RouteMatch struct {
+	Type  string
+	Name  string
+	Value string
+	Regex bool
+}
+
+type matchCondition struct {
+	RouteMatch
+
+	re *regexp.Regexp
+}
+
+func (c *matchCondition) match(req *http.Request) bool {
+	var value string
+	switch c.Type {
+	case RouteMatchTypeHeader:
+		value = req.Header.Get(c.Name)
+	case RouteMatchTypeQuery:
+		value = req.URL.Query().Get(c.Name)
+	case RouteMatchTypeMethod:
+		value = req.Method
+	}
+	if c.re != nil {
+		return c.re.MatchString(value)
+	}
+	if c.Type == RouteMatchTypeMethod {
+		return strings.EqualFold(value, c.Value)
+	}
+	return value == c.Value
+}
+
+// disjoint 返回两个条件是否不可能同时满足。检查同一个字段时，两个完全匹配的值不同，
+// 或者正则表达式不匹配另一个条件的完全匹配值，才能确定不会同时满足。
+// 两个正则表达式是否有共同匹配的值无法判断，总是视为可能同时满足，需要使用不同的优先级区分。
+func (c *matchCondition) disjoint(o *matchCondition) bool {
+	if c.Type != o.Type || c.Name != o.Name {
+		return false
+	}
+	switch {
+	case c.re != nil && o.re != nil:
+		return false
+	case c.re != nil:
+		return !c.matchValue(o.Value)
+	case o.re != nil:
+		return !o.matchValue(c.Value)
+	}
+	if c.Type == RouteMatchTypeMethod {
+		return !strings.EqualFold(c.Value, o.Value)
+	}
+	return c.Value != o.Value
+}
+
+// matchValue 返回正则表达式是否匹配完全匹配条件的值。请求方法的完全匹配不区分大小写，因此同时检查大写和小写形式。
+func (c *matchCondition) matchValue(value string) bool {
+	if c.Type == RouteMatchTypeMethod {
+		return c.re.MatchString(value) || c.re.MatchString(strings.ToUpper(value)) || c.re.MatchString(strings.ToLower(value))
+	}
+	return c.re.MatchString(value)
+}
+
+// RequestMatcher 在 domain、location 和 httpUser 之外按请求头、查询参数和请求方法选择路由，
+// 所有条件都满足时才匹配。
+type RequestMatcher struct {
+	priority int
+	conds    []*matchCondition
+}
+
+func NewRequestMatcher(matches []RouteMatch, priority int) (*RequestMatcher, error) {
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	m := &RequestMatcher{
+		priority: priority,
+	}
+	for _, match := range matches {
+		c := &matchCondition{RouteMatch: match}
+		switch match.Type {
+		case RouteMatchTypeHeader:
+			if match.Name == "" {
+				return nil, fmt.Errorf("header name should not be empty")
+			}
+			c.Name = http.CanonicalHeaderKey(match.Name)
+		case RouteMatchTypeQuery:
+			if match.Name == "" {
+				return nil, fmt.Errorf("query parameter name should not be empty")
+			}
+		case RouteMatchTypeMethod:
+			c.Name = ""
+		default:
+			return nil, fmt.Errorf("invalid route match type [%s]", match.Type)
+		}
+		if match.Regex {
+			re, err := regexp.Compile(match.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex [%s]: %v", match.Value, err)
+			}
+			c.re = re
+		}
+		m.conds = append(m.conds, c)
+	}
+	return m, nil
+}
+
+func (m *RequestMatcher) Match(req *http.Request) bool {
+	if m == nil {
+		return true
+	}
+	if req == nil {
+		return false
+	}
+	for _, c := range m.conds {
+		if !c.match(req) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *RequestMatcher) Priority() int {
+	if m == nil {
+		return 0
+	}
+	return m.priority
+}
+
+// overlaps 返回两个优先级相同的匹配器是否可能匹配同一个请求，这种情况下无法确定应该使用哪个路由。
+func (m *RequestMatcher) overlaps(o *RequestMatcher) bool {
+	if m.Priority() != o.Priority() {
+		return false
+	}
+	for _, c := range m.conds {
+		for _, oc := range o.conds {
+			if c.disjoint(oc) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// key 是匹配器的规范化表示，条件相同的匹配器具有相同的 key，nil 的 key 为 ""。
+func (m *RequestMatcher) key() string {
+	if m == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(m.conds))
+	for _, c := range m.conds {
+		parts = append(parts, c.Type+"|"+c.Name+"|"+strconv.FormatBool(c.Regex)+"|"+c.Value)
+	}
+	sort.Strings(parts)
+	return strconv.Itoa(m.priority) + "#" + strings.Join(parts, "#")
+}
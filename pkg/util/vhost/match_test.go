@@ -0,0 +1,52 @@
+package vhost
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRoutersAddMatchConflict(t *testing.T) {
+	header := func(value string, regex bool) RouteMatch {
+		return RouteMatch{Type: RouteMatchTypeHeader, Name: "x-version", Value: value, Regex: regex}
+	}
+	method := func(value string, regex bool) RouteMatch {
+		return RouteMatch{Type: RouteMatchTypeMethod, Value: value, Regex: regex}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		a, b     []RouteMatch
+		priority int
+		conflict bool
+	}{
+		{"different exact values", []RouteMatch{header("a", false)}, []RouteMatch{header("b", false)}, 0, false},
+		{"same exact value", []RouteMatch{header("a", false)}, []RouteMatch{header("a", false)}, 0, true},
+		{"regex not matching exact value", []RouteMatch{header("^v[0-9]$", true)}, []RouteMatch{header("beta", false)}, 0, false},
+		{"regex matching exact value", []RouteMatch{header("^v[0-9]$", true)}, []RouteMatch{header("v2", false)}, 0, true},
+		{"exact method is case insensitive", []RouteMatch{method("^GET$", true)}, []RouteMatch{method("get", false)}, 0, true},
+		{"regex not matching method", []RouteMatch{method("^(GET|HEAD)$", true)}, []RouteMatch{method("POST", false)}, 0, false},
+		// 两个正则表达式是否有共同匹配的值无法判断
+		{"two disjoint regexes", []RouteMatch{header("^a$", true)}, []RouteMatch{header("^b$", true)}, 0, true},
+		{"two regexes with different priority", []RouteMatch{header("^a$", true)}, []RouteMatch{header("^b$", true)}, 1, false},
+		{"different fields", []RouteMatch{header("a", false)}, []RouteMatch{method("GET", false)}, 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ma, err := NewRequestMatcher(tc.a, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mb, err := NewRequestMatcher(tc.b, tc.priority)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := NewRouters()
+			if err := r.Add("example.test", "/", "", ma, "a"); err != nil {
+				t.Fatal(err)
+			}
+			err = r.Add("example.test", "/", "", mb, "b")
+			if conflict := errors.Is(err, ErrRouterConfigConflict); conflict != tc.conflict {
+				t.Fatalf("conflict %v, want %v (err: %v)", conflict, tc.conflict, err)
+			}
+		})
+	}
+}
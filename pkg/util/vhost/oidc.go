@@ -0,0 +1,395 @@
+package vhost
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"golang.org/x/oauth2"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// OIDCCallbackPath 是身份提供方登录完成后的回调地址，需要为每个启用 OIDC 的域名在身份提供方注册
+	// scheme://domain/.frp/oidc/callback。
+	OIDCCallbackPath = "/.frp/oidc/callback"
+	// OIDCLogoutPath 清除 frps 的登录会话，不会退出身份提供方的登录。
+	OIDCLogoutPath = "/.frp/oidc/logout"
+
+	oidcSessionCookie = "frp_oidc_session"
+	oidcStateCookie   = "frp_oidc_state"
+	// oidcStateTTL 是从跳转到身份提供方到回调之间允许的最长时间
+	oidcStateTTL = 10 * time.Minute
+	// oidcRequestTimeout 是服务发现和用授权码换取令牌的超时时间
+	oidcRequestTimeout = 10 * time.Second
+
+	// 传递给后端的身份请求头，用户请求中的同名请求头总是会被删除或覆盖，htpasswd 认证同样使用 ForwardedUserHeader
+	ForwardedUserHeader   = "X-Forwarded-User"
+	ForwardedEmailHeader  = "X-Forwarded-Email"
+	ForwardedGroupsHeader = "X-Forwarded-Groups"
+)
+
+type OIDCProviderOptions struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// GroupsClaim 是 ID Token 中用户组的声明名称，值应为字符串数组
+	GroupsClaim string
+	// CookieSecret 用于签名会话 cookie，为空时使用随机密钥，frps 重启后用户需要重新登录
+	CookieSecret []byte
+	SessionTTL   time.Duration
+}
+
+// OIDCProvider 是 http 代理登录使用的身份提供方，所有启用 OIDC 的路由共用。
+// 服务发现在第一次需要时进行，失败后下一个请求会重试，因此身份提供方暂时不可用不会影响 frps 启动。
+type OIDCProvider struct {
+	opts OIDCProviderOptions
+
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+	mu       sync.Mutex
+}
+
+func NewOIDCProvider(opts OIDCProviderOptions) (*OIDCProvider, error) {
+	if len(opts.CookieSecret) == 0 {
+		opts.CookieSecret = make([]byte, 32)
+		if _, err := rand.Read(opts.CookieSecret); err != nil {
+			return nil, err
+		}
+	}
+	return &OIDCProvider{opts: opts}, nil
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidc.Provider, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.provider != nil {
+		return p.provider, p.verifier, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, oidcRequestTimeout)
+	defer cancel()
+	provider, err := oidc.NewProvider(ctx, p.opts.Issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.provider = provider
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.opts.ClientID})
+	return p.provider, p.verifier, nil
+}
+
+func (p *OIDCProvider) oauth2Config(provider *oidc.Provider, req *http.Request) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.opts.ClientID,
+		ClientSecret: p.opts.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  requestScheme(req) + "://" + req.Host + OIDCCallbackPath,
+		Scopes:       p.opts.Scopes,
+	}
+}
+
+// OIDCIdentity 是保存在会话 cookie 中的登录用户信息。
+type OIDCIdentity struct {
+	Subject string   `json:"sub"`
+	User    string   `json:"user,omitempty"`
+	Email   string   `json:"email,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	// Expiry 是会话的过期时间，Unix 秒
+	Expiry int64 `json:"exp"`
+}
+
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Redirect string `json:"redirect"`
+	Expiry   int64  `json:"exp"`
+}
+
+// OIDCPolicy 限制可以访问路由的用户，满足任意一个条件即可。所有列表都为空时允许所有登录的用户。
+type OIDCPolicy struct {
+	AllowedEmailDomains []string
+	AllowedEmails       []string
+	AllowedGroups       []string
+}
+
+func (p *OIDCPolicy) allowed(id *OIDCIdentity) bool {
+	if len(p.AllowedEmailDomains) == 0 && len(p.AllowedEmails) == 0 && len(p.AllowedGroups) == 0 {
+		return true
+	}
+	if id.Email != "" {
+		equalFold := func(s string) func(string) bool {
+			return func(v string) bool { return strings.EqualFold(v, s) }
+		}
+		if slices.ContainsFunc(p.AllowedEmails, equalFold(id.Email)) {
+			return true
+		}
+		if i := strings.LastIndexByte(id.Email, '@'); i >= 0 && slices.ContainsFunc(p.AllowedEmailDomains, equalFold(id.Email[i+1:])) {
+			return true
+		}
+	}
+	for _, g := range id.Groups {
+		if slices.Contains(p.AllowedGroups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDCAuth 要求访问路由的用户先通过身份提供方登录，并按 policy 检查登录的用户。
+type OIDCAuth struct {
+	provider *OIDCProvider
+	policy   OIDCPolicy
+}
+
+func NewOIDCAuth(provider *OIDCProvider, policy OIDCPolicy) *OIDCAuth {
+	return &OIDCAuth{
+		provider: provider,
+		policy:   policy,
+	}
+}
+
+// Authenticate 返回 true 表示请求已通过认证，此时已在 req 上设置身份请求头并删除 frps 的 cookie；
+// 返回 false 时响应已经写入 rw，例如跳转到身份提供方登录或者拒绝访问。
+func (a *OIDCAuth) Authenticate(rw http.ResponseWriter, req *http.Request) bool {
+	switch req.URL.Path {
+	case OIDCCallbackPath:
+		a.handleCallback(rw, req)
+		return false
+	case OIDCLogoutPath:
+		a.provider.clearCookie(rw, req, oidcSessionCookie)
+		http.Redirect(rw, req, "/", http.StatusFound)
+		return false
+	}
+
+	var id OIDCIdentity
+	if !a.provider.readCookie(req, oidcSessionCookie, &id) || !a.provider.verifyExpiry(id.Expiry) {
+		a.redirectToLogin(rw, req)
+		return false
+	}
+	if !a.policy.allowed(&id) {
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return false
+	}
+
+	// 删除用户伪造的身份请求头
+	req.Header.Del(ForwardedEmailHeader)
+	req.Header.Del(ForwardedGroupsHeader)
+	req.Header.Set(ForwardedUserHeader, id.User)
+	if id.Email != "" {
+		req.Header.Set(ForwardedEmailHeader, id.Email)
+	}
+	if len(id.Groups) > 0 {
+		req.Header.Set(ForwardedGroupsHeader, strings.Join(id.Groups, ","))
+	}
+	removeOIDCCookies(req)
+	return true
+}
+
+func (a *OIDCAuth) redirectToLogin(rw http.ResponseWriter, req *http.Request) {
+	provider, _, err := a.provider.discover(req.Context())
+	if err != nil {
+		log.Warnf("discover oidc provider [%s] error: %v", a.provider.opts.Issuer, err)
+		http.Error(rw, "OIDC provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	st := oidcState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Redirect: loginRedirect(req.URL.RequestURI()),
+		Expiry:   time.Now().Add(oidcStateTTL).Unix(),
+	}
+	a.provider.setCookie(rw, req, oidcStateCookie, &st, oidcStateTTL)
+	authURL := a.provider.oauth2Config(provider, req).AuthCodeURL(st.State, oidc.Nonce(st.Nonce))
+	http.Redirect(rw, req, authURL, http.StatusFound)
+}
+
+func (a *OIDCAuth) handleCallback(rw http.ResponseWriter, req *http.Request) {
+	var st oidcState
+	if !a.provider.readCookie(req, oidcStateCookie, &st) || !a.provider.verifyExpiry(st.Expiry) ||
+		req.URL.Query().Get("state") != st.State {
+		http.Error(rw, "invalid OIDC login state, please retry", http.StatusBadRequest)
+		return
+	}
+	a.provider.clearCookie(rw, req, oidcStateCookie)
+	if errMsg := req.URL.Query().Get("error"); errMsg != "" {
+		http.Error(rw, "OIDC login failed: "+errMsg, http.StatusForbidden)
+		return
+	}
+
+	id, err := a.provider.exchange(req, st.Nonce)
+	if err != nil {
+		log.Infof("oidc login for host [%s] error: %v", req.Host, err)
+		http.Error(rw, "OIDC login failed", http.StatusForbidden)
+		return
+	}
+	if !a.policy.allowed(id) {
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	a.provider.setCookie(rw, req, oidcSessionCookie, id, a.provider.opts.SessionTTL)
+	http.Redirect(rw, req, loginRedirect(st.Redirect), http.StatusFound)
+}
+
+// loginRedirect 返回登录完成后跳转的地址。只允许以单个 / 开头的本站路径，
+// 浏览器会将 //host 和 /\host 当作其他站点的地址，这些地址统一替换为 /，避免开放重定向。
+func loginRedirect(uri string) string {
+	if !strings.HasPrefix(uri, "/") || strings.HasPrefix(uri, "//") || strings.HasPrefix(uri, "/\\") {
+		return "/"
+	}
+	return uri
+}
+
+// exchange 用回调中的授权码换取并校验 ID Token。
+func (p *OIDCProvider) exchange(req *http.Request, nonce string) (*OIDCIdentity, error) {
+	provider, verifier, err := p.discover(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), oidcRequestTimeout)
+	defer cancel()
+
+	token, err := p.oauth2Config(provider, req).Exchange(ctx, req.URL.Query().Get("code"))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %v", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("no id_token in token response")
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verify id_token: %v", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     *bool  `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	var allClaims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	if err := idToken.Claims(&allClaims); err != nil {
+		return nil, err
+	}
+
+	id := &OIDCIdentity{
+		Subject: idToken.Subject,
+		User:    claims.PreferredUsername,
+		Email:   claims.Email,
+		Expiry:  time.Now().Add(p.opts.SessionTTL).Unix(),
+	}
+	// 未验证的邮箱不能用于 policy 检查
+	if claims.EmailVerified != nil && !*claims.EmailVerified {
+		id.Email = ""
+	}
+	if id.User == "" {
+		id.User = id.Subject
+	}
+	if groups, ok := allClaims[p.opts.GroupsClaim].([]any); ok {
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	}
+	return id, nil
+}
+
+func (p *OIDCProvider) verifyExpiry(expiry int64) bool {
+	return time.Now().Unix() < expiry
+}
+
+// setCookie 将 v 序列化为 JSON 并附加 HMAC 签名。
+func (p *OIDCProvider) setCookie(rw http.ResponseWriter, req *http.Request, name string, v any, ttl time.Duration) {
+	payload, _ := json.Marshal(v)
+	value := base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(p.sign(payload))
+	http.SetCookie(rw, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   requestScheme(req) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (p *OIDCProvider) clearCookie(rw http.ResponseWriter, req *http.Request, name string) {
+	http.SetCookie(rw, &http.Cookie{
+		Name:     name,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   requestScheme(req) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// readCookie 在签名有效时将 cookie 反序列化到 v 中，过期时间由调用方检查。
+func (p *OIDCProvider) readCookie(req *http.Request, name string, v any) bool {
+	c, err := req.Cookie(name)
+	if err != nil {
+		return false
+	}
+	payloadStr, sigStr, ok := strings.Cut(c.Value, ".")
+	if !ok {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(payloadStr)
+	if err != nil {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigStr)
+	if err != nil || !hmac.Equal(sig, p.sign(payload)) {
+		return false
+	}
+	return json.Unmarshal(payload, v) == nil
+}
+
+func (p *OIDCProvider) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.opts.CookieSecret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// removeOIDCCookies 删除请求中 frps 使用的 cookie，避免会话泄露给后端。
+func removeOIDCCookies(req *http.Request) {
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != oidcSessionCookie && c.Name != oidcStateCookie {
+			req.AddCookie(c)
+		}
+	}
+}
+
+// requestScheme 只根据 frps 自身的连接判断，X-Forwarded-Proto 由客户端提供，不能用于回调地址和 cookie 的 Secure 属性。
+func requestScheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,327 @@
+package vhost
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testOIDCClientID = "frp-test"
+
+// testIdP 是只实现服务发现、JWKS 和令牌接口的身份提供方，授权接口由测试直接跳过
+type testIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// claims 是下一次换取令牌时 ID Token 中的声明，nonce 由测试从登录跳转地址中取得
+	claims map[string]any
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, map[string]any{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, map[string]any{"keys": []map[string]any{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(rw http.ResponseWriter, req *http.Request) {
+		if req.PostFormValue("code") != "test-code" {
+			http.Error(rw, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		idp.mu.Lock()
+		claims := idp.claims
+		idp.mu.Unlock()
+		writeJSON(rw, http.StatusOK, map[string]any{
+			"access_token": "test-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idp.sign(t, claims),
+		})
+	})
+	idp.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// oauth2 根据 Content-Type 解析令牌响应
+		rw.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(rw, req)
+	}))
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// sign 返回 RS256 签名的 ID Token
+func (idp *testIdP) sign(t *testing.T, claims map[string]any) string {
+	now := time.Now()
+	payload := map[string]any{
+		"iss": idp.URL,
+		"aud": testOIDCClientID,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		payload[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	body, _ := json.Marshal(payload)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Error(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (idp *testIdP) setClaims(claims map[string]any) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.claims = claims
+}
+
+// newTestOIDCProxy 返回一个反向代理，allow.example.test 允许所有登录用户，deny.example.test 只允许 other.test 的邮箱。
+// 后端返回收到的身份请求头和 cookie。
+func newTestOIDCProxy(t *testing.T, idp *testIdP) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, map[string]string{
+			"user":   req.Header.Get(ForwardedUserHeader),
+			"email":  req.Header.Get(ForwardedEmailHeader),
+			"groups": req.Header.Get(ForwardedGroupsHeader),
+			"cookie": req.Header.Get("Cookie"),
+		})
+	}))
+	t.Cleanup(backend.Close)
+
+	provider, err := NewOIDCProvider(OIDCProviderOptions{
+		Issuer:      idp.URL,
+		ClientID:    testOIDCClientID,
+		Scopes:      []string{"openid", "email"},
+		GroupsClaim: "groups",
+		SessionTTL:  time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp := NewHTTPReverseProxy(HTTPReverseProxyOptions{}, NewRouters())
+	for domain, policy := range map[string]OIDCPolicy{
+		"allow.example.test": {},
+		"deny.example.test":  {AllowedEmailDomains: []string{"other.test"}},
+	} {
+		err := rp.Register(RouteConfig{
+			Domain:   domain,
+			Location: "/",
+			OIDCAuth: NewOIDCAuth(provider, policy),
+			CreateConnFn: func(string) (net.Conn, error) {
+				return net.Dial("tcp", backend.Listener.Addr().String())
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(rp)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testOIDCClient 不跟随跳转，手动保存 cookie，以便检查每一步的响应
+type testOIDCClient struct {
+	t       *testing.T
+	srvURL  string
+	host    string
+	cookies map[string]*http.Cookie
+}
+
+func (c *testOIDCClient) do(uri string, header http.Header) *http.Response {
+	req, err := http.NewRequest("GET", c.srvURL+uri, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Host = c.host
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.t.Cleanup(func() { resp.Body.Close() })
+	for _, cookie := range resp.Cookies() {
+		if cookie.Secure {
+			c.t.Errorf("cookie %s should not be secure on a plain http connection", cookie.Name)
+		}
+		if cookie.MaxAge < 0 {
+			delete(c.cookies, cookie.Name)
+		} else {
+			c.cookies[cookie.Name] = cookie
+		}
+	}
+	return resp
+}
+
+// login 从 uri 开始完成登录，返回回调的响应
+func (c *testOIDCClient) login(idp *testIdP, uri string, claims map[string]any) *http.Response {
+	resp := c.do(uri, http.Header{"X-Forwarded-Proto": {"https"}})
+	if resp.StatusCode != http.StatusFound {
+		c.t.Fatalf("got status %d, want login redirect", resp.StatusCode)
+	}
+	authURL, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL.String(), idp.URL+"/authorize") {
+		c.t.Fatalf("got redirect %s, want idp authorize endpoint", authURL)
+	}
+	query := authURL.Query()
+	// 客户端提供的 X-Forwarded-Proto 不影响回调地址
+	if want := "http://" + c.host + OIDCCallbackPath; query.Get("redirect_uri") != want {
+		c.t.Fatalf("got redirect_uri %s, want %s", query.Get("redirect_uri"), want)
+	}
+
+	claims["nonce"] = query.Get("nonce")
+	idp.setClaims(claims)
+	return c.do(OIDCCallbackPath+"?code=test-code&state="+url.QueryEscape(query.Get("state")), nil)
+}
+
+func TestOIDCAuth(t *testing.T) {
+	idp := newTestIdP(t)
+	srv := newTestOIDCProxy(t, idp)
+	newClient := func(host string) *testOIDCClient {
+		return &testOIDCClient{t: t, srvURL: srv.URL, host: host, cookies: make(map[string]*http.Cookie)}
+	}
+	claims := func() map[string]any {
+		return map[string]any{
+			"sub":                "user-1",
+			"preferred_username": "alice",
+			"email":              "alice@example.test",
+			"email_verified":     true,
+			"groups":             []string{"dev", "ops"},
+		}
+	}
+
+	t.Run("login", func(t *testing.T) {
+		c := newClient("allow.example.test")
+		resp := c.login(idp, "/app?x=1", claims())
+		if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/app?x=1" {
+			t.Fatalf("got status %d location %q, want redirect to /app?x=1", resp.StatusCode, resp.Header.Get("Location"))
+		}
+		if c.cookies[oidcSessionCookie] == nil {
+			t.Fatal("session cookie not set")
+		}
+
+		// 用户伪造的身份请求头被覆盖，frps 的 cookie 不会发送给后端
+		c.cookies["other"] = &http.Cookie{Name: "other", Value: "1"}
+		resp = c.do("/app", http.Header{
+			ForwardedUserHeader:   {"admin"},
+			ForwardedEmailHeader:  {"admin@example.test"},
+			ForwardedGroupsHeader: {"admin"},
+		})
+		var got map[string]string
+		body, _ := io.ReadAll(resp.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatalf("status %d body %q: %v", resp.StatusCode, body, err)
+		}
+		want := map[string]string{"user": "alice", "email": "alice@example.test", "groups": "dev,ops", "cookie": "other=1"}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("backend got %s %q, want %q", k, got[k], v)
+			}
+		}
+	})
+
+	t.Run("open redirect", func(t *testing.T) {
+		c := newClient("allow.example.test")
+		resp := c.login(idp, "//evil.example/", claims())
+		if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/" {
+			t.Fatalf("got status %d location %q, want redirect to /", resp.StatusCode, resp.Header.Get("Location"))
+		}
+	})
+
+	t.Run("invalid state", func(t *testing.T) {
+		c := newClient("allow.example.test")
+		c.do("/", nil)
+		resp := c.do(OIDCCallbackPath+"?code=test-code&state=forged", nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("got status %d, want 400", resp.StatusCode)
+		}
+	})
+
+	t.Run("policy deny", func(t *testing.T) {
+		c := newClient("deny.example.test")
+		resp := c.login(idp, "/", claims())
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("got status %d, want 403", resp.StatusCode)
+		}
+		if c.cookies[oidcSessionCookie] != nil {
+			t.Fatal("session cookie should not be set for denied user")
+		}
+
+		// 未验证的邮箱不能满足 policy
+		c = newClient("deny.example.test")
+		unverified := claims()
+		unverified["email"] = "alice@other.test"
+		unverified["email_verified"] = false
+		if resp := c.login(idp, "/", unverified); resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("got status %d for unverified email, want 403", resp.StatusCode)
+		}
+
+		c = newClient("deny.example.test")
+		verified := claims()
+		verified["email"] = "alice@other.test"
+		if resp := c.login(idp, "/", verified); resp.StatusCode != http.StatusFound {
+			t.Fatalf("got status %d for allowed email, want login redirect", resp.StatusCode)
+		}
+	})
+}
+
+func TestLoginRedirect(t *testing.T) {
+	for uri, want := range map[string]string{
+		"/app?x=1":             "/app?x=1",
+		"/%5Cevil.example/":    "/%5Cevil.example/",
+		"//evil.example/":      "/",
+		"/\\evil.example/":     "/",
+		"https://evil.example": "/",
+		"":                     "/",
+	} {
+		if got := loginRedirect(uri); got != want {
+			t.Errorf("loginRedirect(%q) = %q, want %q", uri, got, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package vhost
+
+import (
+	"golang.org/x/time/rate"
+	"math"
+	"sync"
+	"time"
+)
+
+// requestLimiterSweepInterval 是清理不活跃令牌桶的最短间隔
+const requestLimiterSweepInterval = time.Minute
+
+// RequestLimiter 按令牌桶限制请求速率，每个来源 IP 使用独立的令牌桶。
+// 不活跃到足以重新装满的令牌桶会被清理，之后的请求使用新的满令牌桶，效果与保留时相同。
+type RequestLimiter struct {
+	limit rate.Limit
+	burst int
+	// idleTimeout 是令牌桶从空到满所需的时间，至少为 requestLimiterSweepInterval
+	idleTimeout time.Duration
+
+	buckets   map[string]*requestBucket
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+type requestBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewRequestLimiter 在 requestsPerSecond 不大于 0 时返回 nil，burst 不大于 0 时使用 requestsPerSecond 向上取整。
+func NewRequestLimiter(requestsPerSecond float64, burst int) *RequestLimiter {
+	if requestsPerSecond <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(requestsPerSecond))
+	}
+	idleTimeout := time.Duration(float64(burst) / requestsPerSecond * float64(time.Second))
+	return &RequestLimiter{
+		limit:       rate.Limit(requestsPerSecond),
+		burst:       burst,
+		idleTimeout: max(idleTimeout, requestLimiterSweepInterval),
+		buckets:     make(map[string]*requestBucket),
+		lastSweep:   time.Now(),
+	}
+}
+
+// Limit 返回每秒请求数和突发请求数。
+func (l *RequestLimiter) Limit() (float64, int) {
+	return float64(l.limit), l.burst
+}
+
+// Allow 返回是否允许来自 ip 的请求，不允许时同时返回至少需要等待的时间。nil 的 RequestLimiter 允许所有请求。
+func (l *RequestLimiter) Allow(ip string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= requestLimiterSweepInterval {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) >= l.idleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &requestBucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[ip] = b
+	}
+	b.lastSeen = now
+
+	r := b.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return false, time.Second
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
@@ -0,0 +1,117 @@
+package vhost
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewRequestLimiter(t *testing.T) {
+	if NewRequestLimiter(0, 10) != nil || NewRequestLimiter(-1, 10) != nil {
+		t.Error("non-positive rate should return nil")
+	}
+	var nilLimiter *RequestLimiter
+	if ok, _ := nilLimiter.Allow("1.1.1.1"); !ok {
+		t.Error("nil limiter should allow all requests")
+	}
+	if rps, burst := NewRequestLimiter(2.5, 0).Limit(); rps != 2.5 || burst != 3 {
+		t.Errorf("got rps %v burst %d, want 2.5 and 3", rps, burst)
+	}
+}
+
+func TestRequestLimiterPerIP(t *testing.T) {
+	l := NewRequestLimiter(1, 2)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("1.1.1.1"); !ok {
+			t.Fatalf("request %d within burst should be allowed", i)
+		}
+	}
+	ok, retryAfter := l.Allow("1.1.1.1")
+	if ok {
+		t.Fatal("request over burst should be rejected")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("got retry after %v, want in (0, 1s]", retryAfter)
+	}
+	// 被拒绝的请求不消耗令牌，其他 IP 使用独立的令牌桶
+	if ok, again := l.Allow("1.1.1.1"); ok || again > retryAfter {
+		t.Errorf("got %v %v, want rejected within %v", ok, again, retryAfter)
+	}
+	if ok, _ := l.Allow("2.2.2.2"); !ok {
+		t.Error("request from another ip should be allowed")
+	}
+}
+
+func TestRequestLimiterSweep(t *testing.T) {
+	l := NewRequestLimiter(1, 1)
+	if l.idleTimeout != requestLimiterSweepInterval {
+		t.Fatalf("got idle timeout %v, want at least %v", l.idleTimeout, requestLimiterSweepInterval)
+	}
+	l.Allow("1.1.1.1")
+	l.Allow("2.2.2.2")
+
+	// 距上次清理不足清理间隔时不清理
+	l.buckets["1.1.1.1"].lastSeen = time.Now().Add(-2 * l.idleTimeout)
+	l.Allow("3.3.3.3")
+	if len(l.buckets) != 3 {
+		t.Fatalf("got %d buckets before the sweep interval, want 3", len(l.buckets))
+	}
+
+	l.lastSweep = time.Now().Add(-requestLimiterSweepInterval)
+	l.Allow("2.2.2.2")
+	if _, ok := l.buckets["1.1.1.1"]; ok {
+		t.Error("idle bucket should be swept")
+	}
+	if len(l.buckets) != 2 {
+		t.Errorf("got %d buckets after sweep, want 2", len(l.buckets))
+	}
+	// 清理后的 IP 使用新的满令牌桶
+	if ok, _ := l.Allow("1.1.1.1"); !ok {
+		t.Error("request after sweep should be allowed")
+	}
+}
+
+func TestHTTPReverseProxyRateLimit(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(rw, "ok")
+	}))
+	t.Cleanup(backend.Close)
+
+	rp := NewHTTPReverseProxy(HTTPReverseProxyOptions{}, NewRouters())
+	err := rp.Register(RouteConfig{
+		Domain:         "example.test",
+		Location:       "/",
+		RequestLimiter: NewRequestLimiter(0.5, 1),
+		CreateConnFn: func(string) (net.Conn, error) {
+			return net.Dial("tcp", backend.Listener.Addr().String())
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(rp)
+	t.Cleanup(srv.Close)
+
+	for _, tc := range []struct {
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{http.StatusOK, ""},
+		{http.StatusTooManyRequests, "2"},
+	} {
+		req, _ := http.NewRequest("GET", srv.URL+"/", nil)
+		req.Host = "example.test"
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.wantStatus || resp.Header.Get("Retry-After") != tc.wantRetryAfter {
+			t.Fatalf("got %d with Retry-After %q, want %d with %q",
+				resp.StatusCode, resp.Header.Get("Retry-After"), tc.wantStatus, tc.wantRetryAfter)
+		}
+	}
+}
@@ -1,6 +1,14 @@
 package vhost
 
-import "sync"
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var ErrRouterConfigConflict = errors.New("router config conflict")
 
 type routerByHTTPUser map[string][]*Router
 
@@ -14,6 +22,8 @@ type Router struct {
 	domain   string
 	location string
 	httpUser string
+	// 按请求头、查询参数和请求方法匹配的条件，为 nil 时匹配所有请求
+	matcher *RequestMatcher
 
 	// 在此处存储任何对象
 	payload interface{}
@@ -25,3 +35,165 @@ func NewRouters() *Routers {
 	}
 
 }
+
+// Add 添加路由。同一个 location 上可以有多个带匹配条件的路由和一个不带匹配条件的路由，
+// 如果两个带匹配条件的路由优先级相同且可能匹配同一个请求，则返回 ErrRouterConfigConflict。
+func (r *Routers) Add(domain, location, httpUser string, matcher *RequestMatcher, payload interface{}) error {
+	domain = strings.ToLower(domain)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exist := r.exist(domain, location, httpUser, matcher); exist {
+		return ErrRouterConfigConflict
+	}
+
+	routersByHTTPUser, found := r.indexByDomain[domain]
+	if !found {
+		routersByHTTPUser = make(map[string][]*Router)
+		r.indexByDomain[domain] = routersByHTTPUser
+	}
+
+	locations, found := routersByHTTPUser[httpUser]
+	if !found {
+		locations = make([]*Router, 0, 1)
+	}
+
+	router := &Router{
+		domain:   domain,
+		location: location,
+		httpUser: httpUser,
+		matcher:  matcher,
+		payload:  payload,
+	}
+	locations = append(locations, router)
+
+	// 按 location 从长到短排列，保证最长前缀优先匹配。
+	// location 相同时，带匹配条件的路由按优先级从高到低排在不带匹配条件的路由之前。
+	sort.Stable(sort.Reverse(ByLocation(locations)))
+	routersByHTTPUser[httpUser] = locations
+	return nil
+}
+
+func (r *Routers) Del(domain, location, httpUser string, matcher *RequestMatcher) {
+	domain = strings.ToLower(domain)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	routersByHTTPUser, found := r.indexByDomain[domain]
+	if !found {
+		return
+	}
+
+	vrs, found := routersByHTTPUser[httpUser]
+	if !found {
+		return
+	}
+	newVrs := make([]*Router, 0)
+	for _, vr := range vrs {
+		if vr.location != location || vr.matcher.key() != matcher.key() {
+			newVrs = append(newVrs, vr)
+		}
+	}
+	if len(newVrs) == 0 {
+		delete(routersByHTTPUser, httpUser)
+		if len(routersByHTTPUser) == 0 {
+			delete(r.indexByDomain, domain)
+		}
+		return
+	}
+	routersByHTTPUser[httpUser] = newVrs
+}
+
+// Get 返回 location 是 path 前缀且匹配条件满足的路由，多个路由匹配时返回 location 最长的一个。
+// req 为 nil 时只会返回不带匹配条件的路由。
+func (r *Routers) Get(host, path, httpUser string, req *http.Request) (vr *Router, exist bool) {
+	host = strings.ToLower(host)
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	routersByHTTPUser, found := r.indexByDomain[host]
+	if !found {
+		return
+	}
+
+	vrs, found := routersByHTTPUser[httpUser]
+	if !found {
+		return
+	}
+
+	for _, vr = range vrs {
+		if strings.HasPrefix(path, vr.location) && vr.matcher.Match(req) {
+			return vr, true
+		}
+	}
+	return nil, false
+}
+
+func (r *Routers) exist(host, path, httpUser string, matcher *RequestMatcher) (route *Router, exist bool) {
+	routersByHTTPUser, found := r.indexByDomain[host]
+	if !found {
+		return
+	}
+	routers, found := routersByHTTPUser[httpUser]
+	if !found {
+		return
+	}
+
+	for _, route = range routers {
+		if path != route.location {
+			continue
+		}
+		if matcher == nil && route.matcher == nil {
+			return route, true
+		}
+		if matcher != nil && route.matcher != nil && matcher.overlaps(route.matcher) {
+			return route, true
+		}
+	}
+	return nil, false
+}
+
+// Exist 返回是否有代理使用该域名，包括匹配该域名的通配符域名，例如 a.example.com 匹配 *.example.com。
+func (r *Routers) Exist(domain string) bool {
+	domain = strings.ToLower(domain)
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if _, ok := r.indexByDomain[domain]; ok {
+		return true
+	}
+	domainSplit := strings.Split(domain, ".")
+	for len(domainSplit) > 1 {
+		domainSplit[0] = "*"
+		if _, ok := r.indexByDomain[strings.Join(domainSplit, ".")]; ok {
+			return true
+		}
+		domainSplit = domainSplit[1:]
+	}
+	return false
+}
+
+// ByLocation 按 location 排序，location 相同时按匹配条件的优先级排序，不带匹配条件的路由最小
+type ByLocation []*Router
+
+func (a ByLocation) Len() int {
+	return len(a)
+}
+
+func (a ByLocation) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+
+func (a ByLocation) Less(i, j int) bool {
+	if a[i].location != a[j].location {
+		return strings.Compare(a[i].location, a[j].location) < 0
+	}
+	if (a[i].matcher == nil) != (a[j].matcher == nil) {
+		return a[i].matcher == nil
+	}
+	return a[i].matcher.Priority() < a[j].matcher.Priority()
+}
@@ -1,13 +1,21 @@
 package vhost
 
 import (
+	"context"
+	"fmt"
+	"github.com/fatedier/golib/errors"
+	"github.com/sunyihoo/frp/pkg/util/accesslog"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	netpkg "github.com/sunyihoo/frp/pkg/util/net"
 	"net"
+	"net/http"
+	"strings"
 	"time"
 )
 
 type (
 	muxFunc         func(net.Conn) (net.Conn, map[string]string, error)
-	authFunc        func(conn net.Conn, passwd string, reqInfoMap map[string]string) (bool, error)
+	authFunc        func(conn net.Conn, username, passwd string, reqInfoMap map[string]string) (bool, error)
 	hostRewriteFunc func(net.Conn, string) (net.Conn, error)
 	successFunc     func(net.Conn, map[string]string) error
 	failHookFunc    func(net.Conn)
@@ -26,25 +34,361 @@ type Muxer struct {
 	failHook       failHookFunc
 	rewriteHost    hostRewriteFunc
 	registryRouter *Routers
+	// 未启用访问日志时为 nil
+	accessLogger *accesslog.Logger
+	// 未启用 TLS 终止时为 nil
+	tlsTerminator *TLSTerminator
 }
 
-type ChooseEndPointFunc func() (string, error)
+func NewMuxer(
+	listener net.Listener,
+	vhostFunc muxFunc,
+	timeout time.Duration,
+) (mux *Muxer, err error) {
+	mux = &Muxer{
+		listener:       listener,
+		timeout:        timeout,
+		vhostFunc:      vhostFunc,
+		registryRouter: NewRouters(),
+	}
+	go mux.run()
+	return mux, nil
+}
+
+func (v *Muxer) SetCheckAuthFunc(f authFunc) *Muxer {
+	v.checkAuth = f
+	return v
+}
+
+func (v *Muxer) SetSuccessHookFunc(f successFunc) *Muxer {
+	v.successHook = f
+	return v
+}
+
+func (v *Muxer) SetFailHookFunc(f failHookFunc) *Muxer {
+	v.failHook = f
+	return v
+}
+
+func (v *Muxer) SetRewriteHostFunc(f hostRewriteFunc) *Muxer {
+	v.rewriteHost = f
+	return v
+}
+
+// SetAccessLogger 设置访问日志，路由成功的连接在关闭时记录。
+func (v *Muxer) SetAccessLogger(l *accesslog.Logger) *Muxer {
+	v.accessLogger = l
+	return v
+}
+
+// SetTLSTerminator 设置 TLS 终止，匹配的域名在 frps 上完成 TLS 握手后再将连接交给代理，只用于 https 代理。
+func (v *Muxer) SetTLSTerminator(t *TLSTerminator) *Muxer {
+	v.tlsTerminator = t
+	return v
+}
+
+// ChooseEndPointFunc 为请求选择 endpoint，req 可以用于按请求头或 cookie 固定 endpoint。
+type ChooseEndPointFunc func(req *http.Request) (string, error)
 
+// CreateConnFunc 在无法获取工作连接时应返回包装了 ErrNoWorkConn 的错误，用户将看到 503 页面。
 type CreateConnFunc func(remoteAddr string) (net.Conn, error)
 
 type CreateConnByEndpointFunc func(endpoint, remoteAddr string) (net.Conn, error)
 
 type RouteConfig struct {
-	Domain          string
-	Location        string
-	RewriteHost     string
-	Username        string
-	Password        string
+	Domain      string
+	Location    string
+	RewriteHost string
+	Username    string
+	Password    string
+	// HTPasswd 不为 nil 时使用其中的用户认证，Username 和 Password 不再生效，认证通过的用户名通过 X-Forwarded-User 传递给后端
+	HTPasswd        *netpkg.Htpasswd
 	Headers         map[string]string
 	ResponseHeaders map[string]string
 	RouteByHTTPUser string
+	// BackendHTTP2 为 true 时，通过工作连接以 h2c 的方式向后端发送请求，用于 gRPC 等需要 HTTP/2 的服务
+	BackendHTTP2 bool
+	// Matches 是在 Domain、Location 和 RouteByHTTPUser 之外的匹配条件，全部满足时才使用此路由
+	Matches []RouteMatch
+	// Priority 是带匹配条件的路由在同一个 location 上的优先级，值越大越先匹配
+	Priority int
 
 	CreateConnFn           CreateConnFunc
 	ChooseEndpointFn       ChooseEndPointFunc
 	CreateConnByEndpointFn CreateConnByEndpointFunc
+	// ModifyResponseFn 在返回给用户之前修改后端的响应，可以为 nil
+	ModifyResponseFn func(resp *http.Response) error
+
+	// ProxyName 和 RunID 是注册该路由的代理及其客户端的 run ID，用于访问日志
+	ProxyName string
+	RunID     string
+	// EndpointRunIDFn 返回 endpoint 所属客户端的 run ID，组路由使用它记录实际处理请求的客户端，可以为 nil
+	EndpointRunIDFn func(endpoint string) string
+	// SourceIPFilterFn 在请求工作连接之前检查用户的来源地址，返回 false 时拒绝访问，可以为 nil
+	SourceIPFilterFn netpkg.SourceIPFilterFunc
+	// SourceIPFilterKey 是生成 SourceIPFilterFn 的访问控制列表的规范化表示。
+	// 函数无法比较，组用它要求所有成员使用相同的列表
+	SourceIPFilterKey string
+	// RequestLimiter 按来源 IP 限制 HTTP 请求速率，超过限制时返回 429，可以为 nil
+	RequestLimiter *RequestLimiter
+	// OIDCAuth 要求用户先通过身份提供方登录，在 Username 和 Password 的认证之前检查，可以为 nil
+	OIDCAuth *OIDCAuth
+	// ErrorPages 是代理选择的错误页面，优先于按域名选择的页面，可以为 nil
+	ErrorPages *ErrorPages
+
+	// 注册时分配的唯一 ID
+	routeID string
+}
+
+// Listen 为路由配置创建一个侦听器，匹配该路由的连接将从侦听器的 Accept 返回。
+// 设置了 Username 的路由要求 Muxer 支持认证，例如 sni 无法认证用户，此时返回错误。
+func (v *Muxer) Listen(ctx context.Context, cfg *RouteConfig) (l *Listener, err error) {
+	// 没有 checkAuth 的 Muxer 无法认证用户，不能接受要求认证的路由
+	if cfg.Username != "" && v.checkAuth == nil {
+		return nil, fmt.Errorf("authentication is not supported for domain [%s]", cfg.Domain)
+	}
+	l = &Listener{
+		name:            cfg.Domain,
+		location:        cfg.Location,
+		routeByHTTPUser: cfg.RouteByHTTPUser,
+		rewriteHost:     cfg.RewriteHost,
+		username:        cfg.Username,
+		password:        cfg.Password,
+		proxyName:       cfg.ProxyName,
+		runID:           cfg.RunID,
+		sourceIPFilter:  cfg.SourceIPFilterFn,
+		backendHTTP2:    cfg.BackendHTTP2,
+		mux:             v,
+		accept:          make(chan net.Conn),
+		ctx:             ctx,
+	}
+	err = v.registryRouter.Add(cfg.Domain, cfg.Location, cfg.RouteByHTTPUser, nil, l)
+	if err != nil {
+		return
+	}
+	return l, nil
+}
+
+// getListener 首先检查完整域名，然后依次检查通配符域名，最后检查 "*"。
+func (v *Muxer) getListener(name, path, httpUser string) (*Listener, bool) {
+	findRouter := func(inName, inPath, inHTTPUser string) (*Listener, bool) {
+		vr, ok := v.registryRouter.Get(inName, inPath, inHTTPUser, nil)
+		if ok {
+			return vr.payload.(*Listener), true
+		}
+		// 尝试匹配不区分 HTTP 用户的路由
+		vr, ok = v.registryRouter.Get(inName, inPath, "", nil)
+		if ok {
+			return vr.payload.(*Listener), true
+		}
+		return nil, false
+	}
+
+	l, ok := findRouter(name, path, httpUser)
+	if ok {
+		return l, true
+	}
+
+	domainSplit := strings.Split(name, ".")
+	for len(domainSplit) >= 3 {
+		domainSplit[0] = "*"
+		name = strings.Join(domainSplit, ".")
+
+		l, ok = findRouter(name, path, httpUser)
+		if ok {
+			return l, true
+		}
+		domainSplit = domainSplit[1:]
+	}
+
+	l, ok = findRouter("*", path, httpUser)
+	if ok {
+		return l, true
+	}
+	return nil, false
+}
+
+func (v *Muxer) run() {
+	for {
+		conn, err := v.listener.Accept()
+		if err != nil {
+			return
+		}
+		go v.handle(conn)
+	}
+}
+
+func (v *Muxer) handle(c net.Conn) {
+	start := time.Now()
+	if err := c.SetDeadline(time.Now().Add(v.timeout)); err != nil {
+		_ = c.Close()
+		return
+	}
+
+	sConn, reqInfoMap, err := v.vhostFunc(c)
+	if err != nil {
+		log.Debugf("get hostname from http/https request error: %v", err)
+		_ = c.Close()
+		return
+	}
+
+	name := strings.ToLower(reqInfoMap["Host"])
+	path := strings.ToLower(reqInfoMap["Path"])
+	httpUser := reqInfoMap["HTTPUser"]
+
+	// TLS-ALPN-01 验证在路由之前处理，验证连接不受代理的访问控制影响，也不会转发给客户端
+	if v.tlsTerminator != nil && reqInfoMap["ACMEChallenge"] == "true" && v.tlsTerminator.Match(name) {
+		if _, err := v.tlsTerminator.Terminate(sConn, false); err != ErrACMEChallenge {
+			log.Debugf("acme tls-alpn-01 challenge for host [%s] error: %v", name, err)
+		}
+		_ = sConn.Close()
+		return
+	}
+	l, ok := v.getListener(name, path, httpUser)
+	if !ok {
+		log.Debugf("http request for host [%s] path [%s] httpUser [%s] not found", name, path, httpUser)
+		if v.failHook != nil {
+			v.failHook(sConn)
+		} else {
+			_ = sConn.Close()
+		}
+		return
+	}
+
+	if l.sourceIPFilter != nil && !l.sourceIPFilter(c.RemoteAddr().String()) {
+		log.Debugf("connection from [%s] to host [%s] is rejected by source IP filter", c.RemoteAddr(), name)
+		_ = sConn.Close()
+		return
+	}
+
+	// 如果设置了 checkAuth 以及用户名，则需要验证用户，验证通过后才调用 successHook
+	if l.mux.checkAuth != nil && l.username != "" {
+		ok, err := l.mux.checkAuth(c, l.username, l.password, reqInfoMap)
+		if !ok || err != nil {
+			log.Debugf("auth failed for user: %s", l.username)
+			_ = c.Close()
+			return
+		}
+	}
+
+	if v.successHook != nil {
+		if err := v.successHook(c, reqInfoMap); err != nil {
+			log.Infof("success func failure on vhost connection: %v", err)
+			_ = c.Close()
+			return
+		}
+	}
+
+	if err = sConn.SetDeadline(time.Time{}); err != nil {
+		_ = c.Close()
+		return
+	}
+	c = sConn
+
+	if v.accessLogger.Sampled() {
+		entry := &accesslog.Entry{
+			Time:       start,
+			ProxyName:  l.proxyName,
+			RunID:      l.runID,
+			RemoteAddr: c.RemoteAddr().String(),
+			User:       httpUser,
+			Host:       name,
+		}
+		if reqInfoMap["Scheme"] == "https" {
+			entry.SNI = name
+		}
+		c = accesslog.NewConn(c, v.accessLogger, entry)
+	}
+
+	// 终止 TLS 后代理收到的是 *tls.Conn，代理据此决定是否需要重新加密发往客户端的流量
+	if v.tlsTerminator != nil && v.tlsTerminator.Match(name) {
+		tlsConn, err := v.tlsTerminator.Terminate(c, l.backendHTTP2)
+		if err != nil {
+			if err != ErrACMEChallenge {
+				log.Debugf("terminate tls for host [%s] error: %v", name, err)
+			}
+			_ = c.Close()
+			return
+		}
+		c = tlsConn
+	}
+
+	log.Debugf("new request host [%s] path [%s] httpUser [%s]", name, path, httpUser)
+	err = errors.PanicToError(func() {
+		l.accept <- c
+	})
+	if err != nil {
+		log.Warnf("listener is already closed, ignore this request")
+	}
+}
+
+type Listener struct {
+	name            string
+	location        string
+	routeByHTTPUser string
+	rewriteHost     string
+	username        string
+	password        string
+	proxyName       string
+	runID           string
+	sourceIPFilter  netpkg.SourceIPFilterFunc
+	backendHTTP2    bool
+	mux             *Muxer // 用于关闭 Muxer
+	accept          chan net.Conn
+	ctx             context.Context
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, ok := <-l.accept
+	if !ok {
+		return nil, fmt.Errorf("listener closed")
+	}
+
+	// 如果设置了 rewriteHost，则使用修改后的 host 重写 http 请求
+	if l.mux.rewriteHost != nil {
+		sConn, err := l.mux.rewriteHost(conn, l.rewriteHost)
+		if err != nil {
+			log.Warnf("host header rewrite failed: %v", err)
+			return nil, fmt.Errorf("host header rewrite failed")
+		}
+		log.Debugf("rewrite host to [%s] success", l.rewriteHost)
+		conn = sConn
+	}
+	return conn, nil
+}
+
+func (l *Listener) Close() error {
+	l.mux.registryRouter.Del(l.name, l.location, l.routeByHTTPUser, nil)
+	close(l.accept)
+	return nil
+}
+
+func (l *Listener) Name() string {
+	return l.name
+}
+
+func (l *Listener) Addr() net.Addr {
+	return (*net.TCPAddr)(nil)
+}
+
+// Exist 返回是否有代理注册了该域名。
+func (v *Muxer) Exist(domain string) bool {
+	return v.registryRouter != nil && v.registryRouter.Exist(domain)
+}
+
+type ContextKey string
+
+const (
+	RouteInfoKey   ContextKey = "routeInfo"
+	RouteConfigKey ContextKey = "routeConfig"
+)
+
+// RequestRouteInfo 是 HTTP 请求的路由信息，保存在请求的 context 中。
+type RequestRouteInfo struct {
+	URL        string
+	Host       string
+	HTTPUser   string
+	RemoteAddr string
+	URLHost    string
+	Endpoint   string
 }
@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const queueFileSuffix = ".json"
+
+// diskQueue 是一个有界的先进先出队列，每个元素保存为目录中的一个文件，
+// 因此 frps 重启后会继续投递尚未完成的事件。队列已满时丢弃最旧的元素。
+type diskQueue struct {
+	dir     string
+	maxSize int
+
+	// 队列中元素的序号，从旧到新排列，文件名为序号加后缀
+	seqs     []uint64
+	nextSeq  uint64
+	notifyCh chan struct{}
+	dropped  atomic.Int64
+	mu       sync.Mutex
+}
+
+func newDiskQueue(dir string, maxSize int) (*diskQueue, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &diskQueue{
+		dir:      dir,
+		maxSize:  maxSize,
+		seqs:     make([]uint64, 0),
+		notifyCh: make(chan struct{}, 1),
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, queueFileSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, queueFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.seqs = append(q.seqs, seq)
+	}
+	slices.Sort(q.seqs)
+	if len(q.seqs) > 0 {
+		q.nextSeq = q.seqs[len(q.seqs)-1] + 1
+	}
+	return q, nil
+}
+
+func (q *diskQueue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, queueFileSuffix))
+}
+
+func (q *diskQueue) push(buf []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	seq := q.nextSeq
+	// 先写入临时文件再重命名，保证队列中不会出现不完整的文件
+	tmp := filepath.Join(q.dir, "."+strconv.FormatUint(seq, 10)+".tmp")
+	if err := os.WriteFile(tmp, buf, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.path(seq)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	q.nextSeq++
+	q.seqs = append(q.seqs, seq)
+
+	for len(q.seqs) > q.maxSize {
+		os.Remove(q.path(q.seqs[0]))
+		q.seqs = q.seqs[1:]
+		q.dropped.Add(1)
+	}
+
+	select {
+	case q.notifyCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// peek 返回最旧的元素但不移除它，队列为空时阻塞，直到有新元素或 ctx 结束。
+func (q *diskQueue) peek(ctx context.Context) (uint64, []byte, bool) {
+	for {
+		q.mu.Lock()
+		for len(q.seqs) > 0 {
+			seq := q.seqs[0]
+			buf, err := os.ReadFile(q.path(seq))
+			if err == nil {
+				q.mu.Unlock()
+				return seq, buf, true
+			}
+			// 文件已损坏或被删除，跳过
+			q.seqs = q.seqs[1:]
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.notifyCh:
+		case <-ctx.Done():
+			return 0, nil, false
+		}
+	}
+}
+
+// remove 移除指定的元素。如果该元素已因队列已满而被丢弃，则不做任何事。
+func (q *diskQueue) remove(seq uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if idx := slices.Index(q.seqs, seq); idx >= 0 {
+		q.seqs = slices.Delete(q.seqs, idx, idx+1)
+		os.Remove(q.path(seq))
+	}
+}
+
+func (q *diskQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.seqs)
+}
@@ -0,0 +1,314 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/samber/lo"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 支持的事件类型
+const (
+	EventClientLogin   = "ClientLogin"
+	EventClientLogout  = "ClientLogout"
+	EventProxyOpen     = "ProxyOpen"
+	EventProxyClose    = "ProxyClose"
+	EventAuthFailure   = "AuthFailure"
+	EventQuotaExceeded = "QuotaExceeded"
+	EventGroupFailover = "GroupFailover"
+)
+
+var SupportedEvents = []string{
+	EventClientLogin,
+	EventClientLogout,
+	EventProxyOpen,
+	EventProxyClose,
+	EventAuthFailure,
+	EventQuotaExceeded,
+	EventGroupFailover,
+}
+
+// 请求头
+const (
+	HeaderEvent     = "X-Frp-Event"
+	HeaderDelivery  = "X-Frp-Delivery"
+	HeaderTimestamp = "X-Frp-Timestamp"
+	// HeaderSignature 的值为 "sha256=" 加上 HMAC-SHA256(secret, timestamp + "." + body) 的十六进制编码
+	HeaderSignature = "X-Frp-Signature"
+)
+
+// Event 是发送给 webhook 的请求体。
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Timestamp int64           `json:"timestamp"`
+	Content   json.RawMessage `json:"content"`
+}
+
+type ClientContent struct {
+	User       string `json:"user"`
+	RunID      string `json:"run_id"`
+	Hostname   string `json:"hostname,omitempty"`
+	Os         string `json:"os,omitempty"`
+	Arch       string `json:"arch,omitempty"`
+	Version    string `json:"version,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+}
+
+type ProxyContent struct {
+	User       string `json:"user"`
+	RunID      string `json:"run_id"`
+	ProxyName  string `json:"proxy_name"`
+	ProxyType  string `json:"proxy_type"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+}
+
+type AuthFailureContent struct {
+	User       string `json:"user"`
+	RunID      string `json:"run_id,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	Reason     string `json:"reason"`
+}
+
+// Status 是单个 webhook 的投递统计，用于仪表板 API。
+type Status struct {
+	Name        string `json:"name"`
+	QueueLength int    `json:"queueLength"`
+	Enqueued    int64  `json:"enqueued"`
+	Delivered   int64  `json:"delivered"`
+	Retries     int64  `json:"retries"`
+	// Failed 为重试耗尽后放弃的事件数，Dropped 为队列已满时丢弃的最旧事件数
+	Failed    int64  `json:"failed"`
+	Dropped   int64  `json:"dropped"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Manager 将事件异步投递给所有订阅了该事件的 webhook，不会阻塞调用方。
+type Manager struct {
+	webhooks []*webhook
+}
+
+func NewManager(cfgs []v1.WebhookConfig) (*Manager, error) {
+	m := &Manager{}
+	for _, cfg := range cfgs {
+		w, err := newWebhook(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("webhook [%s]: %v", cfg.Name, err)
+		}
+		m.webhooks = append(m.webhooks, w)
+	}
+	return m, nil
+}
+
+// Run 启动所有 webhook 的投递协程，直到 ctx 结束。
+func (m *Manager) Run(ctx context.Context) {
+	for _, w := range m.webhooks {
+		go w.run(ctx)
+	}
+}
+
+// Notify 将事件写入所有订阅了该事件的 webhook 的队列。
+func (m *Manager) Notify(eventType string, content interface{}) {
+	if len(m.webhooks) == 0 {
+		return
+	}
+	buf, err := json.Marshal(content)
+	if err != nil {
+		log.Warnf("marshal webhook event [%s] error: %v", eventType, err)
+		return
+	}
+	ev := &Event{
+		ID:        newEventID(),
+		Type:      eventType,
+		Timestamp: time.Now().Unix(),
+		Content:   buf,
+	}
+	for _, w := range m.webhooks {
+		if !w.subscribe(eventType) {
+			continue
+		}
+		if err := w.enqueue(ev); err != nil {
+			log.Warnf("webhook [%s] enqueue event [%s] error: %v", w.cfg.Name, eventType, err)
+		}
+	}
+}
+
+func (m *Manager) GetStatus() []Status {
+	out := make([]Status, 0, len(m.webhooks))
+	for _, w := range m.webhooks {
+		out = append(out, w.status())
+	}
+	return out
+}
+
+type webhook struct {
+	cfg     v1.WebhookConfig
+	client  *http.Client
+	queue   *diskQueue
+	backoff time.Duration
+	// maxRetries 为 0 时投递失败后不重试
+	maxRetries int
+
+	enqueued  atomic.Int64
+	delivered atomic.Int64
+	retries   atomic.Int64
+	failed    atomic.Int64
+	lastError string
+	mu        sync.Mutex
+}
+
+func newWebhook(cfg v1.WebhookConfig) (*webhook, error) {
+	queue, err := newDiskQueue(cfg.QueueDir, cfg.MaxQueueSize)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Timeout: time.Duration(cfg.TimeoutMilliseconds) * time.Millisecond,
+	}
+	if strings.HasPrefix(cfg.URL, "https://") {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: !cfg.TLSVerify},
+		}
+	}
+	return &webhook{
+		cfg:        cfg,
+		client:     client,
+		queue:      queue,
+		backoff:    time.Duration(cfg.RetryBackoffMilliseconds) * time.Millisecond,
+		maxRetries: lo.FromPtr(cfg.MaxRetries),
+	}, nil
+}
+
+func (w *webhook) subscribe(eventType string) bool {
+	return len(w.cfg.Events) == 0 || slices.Contains(w.cfg.Events, eventType)
+}
+
+func (w *webhook) enqueue(ev *Event) error {
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if err := w.queue.push(buf); err != nil {
+		return err
+	}
+	w.enqueued.Add(1)
+	return nil
+}
+
+func (w *webhook) run(ctx context.Context) {
+	for {
+		seq, buf, ok := w.queue.peek(ctx)
+		if !ok {
+			return
+		}
+
+		delivered := false
+		for i := 0; i <= w.maxRetries; i++ {
+			if i > 0 {
+				w.retries.Add(1)
+				select {
+				case <-time.After(w.backoff << (i - 1)):
+				case <-ctx.Done():
+					return
+				}
+			}
+			err := w.deliver(ctx, buf)
+			if err == nil {
+				delivered = true
+				break
+			}
+			// frps 退出导致的失败不计入重试次数，事件留在队列中，下次启动后继续投递
+			if ctx.Err() != nil {
+				return
+			}
+			w.mu.Lock()
+			w.lastError = err.Error()
+			w.mu.Unlock()
+		}
+
+		if delivered {
+			w.delivered.Add(1)
+		} else {
+			w.failed.Add(1)
+			log.Warnf("webhook [%s] give up event after %d retries: %s", w.cfg.Name, w.maxRetries, w.status().LastError)
+		}
+		w.queue.remove(seq)
+	}
+}
+
+func (w *webhook) deliver(ctx context.Context, body []byte) error {
+	var ev Event
+	if err := json.Unmarshal(body, &ev); err != nil {
+		// 无法解析的事件不会因重试而成功，直接丢弃
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, ev.Type)
+	req.Header.Set(HeaderDelivery, ev.ID)
+	req.Header.Set(HeaderTimestamp, ts)
+	if w.cfg.Secret != "" {
+		req.Header.Set(HeaderSignature, "sha256="+Sign(w.cfg.Secret, ts, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (w *webhook) status() Status {
+	w.mu.Lock()
+	lastError := w.lastError
+	w.mu.Unlock()
+	return Status{
+		Name:        w.cfg.Name,
+		QueueLength: w.queue.len(),
+		Enqueued:    w.enqueued.Load(),
+		Delivered:   w.delivered.Load(),
+		Retries:     w.retries.Load(),
+		Failed:      w.failed.Load(),
+		Dropped:     w.queue.dropped.Load(),
+		LastError:   lastError,
+	}
+}
+
+// Sign 计算 webhook 请求的签名，接收方可以用相同的方法校验 X-Frp-Signature。
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,163 @@
+package webhook
+
+import (
+	"context"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDiskQueueReload(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newDiskQueue(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"a", "b", "c"} {
+		if err := q.push([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q.len() != 2 || q.dropped.Load() != 1 {
+		t.Fatalf("len %d dropped %d, want 2 and 1", q.len(), q.dropped.Load())
+	}
+
+	// 重新打开目录后，队列中的元素和顺序保持不变
+	q, err = newDiskQueue(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"b", "c"} {
+		seq, buf, ok := q.peek(context.Background())
+		if !ok || string(buf) != want {
+			t.Fatalf("peek got %q, want %q", buf, want)
+		}
+		q.remove(seq)
+	}
+	if err := q.push([]byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	if _, buf, _ := q.peek(context.Background()); string(buf) != "d" {
+		t.Fatalf("peek got %q, want d", buf)
+	}
+}
+
+func TestDiskQueuePeekCanceled(t *testing.T) {
+	q, err := newDiskQueue(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, ok := q.peek(ctx); ok {
+		t.Fatal("peek on empty queue should return false after ctx is done")
+	}
+}
+
+func newTestWebhook(t *testing.T, url string, maxRetries int) *webhook {
+	cfg := v1.WebhookConfig{
+		Name:       "test",
+		URL:        url,
+		Secret:     "secret",
+		QueueDir:   t.TempDir(),
+		MaxRetries: &maxRetries,
+	}
+	cfg.Complete()
+	cfg.RetryBackoffMilliseconds = 10
+	w, err := newWebhook(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestWebhookSignature(t *testing.T) {
+	received := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		ts := req.Header.Get(HeaderTimestamp)
+		received <- req.Header.Get(HeaderSignature) == "sha256="+Sign("secret", ts, body) &&
+			req.Header.Get(HeaderEvent) == EventProxyOpen
+	}))
+	defer srv.Close()
+
+	w := newTestWebhook(t, srv.URL, 0)
+	m := &Manager{webhooks: []*webhook{w}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.Run(ctx)
+	m.Notify(EventProxyOpen, &ProxyContent{User: "u", ProxyName: "p", ProxyType: "tcp"})
+
+	select {
+	case ok := <-received:
+		if !ok {
+			t.Fatal("invalid signature or event header")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered")
+	}
+}
+
+func TestWebhookMaxRetries(t *testing.T) {
+	for _, maxRetries := range []int{0, 2} {
+		requests := make(chan struct{}, 10)
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			requests <- struct{}{}
+			rw.WriteHeader(http.StatusInternalServerError)
+		}))
+
+		w := newTestWebhook(t, srv.URL, maxRetries)
+		if err := w.enqueue(&Event{ID: "1", Type: EventProxyClose}); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go w.run(ctx)
+
+		deadline := time.After(5 * time.Second)
+		for w.failed.Load() == 0 || w.queue.len() != 0 {
+			select {
+			case <-deadline:
+				t.Fatalf("maxRetries %d: event not given up", maxRetries)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		cancel()
+		srv.Close()
+
+		if n := len(requests); n != maxRetries+1 {
+			t.Errorf("maxRetries %d: got %d requests, want %d", maxRetries, n, maxRetries+1)
+		}
+	}
+}
+
+func TestWebhookKeepEventOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// 模拟投递过程中 frps 退出
+		_, _ = io.ReadAll(req.Body)
+		cancel()
+		<-req.Context().Done()
+	}))
+	defer srv.Close()
+
+	w := newTestWebhook(t, srv.URL, 0)
+	if err := w.enqueue(&Event{ID: "1", Type: EventProxyClose}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		w.run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run should return after ctx is done")
+	}
+	if w.queue.len() != 1 || w.failed.Load() != 0 {
+		t.Fatalf("queue len %d failed %d, want 1 and 0", w.queue.len(), w.failed.Load())
+	}
+}
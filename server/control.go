@@ -2,19 +2,31 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/samber/lo"
 	"github.com/sunyihoo/frp/pkg/auth"
 	v1 "github.com/sunyihoo/frp/pkg/config/v1"
 	"github.com/sunyihoo/frp/pkg/msg"
 	plugin "github.com/sunyihoo/frp/pkg/plugin/server"
 	"github.com/sunyihoo/frp/pkg/transport"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"github.com/sunyihoo/frp/pkg/util/util"
+	"github.com/sunyihoo/frp/pkg/util/version"
 	"github.com/sunyihoo/frp/pkg/util/xlog"
+	"github.com/sunyihoo/frp/pkg/webhook"
 	"github.com/sunyihoo/frp/server/controller"
+	"github.com/sunyihoo/frp/server/metrics"
 	"github.com/sunyihoo/frp/server/proxy"
 	"net"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+var errControlClosed = errors.New("control is closed")
+
 type ControlManager struct {
 	// 按运行 ID 编制索引的控件(controls)
 	ctlsByRunID map[string]*Control
@@ -28,6 +40,36 @@ func NewControlManager() *ControlManager {
 	}
 }
 
+// Add 添加控制器，返回被替换的具有相同运行 ID 的旧控制器。
+func (cm *ControlManager) Add(runID string, ctl *Control) (old *Control) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	var ok bool
+	old, ok = cm.ctlsByRunID[runID]
+	if ok {
+		old.Replaced(ctl)
+	}
+	cm.ctlsByRunID[runID] = ctl
+	return
+}
+
+// Del 仅在当前控制器仍为 ctl 时删除，避免删除已经替换它的新控制器。
+func (cm *ControlManager) Del(runID string, ctl *Control) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if c, ok := cm.ctlsByRunID[runID]; ok && c == ctl {
+		delete(cm.ctlsByRunID, runID)
+	}
+}
+
+func (cm *ControlManager) GetByID(runID string) (ctl *Control, ok bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	ctl, ok = cm.ctlsByRunID[runID]
+	return
+}
+
 type Control struct {
 	// 所有资源管理器和控制器
 	rc *controller.ResourceController
@@ -57,6 +99,9 @@ type Control struct {
 	// 工作连接
 	workConnCh chan net.Conn
 
+	// 此客户端的所有代理，按代理名称索引
+	proxies map[string]proxy.Proxy
+
 	// pool count
 	poolCount int
 
@@ -66,6 +111,9 @@ type Control struct {
 	// 上次收到 Ping 消息
 	lastPing atomic.Value
 
+	// 客户端登录时间
+	loginTime time.Time
+
 	// 当新的客户端登录时，将生成一个新的运行ID。
 	// 如果从登录消息获取的运行ID 具有相同的运行ID，则表示它是相同的客户端，
 	// 因此我们可以立即替换旧控制器。
@@ -80,3 +128,372 @@ type Control struct {
 	ctx    context.Context
 	doneCh chan struct{}
 }
+
+func NewControl(
+	ctx context.Context,
+	rc *controller.ResourceController,
+	pxyManager *proxy.Manager,
+	pluginManager *plugin.Manager,
+	authVerifier auth.Verifier,
+	ctlConn net.Conn,
+	loginMsg *msg.Login,
+	serverCfg *v1.ServerConfig,
+) *Control {
+	poolCount := loginMsg.PoolCount
+	if poolCount > int(serverCfg.Transport.MaxPoolCount) {
+		poolCount = int(serverCfg.Transport.MaxPoolCount)
+	}
+	ctl := &Control{
+		rc:            rc,
+		pxyManager:    pxyManager,
+		pluginManager: pluginManager,
+		authVerify:    authVerifier,
+		conn:          ctlConn,
+		loginMsg:      loginMsg,
+		workConnCh:    make(chan net.Conn, poolCount+10),
+		proxies:       make(map[string]proxy.Proxy),
+		poolCount:     poolCount,
+		runID:         loginMsg.RunID,
+		serverCfg:     serverCfg,
+		ctx:           ctx,
+		doneCh:        make(chan struct{}),
+	}
+	ctl.loginTime = time.Now()
+	ctl.lastPing.Store(ctl.loginTime)
+	ctl.msgDispatcher = msg.NewDispatcher(ctl.conn)
+	ctl.registerMsgHandlers()
+	return ctl
+}
+
+// Start 向客户端发送登录成功的消息并开始工作。
+func (ctl *Control) Start() {
+	loginRespMsg := &msg.LoginResp{
+		Version: version.Full(),
+		RunID:   ctl.runID,
+	}
+	_ = msg.WriteMsg(ctl.conn, loginRespMsg)
+
+	go func() {
+		for i := 0; i < ctl.poolCount; i++ {
+			// 忽略错误，出错表示控制器已经关闭
+			_ = ctl.msgDispatcher.Send(&msg.ReqWorkConn{})
+		}
+	}()
+	go ctl.worker()
+}
+
+func (ctl *Control) Close() error {
+	return ctl.conn.Close()
+}
+
+// Replaced 在具有相同运行 ID 的客户端重新登录时调用，关闭旧的控制连接。
+func (ctl *Control) Replaced(newCtl *Control) {
+	log.Infof("[%s] replaced by client [%s]", ctl.runID, newCtl.runID)
+	ctl.conn.Close()
+}
+
+func (ctl *Control) RegisterWorkConn(conn net.Conn) error {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("[%s] panic error: %v", ctl.runID, err)
+			log.Errorf(string(debug.Stack()))
+		}
+	}()
+
+	select {
+	case ctl.workConnCh <- conn:
+		log.Debugf("[%s] new work connection registered", ctl.runID)
+		return nil
+	default:
+		log.Debugf("[%s] work connection pool is full, discarding", ctl.runID)
+		return fmt.Errorf("work connection pool is full, discarding")
+	}
+}
+
+// GetWorkConn 从连接池中取出一个工作连接。连接池为空时请求客户端创建新的工作连接，
+// 在 userConnTimeout 内没有等到时返回错误。
+func (ctl *Control) GetWorkConn() (workConn net.Conn, err error) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("[%s] panic error: %v", ctl.runID, err)
+			log.Errorf(string(debug.Stack()))
+		}
+	}()
+
+	var ok bool
+	select {
+	case workConn, ok = <-ctl.workConnCh:
+		if !ok {
+			return nil, errControlClosed
+		}
+		log.Debugf("[%s] get work connection from pool", ctl.runID)
+	default:
+		if err := ctl.msgDispatcher.Send(&msg.ReqWorkConn{}); err != nil {
+			return nil, errControlClosed
+		}
+
+		select {
+		case workConn, ok = <-ctl.workConnCh:
+			if !ok {
+				return nil, errControlClosed
+			}
+		case <-time.After(time.Duration(ctl.serverCfg.UserConnTimeout) * time.Second):
+			err = fmt.Errorf("timeout trying to get work connection")
+			log.Warnf("[%s] %v", ctl.runID, err)
+			return
+		}
+	}
+
+	// 取出一个工作连接后请求客户端补充一个
+	_ = ctl.msgDispatcher.Send(&msg.ReqWorkConn{})
+	return
+}
+
+func (ctl *Control) heartbeatWorker() {
+	if ctl.serverCfg.Transport.HeartbeatTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if time.Since(ctl.lastPing.Load().(time.Time)) > time.Duration(ctl.serverCfg.Transport.HeartbeatTimeout)*time.Second {
+				log.Warnf("[%s] heartbeat timeout", ctl.runID)
+				ctl.conn.Close()
+				return
+			}
+		case <-ctl.doneCh:
+			return
+		}
+	}
+}
+
+// WaitClosed 阻塞直到控制器关闭。
+func (ctl *Control) WaitClosed() {
+	<-ctl.doneCh
+}
+
+func (ctl *Control) worker() {
+	go ctl.heartbeatWorker()
+	go ctl.msgDispatcher.Run()
+
+	<-ctl.msgDispatcher.Done()
+	ctl.conn.Close()
+
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+
+	close(ctl.workConnCh)
+	for workConn := range ctl.workConnCh {
+		workConn.Close()
+	}
+
+	for _, pxy := range ctl.proxies {
+		pxy.Close()
+		ctl.pxyManager.Del(pxy.GetName())
+		metrics.Server.CloseProxy(pxy.GetName(), pxy.GetConfigurer().GetBaseConfig().Type)
+	}
+
+	metrics.Server.CloseClient()
+	_ = ctl.pluginManager.CloseClient(&plugin.CloseClientContent{
+		User:      ctl.userInfo(),
+		LoginTime: ctl.loginTime.Unix(),
+		Duration:  int64(time.Since(ctl.loginTime) / time.Second),
+	})
+	if ctl.rc.WebhookManager != nil {
+		ctl.rc.WebhookManager.Notify(webhook.EventClientLogout, newClientContent(ctl.loginMsg, ctl.conn))
+	}
+	log.Infof("[%s] client exit success", ctl.loginMsg.RunID)
+	close(ctl.doneCh)
+}
+
+func newClientContent(loginMsg *msg.Login, ctlConn net.Conn) *webhook.ClientContent {
+	return &webhook.ClientContent{
+		User:       loginMsg.User,
+		RunID:      loginMsg.RunID,
+		Hostname:   loginMsg.Hostname,
+		Os:         loginMsg.Os,
+		Arch:       loginMsg.Arch,
+		Version:    loginMsg.Version,
+		RemoteAddr: ctlConn.RemoteAddr().String(),
+	}
+}
+
+func (ctl *Control) registerMsgHandlers() {
+	ctl.msgDispatcher.RegisterHandler(&msg.NewProxy{}, ctl.handleNewProxy)
+	ctl.msgDispatcher.RegisterHandler(&msg.Ping{}, ctl.handlePing)
+	ctl.msgDispatcher.RegisterHandler(&msg.CloseProxy{}, ctl.handleCloseProxy)
+	ctl.msgDispatcher.RegisterHandler(&msg.NatHoleVisitor{}, ctl.handleNatHoleVisitor)
+}
+
+func (ctl *Control) userInfo() plugin.UserInfo {
+	return plugin.UserInfo{
+		User:  ctl.loginMsg.User,
+		Metas: ctl.loginMsg.Metas,
+		RunID: ctl.runID,
+	}
+}
+
+func (ctl *Control) handleNewProxy(m msg.Message) {
+	inMsg := m.(*msg.NewProxy)
+
+	remoteAddr, err := ctl.RegisterProxy(inMsg)
+	resp := &msg.NewProxyResp{
+		ProxyName: inMsg.ProxyName,
+	}
+	if err != nil {
+		log.Warnf("[%s] new proxy [%s] type [%s] error: %v", ctl.runID, inMsg.ProxyName, inMsg.ProxyType, err)
+		resp.Error = util.GenerateResponseErrorString(fmt.Sprintf("new proxy [%s] error", inMsg.ProxyName),
+			err, lo.FromPtr(ctl.serverCfg.DetailedErrorsToClient))
+	} else {
+		resp.RemoteAddr = remoteAddr
+		log.Infof("[%s] new proxy [%s] type [%s] success", ctl.runID, inMsg.ProxyName, inMsg.ProxyType)
+		metrics.Server.NewProxy(inMsg.ProxyName, inMsg.ProxyType)
+	}
+	_ = ctl.msgDispatcher.Send(resp)
+}
+
+func (ctl *Control) handlePing(m msg.Message) {
+	inMsg := m.(*msg.Ping)
+
+	if err := ctl.authVerify.VerifyPing(inMsg); err != nil {
+		log.Warnf("[%s] received invalid ping: %v", ctl.runID, err)
+		if ctl.rc.WebhookManager != nil {
+			ctl.rc.WebhookManager.Notify(webhook.EventAuthFailure, &webhook.AuthFailureContent{
+				User:       ctl.loginMsg.User,
+				RunID:      ctl.runID,
+				RemoteAddr: ctl.conn.RemoteAddr().String(),
+				Reason:     err.Error(),
+			})
+		}
+		_ = ctl.msgDispatcher.Send(&msg.Pong{
+			Error: util.GenerateResponseErrorString("invalid ping", err, lo.FromPtr(ctl.serverCfg.DetailedErrorsToClient)),
+		})
+		return
+	}
+	ctl.lastPing.Store(time.Now())
+	log.Debugf("[%s] receive heartbeat", ctl.runID)
+	_ = ctl.msgDispatcher.Send(&msg.Pong{})
+}
+
+func (ctl *Control) handleCloseProxy(m msg.Message) {
+	inMsg := m.(*msg.CloseProxy)
+	ctl.CloseProxy(inMsg)
+	log.Infof("[%s] close proxy [%s] success", ctl.runID, inMsg.ProxyName)
+}
+
+// handleNatHoleVisitor 在 xtcp 访问者发起 NAT 打洞时先由插件审核，插件拒绝时将原因返回给访问者。
+func (ctl *Control) handleNatHoleVisitor(m msg.Message) {
+	inMsg := m.(*msg.NatHoleVisitor)
+
+	content := &plugin.NatHoleVisitorContent{
+		User:           ctl.userInfo(),
+		NatHoleVisitor: *inMsg,
+	}
+	retContent, err := ctl.pluginManager.NatHoleVisitor(content)
+	if err != nil {
+		log.Warnf("[%s] nat hole visitor for proxy [%s] rejected by plugin: %v", ctl.runID, inMsg.ProxyName, err)
+		_ = ctl.msgDispatcher.Send(&msg.NatHoleResp{
+			TransactionID: inMsg.TransactionID,
+			Error: util.GenerateResponseErrorString("nat hole visitor rejected", err,
+				lo.FromPtr(ctl.serverCfg.DetailedErrorsToClient)),
+		})
+		return
+	}
+	inMsg = &retContent.NatHoleVisitor
+
+	// nathole.Controller 还没有实现会话协调，插件放行后也无法建立 xtcp 会话
+	log.Warnf("[%s] nat hole visitor for proxy [%s] is not supported", ctl.runID, inMsg.ProxyName)
+	_ = ctl.msgDispatcher.Send(&msg.NatHoleResp{
+		TransactionID: inMsg.TransactionID,
+		Error:         "xtcp is not supported by this server",
+	})
+}
+
+// RegisterProxy 按 NewProxy 消息创建并启动代理，代理加入 proxy.Manager 后开始计入流量配额。
+func (ctl *Control) RegisterProxy(pxyMsg *msg.NewProxy) (remoteAddr string, err error) {
+	pxyConf, err := v1.NewProxyConfigurerFromMsg(pxyMsg)
+	if err != nil {
+		return
+	}
+
+	pxy, err := proxy.NewProxy(ctl.ctx, &proxy.Options{
+		UserInfo:           ctl.userInfo(),
+		LoginMsg:           ctl.loginMsg,
+		PoolCount:          ctl.poolCount,
+		ResourceController: ctl.rc,
+		GetWorkConnFn:      ctl.GetWorkConn,
+		Configurer:         pxyConf,
+		ProxyMsg:           pxyMsg,
+		ServerCfg:          ctl.serverCfg,
+	})
+	if err != nil {
+		return remoteAddr, err
+	}
+
+	// 检查每个客户端使用的端口数
+	if ctl.serverCfg.MaxPortsClient > 0 {
+		ctl.mu.Lock()
+		if ctl.portsUsedNum+pxy.GetUsePortsNum() > int(ctl.serverCfg.MaxPortsClient) {
+			ctl.mu.Unlock()
+			err = fmt.Errorf("exceed the max_ports_per_client")
+			return
+		}
+		ctl.portsUsedNum += pxy.GetUsePortsNum()
+		ctl.mu.Unlock()
+
+		defer func() {
+			if err != nil {
+				ctl.mu.Lock()
+				ctl.portsUsedNum -= pxy.GetUsePortsNum()
+				ctl.mu.Unlock()
+			}
+		}()
+	}
+
+	if ctl.pxyManager.Exist(pxyMsg.ProxyName) {
+		err = fmt.Errorf("proxy [%s] already exists", pxyMsg.ProxyName)
+		return
+	}
+
+	remoteAddr, err = pxy.Run()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			pxy.Close()
+		}
+	}()
+
+	err = ctl.pxyManager.Add(pxyMsg.ProxyName, pxy)
+	if err != nil {
+		return
+	}
+
+	ctl.mu.Lock()
+	ctl.proxies[pxy.GetName()] = pxy
+	ctl.mu.Unlock()
+	return
+}
+
+func (ctl *Control) CloseProxy(closeMsg *msg.CloseProxy) {
+	ctl.mu.Lock()
+	pxy, ok := ctl.proxies[closeMsg.ProxyName]
+	if !ok {
+		ctl.mu.Unlock()
+		return
+	}
+
+	if ctl.serverCfg.MaxPortsClient > 0 {
+		ctl.portsUsedNum -= pxy.GetUsePortsNum()
+	}
+	pxy.Close()
+	ctl.pxyManager.Del(pxy.GetName())
+	delete(ctl.proxies, closeMsg.ProxyName)
+	ctl.mu.Unlock()
+
+	metrics.Server.CloseProxy(pxy.GetName(), pxy.GetConfigurer().GetBaseConfig().Type)
+}
@@ -15,12 +15,17 @@
 package controller
 
 import (
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
 	"github.com/sunyihoo/frp/pkg/nathole"
 	plugin "github.com/sunyihoo/frp/pkg/plugin/server"
+	"github.com/sunyihoo/frp/pkg/util/geoip"
+	netpkg "github.com/sunyihoo/frp/pkg/util/net"
 	"github.com/sunyihoo/frp/pkg/util/tcpmux"
 	"github.com/sunyihoo/frp/pkg/util/vhost"
+	"github.com/sunyihoo/frp/pkg/webhook"
 	"github.com/sunyihoo/frp/server/group"
 	"github.com/sunyihoo/frp/server/ports"
+	"github.com/sunyihoo/frp/server/quota"
 	"github.com/sunyihoo/frp/server/visitor"
 )
 
@@ -38,6 +43,9 @@ type ResourceController struct {
 	// TCP 多路复用器组控制器
 	TCPMuxGroupCtl *group.TCPMuxGroupCtl
 
+	// UDP 组控制器
+	UDPGroupCtl *group.UDPGroupCtl
+
 	// 管理所有 TCP 端口
 	TCPPortManager *ports.Manager
 
@@ -50,12 +58,51 @@ type ResourceController struct {
 	// 对于 HTTPS 代理，按主机名和其他信息将请求路由到不同的客户端
 	VhostHTTPSMuxer *vhost.HTTPMuxer
 
+	// 为 https 代理在 frps 上终止 TLS，未启用时为 nil
+	TLSTerminator *vhost.TLSTerminator
+
+	// 为 vhost 域名自动申请证书，vhostHTTPPort 上的 HTTP 服务需要用它处理 HTTP-01 验证，未启用时为 nil
+	ACMEManager *vhost.ACMEManager
+
 	// 用于连接nat hole的控制器
 	NatHoleController *nathole.Controller
 
 	// 利用 HTTP CONNECT 方法在一个 TCP 连接上多路复用多个流 todo ?
 	TCPMuxController *tcpmux.HTTPConnectTCPMuxer
 
+	// 按 SNI 在一个端口上多路复用 TLS 连接，未启用时为 nil
+	TCPMuxSNIController *tcpmux.SNITCPMuxer
+
+	// 按 SOCKS5 CONNECT 请求的目标主机名在一个端口上多路复用连接，未启用时为 nil
+	TCPMuxSOCKS5Controller *tcpmux.SOCKS5TCPMuxer
+
 	// 所有服务端管理者插件
 	PluginManager *plugin.Manager
+
+	// 按用户和按代理统计流量配额，新的用户连接需要先经过它的检查
+	QuotaManager *quota.Manager
+
+	// 将客户端登录、代理启停等事件异步投递给 webhook
+	WebhookManager *webhook.Manager
+
+	// 所有代理共用的来源 IP 限制，先于代理自身的列表检查，未配置时为 nil
+	ProxySourceIPACL *netpkg.IPACL
+
+	// 按来源 IP 查询国家代码，未配置 geoIP.dbFile 时为 nil
+	GeoIPDatabase *geoip.Database
+
+	// 所有代理共用的来源国家限制，先于代理自身的列表检查，未配置时为 nil
+	ProxyCountryACL *geoip.CountryACL
+
+	// frps 按域名配置的 vhost HTTP 请求限速，是代理请求限速的上限
+	VhostHTTPRateLimits []v1.VhostHTTPRateLimitConfig
+
+	// http 代理 OIDC 登录使用的身份提供方，未配置 vhostHTTPOIDC 时为 nil
+	VhostHTTPOIDCProvider *vhost.OIDCProvider
+
+	// http 代理可以引用的 htpasswd 用户列表，键为 htpasswd.files 中的名称
+	HTPasswdFiles map[string]*netpkg.Htpasswd
+
+	// vhost HTTP 请求的错误页面，http 代理按名称引用其中的模板组
+	VhostHTTPErrorPages *vhost.ErrorPageRegistry
 }
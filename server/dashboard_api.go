@@ -0,0 +1,244 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	plugin "github.com/sunyihoo/frp/pkg/plugin/server"
+	httppkg "github.com/sunyihoo/frp/pkg/util/http"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"github.com/sunyihoo/frp/pkg/webhook"
+	"github.com/sunyihoo/frp/server/group"
+	"github.com/sunyihoo/frp/server/quota"
+	"net/http"
+	"sort"
+)
+
+type GeneralResponse struct {
+	Code int
+	Msg  string
+}
+
+func (svr *Service) registerRouteHandlers(helper *httppkg.RouterRegisterHelper) {
+	subRouter := helper.Router.NewRoute().Subrouter()
+	subRouter.Use(helper.AuthMiddleware)
+
+	// 流量配额
+	subRouter.HandleFunc("/api/quota", svr.apiQuota).Methods("GET")
+	subRouter.HandleFunc("/api/quota/{scope}/{name}", svr.apiQuotaByName).Methods("GET")
+
+	// 服务端插件
+	subRouter.HandleFunc("/api/plugins", svr.apiPlugins).Methods("GET")
+
+	// 事件 webhook
+	subRouter.HandleFunc("/api/webhooks", svr.apiWebhooks).Methods("GET")
+
+	// 负载均衡组
+	subRouter.HandleFunc("/api/groups/http", svr.apiHTTPGroups).Methods("GET")
+	subRouter.HandleFunc("/api/groups/tcp", svr.apiTCPGroups).Methods("GET")
+	subRouter.HandleFunc("/api/groups/tcpmux", svr.apiTCPMuxGroups).Methods("GET")
+	subRouter.HandleFunc("/api/groups/failover", svr.apiFailoverGroups).Methods("GET")
+
+	// vhost HTTP 维护模式
+	subRouter.HandleFunc("/api/maintenance", svr.apiMaintenance).Methods("GET")
+	subRouter.HandleFunc("/api/maintenance/{domain}", svr.apiSetMaintenance).Methods("PUT")
+	subRouter.HandleFunc("/api/maintenance/{domain}", svr.apiClearMaintenance).Methods("DELETE")
+}
+
+func writeGeneralResponse(w http.ResponseWriter, r *http.Request, res *GeneralResponse) {
+	log.Infof("http response [%s]: code [%d]", r.URL.Path, res.Code)
+	w.WriteHeader(res.Code)
+	if len(res.Msg) > 0 {
+		_, _ = w.Write([]byte(res.Msg))
+	}
+}
+
+type QuotaStatusResp struct {
+	Quotas []quota.Status `json:"quotas"`
+}
+
+type PluginsResp struct {
+	Plugins []plugin.PluginStatus `json:"plugins"`
+}
+
+// /api/plugins
+func (svr *Service) apiPlugins(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	defer writeGeneralResponse(w, r, &res)
+	log.Infof("http request: [%s]", r.URL.Path)
+
+	buf, _ := json.Marshal(&PluginsResp{Plugins: svr.pluginManager.GetStatus()})
+	res.Msg = string(buf)
+}
+
+type WebhooksResp struct {
+	Webhooks []webhook.Status `json:"webhooks"`
+}
+
+// /api/webhooks
+func (svr *Service) apiWebhooks(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	defer writeGeneralResponse(w, r, &res)
+	log.Infof("http request: [%s]", r.URL.Path)
+
+	buf, _ := json.Marshal(&WebhooksResp{Webhooks: svr.webhookManager.GetStatus()})
+	res.Msg = string(buf)
+}
+
+type HTTPGroupsResp struct {
+	Groups []group.HTTPGroupStatus `json:"groups"`
+}
+
+// /api/groups/http
+func (svr *Service) apiHTTPGroups(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	defer writeGeneralResponse(w, r, &res)
+	log.Infof("http request: [%s]", r.URL.Path)
+
+	buf, _ := json.Marshal(&HTTPGroupsResp{Groups: svr.rc.HTTPGroupCtl.GetStatus()})
+	res.Msg = string(buf)
+}
+
+type GroupsResp struct {
+	Groups []group.GroupStatus `json:"groups"`
+}
+
+// /api/groups/tcp
+func (svr *Service) apiTCPGroups(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	defer writeGeneralResponse(w, r, &res)
+	log.Infof("http request: [%s]", r.URL.Path)
+
+	buf, _ := json.Marshal(&GroupsResp{Groups: svr.rc.TCPGroupCtl.GetStatus()})
+	res.Msg = string(buf)
+}
+
+// /api/groups/tcpmux
+func (svr *Service) apiTCPMuxGroups(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	defer writeGeneralResponse(w, r, &res)
+	log.Infof("http request: [%s]", r.URL.Path)
+
+	buf, _ := json.Marshal(&GroupsResp{Groups: svr.rc.TCPMuxGroupCtl.GetStatus()})
+	res.Msg = string(buf)
+}
+
+type FailoverGroupsResp struct {
+	Groups []group.FailoverGroupStatus `json:"groups"`
+	// 最近的切换事件，按时间先后排列
+	Events []group.FailoverEvent `json:"events"`
+}
+
+// /api/groups/failover
+func (svr *Service) apiFailoverGroups(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	defer writeGeneralResponse(w, r, &res)
+	log.Infof("http request: [%s]", r.URL.Path)
+
+	groups, events := svr.failoverManager.GetStatus()
+	buf, _ := json.Marshal(&FailoverGroupsResp{Groups: groups, Events: events})
+	res.Msg = string(buf)
+}
+
+// /api/quota
+func (svr *Service) apiQuota(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	defer writeGeneralResponse(w, r, &res)
+	log.Infof("http request: [%s]", r.URL.Path)
+
+	buf, _ := json.Marshal(&QuotaStatusResp{Quotas: svr.quotaManager.GetStatus()})
+	res.Msg = string(buf)
+}
+
+// /api/quota/:scope/:name
+func (svr *Service) apiQuotaByName(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	defer writeGeneralResponse(w, r, &res)
+	params := mux.Vars(r)
+	scope, name := params["scope"], params["name"]
+	log.Infof("http request: [%s]", r.URL.Path)
+
+	if scope != quota.ScopeUser && scope != quota.ScopeProxy {
+		res.Code = 400
+		res.Msg = "scope should be user or proxy"
+		return
+	}
+	buf, _ := json.Marshal(svr.quotaManager.GetStatusByName(scope, name))
+	res.Msg = string(buf)
+}
+
+type MaintenanceDomain struct {
+	Domain string `json:"domain"`
+	// ErrorPages 是维护期间使用的错误页面模板组，为空时按代理和域名选择
+	ErrorPages string `json:"errorPages,omitempty"`
+}
+
+type MaintenanceResp struct {
+	Domains []MaintenanceDomain `json:"domains"`
+}
+
+type SetMaintenanceReq struct {
+	ErrorPages string `json:"errorPages,omitempty"`
+}
+
+// /api/maintenance
+func (svr *Service) apiMaintenance(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	defer writeGeneralResponse(w, r, &res)
+	log.Infof("http request: [%s]", r.URL.Path)
+
+	resp := MaintenanceResp{Domains: make([]MaintenanceDomain, 0)}
+	if svr.rc.HTTPReverseProxy != nil {
+		for domain, pages := range svr.rc.HTTPReverseProxy.GetMaintenance() {
+			resp.Domains = append(resp.Domains, MaintenanceDomain{Domain: domain, ErrorPages: pages})
+		}
+	}
+	sort.Slice(resp.Domains, func(i, j int) bool {
+		return resp.Domains[i].Domain < resp.Domains[j].Domain
+	})
+	buf, _ := json.Marshal(&resp)
+	res.Msg = string(buf)
+}
+
+// PUT /api/maintenance/:domain
+func (svr *Service) apiSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	defer writeGeneralResponse(w, r, &res)
+	domain := mux.Vars(r)["domain"]
+	log.Infof("http request: [%s]", r.URL.Path)
+
+	if svr.rc.HTTPReverseProxy == nil {
+		res.Code = 400
+		res.Msg = "vhostHTTPPort is not enabled"
+		return
+	}
+	var req SetMaintenanceReq
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			res.Code = 400
+			res.Msg = fmt.Sprintf("invalid request body: %v", err)
+			return
+		}
+	}
+	if err := svr.rc.HTTPReverseProxy.SetMaintenance(domain, req.ErrorPages); err != nil {
+		res.Code = 400
+		res.Msg = err.Error()
+		return
+	}
+	log.Infof("domain [%s] enters maintenance mode", domain)
+}
+
+// DELETE /api/maintenance/:domain
+func (svr *Service) apiClearMaintenance(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	defer writeGeneralResponse(w, r, &res)
+	domain := mux.Vars(r)["domain"]
+	log.Infof("http request: [%s]", r.URL.Path)
+
+	if svr.rc.HTTPReverseProxy == nil || !svr.rc.HTTPReverseProxy.ClearMaintenance(domain) {
+		res.Code = 404
+		res.Msg = "domain is not in maintenance mode"
+		return
+	}
+	log.Infof("domain [%s] leaves maintenance mode", domain)
+}
@@ -0,0 +1,310 @@
+package group
+
+import (
+	"fmt"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"hash/crc32"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 一致性哈希环上每个成员的虚拟节点数，越多分布越均匀
+const hashRingReplicas = 160
+
+// GroupStatus 是 TCP 或 TCPMux 组的状态。
+type GroupStatus struct {
+	Name      string              `json:"name"`
+	Algorithm string              `json:"algorithm"`
+	Members   []GroupMemberStatus `json:"members"`
+}
+
+// GroupMemberStatus 是组成员的状态，HealthCheck 为 false 表示未启用服务端健康检查，此时 Healthy 总是为 true。
+type GroupMemberStatus struct {
+	Name        string `json:"name"`
+	Priority    int    `json:"priority"`
+	ActiveConns int64  `json:"activeConns"`
+	HealthCheck bool   `json:"healthCheck"`
+	Healthy     bool   `json:"healthy"`
+	HealthError string `json:"healthError,omitempty"`
+}
+
+// groupMember 是组中的一个代理，组按负载均衡算法将接受的连接交给它。
+type groupMember struct {
+	name string
+	// 主备模式下的优先级，值越大越优先
+	priority int
+	connCh   chan net.Conn
+	closeCh  chan struct{}
+	// health 为 nil 表示未启用服务端健康检查
+	health *healthChecker
+
+	activeConns atomic.Int64
+}
+
+func newGroupMember(name string, priority int, closeCh chan struct{}) *groupMember {
+	return &groupMember{
+		name:     name,
+		priority: priority,
+		connCh:   make(chan net.Conn),
+		closeCh:  closeCh,
+	}
+}
+
+// memberConn 在关闭时减少成员的活动连接数。
+type memberConn struct {
+	net.Conn
+
+	member *groupMember
+	once   sync.Once
+}
+
+func (c *memberConn) Close() error {
+	c.once.Do(func() {
+		c.member.activeConns.Add(-1)
+	})
+	return c.Conn.Close()
+}
+
+type hashRingNode struct {
+	hash   uint32
+	member *groupMember
+}
+
+// balancer 按负载均衡算法在组成员之间分配连接。
+type balancer struct {
+	algorithm string
+
+	// members 按加入顺序排列
+	members []*groupMember
+	// ring 是按哈希值排序的一致性哈希环，仅用于 sourceIPHash
+	ring  []hashRingNode
+	index uint64
+	// failover 仅用于主备模式
+	failover *failover
+	mu       sync.RWMutex
+}
+
+func algorithmOrDefault(algorithm string) string {
+	if algorithm == "" {
+		return v1.LoadBalanceAlgorithmRoundRobin
+	}
+	return algorithm
+}
+
+func newBalancer(algorithm string) (*balancer, error) {
+	algorithm = algorithmOrDefault(algorithm)
+	switch algorithm {
+	case v1.LoadBalanceAlgorithmRoundRobin, v1.LoadBalanceAlgorithmLeastConn, v1.LoadBalanceAlgorithmSourceIPHash,
+		v1.LoadBalanceAlgorithmFailover:
+	default:
+		return nil, fmt.Errorf("unsupported load balance algorithm [%s]", algorithm)
+	}
+	return &balancer{
+		algorithm: algorithm,
+		members:   make([]*groupMember, 0),
+	}, nil
+}
+
+// enableFailover 在主备模式下调用，必须在添加成员之前调用。
+func (b *balancer) enableFailover(groupType, group string, failbackDelay time.Duration, manager *FailoverManager) {
+	b.failover = newFailover(groupType, group, failbackDelay, manager, b.refresh)
+}
+
+// refresh 在成员变化或到达 failback 时间时重新选择主备模式的活动成员。
+func (b *balancer) refresh() {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	b.chooseActive()
+}
+
+// chooseActive 调用方需要持有锁。
+func (b *balancer) chooseActive() *groupMember {
+	candidates := make([]failoverCandidate, 0, len(b.members))
+	for _, m := range b.members {
+		candidates = append(candidates, failoverCandidate{
+			name:     m.name,
+			priority: m.priority,
+			live:     m.health.isHealthy(),
+		})
+	}
+	active := b.failover.choose(candidates)
+	for _, m := range b.members {
+		if m.name == active {
+			return m
+		}
+	}
+	return nil
+}
+
+// close 在组中最后一个成员离开后调用。
+func (b *balancer) close() {
+	if b.failover != nil {
+		b.failover.close()
+	}
+}
+
+func (b *balancer) add(m *groupMember) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.members = append(b.members, m)
+	if b.algorithm == v1.LoadBalanceAlgorithmSourceIPHash {
+		for i := 0; i < hashRingReplicas; i++ {
+			b.ring = append(b.ring, hashRingNode{
+				hash:   crc32.ChecksumIEEE([]byte(m.name + "#" + strconv.Itoa(i))),
+				member: m,
+			})
+		}
+		sort.Slice(b.ring, func(i, j int) bool {
+			return b.ring[i].hash < b.ring[j].hash
+		})
+	}
+	if b.failover != nil {
+		b.chooseActive()
+	}
+}
+
+// remove 可以重复调用。
+func (b *balancer) remove(m *groupMember) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, tmp := range b.members {
+		if tmp == m {
+			b.members = append(b.members[:i], b.members[i+1:]...)
+			break
+		}
+	}
+	if len(b.ring) > 0 {
+		ring := b.ring[:0]
+		for _, node := range b.ring {
+			if node.member != m {
+				ring = append(ring, node)
+			}
+		}
+		b.ring = ring
+	}
+	if b.failover != nil {
+		b.chooseActive()
+	}
+}
+
+// healthyMembers 返回健康检查通过的成员，所有成员都不健康时返回全部成员，调用方需要持有锁。
+func (b *balancer) healthyMembers() []*groupMember {
+	healthy := make([]*groupMember, 0, len(b.members))
+	for _, m := range b.members {
+		if m.health.isHealthy() {
+			healthy = append(healthy, m)
+		}
+	}
+	if len(healthy) == 0 {
+		return b.members
+	}
+	return healthy
+}
+
+func (b *balancer) pick(srcAddr net.Addr) *groupMember {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.members) == 0 {
+		return nil
+	}
+
+	switch b.algorithm {
+	case v1.LoadBalanceAlgorithmFailover:
+		return b.chooseActive()
+	case v1.LoadBalanceAlgorithmLeastConn:
+		members := b.healthyMembers()
+		// 活动连接数相同时从不同的成员开始查找，避免总是选择第一个
+		start := int(atomic.AddUint64(&b.index, 1) % uint64(len(members)))
+		var best *groupMember
+		for i := 0; i < len(members); i++ {
+			m := members[(start+i)%len(members)]
+			if best == nil || m.activeConns.Load() < best.activeConns.Load() {
+				best = m
+			}
+		}
+		return best
+	case v1.LoadBalanceAlgorithmSourceIPHash:
+		h := crc32.ChecksumIEEE([]byte(sourceIP(srcAddr)))
+		i := sort.Search(len(b.ring), func(i int) bool {
+			return b.ring[i].hash >= h
+		})
+		// 沿哈希环跳过不健康的成员，这样只有不健康成员的来源 IP 会改变代理
+		for j := 0; j < len(b.ring); j++ {
+			node := b.ring[(i+j)%len(b.ring)]
+			if node.member.health.isHealthy() {
+				return node.member
+			}
+		}
+		return b.ring[i%len(b.ring)].member
+	default:
+		members := b.healthyMembers()
+		return members[atomic.AddUint64(&b.index, 1)%uint64(len(members))]
+	}
+}
+
+// enableHealthCheck 为成员启用服务端健康检查，未配置健康检查时不做任何事情。
+// 成员离开组时需要调用 m.health.stop()。
+func (b *balancer) enableHealthCheck(m *groupMember, cfg v1.HealthCheckConfig, dial DialFunc) {
+	m.health = newHealthChecker(m.name, cfg, dial, b.refreshHealth)
+	m.health.start()
+}
+
+// status 返回成员的状态，按加入顺序排列。
+func (b *balancer) status() []GroupMemberStatus {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	res := make([]GroupMemberStatus, 0, len(b.members))
+	for _, m := range b.members {
+		enabled, healthy, lastError := m.health.status()
+		res = append(res, GroupMemberStatus{
+			Name:        m.name,
+			Priority:    m.priority,
+			ActiveConns: m.activeConns.Load(),
+			HealthCheck: enabled,
+			Healthy:     healthy,
+			HealthError: lastError,
+		})
+	}
+	return res
+}
+
+// refreshHealth 在成员的健康状态变化时调用。
+func (b *balancer) refreshHealth() {
+	if b.failover != nil {
+		b.refresh()
+	}
+}
+
+// dispatch 将连接交给选中的成员，成员已经关闭时重新选择，没有成员时关闭连接。
+func (b *balancer) dispatch(c net.Conn) {
+	for {
+		m := b.pick(c.RemoteAddr())
+		if m == nil {
+			c.Close()
+			return
+		}
+		m.activeConns.Add(1)
+		select {
+		case m.connCh <- &memberConn{Conn: c, member: m}:
+			return
+		case <-m.closeCh:
+			m.activeConns.Add(-1)
+			b.remove(m)
+		}
+	}
+}
+
+func sourceIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,150 @@
+package group
+
+import (
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"net"
+	"testing"
+)
+
+func newTestBalancer(t *testing.T, algorithm string, names ...string) (*balancer, map[string]*groupMember) {
+	b, err := newBalancer(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	members := make(map[string]*groupMember)
+	for _, name := range names {
+		m := newGroupMember(name, 0, make(chan struct{}))
+		b.add(m)
+		members[name] = m
+	}
+	return b, members
+}
+
+func testSourceAddr(i int) net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 10000 + i%1000}
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	b, _ := newTestBalancer(t, "", "a", "b", "c")
+	counts := make(map[string]int)
+	prev := ""
+	for i := 0; i < 300; i++ {
+		name := b.pick(testSourceAddr(i)).name
+		if name == prev {
+			t.Fatalf("pick %d: %s picked twice in a row", i, name)
+		}
+		prev = name
+		counts[name]++
+	}
+	for name, n := range counts {
+		if n != 100 {
+			t.Errorf("member %s: got %d connections, want 100", name, n)
+		}
+	}
+}
+
+func TestBalancerLeastConn(t *testing.T) {
+	b, members := newTestBalancer(t, v1.LoadBalanceAlgorithmLeastConn, "a", "b", "c")
+	members["a"].activeConns.Store(3)
+	members["b"].activeConns.Store(1)
+	members["c"].activeConns.Store(2)
+	if got := b.pick(nil).name; got != "b" {
+		t.Fatalf("got %s, want b", got)
+	}
+
+	// 活动连接数相同时轮流选择，而不是总是选择第一个
+	members["c"].activeConns.Store(1)
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		seen[b.pick(nil).name] = true
+	}
+	if len(seen) != 2 || !seen["b"] || !seen["c"] {
+		t.Fatalf("got %v, want b and c", seen)
+	}
+
+	// 按分配的连接模拟负载，各成员的连接数保持均衡
+	members["a"].activeConns.Store(0)
+	members["b"].activeConns.Store(0)
+	members["c"].activeConns.Store(0)
+	for i := 0; i < 30; i++ {
+		b.pick(nil).activeConns.Add(1)
+	}
+	for name, m := range members {
+		if n := m.activeConns.Load(); n != 10 {
+			t.Errorf("member %s: got %d connections, want 10", name, n)
+		}
+	}
+}
+
+func TestBalancerSourceIPHash(t *testing.T) {
+	const numAddrs = 2000
+	b, members := newTestBalancer(t, v1.LoadBalanceAlgorithmSourceIPHash, "a", "b", "c", "d")
+
+	assign := func() map[int]string {
+		res := make(map[int]string, numAddrs)
+		for i := 0; i < numAddrs; i++ {
+			res[i] = b.pick(testSourceAddr(i)).name
+		}
+		return res
+	}
+
+	before := assign()
+	counts := make(map[string]int)
+	for i, name := range before {
+		counts[name]++
+		// 同一个来源 IP 的不同端口总是发往同一个成员
+		addr := testSourceAddr(i).(*net.TCPAddr)
+		addr.Port++
+		if got := b.pick(addr).name; got != name {
+			t.Fatalf("addr %s: got %s, want %s", addr, got, name)
+		}
+	}
+	for name, n := range counts {
+		if n < numAddrs/4/2 {
+			t.Errorf("member %s: got %d of %d source IPs, distribution is too uneven", name, n, numAddrs)
+		}
+	}
+
+	// 新成员加入时，只有分配给新成员的来源 IP 改变代理
+	b.add(newGroupMember("e", 0, make(chan struct{})))
+	afterJoin := assign()
+	moved := 0
+	for i, name := range afterJoin {
+		if name != before[i] {
+			if name != "e" {
+				t.Fatalf("source %d moved from %s to %s, want e", i, before[i], name)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > numAddrs*2/5 {
+		t.Errorf("%d of %d source IPs moved after join, want about 1/5", moved, numAddrs)
+	}
+
+	// 成员离开时，只有原来分配给它的来源 IP 改变代理
+	b.remove(members["b"])
+	afterLeave := assign()
+	for i, name := range afterLeave {
+		if afterJoin[i] != "b" && name != afterJoin[i] {
+			t.Fatalf("source %d moved from %s to %s after b left", i, afterJoin[i], name)
+		}
+		if name == "b" {
+			t.Fatalf("source %d still assigned to removed member b", i)
+		}
+	}
+}
+
+func TestBalancerEmpty(t *testing.T) {
+	for _, algorithm := range []string{
+		v1.LoadBalanceAlgorithmRoundRobin, v1.LoadBalanceAlgorithmLeastConn, v1.LoadBalanceAlgorithmSourceIPHash,
+	} {
+		b, members := newTestBalancer(t, algorithm, "a")
+		b.remove(members["a"])
+		if m := b.pick(testSourceAddr(1)); m != nil {
+			t.Errorf("%s: got %s from empty balancer", algorithm, m.name)
+		}
+	}
+	if _, err := newBalancer("random"); err == nil {
+		t.Error("unsupported algorithm should return error")
+	}
+}
@@ -0,0 +1,269 @@
+package group
+
+import (
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	GroupTypeTCP    = "tcp"
+	GroupTypeTCPMux = "tcpmux"
+	GroupTypeHTTP   = "http"
+
+	FailoverReasonInitial    = "initial"
+	FailoverReasonMemberDown = "memberDown"
+	FailoverReasonFailback   = "failback"
+
+	// 保留的最近切换事件数
+	maxFailoverEvents = 100
+)
+
+// FailoverEvent 是主备组切换活动成员的事件，To 为空表示组内已经没有可用的成员。
+type FailoverEvent struct {
+	Type   string    `json:"type"`
+	Group  string    `json:"group"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+type FailoverMemberStatus struct {
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+	Live     bool   `json:"live"`
+}
+
+type FailoverGroupStatus struct {
+	Type   string `json:"type"`
+	Group  string `json:"group"`
+	Active string `json:"active"`
+	// FailbackAt 不为空时，更高优先级的成员已经恢复，将在该时间切换回去
+	FailbackAt *time.Time             `json:"failbackAt,omitempty"`
+	Members    []FailoverMemberStatus `json:"members"`
+}
+
+// FailoverManager 记录所有主备组的状态和最近的切换事件，并将切换事件交给 handler。
+type FailoverManager struct {
+	handler func(FailoverEvent)
+	groups  map[*failover]struct{}
+	events  []FailoverEvent
+	mu      sync.Mutex
+}
+
+func NewFailoverManager() *FailoverManager {
+	return &FailoverManager{
+		groups: make(map[*failover]struct{}),
+		events: make([]FailoverEvent, 0),
+	}
+}
+
+// SetEventHandler 设置切换事件的处理函数，它在切换发生的 goroutine 中异步调用。
+func (m *FailoverManager) SetEventHandler(handler func(FailoverEvent)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handler = handler
+}
+
+func (m *FailoverManager) add(f *failover) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.groups[f] = struct{}{}
+}
+
+func (m *FailoverManager) remove(f *failover) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.groups, f)
+}
+
+func (m *FailoverManager) notify(ev FailoverEvent) {
+	log.Infof("%s group [%s] failover from [%s] to [%s], reason: %s", ev.Type, ev.Group, ev.From, ev.To, ev.Reason)
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.events = append(m.events, ev)
+	if len(m.events) > maxFailoverEvents {
+		m.events = m.events[len(m.events)-maxFailoverEvents:]
+	}
+	handler := m.handler
+	m.mu.Unlock()
+
+	if handler != nil {
+		go handler(ev)
+	}
+}
+
+// GetStatus 返回所有主备组的状态，按类型和组名称排序，以及最近的切换事件。
+func (m *FailoverManager) GetStatus() ([]FailoverGroupStatus, []FailoverEvent) {
+	m.mu.Lock()
+	groups := make([]*failover, 0, len(m.groups))
+	for f := range m.groups {
+		groups = append(groups, f)
+	}
+	events := make([]FailoverEvent, len(m.events))
+	copy(events, m.events)
+	m.mu.Unlock()
+
+	res := make([]FailoverGroupStatus, 0, len(groups))
+	for _, f := range groups {
+		res = append(res, f.status())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Type != res[j].Type {
+			return res[i].Type < res[j].Type
+		}
+		return res[i].Group < res[j].Group
+	})
+	return res, events
+}
+
+type failoverCandidate struct {
+	name     string
+	priority int
+	live     bool
+}
+
+// failover 让所有流量都发往优先级最高的存活成员，活动成员不可用时切换到下一个，
+// 更高优先级的成员恢复后，在 failbackDelay 之后切换回去，failbackDelay 小于 0 时不自动切换回去。
+type failover struct {
+	groupType     string
+	group         string
+	failbackDelay time.Duration
+	manager       *FailoverManager
+	// 到达 failback 时间时由定时器调用，重新选择活动成员
+	refresh func()
+
+	active     string
+	failbackAt time.Time
+	timer      *time.Timer
+	candidates []failoverCandidate
+	mu         sync.Mutex
+}
+
+func newFailover(groupType, group string, failbackDelay time.Duration, manager *FailoverManager, refresh func()) *failover {
+	f := &failover{
+		groupType:     groupType,
+		group:         group,
+		failbackDelay: failbackDelay,
+		manager:       manager,
+		refresh:       refresh,
+	}
+	manager.add(f)
+	return f
+}
+
+// choose 根据成员当前的状态返回活动成员的名称，没有存活的成员时返回空字符串。
+// candidates 按加入顺序排列，优先级相同时选择先加入的成员。
+func (f *failover) choose(candidates []failoverCandidate) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.candidates = candidates
+
+	var best, cur *failoverCandidate
+	for i := range candidates {
+		c := &candidates[i]
+		if !c.live {
+			continue
+		}
+		if best == nil || c.priority > best.priority {
+			best = c
+		}
+		if c.name == f.active {
+			cur = c
+		}
+	}
+
+	switch {
+	case best == nil:
+		f.switchTo("", FailoverReasonMemberDown)
+	case cur == nil:
+		reason := FailoverReasonMemberDown
+		if f.active == "" {
+			reason = FailoverReasonInitial
+		}
+		f.switchTo(best.name, reason)
+	case best.priority > cur.priority && f.failbackDelay >= 0:
+		now := time.Now()
+		if f.failbackDelay == 0 || (!f.failbackAt.IsZero() && !now.Before(f.failbackAt)) {
+			f.switchTo(best.name, FailoverReasonFailback)
+		} else if f.failbackAt.IsZero() {
+			f.failbackAt = now.Add(f.failbackDelay)
+			if f.refresh != nil {
+				f.timer = time.AfterFunc(f.failbackDelay, f.refresh)
+			}
+		}
+	default:
+		f.cancelFailback()
+	}
+	return f.active
+}
+
+// switchTo 调用方需要持有锁。
+func (f *failover) switchTo(name string, reason string) {
+	f.cancelFailback()
+	if name == f.active {
+		return
+	}
+	from := f.active
+	f.active = name
+	if from == "" && reason == FailoverReasonMemberDown {
+		return
+	}
+	f.manager.notify(FailoverEvent{
+		Type:   f.groupType,
+		Group:  f.group,
+		From:   from,
+		To:     name,
+		Reason: reason,
+		Time:   time.Now(),
+	})
+}
+
+func (f *failover) cancelFailback() {
+	f.failbackAt = time.Time{}
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+}
+
+// close 在组中最后一个成员离开后调用。
+func (f *failover) close() {
+	f.mu.Lock()
+	f.cancelFailback()
+	f.mu.Unlock()
+	f.manager.remove(f)
+}
+
+func (f *failover) status() FailoverGroupStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := FailoverGroupStatus{
+		Type:    f.groupType,
+		Group:   f.group,
+		Active:  f.active,
+		Members: make([]FailoverMemberStatus, 0, len(f.candidates)),
+	}
+	if !f.failbackAt.IsZero() {
+		failbackAt := f.failbackAt
+		s.FailbackAt = &failbackAt
+	}
+	for _, c := range f.candidates {
+		s.Members = append(s.Members, FailoverMemberStatus{
+			Name:     c.name,
+			Priority: c.priority,
+			Live:     c.live,
+		})
+	}
+	return s
+}
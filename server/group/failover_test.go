@@ -0,0 +1,157 @@
+package group
+
+import (
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"testing"
+	"time"
+)
+
+type testFailoverMember struct {
+	name     string
+	priority int
+}
+
+func newTestFailoverBalancer(t *testing.T, failbackDelay time.Duration, members ...testFailoverMember) (*balancer, *FailoverManager, map[string]*groupMember) {
+	t.Helper()
+	b, err := newBalancer(v1.LoadBalanceAlgorithmFailover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := NewFailoverManager()
+	b.enableFailover(GroupTypeTCP, "test", failbackDelay, manager)
+	t.Cleanup(b.close)
+	res := make(map[string]*groupMember)
+	for _, tm := range members {
+		m := newGroupMember(tm.name, tm.priority, make(chan struct{}))
+		b.add(m)
+		res[tm.name] = m
+	}
+	return b, manager, res
+}
+
+// checkFailoverEvents 检查切换事件的 From、To 和 Reason
+func checkFailoverEvents(t *testing.T, manager *FailoverManager, want ...FailoverEvent) {
+	t.Helper()
+	_, events := manager.GetStatus()
+	if len(events) != len(want) {
+		t.Fatalf("got %d events %+v, want %d", len(events), events, len(want))
+	}
+	for i, ev := range events {
+		if ev.Type != GroupTypeTCP || ev.Group != "test" ||
+			ev.From != want[i].From || ev.To != want[i].To || ev.Reason != want[i].Reason {
+			t.Fatalf("event %d: got %+v, want %+v", i, ev, want[i])
+		}
+	}
+}
+
+func TestFailoverHighestPriority(t *testing.T) {
+	b, manager, members := newTestFailoverBalancer(t, 0,
+		testFailoverMember{"a", 1}, testFailoverMember{"b", 3}, testFailoverMember{"c", 3})
+
+	// 选择优先级最高的成员，优先级相同时选择先加入的成员
+	for i := 0; i < 10; i++ {
+		if got := b.pick(testSourceAddr(i)).name; got != "b" {
+			t.Fatalf("pick %d: got %s, want b", i, got)
+		}
+	}
+
+	b.remove(members["b"])
+	if got := b.pick(nil).name; got != "c" {
+		t.Fatalf("got %s after b left, want c", got)
+	}
+	b.remove(members["c"])
+	if got := b.pick(nil).name; got != "a" {
+		t.Fatalf("got %s after c left, want a", got)
+	}
+	b.remove(members["a"])
+	if m := b.pick(nil); m != nil {
+		t.Fatalf("got %s from empty group", m.name)
+	}
+
+	checkFailoverEvents(t, manager,
+		FailoverEvent{To: "a", Reason: FailoverReasonInitial},
+		FailoverEvent{From: "a", To: "b", Reason: FailoverReasonFailback},
+		FailoverEvent{From: "b", To: "c", Reason: FailoverReasonMemberDown},
+		FailoverEvent{From: "c", To: "a", Reason: FailoverReasonMemberDown},
+		FailoverEvent{From: "a", To: "", Reason: FailoverReasonMemberDown},
+	)
+
+	statuses, _ := manager.GetStatus()
+	if len(statuses) != 1 || statuses[0].Active != "" || len(statuses[0].Members) != 0 {
+		t.Fatalf("got status %+v, want an empty group", statuses)
+	}
+	b.close()
+	if statuses, _ := manager.GetStatus(); len(statuses) != 0 {
+		t.Fatalf("got status %+v after close, want no groups", statuses)
+	}
+}
+
+func TestFailoverFailbackDelay(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	b, manager, _ := newTestFailoverBalancer(t, delay, testFailoverMember{"backup", 1})
+
+	start := time.Now()
+	b.add(newGroupMember("primary", 2, make(chan struct{})))
+
+	// 更高优先级的成员加入后，在 failbackDelay 之后才切换回去
+	if got := b.pick(nil).name; got != "backup" {
+		t.Fatalf("got %s before the failback delay, want backup", got)
+	}
+	statuses, _ := manager.GetStatus()
+	if len(statuses) != 1 || statuses[0].FailbackAt == nil {
+		t.Fatalf("got status %+v, want a pending failback", statuses)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		statuses, _ = manager.GetStatus()
+		if statuses[0].Active == "primary" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("group did not fail back to primary")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Fatalf("failed back after %v, want at least %v", elapsed, delay)
+	}
+	if statuses[0].FailbackAt != nil {
+		t.Fatalf("got failback time %v after failback, want none", statuses[0].FailbackAt)
+	}
+	checkFailoverEvents(t, manager,
+		FailoverEvent{To: "backup", Reason: FailoverReasonInitial},
+		FailoverEvent{From: "backup", To: "primary", Reason: FailoverReasonFailback},
+	)
+}
+
+func TestFailoverFailbackCanceled(t *testing.T) {
+	b, manager, _ := newTestFailoverBalancer(t, 200*time.Millisecond, testFailoverMember{"backup", 1})
+
+	// 等待期间更高优先级的成员离开，取消切换
+	primary := newGroupMember("primary", 2, make(chan struct{}))
+	b.add(primary)
+	b.remove(primary)
+	if statuses, _ := manager.GetStatus(); statuses[0].FailbackAt != nil {
+		t.Fatalf("got failback time %v after primary left, want none", statuses[0].FailbackAt)
+	}
+	time.Sleep(400 * time.Millisecond)
+	if got := b.pick(nil).name; got != "backup" {
+		t.Fatalf("got %s, want backup", got)
+	}
+	checkFailoverEvents(t, manager, FailoverEvent{To: "backup", Reason: FailoverReasonInitial})
+}
+
+func TestFailoverNoFailback(t *testing.T) {
+	b, manager, _ := newTestFailoverBalancer(t, -1, testFailoverMember{"backup", 1})
+
+	// failbackDelay 小于 0 时不自动切换回去
+	b.add(newGroupMember("primary", 2, make(chan struct{})))
+	if got := b.pick(nil).name; got != "backup" {
+		t.Fatalf("got %s, want backup", got)
+	}
+	if statuses, _ := manager.GetStatus(); statuses[0].FailbackAt != nil {
+		t.Fatalf("got failback time %v, want none", statuses[0].FailbackAt)
+	}
+	checkFailoverEvents(t, manager, FailoverEvent{To: "backup", Reason: FailoverReasonInitial})
+}
@@ -0,0 +1,225 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHealthCheckTimeout  = 3 * time.Second
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckMaxFail  = 1
+)
+
+// DialFunc 通过工作连接连接到代理的后端，用于服务端健康检查。
+type DialFunc func() (net.Conn, error)
+
+// healthChecker 由 frps 定期通过工作连接检查组成员的后端，连续失败 MaxFailed 次后将成员标记为不健康，
+// 组在选择成员时跳过不健康的成员，一次检查成功后恢复。
+type healthChecker struct {
+	name     string
+	checkTyp string
+	timeout  time.Duration
+	interval time.Duration
+	maxFail  int
+	path     string
+	headers  []v1.HTTPHeader
+	dial     DialFunc
+	// 健康状态变化时调用
+	onChange func()
+
+	healthy   atomic.Bool
+	failures  int
+	lastError string
+	mu        sync.Mutex
+
+	cancel context.CancelFunc
+}
+
+// newHealthChecker 在未配置健康检查时返回 nil，nil 的 healthChecker 总是健康的。
+func newHealthChecker(name string, cfg v1.HealthCheckConfig, dial DialFunc, onChange func()) *healthChecker {
+	if cfg.Type == "" || dial == nil {
+		return nil
+	}
+	hc := &healthChecker{
+		name:     name,
+		checkTyp: cfg.Type,
+		timeout:  time.Duration(cfg.TimeoutSeconds) * time.Second,
+		interval: time.Duration(cfg.IntervalSeconds) * time.Second,
+		maxFail:  cfg.MaxFailed,
+		path:     cfg.Path,
+		headers:  cfg.HTTPHeaders,
+		dial:     dial,
+		onChange: onChange,
+	}
+	if hc.timeout <= 0 {
+		hc.timeout = defaultHealthCheckTimeout
+	}
+	if hc.interval <= 0 {
+		hc.interval = defaultHealthCheckInterval
+	}
+	if hc.maxFail <= 0 {
+		hc.maxFail = defaultHealthCheckMaxFail
+	}
+	if hc.path == "" {
+		hc.path = "/"
+	}
+	hc.healthy.Store(true)
+	return hc
+}
+
+func (hc *healthChecker) start() {
+	if hc == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	hc.cancel = cancel
+	go hc.run(ctx)
+}
+
+func (hc *healthChecker) stop() {
+	if hc == nil || hc.cancel == nil {
+		return
+	}
+	hc.cancel()
+}
+
+func (hc *healthChecker) isHealthy() bool {
+	return hc == nil || hc.healthy.Load()
+}
+
+// status 返回健康状态和最近一次检查的错误，未配置健康检查时 enabled 为 false。
+func (hc *healthChecker) status() (enabled bool, healthy bool, lastError string) {
+	if hc == nil {
+		return false, true, ""
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return true, hc.healthy.Load(), hc.lastError
+}
+
+func (hc *healthChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(hc.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, hc.timeout)
+		err := hc.check(checkCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		hc.report(err)
+	}
+}
+
+func (hc *healthChecker) report(err error) {
+	hc.mu.Lock()
+	changed := false
+	if err == nil {
+		hc.failures = 0
+		hc.lastError = ""
+		if !hc.healthy.Load() {
+			hc.healthy.Store(true)
+			changed = true
+			log.Infof("group member [%s] health check success, back to service", hc.name)
+		}
+	} else {
+		hc.failures++
+		hc.lastError = err.Error()
+		log.Debugf("group member [%s] health check failed: %v", hc.name, err)
+		if hc.healthy.Load() && hc.failures >= hc.maxFail {
+			hc.healthy.Store(false)
+			changed = true
+			log.Warnf("group member [%s] health check failed %d times, eject it: %v", hc.name, hc.failures, err)
+		}
+	}
+	hc.mu.Unlock()
+
+	if changed && hc.onChange != nil {
+		hc.onChange()
+	}
+}
+
+func (hc *healthChecker) check(ctx context.Context) error {
+	switch hc.checkTyp {
+	case "tcp":
+		return hc.checkTCP(ctx)
+	case "http":
+		return hc.checkHTTP(ctx)
+	default:
+		return fmt.Errorf("unsupported health check type [%s]", hc.checkTyp)
+	}
+}
+
+// checkTCP 通过工作连接连接后端。客户端连接后端失败时会关闭工作连接，
+// 因此连接在超时前被关闭视为失败，超时时仍然保持的连接视为成功。
+func (hc *healthChecker) checkTCP(ctx context.Context) error {
+	conn, err := hc.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetReadDeadline(deadline)
+	buf := make([]byte, 1)
+	_, err = conn.Read(buf)
+	switch {
+	case err == nil, errors.Is(err, os.ErrDeadlineExceeded):
+		return nil
+	case errors.Is(err, io.EOF):
+		return fmt.Errorf("connection closed by client")
+	default:
+		return err
+	}
+}
+
+// checkHTTP 通过工作连接向后端发送 GET 请求，响应状态码不是 2xx 时失败。
+func (hc *healthChecker) checkHTTP(ctx context.Context) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(context.Context, string, string) (net.Conn, error) {
+				return hc.dial()
+			},
+			DisableKeepAlives: true,
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://frp-health-check"+hc.path, nil)
+	if err != nil {
+		return err
+	}
+	for _, h := range hc.headers {
+		if http.CanonicalHeaderKey(h.Name) == "Host" {
+			req.Host = h.Value
+			continue
+		}
+		req.Header.Set(h.Name, h.Value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("health check status code [%d]", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package group
+
+import (
+	"context"
+	"errors"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func failTestDial() (net.Conn, error) {
+	return nil, errors.New("dial failed")
+}
+
+func TestHealthCheckerEjectAndRecover(t *testing.T) {
+	var changes atomic.Int32
+	hc := newHealthChecker("a", v1.HealthCheckConfig{Type: "tcp", MaxFailed: 3}, failTestDial, func() { changes.Add(1) })
+	errFail := errors.New("check failed")
+
+	for _, tc := range []struct {
+		name        string
+		err         error
+		wantHealthy bool
+		wantChanges int32
+	}{
+		{"first failure", errFail, true, 0},
+		{"second failure", errFail, true, 0},
+		{"ejected after max failures", errFail, false, 1},
+		{"still ejected", errFail, false, 1},
+		{"recovered after one success", nil, true, 2},
+		{"failures reset after success", errFail, true, 2},
+	} {
+		hc.report(tc.err)
+		if hc.isHealthy() != tc.wantHealthy || changes.Load() != tc.wantChanges {
+			t.Fatalf("%s: got healthy %v with %d changes, want %v with %d",
+				tc.name, hc.isHealthy(), changes.Load(), tc.wantHealthy, tc.wantChanges)
+		}
+	}
+	if enabled, _, lastError := hc.status(); !enabled || lastError != errFail.Error() {
+		t.Fatalf("got status %v %q, want enabled with the last error", enabled, lastError)
+	}
+}
+
+func TestHealthCheckerDisabled(t *testing.T) {
+	if hc := newHealthChecker("a", v1.HealthCheckConfig{}, failTestDial, nil); hc != nil {
+		t.Fatal("health checker without type should be nil")
+	}
+	if hc := newHealthChecker("a", v1.HealthCheckConfig{Type: "tcp"}, nil, nil); hc != nil {
+		t.Fatal("health checker without dial should be nil")
+	}
+	var hc *healthChecker
+	hc.start()
+	hc.stop()
+	if enabled, healthy, _ := hc.status(); enabled || !healthy || !hc.isHealthy() {
+		t.Fatal("nil health checker should be disabled and healthy")
+	}
+}
+
+func TestHealthCheckerTCP(t *testing.T) {
+	hc := newHealthChecker("a", v1.HealthCheckConfig{Type: "tcp"}, failTestDial, nil)
+	check := func(dial DialFunc) error {
+		hc.dial = dial
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		return hc.check(ctx)
+	}
+
+	// 客户端连接后端失败时关闭工作连接
+	err := check(func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		c2.Close()
+		return c1, nil
+	})
+	if err == nil {
+		t.Fatal("work connection closed by client should fail")
+	}
+	// 连接保持到超时视为成功
+	err = check(func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		t.Cleanup(func() { c2.Close() })
+		return c1, nil
+	})
+	if err != nil {
+		t.Fatalf("connection kept open should succeed: %v", err)
+	}
+	if err := check(failTestDial); err == nil {
+		t.Fatal("dial error should fail")
+	}
+}
+
+func TestHealthCheckerHTTPRun(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	var (
+		mu       sync.Mutex
+		lastReq  *http.Request
+		reqCount int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		lastReq = req
+		reqCount++
+		mu.Unlock()
+		rw.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(srv.Close)
+
+	var changes atomic.Int32
+	hc := newHealthChecker("a", v1.HealthCheckConfig{
+		Type:        "http",
+		MaxFailed:   2,
+		Path:        "/healthz",
+		HTTPHeaders: []v1.HTTPHeader{{Name: "host", Value: "example.test"}, {Name: "X-Check", Value: "frp"}},
+	}, func() (net.Conn, error) {
+		return net.Dial("tcp", srv.Listener.Addr().String())
+	}, func() { changes.Add(1) })
+	hc.interval = 20 * time.Millisecond
+	hc.start()
+	t.Cleanup(hc.stop)
+
+	waitHealthy := func(want bool) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for hc.isHealthy() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("health checker did not become healthy=%v", want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	status.Store(http.StatusServiceUnavailable)
+	waitHealthy(false)
+	if _, _, lastError := hc.status(); !strings.Contains(lastError, "503") {
+		t.Fatalf("got last error %q, want status code 503", lastError)
+	}
+	mu.Lock()
+	if reqCount < 2 {
+		t.Errorf("ejected after %d checks, want at least 2", reqCount)
+	}
+	if lastReq.URL.Path != "/healthz" || lastReq.Host != "example.test" || lastReq.Header.Get("X-Check") != "frp" {
+		t.Errorf("got request %s %s %v, want path, host and headers from the config", lastReq.Host, lastReq.URL.Path, lastReq.Header)
+	}
+	mu.Unlock()
+
+	status.Store(http.StatusNoContent)
+	waitHealthy(true)
+	if changes.Load() != 2 {
+		t.Fatalf("got %d changes, want 2", changes.Load())
+	}
+}
+
+func TestBalancerSkipsUnhealthyMembers(t *testing.T) {
+	b, members := newTestBalancer(t, v1.LoadBalanceAlgorithmRoundRobin, "a", "b", "c")
+	for _, m := range members {
+		b.enableHealthCheck(m, v1.HealthCheckConfig{Type: "tcp"}, failTestDial)
+		t.Cleanup(m.health.stop)
+	}
+	pickAll := func() map[string]int {
+		counts := make(map[string]int)
+		for i := 0; i < 30; i++ {
+			counts[b.pick(testSourceAddr(i)).name]++
+		}
+		return counts
+	}
+
+	members["b"].health.report(errors.New("check failed"))
+	if counts := pickAll(); counts["b"] != 0 || counts["a"] != 15 || counts["c"] != 15 {
+		t.Fatalf("got %v, want b ejected", counts)
+	}
+	if s := b.status(); !s[1].HealthCheck || s[1].Healthy || s[1].HealthError == "" {
+		t.Fatalf("got status %+v, want b unhealthy", s[1])
+	}
+
+	// 所有成员都不健康时仍然分配给全部成员
+	members["a"].health.report(errors.New("check failed"))
+	members["c"].health.report(errors.New("check failed"))
+	if counts := pickAll(); len(counts) != 3 {
+		t.Fatalf("got %v, want all members when none is healthy", counts)
+	}
+
+	members["a"].health.report(nil)
+	members["b"].health.report(nil)
+	if counts := pickAll(); counts["c"] != 0 || counts["a"] != 15 || counts["b"] != 15 {
+		t.Fatalf("got %v, want b recovered", counts)
+	}
+}
+
+func TestFailoverHealthCheck(t *testing.T) {
+	b, manager, members := newTestFailoverBalancer(t, 0, testFailoverMember{"primary", 2}, testFailoverMember{"backup", 1})
+	primary := members["primary"]
+	b.enableHealthCheck(primary, v1.HealthCheckConfig{Type: "tcp"}, failTestDial)
+	t.Cleanup(primary.health.stop)
+
+	// 活动成员被剔除后切换到备用成员，恢复后切换回去
+	primary.health.report(errors.New("check failed"))
+	if got := b.pick(nil).name; got != "backup" {
+		t.Fatalf("got %s after primary was ejected, want backup", got)
+	}
+	primary.health.report(nil)
+	if got := b.pick(nil).name; got != "primary" {
+		t.Fatalf("got %s after primary recovered, want primary", got)
+	}
+	checkFailoverEvents(t, manager,
+		FailoverEvent{To: "primary", Reason: FailoverReasonInitial},
+		FailoverEvent{From: "primary", To: "backup", Reason: FailoverReasonMemberDown},
+		FailoverEvent{From: "backup", To: "primary", Reason: FailoverReasonFailback},
+	)
+}
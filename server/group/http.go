@@ -1,8 +1,17 @@
 package group
 
 import (
+	"crypto/rand"
+	"fmt"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
 	"github.com/sunyihoo/frp/pkg/util/vhost"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type HTTPGroupController struct {
@@ -13,20 +22,472 @@ type HTTPGroupController struct {
 	// createConn 将从组的一个代理获取连接
 	vhostRouter *vhost.Routers
 
+	// 会话保持 cookie 的签名密钥
+	stickyKey []byte
+	// 记录主备模式的切换事件，可以为 nil
+	failoverManager *FailoverManager
+
 	mu sync.Mutex
 }
 
+func NewHTTPGroupController(vhostRouter *vhost.Routers) *HTTPGroupController {
+	stickyKey := make([]byte, 32)
+	_, _ = rand.Read(stickyKey)
+	return &HTTPGroupController{
+		groups:      make(map[string]*HTTPGroup),
+		vhostRouter: vhostRouter,
+		stickyKey:   stickyKey,
+	}
+}
+
+func (ctl *HTTPGroupController) SetFailoverManager(fm *FailoverManager) {
+	ctl.failoverManager = fm
+}
+
+func (ctl *HTTPGroupController) Register(proxyName string, lbCfg v1.LoadBalanceConfig, routeConfig vhost.RouteConfig) (err error) {
+	indexKey := lbCfg.Group
+	ctl.mu.Lock()
+	g, ok := ctl.groups[indexKey]
+	if !ok {
+		g = NewHTTPGroup(ctl)
+		ctl.groups[indexKey] = g
+	}
+	ctl.mu.Unlock()
+
+	return g.Register(proxyName, lbCfg, routeConfig)
+}
+
+func (ctl *HTTPGroupController) UnRegister(proxyName, group string) {
+	indexKey := group
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	g, ok := ctl.groups[indexKey]
+	if !ok {
+		return
+	}
+
+	isEmpty := g.UnRegister(proxyName)
+	if isEmpty {
+		delete(ctl.groups, indexKey)
+	}
+}
+
+// GetStatus 返回所有 HTTP 组的成员和请求分配情况，按组名称排序。
+func (ctl *HTTPGroupController) GetStatus() []HTTPGroupStatus {
+	ctl.mu.Lock()
+	groups := make([]*HTTPGroup, 0, len(ctl.groups))
+	for _, g := range ctl.groups {
+		groups = append(groups, g)
+	}
+	ctl.mu.Unlock()
+
+	res := make([]HTTPGroupStatus, 0, len(groups))
+	for _, g := range groups {
+		res = append(res, g.GetStatus())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+type HTTPGroupStatus struct {
+	Name            string                  `json:"name"`
+	Domain          string                  `json:"domain"`
+	Location        string                  `json:"location"`
+	RouteByHTTPUser string                  `json:"routeByHTTPUser,omitempty"`
+	StickySession   bool                    `json:"stickySession"`
+	Algorithm       string                  `json:"algorithm,omitempty"`
+	Members         []HTTPGroupMemberStatus `json:"members"`
+}
+
+type HTTPGroupMemberStatus struct {
+	Name     string `json:"name"`
+	Weight   int    `json:"weight"`
+	Priority int    `json:"priority"`
+	// 按权重计算的请求比例
+	ExpectedRatio float64 `json:"expectedRatio"`
+	// 注册以来分配到的请求数及其占全组的比例，包括固定发往该代理的请求
+	Requests uint64  `json:"requests"`
+	Ratio    float64 `json:"ratio"`
+	// HealthCheck 为 false 表示未启用服务端健康检查，此时 Healthy 总是为 true
+	HealthCheck bool   `json:"healthCheck"`
+	Healthy     bool   `json:"healthy"`
+	HealthError string `json:"healthError,omitempty"`
+}
+
+type httpGroupMember struct {
+	name     string
+	runID    string
+	createFn vhost.CreateConnFunc
+	weight   int
+	priority int
+	// health 为 nil 表示未启用服务端健康检查
+	health *healthChecker
+
+	// 平滑加权轮询的当前权重
+	currentWeight int
+	requests      atomic.Uint64
+}
+
 type HTTPGroup struct {
 	group           string
 	groupKey        string
 	domain          string
 	location        string
 	routeByHTTPUser string
+	matches         []vhost.RouteMatch
+	priority        int
+	// 组内代理的来源 IP 和国家列表必须相同
+	sourceIPFilterKey string
+	pinHeader         string
+	pinCookie         string
+	sticky            stickySession
+	algorithm         string
+	// 主备模式切换回高优先级成员的延迟
+	failbackDelaySeconds int
+	// failover 仅用于主备模式
+	failover *failover
+
+	// members 按注册顺序排列
+	members []*httpGroupMember
+	ctl     *HTTPGroupController
+	mu      sync.RWMutex
+}
+
+func NewHTTPGroup(ctl *HTTPGroupController) *HTTPGroup {
+	return &HTTPGroup{
+		members: make([]*httpGroupMember, 0),
+		ctl:     ctl,
+	}
+}
+
+func (g *HTTPGroup) Register(proxyName string, lbCfg v1.LoadBalanceConfig, routeConfig vhost.RouteConfig) (err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sticky := newStickySession(lbCfg.StickySession, g.ctl.stickyKey)
+	if len(g.members) == 0 {
+		// 组中的第一个代理
+		matcher, err := vhost.NewRequestMatcher(routeConfig.Matches, routeConfig.Priority)
+		if err != nil {
+			return err
+		}
+		tmp := routeConfig // 复制对象，组内所有代理的来源 IP 和国家列表相同，因此使用第一个代理的 SourceIPFilterFn
+		tmp.CreateConnFn = g.createConn
+		tmp.ChooseEndpointFn = g.chooseEndpoint
+		tmp.CreateConnByEndpointFn = g.createConnByEndpoint
+		tmp.ModifyResponseFn = g.modifyResponse
+		tmp.EndpointRunIDFn = g.endpointRunID
+		err = g.ctl.vhostRouter.Add(routeConfig.Domain, routeConfig.Location, routeConfig.RouteByHTTPUser, matcher, &tmp)
+		if err != nil {
+			return err
+		}
+
+		g.group = lbCfg.Group
+		g.groupKey = lbCfg.GroupKey
+		g.domain = routeConfig.Domain
+		g.location = routeConfig.Location
+		g.routeByHTTPUser = routeConfig.RouteByHTTPUser
+		g.matches = routeConfig.Matches
+		g.priority = routeConfig.Priority
+		g.sourceIPFilterKey = routeConfig.SourceIPFilterKey
+		g.pinHeader = lbCfg.PinHeader
+		g.pinCookie = lbCfg.PinCookie
+		g.sticky = sticky
+		g.algorithm = lbCfg.Algorithm
+		g.failbackDelaySeconds = lbCfg.FailbackDelaySeconds
+		if lbCfg.Algorithm == v1.LoadBalanceAlgorithmFailover {
+			g.failover = newFailover(GroupTypeHTTP, lbCfg.Group, time.Duration(lbCfg.FailbackDelaySeconds)*time.Second,
+				g.ctl.failoverManager, g.refresh)
+		}
+	} else {
+		if g.group != lbCfg.Group || g.domain != routeConfig.Domain ||
+			g.location != routeConfig.Location ||
+			g.routeByHTTPUser != routeConfig.RouteByHTTPUser ||
+			!reflect.DeepEqual(g.matches, routeConfig.Matches) || g.priority != routeConfig.Priority ||
+			g.sourceIPFilterKey != routeConfig.SourceIPFilterKey ||
+			g.pinHeader != lbCfg.PinHeader || g.pinCookie != lbCfg.PinCookie ||
+			!g.sticky.equal(&sticky) || g.algorithm != lbCfg.Algorithm ||
+			g.failbackDelaySeconds != lbCfg.FailbackDelaySeconds {
+			return ErrGroupParamsInvalid
+		}
+		if g.groupKey != lbCfg.GroupKey {
+			return ErrGroupAuthFailed
+		}
+	}
+	if g.getMember(proxyName) != nil {
+		return ErrProxyRepeated
+	}
+
+	weight := lbCfg.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	createFn := routeConfig.CreateConnFn
+	m := &httpGroupMember{
+		name:     proxyName,
+		runID:    routeConfig.RunID,
+		createFn: createFn,
+		weight:   weight,
+		priority: lbCfg.Priority,
+	}
+	if createFn != nil {
+		m.health = newHealthChecker(proxyName, lbCfg.HealthCheck, func() (net.Conn, error) {
+			return createFn("")
+		}, g.refreshHealth)
+	}
+	m.health.start()
+	g.members = append(g.members, m)
+	g.resetWeights()
+	if g.failover != nil {
+		g.chooseActive()
+	}
+	return nil
+}
+
+func (g *HTTPGroup) UnRegister(proxyName string) (isEmpty bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, m := range g.members {
+		if m.name == proxyName {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			m.health.stop()
+			break
+		}
+	}
+	g.resetWeights()
+	if g.failover != nil {
+		g.chooseActive()
+	}
+
+	if len(g.members) == 0 {
+		isEmpty = true
+		if g.failover != nil {
+			g.failover.close()
+		}
+		matcher, _ := vhost.NewRequestMatcher(g.matches, g.priority)
+		g.ctl.vhostRouter.Del(g.domain, g.location, g.routeByHTTPUser, matcher)
+	}
+	return
+}
+
+// resetWeights 在成员变化后重新开始加权轮询，调用方需要持有写锁。
+func (g *HTTPGroup) resetWeights() {
+	for _, m := range g.members {
+		m.currentWeight = 0
+	}
+}
+
+// refresh 在到达 failback 时间时重新选择主备模式的活动成员。
+func (g *HTTPGroup) refresh() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.chooseActive()
+}
+
+// refreshHealth 在成员的健康状态变化时调用。
+func (g *HTTPGroup) refreshHealth() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.resetWeights()
+	if g.failover != nil {
+		g.chooseActive()
+	}
+}
+
+// healthyMembers 返回健康检查通过的成员，所有成员都不健康时返回全部成员，调用方需要持有锁。
+func (g *HTTPGroup) healthyMembers() []*httpGroupMember {
+	healthy := make([]*httpGroupMember, 0, len(g.members))
+	for _, m := range g.members {
+		if m.health.isHealthy() {
+			healthy = append(healthy, m)
+		}
+	}
+	if len(healthy) == 0 {
+		return g.members
+	}
+	return healthy
+}
+
+// chooseActive 返回主备模式的活动成员，调用方需要持有写锁。
+func (g *HTTPGroup) chooseActive() *httpGroupMember {
+	candidates := make([]failoverCandidate, 0, len(g.members))
+	for _, m := range g.members {
+		candidates = append(candidates, failoverCandidate{
+			name:     m.name,
+			priority: m.priority,
+			live:     m.health.isHealthy(),
+		})
+	}
+	return g.getMember(g.failover.choose(candidates))
+}
+
+// getMember 调用方需要持有锁。
+func (g *HTTPGroup) getMember(name string) *httpGroupMember {
+	for _, m := range g.members {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// pick 为请求选择一个成员。请求通过 pinHeader 或 pinCookie 指定了存在的成员时直接使用它。
+// 主备模式下使用活动成员，否则先使用会话保持 cookie 中记录的成员，再使用平滑加权轮询，使请求按权重比例均匀地分散到各个成员。
+// 健康检查失败的成员不参与选择，除非所有成员都不健康。
+func (g *HTTPGroup) pick(req *http.Request) *httpGroupMember {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if m := g.pinnedMember(req); m != nil {
+		m.requests.Add(1)
+		return m
+	}
+	if g.failover != nil {
+		m := g.chooseActive()
+		if m != nil {
+			m.requests.Add(1)
+		}
+		return m
+	}
+	if name, _ := g.sticky.memberFromRequest(req); name != "" {
+		// 成员离开组或不健康时 cookie 失效，重新选择成员并在响应中更新 cookie
+		if m := g.getMember(name); m != nil && m.health.isHealthy() {
+			m.requests.Add(1)
+			return m
+		}
+	}
+
+	var (
+		best  *httpGroupMember
+		total int
+	)
+	for _, m := range g.healthyMembers() {
+		m.currentWeight += m.weight
+		total += m.weight
+		if best == nil || m.currentWeight > best.currentWeight {
+			best = m
+		}
+	}
+	if best != nil {
+		best.currentWeight -= total
+		best.requests.Add(1)
+	}
+	return best
+}
+
+func (g *HTTPGroup) pinnedMember(req *http.Request) *httpGroupMember {
+	if req == nil {
+		return nil
+	}
+	name := ""
+	if g.pinHeader != "" {
+		name = req.Header.Get(g.pinHeader)
+	}
+	if name == "" && g.pinCookie != "" {
+		if c, err := req.Cookie(g.pinCookie); err == nil {
+			name = c.Value
+		}
+	}
+	if name == "" {
+		return nil
+	}
+	return g.getMember(name)
+}
+
+func (g *HTTPGroup) createConn(remoteAddr string) (net.Conn, error) {
+	m := g.pick(nil)
+	if m == nil {
+		g.mu.RLock()
+		defer g.mu.RUnlock()
+		return nil, fmt.Errorf("%w: no CreateConnFunc for http group [%s], domain [%s], location [%s], routeByHTTPUser [%s]",
+			vhost.ErrNoWorkConn, g.group, g.domain, g.location, g.routeByHTTPUser)
+	}
+	return m.createFn(remoteAddr)
+}
+
+func (g *HTTPGroup) chooseEndpoint(req *http.Request) (string, error) {
+	m := g.pick(req)
+	if m == nil {
+		g.mu.RLock()
+		defer g.mu.RUnlock()
+		return "", fmt.Errorf("no healthy endpoint for http group [%s], domain [%s], location [%s], routeByHTTPUser [%s]",
+			g.group, g.domain, g.location, g.routeByHTTPUser)
+	}
+	return m.name, nil
+}
+
+func (g *HTTPGroup) createConnByEndpoint(endpoint, remoteAddr string) (net.Conn, error) {
+	var f vhost.CreateConnFunc
+	g.mu.RLock()
+	if m := g.getMember(endpoint); m != nil {
+		f = m.createFn
+	}
+	g.mu.RUnlock()
+
+	if f == nil {
+		return nil, fmt.Errorf("%w: no CreateConnFunc for endpoint [%s] in group [%s]", vhost.ErrNoWorkConn, endpoint, g.group)
+	}
+	return f(remoteAddr)
+}
+
+// endpointRunID 返回成员所属客户端的 run ID，用于访问日志。
+func (g *HTTPGroup) endpointRunID(endpoint string) string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if m := g.getMember(endpoint); m != nil {
+		return m.runID
+	}
+	return ""
+}
+
+// modifyResponse 在启用会话保持时通过 cookie 记录本次请求使用的成员。
+func (g *HTTPGroup) modifyResponse(resp *http.Response) error {
+	reqRouteInfo, ok := resp.Request.Context().Value(vhost.RouteInfoKey).(*vhost.RequestRouteInfo)
+	if !ok {
+		return nil
+	}
+	g.mu.RLock()
+	sticky, location := g.sticky, g.location
+	g.mu.RUnlock()
+	sticky.setCookie(resp, reqRouteInfo.Endpoint, location)
+	return nil
+}
+
+func (g *HTTPGroup) GetStatus() HTTPGroupStatus {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
 
-	// createFuncs 按代理名称编制索引的创建方法
-	createFuncs map[string]vhost.CreateConnFunc
-	pxyNames    []string
-	index       uint64
-	ctl         *HTTPGroupController
-	mu          sync.RWMutex
+	status := HTTPGroupStatus{
+		Name:            g.group,
+		Domain:          g.domain,
+		Location:        g.location,
+		RouteByHTTPUser: g.routeByHTTPUser,
+		StickySession:   g.sticky.enable,
+		Algorithm:       g.algorithm,
+		Members:         make([]HTTPGroupMemberStatus, 0, len(g.members)),
+	}
+	var totalWeight int
+	var totalRequests uint64
+	for _, m := range g.members {
+		totalWeight += m.weight
+		totalRequests += m.requests.Load()
+	}
+	for _, m := range g.members {
+		ms := HTTPGroupMemberStatus{
+			Name:     m.name,
+			Weight:   m.weight,
+			Priority: m.priority,
+			Requests: m.requests.Load(),
+		}
+		if totalWeight > 0 {
+			ms.ExpectedRatio = float64(m.weight) / float64(totalWeight)
+		}
+		if totalRequests > 0 {
+			ms.Ratio = float64(ms.Requests) / float64(totalRequests)
+		}
+		ms.HealthCheck, ms.Healthy, ms.HealthError = m.health.status()
+		status.Members = append(status.Members, ms)
+	}
+	return status
 }
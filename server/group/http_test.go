@@ -0,0 +1,113 @@
+package group
+
+import (
+	"errors"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/util/vhost"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type testHTTPMember struct {
+	name   string
+	weight int
+}
+
+func newTestHTTPGroup(t *testing.T, lbCfg v1.LoadBalanceConfig, members ...testHTTPMember) *HTTPGroup {
+	ctl := NewHTTPGroupController(vhost.NewRouters())
+	lbCfg.Group = "test"
+	for _, m := range members {
+		lbCfg.Weight = m.weight
+		err := ctl.Register(m.name, lbCfg, vhost.RouteConfig{Domain: "example.test", Location: "/"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ctl.groups["test"]
+}
+
+func TestHTTPGroupWeightedRoundRobin(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		members []testHTTPMember
+		// 一轮中的选择顺序，平滑加权轮询不会连续选择同一个成员太多次
+		want string
+	}{
+		{"equal weights", []testHTTPMember{{"a", 1}, {"b", 1}, {"c", 1}}, "abc"},
+		{"zero weight is 1", []testHTTPMember{{"a", 0}, {"b", 1}}, "ab"},
+		{"5:1:1", []testHTTPMember{{"a", 5}, {"b", 1}, {"c", 1}}, "aabacaa"},
+		{"canary 9:1", []testHTTPMember{{"stable", 9}, {"canary", 1}}, "ssssscssss"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newTestHTTPGroup(t, v1.LoadBalanceConfig{}, tc.members...)
+			// 连续两轮的顺序和比例都应相同
+			for round := 0; round < 2; round++ {
+				var got strings.Builder
+				for range tc.want {
+					got.WriteByte(g.pick(nil).name[0])
+				}
+				if got.String() != tc.want {
+					t.Fatalf("round %d: got %s, want %s", round, got.String(), tc.want)
+				}
+			}
+			for _, ms := range g.GetStatus().Members {
+				if ms.Ratio != ms.ExpectedRatio {
+					t.Errorf("member %s: ratio %v, want %v", ms.Name, ms.Ratio, ms.ExpectedRatio)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPGroupPin(t *testing.T) {
+	lbCfg := v1.LoadBalanceConfig{PinHeader: "X-Frp-Pin", PinCookie: "frp_pin"}
+	g := newTestHTTPGroup(t, lbCfg, testHTTPMember{"stable", 100}, testHTTPMember{"canary", 1})
+
+	for _, tc := range []struct {
+		name   string
+		header string
+		cookie string
+		want   string
+	}{
+		{"header", "canary", "", "canary"},
+		{"cookie", "", "canary", "canary"},
+		{"header before cookie", "stable", "canary", "stable"},
+		{"unknown member uses weights", "unknown", "", "stable"},
+		{"no pin uses weights", "", "", "stable"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "http://example.test/", nil)
+			if tc.header != "" {
+				req.Header.Set(lbCfg.PinHeader, tc.header)
+			}
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: lbCfg.PinCookie, Value: tc.cookie})
+			}
+			// 固定的请求总是发往同一个成员，不受权重影响
+			for i := 0; i < 3; i++ {
+				if got := g.pick(req).name; got != tc.want {
+					t.Fatalf("request %d: got %s, want %s", i, got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPGroupRequireSameSourceIPFilter(t *testing.T) {
+	ctl := NewHTTPGroupController(vhost.NewRouters())
+	lbCfg := v1.LoadBalanceConfig{Group: "test"}
+	routeConfig := vhost.RouteConfig{Domain: "example.test", Location: "/", SourceIPFilterKey: "10.0.0.0/8|||"}
+	if err := ctl.Register("a", lbCfg, routeConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	other := routeConfig
+	other.SourceIPFilterKey = "|10.0.0.0/8||"
+	if err := ctl.Register("b", lbCfg, other); !errors.Is(err, ErrGroupParamsInvalid) {
+		t.Fatalf("got %v, want ErrGroupParamsInvalid", err)
+	}
+	if err := ctl.Register("c", lbCfg, routeConfig); err != nil {
+		t.Fatal(err)
+	}
+}
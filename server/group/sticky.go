@@ -0,0 +1,117 @@
+package group
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStickyCookieName = "frp_sticky"
+	defaultStickyTTL        = time.Hour
+)
+
+// stickySession 使用签名的 cookie 记录请求应该发往的组成员。
+// cookie 的值为 base64(成员名称).过期时间.签名，签名密钥在 frps 启动时随机生成，因此 frps 重启后需要重新选择成员。
+type stickySession struct {
+	enable     bool
+	cookieName string
+	ttl        time.Duration
+	key        []byte
+}
+
+func newStickySession(cfg v1.StickySessionConfig, key []byte) stickySession {
+	s := stickySession{
+		enable:     cfg.Enable,
+		cookieName: cfg.CookieName,
+		ttl:        time.Duration(cfg.TTLSeconds) * time.Second,
+		key:        key,
+	}
+	if s.cookieName == "" {
+		s.cookieName = defaultStickyCookieName
+	}
+	if s.ttl <= 0 {
+		s.ttl = defaultStickyTTL
+	}
+	return s
+}
+
+func (s *stickySession) equal(o *stickySession) bool {
+	return s.enable == o.enable && s.cookieName == o.cookieName && s.ttl == o.ttl
+}
+
+func (s *stickySession) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *stickySession) encode(member string, expire time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(member)) + "." + strconv.FormatInt(expire.Unix(), 10)
+	return payload + "." + s.sign(payload)
+}
+
+// memberFromRequest 返回请求的 cookie 中记录的成员名称和过期时间，cookie 不存在、签名错误或已经过期时返回空字符串。
+func (s *stickySession) memberFromRequest(req *http.Request) (string, time.Time) {
+	if !s.enable || req == nil {
+		return "", time.Time{}
+	}
+	c, err := req.Cookie(s.cookieName)
+	if err != nil {
+		return "", time.Time{}
+	}
+	idx := strings.LastIndex(c.Value, ".")
+	if idx < 0 {
+		return "", time.Time{}
+	}
+	payload, sig := c.Value[:idx], c.Value[idx+1:]
+	if !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return "", time.Time{}
+	}
+	encodedName, expireStr, ok := strings.Cut(payload, ".")
+	if !ok {
+		return "", time.Time{}
+	}
+	expireUnix, err := strconv.ParseInt(expireStr, 10, 64)
+	if err != nil {
+		return "", time.Time{}
+	}
+	expire := time.Unix(expireUnix, 0)
+	if time.Now().After(expire) {
+		return "", time.Time{}
+	}
+	name, err := base64.RawURLEncoding.DecodeString(encodedName)
+	if err != nil {
+		return "", time.Time{}
+	}
+	return string(name), expire
+}
+
+// setCookie 在响应中记录本次请求使用的成员。请求中已有同一成员且剩余有效期超过一半的 cookie 时不再重复设置。
+func (s *stickySession) setCookie(resp *http.Response, member string, path string) {
+	if !s.enable || member == "" {
+		return
+	}
+	name, expire := s.memberFromRequest(resp.Request)
+	if name == member && time.Until(expire) > s.ttl/2 {
+		return
+	}
+	if path == "" {
+		path = "/"
+	}
+	c := &http.Cookie{
+		Name:     s.cookieName,
+		Value:    s.encode(member, time.Now().Add(s.ttl)),
+		Path:     path,
+		MaxAge:   int(s.ttl.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	resp.Header.Add("Set-Cookie", c.String())
+}
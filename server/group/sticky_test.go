@@ -0,0 +1,141 @@
+package group
+
+import (
+	"context"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/util/vhost"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestStickyRequest(cookieName, value string) *http.Request {
+	req, _ := http.NewRequest("GET", "http://example.test/", nil)
+	if value != "" {
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: value})
+	}
+	return req
+}
+
+func TestStickySessionVerify(t *testing.T) {
+	s := newStickySession(v1.StickySessionConfig{Enable: true, TTLSeconds: 60}, []byte("key"))
+	other := newStickySession(v1.StickySessionConfig{Enable: true, TTLSeconds: 60}, []byte("other key"))
+	expire := time.Now().Add(time.Minute)
+	valid := s.encode("alice.web", expire)
+	payload := valid[:strings.LastIndex(valid, ".")]
+	sig := valid[strings.LastIndex(valid, ".")+1:]
+	forged := other.encode("bob.web", expire)
+
+	for _, tc := range []struct {
+		name   string
+		cookie string
+		value  string
+		want   string
+	}{
+		{"valid", defaultStickyCookieName, valid, "alice.web"},
+		{"no cookie", defaultStickyCookieName, "", ""},
+		{"other cookie name", "other", valid, ""},
+		{"tampered signature", defaultStickyCookieName, payload + "." + strings.Repeat("0", len(sig)), ""},
+		{"tampered member", defaultStickyCookieName,
+			strings.Replace(payload, payload[:strings.Index(payload, ".")], "Ym9iLndlYg", 1) + "." + sig, ""},
+		{"signed with other key", defaultStickyCookieName, forged, ""},
+		{"expired", defaultStickyCookieName, s.encode("alice.web", time.Now().Add(-time.Second)), ""},
+		{"malformed", defaultStickyCookieName, "alice.web", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			name, gotExpire := s.memberFromRequest(newTestStickyRequest(tc.cookie, tc.value))
+			if name != tc.want {
+				t.Fatalf("got member %q, want %q", name, tc.want)
+			}
+			if name != "" && gotExpire.Unix() != expire.Unix() {
+				t.Fatalf("got expire %v, want %v", gotExpire, expire)
+			}
+		})
+	}
+
+	disabled := newStickySession(v1.StickySessionConfig{TTLSeconds: 60}, []byte("key"))
+	if name, _ := disabled.memberFromRequest(newTestStickyRequest(defaultStickyCookieName, valid)); name != "" {
+		t.Fatalf("disabled sticky session got member %q", name)
+	}
+}
+
+func TestStickySessionSetCookie(t *testing.T) {
+	const ttl = 60 * time.Second
+	s := newStickySession(v1.StickySessionConfig{Enable: true, CookieName: "sid", TTLSeconds: 60}, []byte("key"))
+
+	for _, tc := range []struct {
+		name    string
+		member  string
+		cookie  string
+		path    string
+		wantSet bool
+	}{
+		{"first request", "a", "", "/api", true},
+		{"same member with fresh cookie", "a", s.encode("a", time.Now().Add(ttl)), "/api", false},
+		{"same member past half ttl", "a", s.encode("a", time.Now().Add(ttl/2-time.Second)), "/api", true},
+		{"expired cookie", "a", s.encode("a", time.Now().Add(-time.Second)), "/api", true},
+		{"different member", "b", s.encode("a", time.Now().Add(ttl)), "/api", true},
+		{"default path", "a", "", "", true},
+		{"no member", "", "", "/api", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{Header: make(http.Header), Request: newTestStickyRequest("sid", tc.cookie)}
+			s.setCookie(resp, tc.member, tc.path)
+			cookies := resp.Cookies()
+			if !tc.wantSet {
+				if len(cookies) != 0 {
+					t.Fatalf("got cookies %v, want none", cookies)
+				}
+				return
+			}
+			if len(cookies) != 1 {
+				t.Fatalf("got cookies %v, want one", cookies)
+			}
+			c := cookies[0]
+			wantPath := tc.path
+			if wantPath == "" {
+				wantPath = "/"
+			}
+			if c.Name != "sid" || c.Path != wantPath || c.MaxAge != 60 || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+				t.Fatalf("got cookie %+v, want sid for %s with max age 60", c, wantPath)
+			}
+			name, expire := s.memberFromRequest(newTestStickyRequest("sid", c.Value))
+			if name != tc.member || time.Until(expire) <= ttl/2 {
+				t.Fatalf("got member %q expiring at %v, want %q with a full ttl", name, expire, tc.member)
+			}
+		})
+	}
+}
+
+func TestHTTPGroupStickySession(t *testing.T) {
+	lbCfg := v1.LoadBalanceConfig{StickySession: v1.StickySessionConfig{Enable: true}}
+	g := newTestHTTPGroup(t, lbCfg, testHTTPMember{"a", 1}, testHTTPMember{"b", 1}, testHTTPMember{"c", 1})
+
+	// 带有 cookie 的请求总是发往 cookie 中记录的成员
+	req := newTestStickyRequest(defaultStickyCookieName, g.sticky.encode("b", time.Now().Add(time.Hour)))
+	for i := 0; i < 5; i++ {
+		if got := g.pick(req).name; got != "b" {
+			t.Fatalf("request %d: got %s, want b", i, got)
+		}
+	}
+
+	// 成员离开组后重新选择成员，并在响应中更新 cookie
+	g.UnRegister("b")
+	m := g.pick(req)
+	if m == nil || m.name == "b" {
+		t.Fatalf("got %v after b left, want another member", m)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), vhost.RouteInfoKey, &vhost.RequestRouteInfo{Endpoint: m.name}))
+	resp := &http.Response{Header: make(http.Header), Request: req}
+	if err := g.modifyResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got cookies %v, want the updated sticky cookie", cookies)
+	}
+	if name, _ := g.sticky.memberFromRequest(newTestStickyRequest(defaultStickyCookieName, cookies[0].Value)); name != m.name {
+		t.Fatalf("got cookie for %q, want %q", name, m.name)
+	}
+}
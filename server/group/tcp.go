@@ -1,9 +1,13 @@
 package group
 
 import (
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
 	"github.com/sunyihoo/frp/server/ports"
 	"net"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 )
 
 type TCPGroupCtl struct {
@@ -11,7 +15,71 @@ type TCPGroupCtl struct {
 
 	// portManager 用于管理端口
 	portManager *ports.Manager
-	mu          sync.Mutex
+	// 记录主备模式的切换事件，可以为 nil
+	failoverManager *FailoverManager
+	mu              sync.Mutex
+}
+
+func NewTCPGroupCtl(portManager *ports.Manager) *TCPGroupCtl {
+	return &TCPGroupCtl{
+		groups:      make(map[string]*TCPGroup),
+		portManager: portManager,
+	}
+}
+
+func (tgc *TCPGroupCtl) SetFailoverManager(fm *FailoverManager) {
+	tgc.failoverManager = fm
+}
+
+// Listen 是 TCPGroupCtl 的包装器，如果组不存在，它将创建一个新组。
+// dial 通过工作连接连接代理的后端，用于 lbCfg.HealthCheck 配置的服务端健康检查，可以为 nil
+func (tgc *TCPGroupCtl) Listen(proxyName string, lbCfg v1.LoadBalanceConfig, addr string, port int, dial DialFunc) (l net.Listener, realPort int, err error) {
+	tgc.mu.Lock()
+	tcpGroup, ok := tgc.groups[lbCfg.Group]
+	if !ok {
+		tcpGroup = NewTCPGroup(tgc)
+		tgc.groups[lbCfg.Group] = tcpGroup
+	}
+	tgc.mu.Unlock()
+
+	return tcpGroup.Listen(proxyName, lbCfg, addr, port, dial)
+}
+
+// GetStatus 返回所有组的状态，按组名称排序
+func (tgc *TCPGroupCtl) GetStatus() []GroupStatus {
+	tgc.mu.Lock()
+	groups := make([]*TCPGroup, 0, len(tgc.groups))
+	for _, g := range tgc.groups {
+		groups = append(groups, g)
+	}
+	tgc.mu.Unlock()
+
+	res := make([]GroupStatus, 0, len(groups))
+	for _, g := range groups {
+		g.mu.Lock()
+		b := g.balancer
+		name := g.group
+		g.mu.Unlock()
+		if b == nil {
+			continue
+		}
+		res = append(res, GroupStatus{
+			Name:      name,
+			Algorithm: b.algorithm,
+			Members:   b.status(),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// RemoveGroup 删除组
+func (tgc *TCPGroupCtl) RemoveGroup(group string) {
+	tgc.mu.Lock()
+	defer tgc.mu.Unlock()
+	delete(tgc.groups, group)
 }
 
 // TCPGroup 将路由连接到不同的代理
@@ -19,21 +87,167 @@ type TCPGroup struct {
 	group    string
 	groupKey string
 	addr     string
-	port     string
-	realPort string
+	port     int
+	realPort int
 
-	acceptCh chan net.Conn
+	// 主备模式切换回高优先级成员的延迟
+	failbackDelaySeconds int
+
+	balancer *balancer
 	tcpLn    net.Listener
 	lns      []*TCPGroupListener
 	ctl      *TCPGroupCtl
 	mu       sync.Mutex
 }
 
+func NewTCPGroup(ctl *TCPGroupCtl) *TCPGroup {
+	return &TCPGroup{
+		lns: make([]*TCPGroupListener, 0),
+		ctl: ctl,
+	}
+}
+
+// Listen 将在第一次侦听时创建一个新的 TCPGroupListener，如果是第一个侦听器，它将在真实端口上侦听
+// 否则，它将使用现有的侦听器，组内的连接按 lbCfg.Algorithm 分配
+func (tg *TCPGroup) Listen(proxyName string, lbCfg v1.LoadBalanceConfig, addr string, port int, dial DialFunc) (ln *TCPGroupListener, realPort int, err error) {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	if len(tg.lns) == 0 {
+		// 组中的第一个代理
+		b, errRet := newBalancer(lbCfg.Algorithm)
+		if errRet != nil {
+			err = errRet
+			return
+		}
+		realPort, err = tg.ctl.portManager.Acquire(proxyName, port)
+		if err != nil {
+			return
+		}
+		if b.algorithm == v1.LoadBalanceAlgorithmFailover {
+			b.enableFailover(GroupTypeTCP, lbCfg.Group, time.Duration(lbCfg.FailbackDelaySeconds)*time.Second, tg.ctl.failoverManager)
+		}
+		tcpLn, errRet := net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(realPort)))
+		if errRet != nil {
+			tg.ctl.portManager.Release(realPort)
+			err = errRet
+			return
+		}
+		ln = newTCPGroupListener(proxyName, lbCfg, tg, tcpLn.Addr())
+
+		tg.failbackDelaySeconds = lbCfg.FailbackDelaySeconds
+		tg.group = lbCfg.Group
+		tg.groupKey = lbCfg.GroupKey
+		tg.addr = addr
+		tg.port = port
+		tg.realPort = realPort
+		tg.tcpLn = tcpLn
+		tg.balancer = b
+		tg.lns = append(tg.lns, ln)
+		b.enableHealthCheck(ln.member, lbCfg.HealthCheck, dial)
+		b.add(ln.member)
+		go tg.worker(tcpLn, b)
+	} else {
+		// 同一组中的地址和端口必须相等
+		if tg.group != lbCfg.Group || tg.addr != addr || tg.balancer.algorithm != algorithmOrDefault(lbCfg.Algorithm) ||
+			tg.failbackDelaySeconds != lbCfg.FailbackDelaySeconds {
+			err = ErrGroupParamsInvalid
+			return
+		}
+		if tg.port != port {
+			err = ErrGroupDifferentPort
+			return
+		}
+		if tg.groupKey != lbCfg.GroupKey {
+			err = ErrGroupAuthFailed
+			return
+		}
+		for _, tmpLn := range tg.lns {
+			if tmpLn.member.name == proxyName {
+				err = ErrProxyRepeated
+				return
+			}
+		}
+		ln = newTCPGroupListener(proxyName, lbCfg, tg, tg.lns[0].Addr())
+		realPort = tg.realPort
+		tg.lns = append(tg.lns, ln)
+		tg.balancer.enableHealthCheck(ln.member, lbCfg.HealthCheck, dial)
+		tg.balancer.add(ln.member)
+	}
+	return
+}
+
+// worker 从真实端口接受连接，并按负载均衡算法交给组内的侦听器
+func (tg *TCPGroup) worker(tcpLn net.Listener, b *balancer) {
+	for {
+		c, err := tcpLn.Accept()
+		if err != nil {
+			return
+		}
+		b.dispatch(c)
+	}
+}
+
+// CloseListener 从组中移除侦听器，最后一个侦听器关闭时释放真实端口
+func (tg *TCPGroup) CloseListener(ln *TCPGroupListener) {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	for i, tmpLn := range tg.lns {
+		if tmpLn == ln {
+			tg.lns = append(tg.lns[:i], tg.lns[i+1:]...)
+			tg.balancer.remove(ln.member)
+			ln.member.health.stop()
+			break
+		}
+	}
+	if len(tg.lns) == 0 {
+		tg.tcpLn.Close()
+		tg.balancer.close()
+		tg.ctl.portManager.Release(tg.realPort)
+		tg.ctl.RemoveGroup(tg.group)
+	}
+}
+
 // TCPGroupListener TCP组侦听者
 type TCPGroupListener struct {
 	groupName string
 	group     *TCPGroup
+	member    *groupMember
+
+	addr      net.Addr
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+func newTCPGroupListener(proxyName string, lbCfg v1.LoadBalanceConfig, group *TCPGroup, addr net.Addr) *TCPGroupListener {
+	closeCh := make(chan struct{})
+	return &TCPGroupListener{
+		groupName: lbCfg.Group,
+		group:     group,
+		member:    newGroupMember(proxyName, lbCfg.Priority, closeCh),
+		addr:      addr,
+		closeCh:   closeCh,
+	}
+}
+
+// Accept 等待并返回分配给此侦听器的下一个连接
+func (ln *TCPGroupListener) Accept() (c net.Conn, err error) {
+	select {
+	case <-ln.closeCh:
+		return nil, ErrListenerClosed
+	case c = <-ln.member.connCh:
+		return c, nil
+	}
+}
+
+func (ln *TCPGroupListener) Addr() net.Addr {
+	return ln.addr
+}
 
-	addr    net.Addr
-	closeCh chan struct{}
+// Close 关闭侦听器并将自身从 TCPGroup 中移除
+func (ln *TCPGroupListener) Close() (err error) {
+	ln.closeOnce.Do(func() {
+		close(ln.closeCh)
+		ln.group.CloseListener(ln)
+	})
+	return
 }
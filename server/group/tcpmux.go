@@ -1,9 +1,15 @@
 package group
 
 import (
+	"context"
+	"fmt"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
 	"github.com/sunyihoo/frp/pkg/util/tcpmux"
+	"github.com/sunyihoo/frp/pkg/util/vhost"
 	"net"
+	"sort"
 	"sync"
+	"time"
 )
 
 type TCPMuxGroupCtl struct {
@@ -11,29 +17,291 @@ type TCPMuxGroupCtl struct {
 
 	// tcpMuxHTTPConnectMuxer 被用于管理 muxer
 	tcpMuxHTTPConnectMuxer *tcpmux.HTTPConnectTCPMuxer
-	mu                     sync.Mutex
+	// 未启用的 muxer 为 nil
+	tcpMuxSNIMuxer    *tcpmux.SNITCPMuxer
+	tcpMuxSOCKS5Muxer *tcpmux.SOCKS5TCPMuxer
+	// 记录主备模式的切换事件，可以为 nil
+	failoverManager *FailoverManager
+	mu              sync.Mutex
+}
+
+func NewTCPMuxGroupCtl(
+	tcpMuxHTTPConnectMuxer *tcpmux.HTTPConnectTCPMuxer,
+	tcpMuxSNIMuxer *tcpmux.SNITCPMuxer,
+	tcpMuxSOCKS5Muxer *tcpmux.SOCKS5TCPMuxer,
+) *TCPMuxGroupCtl {
+	return &TCPMuxGroupCtl{
+		groups:                 make(map[string]*TCPMuxGroup),
+		tcpMuxHTTPConnectMuxer: tcpMuxHTTPConnectMuxer,
+		tcpMuxSNIMuxer:         tcpMuxSNIMuxer,
+		tcpMuxSOCKS5Muxer:      tcpMuxSOCKS5Muxer,
+	}
+}
+
+func (tmgc *TCPMuxGroupCtl) SetFailoverManager(fm *FailoverManager) {
+	tmgc.failoverManager = fm
+}
+
+// Listen 是 TCPMuxGroup 的包装器，如果组不存在，它将创建一个新组。
+// dial 通过工作连接连接代理的后端，用于 lbCfg.HealthCheck 配置的服务端健康检查，可以为 nil
+func (tmgc *TCPMuxGroupCtl) Listen(
+	ctx context.Context,
+	multiplexer string,
+	proxyName string,
+	lbCfg v1.LoadBalanceConfig,
+	routeConfig vhost.RouteConfig,
+	dial DialFunc,
+) (l net.Listener, err error) {
+	var muxer *vhost.Muxer
+	switch v1.TCPMultiplexerType(multiplexer) {
+	case v1.TCPMultiplexerHTTPConnect:
+		if tmgc.tcpMuxHTTPConnectMuxer != nil {
+			muxer = tmgc.tcpMuxHTTPConnectMuxer.Muxer
+		}
+	case v1.TCPMultiplexerSNI:
+		if tmgc.tcpMuxSNIMuxer != nil {
+			muxer = tmgc.tcpMuxSNIMuxer.Muxer
+		}
+	case v1.TCPMultiplexerSOCKS5:
+		if tmgc.tcpMuxSOCKS5Muxer != nil {
+			muxer = tmgc.tcpMuxSOCKS5Muxer.Muxer
+		}
+	default:
+		return nil, fmt.Errorf("unknown multiplexer [%s]", multiplexer)
+	}
+	if muxer == nil {
+		return nil, fmt.Errorf("multiplexer [%s] is not enabled", multiplexer)
+	}
+	// sni 无法在转发之前认证用户，忽略用户名会使代理在没有认证的情况下被访问
+	if v1.TCPMultiplexerType(multiplexer) == v1.TCPMultiplexerSNI && routeConfig.Username != "" {
+		return nil, fmt.Errorf("multiplexer [%s] does not support httpUser", multiplexer)
+	}
+
+	tmgc.mu.Lock()
+	tcpMuxGroup, ok := tmgc.groups[lbCfg.Group]
+	if !ok {
+		tcpMuxGroup = NewTCPMuxGroup(tmgc)
+		tmgc.groups[lbCfg.Group] = tcpMuxGroup
+	}
+	tmgc.mu.Unlock()
+
+	return tcpMuxGroup.Listen(ctx, multiplexer, muxer, proxyName, lbCfg, routeConfig, dial)
+}
+
+// GetStatus 返回所有组的状态，按组名称排序
+func (tmgc *TCPMuxGroupCtl) GetStatus() []GroupStatus {
+	tmgc.mu.Lock()
+	groups := make([]*TCPMuxGroup, 0, len(tmgc.groups))
+	for _, g := range tmgc.groups {
+		groups = append(groups, g)
+	}
+	tmgc.mu.Unlock()
+
+	res := make([]GroupStatus, 0, len(groups))
+	for _, g := range groups {
+		g.mu.Lock()
+		b := g.balancer
+		name := g.group
+		g.mu.Unlock()
+		if b == nil {
+			continue
+		}
+		res = append(res, GroupStatus{
+			Name:      name,
+			Algorithm: b.algorithm,
+			Members:   b.status(),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// RemoveGroup 删除组
+func (tmgc *TCPMuxGroupCtl) RemoveGroup(group string) {
+	tmgc.mu.Lock()
+	defer tmgc.mu.Unlock()
+	delete(tmgc.groups, group)
 }
 
 type TCPMuxGroup struct {
+	multiplexer     string
 	group           string
 	groupKey        string
 	domain          string
 	routeByHTTPUser string
 	username        string
 	password        string
+	// 组内代理的来源 IP 和国家列表必须相同
+	sourceIPFilterKey string
+
+	// 主备模式切换回高优先级成员的延迟
+	failbackDelaySeconds int
 
-	acceptCh chan net.Conn
+	balancer *balancer
 	tcpMuxLn net.Listener
 	lns      []*TCPMuxGroupListener
 	ctl      *TCPMuxGroupCtl
 	mu       sync.Mutex
 }
 
+func NewTCPMuxGroup(ctl *TCPMuxGroupCtl) *TCPMuxGroup {
+	return &TCPMuxGroup{
+		lns: make([]*TCPMuxGroupListener, 0),
+		ctl: ctl,
+	}
+}
+
+// Listen 将在第一次侦听时创建一个新的 TCPMuxGroupListener，如果是第一个侦听器，它将在 muxer 上注册路由
+// 否则，它将使用现有的侦听器，组内的连接按 lbCfg.Algorithm 分配。同一组的代理必须使用相同的 multiplexer
+func (tmg *TCPMuxGroup) Listen(
+	ctx context.Context,
+	multiplexer string,
+	muxer *vhost.Muxer,
+	proxyName string,
+	lbCfg v1.LoadBalanceConfig,
+	routeConfig vhost.RouteConfig,
+	dial DialFunc,
+) (ln *TCPMuxGroupListener, err error) {
+	tmg.mu.Lock()
+	defer tmg.mu.Unlock()
+	if len(tmg.lns) == 0 {
+		// 组中的第一个代理
+		b, errRet := newBalancer(lbCfg.Algorithm)
+		if errRet != nil {
+			err = errRet
+			return
+		}
+		// 组内代理的来源 IP 和国家列表相同，因此使用第一个代理的来源 IP 过滤函数
+		tcpMuxLn, errRet := muxer.Listen(ctx, &vhost.RouteConfig{
+			Domain:           routeConfig.Domain,
+			RouteByHTTPUser:  routeConfig.RouteByHTTPUser,
+			Username:         routeConfig.Username,
+			Password:         routeConfig.Password,
+			SourceIPFilterFn: routeConfig.SourceIPFilterFn,
+		})
+		if errRet != nil {
+			return nil, errRet
+		}
+		if b.algorithm == v1.LoadBalanceAlgorithmFailover {
+			b.enableFailover(GroupTypeTCPMux, lbCfg.Group, time.Duration(lbCfg.FailbackDelaySeconds)*time.Second, tmg.ctl.failoverManager)
+		}
+		ln = newTCPMuxGroupListener(proxyName, lbCfg, tmg, tcpMuxLn.Addr())
+
+		tmg.failbackDelaySeconds = lbCfg.FailbackDelaySeconds
+		tmg.multiplexer = multiplexer
+		tmg.group = lbCfg.Group
+		tmg.groupKey = lbCfg.GroupKey
+		tmg.domain = routeConfig.Domain
+		tmg.routeByHTTPUser = routeConfig.RouteByHTTPUser
+		tmg.username = routeConfig.Username
+		tmg.password = routeConfig.Password
+		tmg.sourceIPFilterKey = routeConfig.SourceIPFilterKey
+		tmg.tcpMuxLn = tcpMuxLn
+		tmg.balancer = b
+		tmg.lns = append(tmg.lns, ln)
+		b.enableHealthCheck(ln.member, lbCfg.HealthCheck, dial)
+		b.add(ln.member)
+		go tmg.worker(tcpMuxLn, b)
+	} else {
+		// 同一组中的路由参数必须相等
+		if tmg.multiplexer != multiplexer || tmg.group != lbCfg.Group || tmg.domain != routeConfig.Domain ||
+			tmg.routeByHTTPUser != routeConfig.RouteByHTTPUser ||
+			tmg.username != routeConfig.Username ||
+			tmg.password != routeConfig.Password ||
+			tmg.sourceIPFilterKey != routeConfig.SourceIPFilterKey ||
+			tmg.balancer.algorithm != algorithmOrDefault(lbCfg.Algorithm) ||
+			tmg.failbackDelaySeconds != lbCfg.FailbackDelaySeconds {
+			return nil, ErrGroupParamsInvalid
+		}
+		if tmg.groupKey != lbCfg.GroupKey {
+			return nil, ErrGroupAuthFailed
+		}
+		for _, tmpLn := range tmg.lns {
+			if tmpLn.member.name == proxyName {
+				return nil, ErrProxyRepeated
+			}
+		}
+		ln = newTCPMuxGroupListener(proxyName, lbCfg, tmg, tmg.lns[0].Addr())
+		tmg.lns = append(tmg.lns, ln)
+		tmg.balancer.enableHealthCheck(ln.member, lbCfg.HealthCheck, dial)
+		tmg.balancer.add(ln.member)
+	}
+	return
+}
+
+// worker 从 muxer 接受连接，并按负载均衡算法交给组内的侦听器
+func (tmg *TCPMuxGroup) worker(tcpMuxLn net.Listener, b *balancer) {
+	for {
+		c, err := tcpMuxLn.Accept()
+		if err != nil {
+			return
+		}
+		b.dispatch(c)
+	}
+}
+
+// CloseListener 从组中移除侦听器，最后一个侦听器关闭时注销路由
+func (tmg *TCPMuxGroup) CloseListener(ln *TCPMuxGroupListener) {
+	tmg.mu.Lock()
+	defer tmg.mu.Unlock()
+	for i, tmpLn := range tmg.lns {
+		if tmpLn == ln {
+			tmg.lns = append(tmg.lns[:i], tmg.lns[i+1:]...)
+			tmg.balancer.remove(ln.member)
+			ln.member.health.stop()
+			break
+		}
+	}
+	if len(tmg.lns) == 0 {
+		tmg.tcpMuxLn.Close()
+		tmg.balancer.close()
+		tmg.ctl.RemoveGroup(tmg.group)
+	}
+}
+
 // TCPMuxGroupListener TCPMux组侦听者
 type TCPMuxGroupListener struct {
 	groupName string
 	group     *TCPMuxGroup
+	member    *groupMember
+
+	addr      net.Addr
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+func newTCPMuxGroupListener(proxyName string, lbCfg v1.LoadBalanceConfig, group *TCPMuxGroup, addr net.Addr) *TCPMuxGroupListener {
+	closeCh := make(chan struct{})
+	return &TCPMuxGroupListener{
+		groupName: lbCfg.Group,
+		group:     group,
+		member:    newGroupMember(proxyName, lbCfg.Priority, closeCh),
+		addr:      addr,
+		closeCh:   closeCh,
+	}
+}
+
+// Accept 等待并返回分配给此侦听器的下一个连接
+func (ln *TCPMuxGroupListener) Accept() (c net.Conn, err error) {
+	select {
+	case <-ln.closeCh:
+		return nil, ErrListenerClosed
+	case c = <-ln.member.connCh:
+		return c, nil
+	}
+}
+
+func (ln *TCPMuxGroupListener) Addr() net.Addr {
+	return ln.addr
+}
 
-	addr    net.Addr
-	closeCh chan struct{}
+// Close 关闭侦听器并将自身从 TCPMuxGroup 中移除
+func (ln *TCPMuxGroupListener) Close() (err error) {
+	ln.closeOnce.Do(func() {
+		close(ln.closeCh)
+		ln.group.CloseListener(ln)
+	})
+	return
 }
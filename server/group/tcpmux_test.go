@@ -0,0 +1,73 @@
+package group
+
+import (
+	"context"
+	"errors"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/util/tcpmux"
+	"github.com/sunyihoo/frp/pkg/util/vhost"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPMuxGroupSNIRejectAuth(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	sniMuxer, err := tcpmux.NewSNITCPMuxer(l, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctl := NewTCPMuxGroupCtl(nil, sniMuxer, nil)
+	lbCfg := v1.LoadBalanceConfig{Group: "test"}
+
+	_, err = ctl.Listen(context.Background(), string(v1.TCPMultiplexerSNI), "a", lbCfg,
+		vhost.RouteConfig{Domain: "example.test", Username: "user", Password: "passwd"}, nil)
+	if err == nil {
+		t.Fatal("sni proxy with httpUser should be rejected")
+	}
+
+	// 非组代理直接在 muxer 上注册路由，同样不能要求认证
+	_, err = sniMuxer.Listen(context.Background(), &vhost.RouteConfig{Domain: "example.test", Username: "user"})
+	if err == nil {
+		t.Fatal("sni route with username should be rejected")
+	}
+
+	ln, err := ctl.Listen(context.Background(), string(v1.TCPMultiplexerSNI), "a", lbCfg,
+		vhost.RouteConfig{Domain: "example.test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+}
+
+func TestTCPMuxGroupRequireSameSourceIPFilter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	sniMuxer, err := tcpmux.NewSNITCPMuxer(l, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctl := NewTCPMuxGroupCtl(nil, sniMuxer, nil)
+	lbCfg := v1.LoadBalanceConfig{Group: "test"}
+	routeConfig := vhost.RouteConfig{Domain: "example.test", SourceIPFilterKey: "||cn|"}
+
+	ln, err := ctl.Listen(context.Background(), string(v1.TCPMultiplexerSNI), "a", lbCfg, routeConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	other := routeConfig
+	other.SourceIPFilterKey = ""
+	_, err = ctl.Listen(context.Background(), string(v1.TCPMultiplexerSNI), "b", lbCfg, other, nil)
+	if !errors.Is(err, ErrGroupParamsInvalid) {
+		t.Fatalf("got %v, want ErrGroupParamsInvalid", err)
+	}
+}
@@ -0,0 +1,358 @@
+package group
+
+import (
+	"fmt"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"github.com/sunyihoo/frp/server/ports"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultUDPSessionIdleTimeout = 60 * time.Second
+	// 每个成员等待读取的数据包数，超过后丢弃新的数据包
+	udpMemberQueueSize = 1024
+	udpMaxPacketSize   = 64 * 1024
+)
+
+type UDPGroupCtl struct {
+	groups map[string]*UDPGroup
+
+	// portManager 用于管理端口
+	portManager *ports.Manager
+	mu          sync.Mutex
+}
+
+func NewUDPGroupCtl(portManager *ports.Manager) *UDPGroupCtl {
+	return &UDPGroupCtl{
+		groups:      make(map[string]*UDPGroup),
+		portManager: portManager,
+	}
+}
+
+// Listen 是 UDPGroup 的包装器，如果组不存在，它将创建一个新组
+func (ugc *UDPGroupCtl) Listen(proxyName string, lbCfg v1.LoadBalanceConfig, addr string, port int) (l *UDPGroupListener, realPort int, err error) {
+	ugc.mu.Lock()
+	udpGroup, ok := ugc.groups[lbCfg.Group]
+	if !ok {
+		udpGroup = NewUDPGroup(ugc)
+		ugc.groups[lbCfg.Group] = udpGroup
+	}
+	ugc.mu.Unlock()
+
+	return udpGroup.Listen(proxyName, lbCfg, addr, port)
+}
+
+// RemoveGroup 删除组
+func (ugc *UDPGroupCtl) RemoveGroup(group string) {
+	ugc.mu.Lock()
+	defer ugc.mu.Unlock()
+	delete(ugc.groups, group)
+}
+
+type udpPacket struct {
+	data []byte
+	addr net.Addr
+}
+
+// udpFlow 是一个来源地址的会话，会话期间的数据包都交给同一个侦听器
+type udpFlow struct {
+	ln         *UDPGroupListener
+	lastActive time.Time
+}
+
+// UDPGroup 让多个 UDP 代理共享一个 UDP 端口。新来源地址的第一个数据包按负载均衡算法选择代理，
+// 之后该来源地址的数据包都发往同一个代理，直到会话空闲超时或该代理离开组。
+type UDPGroup struct {
+	group       string
+	groupKey    string
+	addr        string
+	port        int
+	realPort    int
+	idleTimeout time.Duration
+
+	balancer *balancer
+	udpConn  *net.UDPConn
+	lns      []*UDPGroupListener
+	// flows 按来源地址索引
+	flows   map[string]*udpFlow
+	closeCh chan struct{}
+	ctl     *UDPGroupCtl
+	mu      sync.Mutex
+}
+
+func NewUDPGroup(ctl *UDPGroupCtl) *UDPGroup {
+	return &UDPGroup{
+		lns:   make([]*UDPGroupListener, 0),
+		flows: make(map[string]*udpFlow),
+		ctl:   ctl,
+	}
+}
+
+// Listen 将在第一次侦听时创建一个新的 UDPGroupListener，如果是第一个侦听器，它将在真实端口上侦听
+// 否则，它将使用现有的 UDP 端口
+func (ug *UDPGroup) Listen(proxyName string, lbCfg v1.LoadBalanceConfig, addr string, port int) (ln *UDPGroupListener, realPort int, err error) {
+	ug.mu.Lock()
+	defer ug.mu.Unlock()
+
+	idleTimeout := time.Duration(lbCfg.SessionIdleTimeoutSeconds) * time.Second
+	if idleTimeout <= 0 {
+		idleTimeout = defaultUDPSessionIdleTimeout
+	}
+	if len(ug.lns) == 0 {
+		// 组中的第一个代理
+		if lbCfg.Algorithm == v1.LoadBalanceAlgorithmFailover {
+			err = fmt.Errorf("load balance algorithm [%s] is not supported by udp group", lbCfg.Algorithm)
+			return
+		}
+		b, errRet := newBalancer(lbCfg.Algorithm)
+		if errRet != nil {
+			err = errRet
+			return
+		}
+		realPort, err = ug.ctl.portManager.Acquire(proxyName, port)
+		if err != nil {
+			return
+		}
+		udpAddr, errRet := net.ResolveUDPAddr("udp", net.JoinHostPort(addr, strconv.Itoa(realPort)))
+		if errRet != nil {
+			ug.ctl.portManager.Release(realPort)
+			err = errRet
+			return
+		}
+		udpConn, errRet := net.ListenUDP("udp", udpAddr)
+		if errRet != nil {
+			ug.ctl.portManager.Release(realPort)
+			err = errRet
+			return
+		}
+		ln = newUDPGroupListener(proxyName, ug)
+
+		ug.group = lbCfg.Group
+		ug.groupKey = lbCfg.GroupKey
+		ug.addr = addr
+		ug.port = port
+		ug.realPort = realPort
+		ug.idleTimeout = idleTimeout
+		ug.balancer = b
+		ug.udpConn = udpConn
+		ug.closeCh = make(chan struct{})
+		ug.lns = append(ug.lns, ln)
+		b.add(ln.member)
+		go ug.worker(udpConn, b)
+		go ug.expireFlows(ug.closeCh, idleTimeout)
+	} else {
+		// 同一组中的地址和端口必须相等
+		if ug.group != lbCfg.Group || ug.addr != addr ||
+			ug.balancer.algorithm != algorithmOrDefault(lbCfg.Algorithm) || ug.idleTimeout != idleTimeout {
+			err = ErrGroupParamsInvalid
+			return
+		}
+		if ug.port != port {
+			err = ErrGroupDifferentPort
+			return
+		}
+		if ug.groupKey != lbCfg.GroupKey {
+			err = ErrGroupAuthFailed
+			return
+		}
+		for _, tmpLn := range ug.lns {
+			if tmpLn.member.name == proxyName {
+				err = ErrProxyRepeated
+				return
+			}
+		}
+		ln = newUDPGroupListener(proxyName, ug)
+		realPort = ug.realPort
+		ug.lns = append(ug.lns, ln)
+		ug.balancer.add(ln.member)
+	}
+	return
+}
+
+// worker 从 UDP 端口读取数据包，并交给来源地址所属会话的侦听器
+func (ug *UDPGroup) worker(udpConn *net.UDPConn, b *balancer) {
+	buf := make([]byte, udpMaxPacketSize)
+	for {
+		n, remoteAddr, err := udpConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		ln := ug.getFlowListener(remoteAddr, b)
+		if ln == nil {
+			continue
+		}
+
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		select {
+		case ln.packetCh <- &udpPacket{data: data, addr: remoteAddr}:
+		default:
+			log.Tracef("udp group [%s] proxy [%s] packet queue is full, drop packet from [%s]",
+				ug.group, ln.member.name, remoteAddr.String())
+		}
+	}
+}
+
+// getFlowListener 返回来源地址所属会话的侦听器，会话不存在时按负载均衡算法选择一个。
+func (ug *UDPGroup) getFlowListener(remoteAddr *net.UDPAddr, b *balancer) *UDPGroupListener {
+	key := remoteAddr.String()
+	now := time.Now()
+
+	ug.mu.Lock()
+	defer ug.mu.Unlock()
+	if flow, ok := ug.flows[key]; ok {
+		flow.lastActive = now
+		return flow.ln
+	}
+
+	m := b.pick(remoteAddr)
+	if m == nil {
+		return nil
+	}
+	for _, ln := range ug.lns {
+		if ln.member == m {
+			m.activeConns.Add(1)
+			ug.flows[key] = &udpFlow{ln: ln, lastActive: now}
+			return ln
+		}
+	}
+	return nil
+}
+
+// touchFlow 在代理发送响应时刷新会话的活跃时间
+func (ug *UDPGroup) touchFlow(ln *UDPGroupListener, addr net.Addr) {
+	ug.mu.Lock()
+	defer ug.mu.Unlock()
+	if flow, ok := ug.flows[addr.String()]; ok && flow.ln == ln {
+		flow.lastActive = time.Now()
+	}
+}
+
+func (ug *UDPGroup) expireFlows(closeCh chan struct{}, idleTimeout time.Duration) {
+	ticker := time.NewTicker(idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closeCh:
+			return
+		case now := <-ticker.C:
+			ug.mu.Lock()
+			for key, flow := range ug.flows {
+				if now.Sub(flow.lastActive) > idleTimeout {
+					flow.ln.member.activeConns.Add(-1)
+					delete(ug.flows, key)
+				}
+			}
+			ug.mu.Unlock()
+		}
+	}
+}
+
+// CloseListener 从组中移除侦听器，它的会话将在下一个数据包到达时重新分配给其他侦听器。
+// 最后一个侦听器关闭时释放真实端口
+func (ug *UDPGroup) CloseListener(ln *UDPGroupListener) {
+	ug.mu.Lock()
+	defer ug.mu.Unlock()
+	for i, tmpLn := range ug.lns {
+		if tmpLn == ln {
+			ug.lns = append(ug.lns[:i], ug.lns[i+1:]...)
+			ug.balancer.remove(ln.member)
+			break
+		}
+	}
+	for key, flow := range ug.flows {
+		if flow.ln == ln {
+			delete(ug.flows, key)
+		}
+	}
+	if len(ug.lns) == 0 {
+		close(ug.closeCh)
+		ug.udpConn.Close()
+		ug.ctl.portManager.Release(ug.realPort)
+		ug.ctl.RemoveGroup(ug.group)
+	}
+}
+
+// UDPGroupListener 是 UDP 组中一个代理的 net.PacketConn，ReadFrom 返回分配给该代理的会话的数据包，
+// WriteTo 通过组共享的 UDP 端口发送响应。
+type UDPGroupListener struct {
+	group  *UDPGroup
+	member *groupMember
+
+	packetCh     chan *udpPacket
+	closeCh      chan struct{}
+	closeOnce    sync.Once
+	readDeadline atomic.Value
+}
+
+func newUDPGroupListener(proxyName string, group *UDPGroup) *UDPGroupListener {
+	closeCh := make(chan struct{})
+	return &UDPGroupListener{
+		group:    group,
+		member:   newGroupMember(proxyName, 0, closeCh),
+		packetCh: make(chan *udpPacket, udpMemberQueueSize),
+		closeCh:  closeCh,
+	}
+}
+
+func (ln *UDPGroupListener) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	var timeoutCh <-chan time.Time
+	if deadline, ok := ln.readDeadline.Load().(time.Time); ok && !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	select {
+	case <-ln.closeCh:
+		return 0, nil, ErrListenerClosed
+	case <-timeoutCh:
+		return 0, nil, os.ErrDeadlineExceeded
+	case packet := <-ln.packetCh:
+		n = copy(p, packet.data)
+		return n, packet.addr, nil
+	}
+}
+
+func (ln *UDPGroupListener) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	select {
+	case <-ln.closeCh:
+		return 0, ErrListenerClosed
+	default:
+	}
+	ln.group.touchFlow(ln, addr)
+	return ln.group.udpConn.WriteTo(p, addr)
+}
+
+func (ln *UDPGroupListener) LocalAddr() net.Addr {
+	return ln.group.udpConn.LocalAddr()
+}
+
+func (ln *UDPGroupListener) SetDeadline(t time.Time) error {
+	return ln.SetReadDeadline(t)
+}
+
+func (ln *UDPGroupListener) SetReadDeadline(t time.Time) error {
+	ln.readDeadline.Store(t)
+	return nil
+}
+
+// SetWriteDeadline 不做任何事情，UDP 端口由组内的代理共享，不能为单个代理设置写超时
+func (ln *UDPGroupListener) SetWriteDeadline(_ time.Time) error {
+	return nil
+}
+
+// Close 关闭侦听器并将自身从 UDPGroup 中移除
+func (ln *UDPGroupListener) Close() error {
+	ln.closeOnce.Do(func() {
+		close(ln.closeCh)
+		ln.group.CloseListener(ln)
+	})
+	return nil
+}
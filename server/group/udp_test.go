@@ -0,0 +1,224 @@
+package group
+
+import (
+	"errors"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/server/ports"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testUDPPacket struct {
+	ln   int
+	data string
+	addr net.Addr
+}
+
+// newTestUDPGroup 在随机端口上创建一个 UDP 组，names 中的每个代理都加入该组
+func newTestUDPGroup(t *testing.T, lbCfg v1.LoadBalanceConfig, names ...string) (*UDPGroupCtl, []*UDPGroupListener, int) {
+	t.Helper()
+	ctl := NewUDPGroupCtl(ports.NewManager("udp", "127.0.0.1", nil))
+	lns := make([]*UDPGroupListener, 0, len(names))
+	port := 0
+	for _, name := range names {
+		ln, realPort, err := ctl.Listen(name, lbCfg, "127.0.0.1", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port != 0 && realPort != port {
+			t.Fatalf("proxy %s got port %d, want the shared port %d", name, realPort, port)
+		}
+		port = realPort
+		lns = append(lns, ln)
+	}
+	t.Cleanup(func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	})
+	return ctl, lns, port
+}
+
+// readTestUDPPackets 从每个侦听器读取数据包，ln 为收到数据包的侦听器下标
+func readTestUDPPackets(lns []*UDPGroupListener) <-chan testUDPPacket {
+	ch := make(chan testUDPPacket, 16)
+	for i, ln := range lns {
+		go func(i int, ln *UDPGroupListener) {
+			buf := make([]byte, 1024)
+			for {
+				n, addr, err := ln.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				ch <- testUDPPacket{ln: i, data: string(buf[:n]), addr: addr}
+			}
+		}(i, ln)
+	}
+	return ch
+}
+
+func dialTestUDPGroup(t *testing.T, port int) *net.UDPConn {
+	t.Helper()
+	raddr, _ := net.ResolveUDPAddr("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// sendTestUDPPacket 发送数据包并返回收到它的侦听器
+func sendTestUDPPacket(t *testing.T, conn *net.UDPConn, ch <-chan testUDPPacket, data string) testUDPPacket {
+	t.Helper()
+	if _, err := conn.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-ch:
+		if p.data != data {
+			t.Fatalf("got packet %q, want %q", p.data, data)
+		}
+		return p
+	case <-time.After(2 * time.Second):
+		t.Fatalf("packet %q was not delivered to any listener", data)
+	}
+	return testUDPPacket{}
+}
+
+func TestUDPGroupSourceAffinity(t *testing.T) {
+	_, lns, port := newTestUDPGroup(t, v1.LoadBalanceConfig{Group: "test"}, "a", "b")
+	ch := readTestUDPPackets(lns)
+	c1 := dialTestUDPGroup(t, port)
+	c2 := dialTestUDPGroup(t, port)
+
+	// 同一来源地址的数据包都发往第一个数据包选择的侦听器
+	first := sendTestUDPPacket(t, c1, ch, "c1-0")
+	for _, data := range []string{"c1-1", "c1-2"} {
+		if p := sendTestUDPPacket(t, c1, ch, data); p.ln != first.ln {
+			t.Fatalf("packet %s went to listener %d, want %d", data, p.ln, first.ln)
+		}
+	}
+	if first.addr.String() != c1.LocalAddr().String() {
+		t.Fatalf("got source %s, want %s", first.addr, c1.LocalAddr())
+	}
+
+	// 新的来源地址按轮询选择另一个侦听器
+	second := sendTestUDPPacket(t, c2, ch, "c2-0")
+	if second.ln == first.ln {
+		t.Fatal("second source should go to the other listener")
+	}
+	for i, ln := range lns {
+		if got := ln.member.activeConns.Load(); got != 1 {
+			t.Errorf("listener %d has %d active flows, want 1", i, got)
+		}
+	}
+
+	// 响应通过共享端口发回来源地址
+	if _, err := lns[first.ln].WriteTo([]byte("pong"), first.addr); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	_ = c1.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := c1.Read(buf)
+	if err != nil || string(buf[:n]) != "pong" {
+		t.Fatalf("got reply %q %v, want pong", buf[:n], err)
+	}
+}
+
+func TestUDPGroupIdleExpiry(t *testing.T) {
+	_, lns, port := newTestUDPGroup(t, v1.LoadBalanceConfig{Group: "test", SessionIdleTimeoutSeconds: 1}, "a", "b")
+	ch := readTestUDPPackets(lns)
+	c1 := dialTestUDPGroup(t, port)
+
+	first := sendTestUDPPacket(t, c1, ch, "c1-0")
+	member := lns[first.ln].member
+	if got := member.activeConns.Load(); got != 1 {
+		t.Fatalf("got %d active flows, want 1", got)
+	}
+
+	ug := lns[0].group
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ug.mu.Lock()
+		n := len(ug.flows)
+		ug.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("idle flow was not expired")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if got := member.activeConns.Load(); got != 0 {
+		t.Fatalf("got %d active flows after expiry, want 0", got)
+	}
+
+	// 会话过期后重新按轮询选择侦听器
+	if p := sendTestUDPPacket(t, c1, ch, "c1-1"); p.ln == first.ln {
+		t.Fatal("packet after expiry should start a new flow on the next listener")
+	}
+}
+
+func TestUDPGroupReassignAfterCloseListener(t *testing.T) {
+	_, lns, port := newTestUDPGroup(t, v1.LoadBalanceConfig{Group: "test"}, "a", "b")
+	ch := readTestUDPPackets(lns)
+	c1 := dialTestUDPGroup(t, port)
+
+	first := sendTestUDPPacket(t, c1, ch, "c1-0")
+	lns[first.ln].Close()
+	if _, _, err := lns[first.ln].ReadFrom(make([]byte, 1)); !errors.Is(err, ErrListenerClosed) {
+		t.Fatalf("got error %v reading a closed listener, want %v", err, ErrListenerClosed)
+	}
+
+	// 会话随侦听器一起删除，之后的数据包交给剩余的侦听器
+	for _, data := range []string{"c1-1", "c1-2"} {
+		if p := sendTestUDPPacket(t, c1, ch, data); p.ln == first.ln {
+			t.Fatalf("packet %s went to the closed listener", data)
+		}
+	}
+}
+
+func TestUDPGroupReleasePort(t *testing.T) {
+	ctl, lns, port := newTestUDPGroup(t, v1.LoadBalanceConfig{Group: "test"}, "a", "b")
+	pm := ctl.portManager
+
+	if _, err := pm.Acquire("other", port); !errors.Is(err, ports.ErrPortAlreadyUsed) {
+		t.Fatalf("got error %v acquiring the group port, want %v", err, ports.ErrPortAlreadyUsed)
+	}
+
+	lns[0].Close()
+	ctl.mu.Lock()
+	_, ok := ctl.groups["test"]
+	ctl.mu.Unlock()
+	if !ok {
+		t.Fatal("group should remain while it has members")
+	}
+	if _, err := pm.Acquire("other", port); err == nil {
+		t.Fatal("port should stay in use while the group has members")
+	}
+
+	// 最后一个成员离开后删除组并释放端口
+	lns[1].Close()
+	ctl.mu.Lock()
+	_, ok = ctl.groups["test"]
+	ctl.mu.Unlock()
+	if ok {
+		t.Fatal("group should be removed after the last member leaves")
+	}
+	realPort, err := pm.Acquire("other", port)
+	if err != nil {
+		t.Fatalf("port should be released after the last member leaves: %v", err)
+	}
+	pm.Release(realPort)
+
+	// 端口释放后可以重新创建同名的组
+	ln, _, err := ctl.Listen("c", v1.LoadBalanceConfig{Group: "test"}, "127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+}
@@ -13,8 +13,21 @@ type ServerMetrics interface {
 	CloseConnection(name string, proxyType string)
 	AddTrafficIn(name string, proxyType string, trafficBytes int64)
 	AddTrafficOut(name string, proxyType string, trafficBytes int64)
+	// RejectConnection 记录在请求工作连接之前被拒绝的用户连接，reason 为 RejectReasonXxx
+	RejectConnection(name string, proxyType string, reason string)
+	// AddCountryConnection 记录来自 country 的用户连接，仅在启用 GeoIP 时调用，未知国家为空字符串
+	AddCountryConnection(name string, proxyType string, country string)
 }
 
+const (
+	// RejectReasonSourceIP 表示来源 IP 不在允许列表中或在拒绝列表中
+	RejectReasonSourceIP = "source_ip"
+	// RejectReasonCountry 表示来源 IP 所属国家不在允许列表中或在拒绝列表中
+	RejectReasonCountry = "country"
+	// RejectReasonQuota 表示代理或其所属用户的流量配额已超出，且处理方式为 "reject"
+	RejectReasonQuota = "quota"
+)
+
 var Server ServerMetrics = noopServerMetrics{}
 
 var registerMetrics sync.Once
@@ -27,11 +40,13 @@ func Register(m ServerMetrics) {
 
 type noopServerMetrics struct{}
 
-func (noopServerMetrics) NewClient()                          {}
-func (noopServerMetrics) CloseClient()                        {}
-func (noopServerMetrics) NewProxy(string, string)             {}
-func (noopServerMetrics) CloseProxy(string, string)           {}
-func (noopServerMetrics) OpenConnection(string, string)       {}
-func (noopServerMetrics) CloseConnection(string, string)      {}
-func (noopServerMetrics) AddTrafficIn(string, string, int64)  {}
-func (noopServerMetrics) AddTrafficOut(string, string, int64) {}
+func (noopServerMetrics) NewClient()                                  {}
+func (noopServerMetrics) CloseClient()                                {}
+func (noopServerMetrics) NewProxy(string, string)                     {}
+func (noopServerMetrics) CloseProxy(string, string)                   {}
+func (noopServerMetrics) OpenConnection(string, string)               {}
+func (noopServerMetrics) CloseConnection(string, string)              {}
+func (noopServerMetrics) AddTrafficIn(string, string, int64)          {}
+func (noopServerMetrics) AddTrafficOut(string, string, int64)         {}
+func (noopServerMetrics) RejectConnection(string, string, string)     {}
+func (noopServerMetrics) AddCountryConnection(string, string, string) {}
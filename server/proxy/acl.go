@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"github.com/sunyihoo/frp/pkg/msg"
+	"github.com/sunyihoo/frp/pkg/util/geoip"
+	netpkg "github.com/sunyihoo/frp/pkg/util/net"
+	"github.com/sunyihoo/frp/server/controller"
+	"github.com/sunyihoo/frp/server/metrics"
+	"sort"
+	"strings"
+)
+
+// SourceIPFilterKey 返回代理请求中来源 IP 和国家列表的规范化表示，列表相同的代理具有相同的 key，
+// 用于 http 和 tcpmux 组检查成员的访问控制是否一致。
+func SourceIPFilterKey(pxyMsg *msg.NewProxy) string {
+	lists := [][]string{pxyMsg.AllowSourceIPs, pxyMsg.DenySourceIPs, pxyMsg.AllowCountries, pxyMsg.DenyCountries}
+	parts := make([]string, 0, len(lists))
+	for _, list := range lists {
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			items = append(items, strings.ToLower(strings.TrimSpace(item)))
+		}
+		sort.Strings(items)
+		parts = append(parts, strings.Join(items, ","))
+	}
+	return strings.Join(parts, "|")
+}
+
+// NewSourceIPFilter 组合 frps 和代理请求的来源 IP 列表以及国家列表，被拒绝的连接计入指标。
+// frps 的列表总是先检查，因此客户端只能进一步收紧访问范围。启用 GeoIP 时，允许的连接按来源国家计入指标。
+// 没有任何列表且未启用 GeoIP 时返回 nil。
+// tcp 和 udp 代理用它包装侦听器，http、https 和 tcpmux 代理将它和 SourceIPFilterKey 一起设置到 vhost.RouteConfig 中。
+func NewSourceIPFilter(rc *controller.ResourceController, pxyMsg *msg.NewProxy) (netpkg.SourceIPFilterFunc, error) {
+	ipACL, err := netpkg.NewIPACL(pxyMsg.AllowSourceIPs, pxyMsg.DenySourceIPs, rc.ProxySourceIPACL)
+	if err != nil {
+		return nil, err
+	}
+	// 未启用 GeoIP 时忽略国家列表
+	var countryACL *geoip.CountryACL
+	if rc.GeoIPDatabase != nil {
+		countryACL = geoip.NewCountryACL(pxyMsg.AllowCountries, pxyMsg.DenyCountries, rc.ProxyCountryACL)
+	}
+	if ipACL == nil && rc.GeoIPDatabase == nil {
+		return nil, nil
+	}
+
+	name, proxyType := pxyMsg.ProxyName, pxyMsg.ProxyType
+	return func(remoteAddr string) bool {
+		if !ipACL.AllowedAddr(remoteAddr) {
+			metrics.Server.RejectConnection(name, proxyType, metrics.RejectReasonSourceIP)
+			return false
+		}
+		if rc.GeoIPDatabase == nil {
+			return true
+		}
+		country := rc.GeoIPDatabase.Country(remoteAddr)
+		if !countryACL.Allowed(country) {
+			metrics.Server.RejectConnection(name, proxyType, metrics.RejectReasonCountry)
+			return false
+		}
+		metrics.Server.AddCountryConnection(name, proxyType, country)
+		return true
+	}, nil
+}
@@ -0,0 +1,20 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/sunyihoo/frp/pkg/msg"
+	"github.com/sunyihoo/frp/pkg/util/vhost"
+	"github.com/sunyihoo/frp/server/controller"
+)
+
+// GetErrorPages 返回 http 代理引用的错误页面模板组，代理没有引用时返回 nil。
+func GetErrorPages(rc *controller.ResourceController, pxyMsg *msg.NewProxy) (*vhost.ErrorPages, error) {
+	if pxyMsg.ErrorPages == "" {
+		return nil, nil
+	}
+	pages, ok := rc.VhostHTTPErrorPages.Get(pxyMsg.ErrorPages)
+	if !ok {
+		return nil, fmt.Errorf("proxy [%s] references error pages [%s] which is not configured in frps", pxyMsg.ProxyName, pxyMsg.ErrorPages)
+	}
+	return pages, nil
+}
@@ -0,0 +1,20 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/sunyihoo/frp/pkg/msg"
+	netpkg "github.com/sunyihoo/frp/pkg/util/net"
+	"github.com/sunyihoo/frp/server/controller"
+)
+
+// GetHTPasswd 返回 http 代理引用的 htpasswd 用户列表，代理没有引用时返回 nil。
+func GetHTPasswd(rc *controller.ResourceController, pxyMsg *msg.NewProxy) (*netpkg.Htpasswd, error) {
+	if pxyMsg.HTPasswd == "" {
+		return nil, nil
+	}
+	h, ok := rc.HTPasswdFiles[pxyMsg.HTPasswd]
+	if !ok {
+		return nil, fmt.Errorf("proxy [%s] references htpasswd [%s] which is not configured in frps", pxyMsg.ProxyName, pxyMsg.HTPasswd)
+	}
+	return h, nil
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"fmt"
+	libio "github.com/fatedier/golib/io"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/util/limit"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	netpkg "github.com/sunyihoo/frp/pkg/util/net"
+	"github.com/sunyihoo/frp/pkg/util/vhost"
+	"github.com/sunyihoo/frp/server/metrics"
+	"golang.org/x/time/rate"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterProxyFactory(reflect.TypeOf(&v1.HTTPProxyConfig{}), NewHTTPProxy)
+}
+
+type HTTPProxy struct {
+	*BaseProxy
+	cfg *v1.HTTPProxyConfig
+
+	closeFuncs []func()
+}
+
+func NewHTTPProxy(baseProxy *BaseProxy) Proxy {
+	unwrapped, ok := baseProxy.GetConfigurer().(*v1.HTTPProxyConfig)
+	if !ok {
+		return nil
+	}
+	return &HTTPProxy{
+		BaseProxy: baseProxy,
+		cfg:       unwrapped,
+	}
+}
+
+func (pxy *HTTPProxy) Run() (remoteAddr string, err error) {
+	routeConfig := vhost.RouteConfig{
+		RewriteHost:     pxy.cfg.HostHeaderRewrite,
+		RouteByHTTPUser: pxy.cfg.RouteByHTTPUser,
+		Headers:         pxy.cfg.RequestHeaders.Set,
+		ResponseHeaders: pxy.cfg.ResponseHeaders.Set,
+		Username:        pxy.cfg.HTTPUser,
+		Password:        pxy.cfg.HTTPPassword,
+		BackendHTTP2:    pxy.cfg.BackendHTTP2,
+		Priority:        pxy.cfg.RoutePriority,
+		CreateConnFn:    pxy.GetRealConn,
+		ProxyName:       pxy.name,
+		RunID:           pxy.loginMsg.RunID,
+
+		SourceIPFilterFn:  pxy.sourceIPFilter,
+		SourceIPFilterKey: SourceIPFilterKey(pxy.pxyMsg),
+	}
+	for _, m := range pxy.cfg.RouteMatches {
+		routeConfig.Matches = append(routeConfig.Matches, vhost.RouteMatch{Type: m.Type, Name: m.Name, Value: m.Value, Regex: m.Regex})
+	}
+
+	locations := pxy.cfg.Locations
+	if len(locations) == 0 {
+		locations = []string{""}
+	}
+
+	defer func() {
+		if err != nil {
+			pxy.Close()
+		}
+	}()
+
+	if routeConfig.OIDCAuth, err = NewHTTPOIDCAuth(pxy.rc, pxy.pxyMsg); err != nil {
+		return
+	}
+	if routeConfig.HTPasswd, err = GetHTPasswd(pxy.rc, pxy.pxyMsg); err != nil {
+		return
+	}
+	if routeConfig.ErrorPages, err = GetErrorPages(pxy.rc, pxy.pxyMsg); err != nil {
+		return
+	}
+
+	domains := make([]string, 0, len(pxy.cfg.CustomDomains)+1)
+	for _, domain := range pxy.cfg.CustomDomains {
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if pxy.cfg.SubDomain != "" {
+		domains = append(domains, pxy.cfg.SubDomain+"."+pxy.serverCfg.SubDomainHost)
+	}
+
+	addrs := make([]string, 0)
+	for _, domain := range domains {
+		routeConfig.Domain = domain
+		// 同一域名的各个 location 共用请求限速
+		routeConfig.RequestLimiter = NewHTTPRequestLimiter(pxy.rc, pxy.pxyMsg, domain)
+		for _, location := range locations {
+			routeConfig.Location = location
+			tmpRouteConfig := routeConfig
+
+			if pxy.cfg.LoadBalancer.Group != "" {
+				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.LoadBalancer, routeConfig)
+				if err != nil {
+					return
+				}
+				pxy.closeFuncs = append(pxy.closeFuncs, func() {
+					pxy.rc.HTTPGroupCtl.UnRegister(pxy.name, pxy.cfg.LoadBalancer.Group)
+				})
+			} else {
+				err = pxy.rc.HTTPReverseProxy.Register(routeConfig)
+				if err != nil {
+					return
+				}
+				pxy.closeFuncs = append(pxy.closeFuncs, func() {
+					pxy.rc.HTTPReverseProxy.UnRegister(tmpRouteConfig)
+				})
+			}
+			addrs = append(addrs, net.JoinHostPort(domain, strconv.Itoa(pxy.serverCfg.VhostHTTPPort)))
+			log.Infof("[%s] http proxy listen for host [%s] location [%s] group [%s], routeByHTTPUser [%s]",
+				pxy.name, domain, location, pxy.cfg.LoadBalancer.Group, pxy.cfg.RouteByHTTPUser)
+		}
+	}
+	remoteAddr = strings.Join(addrs, ",")
+	return
+}
+
+// GetRealConn 为 vhost HTTP 反向代理创建到后端的连接。与 HandleUserTCPConnection 相同，
+// 流量配额已超出时按配额的处理方式拒绝或限速，连接关闭时流量计入代理的统计和配额。
+func (pxy *HTTPProxy) GetRealConn(remoteAddr string) (workConn net.Conn, err error) {
+	name := pxy.GetName()
+	proxyType := pxy.cfg.Type
+
+	var quotaLimiters []*rate.Limiter
+	if pxy.rc.QuotaManager != nil {
+		if quotaLimiters, err = pxy.rc.QuotaManager.CheckUserConn(name); err != nil {
+			log.Infof("[%s] reject http request from [%s]: %v", name, remoteAddr, err)
+			metrics.Server.RejectConnection(name, proxyType, metrics.RejectReasonQuota)
+			return nil, fmt.Errorf("%w: %v", vhost.ErrNoWorkConn, err)
+		}
+	}
+
+	// remoteAddr 只用于 StartWorkConn 消息，解析失败时不影响转发
+	var src net.Addr
+	if rAddr, err := net.ResolveTCPAddr("tcp", remoteAddr); err == nil {
+		src = rAddr
+	}
+	tmpConn, err := pxy.openWorkConn(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var rwc io.ReadWriteCloser = tmpConn
+	for _, l := range append([]*rate.Limiter{pxy.GetLimiter()}, quotaLimiters...) {
+		if l == nil {
+			continue
+		}
+		under := rwc
+		rwc = libio.WrapReadWriteCloser(limit.NewReader(under, l), limit.NewWriter(under, l), under.Close)
+	}
+
+	workConn = netpkg.WrapReadWriteCloserToConn(rwc, tmpConn)
+	workConn = netpkg.WrapStatsConn(workConn, pxy.updateStatsAfterClosedConn)
+	metrics.Server.OpenConnection(name, proxyType)
+	return
+}
+
+func (pxy *HTTPProxy) updateStatsAfterClosedConn(totalRead, totalWrite int64) {
+	name := pxy.GetName()
+	proxyType := pxy.cfg.Type
+	metrics.Server.CloseConnection(name, proxyType)
+	metrics.Server.AddTrafficIn(name, proxyType, totalWrite)
+	metrics.Server.AddTrafficOut(name, proxyType, totalRead)
+}
+
+func (pxy *HTTPProxy) Close() {
+	pxy.BaseProxy.Close()
+	for _, closeFn := range pxy.closeFuncs {
+		closeFn()
+	}
+	pxy.closeFuncs = nil
+}
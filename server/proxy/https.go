@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"github.com/sunyihoo/frp/pkg/util/vhost"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterProxyFactory(reflect.TypeOf(&v1.HTTPSProxyConfig{}), NewHTTPSProxy)
+}
+
+type HTTPSProxy struct {
+	*BaseProxy
+	cfg *v1.HTTPSProxyConfig
+}
+
+func NewHTTPSProxy(baseProxy *BaseProxy) Proxy {
+	unwrapped, ok := baseProxy.GetConfigurer().(*v1.HTTPSProxyConfig)
+	if !ok {
+		return nil
+	}
+	return &HTTPSProxy{
+		BaseProxy: baseProxy,
+		cfg:       unwrapped,
+	}
+}
+
+func (pxy *HTTPSProxy) Run() (remoteAddr string, err error) {
+	routeConfig := &vhost.RouteConfig{
+		ProxyName:         pxy.name,
+		RunID:             pxy.loginMsg.RunID,
+		SourceIPFilterFn:  pxy.sourceIPFilter,
+		SourceIPFilterKey: SourceIPFilterKey(pxy.pxyMsg),
+	}
+
+	defer func() {
+		if err != nil {
+			pxy.Close()
+		}
+	}()
+
+	domains := make([]string, 0, len(pxy.cfg.CustomDomains)+1)
+	for _, domain := range pxy.cfg.CustomDomains {
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if pxy.cfg.SubDomain != "" {
+		domains = append(domains, pxy.cfg.SubDomain+"."+pxy.serverCfg.SubDomainHost)
+	}
+
+	addrs := make([]string, 0)
+	for _, domain := range domains {
+		routeConfig.Domain = domain
+		l, errRet := pxy.rc.VhostHTTPSMuxer.Listen(pxy.ctx, routeConfig)
+		if errRet != nil {
+			err = errRet
+			return
+		}
+		log.Infof("[%s] https proxy listen for host [%s]", pxy.name, domain)
+		pxy.listeners = append(pxy.listeners, l)
+		addrs = append(addrs, net.JoinHostPort(domain, strconv.Itoa(pxy.serverCfg.VhostHTTPSPort)))
+	}
+
+	pxy.startCommonTCPListenersHandler(pxy)
+	remoteAddr = strings.Join(addrs, ",")
+	return
+}
@@ -0,0 +1,24 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/sunyihoo/frp/pkg/msg"
+	"github.com/sunyihoo/frp/pkg/util/vhost"
+	"github.com/sunyihoo/frp/server/controller"
+)
+
+// NewHTTPOIDCAuth 为请求了 OIDC 登录的 http 代理创建 vhost.OIDCAuth，代理没有请求时返回 nil。
+// frps 没有配置 vhostHTTPOIDC 时返回错误，而不是在没有登录保护的情况下暴露代理。
+func NewHTTPOIDCAuth(rc *controller.ResourceController, pxyMsg *msg.NewProxy) (*vhost.OIDCAuth, error) {
+	if pxyMsg.OIDC == nil {
+		return nil, nil
+	}
+	if rc.VhostHTTPOIDCProvider == nil {
+		return nil, fmt.Errorf("proxy [%s] requires oidc login but vhostHTTPOIDC is not configured in frps", pxyMsg.ProxyName)
+	}
+	return vhost.NewOIDCAuth(rc.VhostHTTPOIDCProvider, vhost.OIDCPolicy{
+		AllowedEmailDomains: pxyMsg.OIDC.AllowedEmailDomains,
+		AllowedEmails:       pxyMsg.OIDC.AllowedEmails,
+		AllowedGroups:       pxyMsg.OIDC.AllowedGroups,
+	}), nil
+}
@@ -2,13 +2,25 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	libio "github.com/fatedier/golib/io"
+	"github.com/sunyihoo/frp/pkg/config/types"
 	v1 "github.com/sunyihoo/frp/pkg/config/v1"
 	"github.com/sunyihoo/frp/pkg/msg"
 	plugin "github.com/sunyihoo/frp/pkg/plugin/server"
+	"github.com/sunyihoo/frp/pkg/util/limit"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	netpkg "github.com/sunyihoo/frp/pkg/util/net"
+	"github.com/sunyihoo/frp/pkg/webhook"
 	"github.com/sunyihoo/frp/server/controller"
+	"github.com/sunyihoo/frp/server/metrics"
 	"golang.org/x/time/rate"
+	"io"
 	"net"
+	"reflect"
 	"sync"
+	"time"
 )
 
 type Proxy interface {
@@ -25,6 +37,215 @@ type Proxy interface {
 	Close()
 }
 
+type GetWorkConnFn func() (net.Conn, error)
+
+type BaseProxy struct {
+	name          string
+	rc            *controller.ResourceController
+	listeners     []net.Listener
+	usedPortsNum  int
+	poolCount     int
+	getWorkConnFn GetWorkConnFn
+	serverCfg     *v1.ServerConfig
+	limiter       *rate.Limiter
+	userInfo      plugin.UserInfo
+	loginMsg      *msg.Login
+	pxyMsg        *msg.NewProxy
+	configurer    v1.ProxyConfigurer
+
+	// 组合 frps 和代理请求的来源 IP 及国家列表，为 nil 时不限制
+	sourceIPFilter netpkg.SourceIPFilterFunc
+
+	mu  sync.RWMutex
+	ctx context.Context
+}
+
+func (pxy *BaseProxy) GetName() string {
+	return pxy.name
+}
+
+func (pxy *BaseProxy) Context() context.Context {
+	return pxy.ctx
+}
+
+func (pxy *BaseProxy) GetUsePortsNum() int {
+	return pxy.usedPortsNum
+}
+
+func (pxy *BaseProxy) GetResourceController() *controller.ResourceController {
+	return pxy.rc
+}
+
+func (pxy *BaseProxy) GetUserInfo() plugin.UserInfo {
+	return pxy.userInfo
+}
+
+func (pxy *BaseProxy) GetLoginMsg() *msg.Login {
+	return pxy.loginMsg
+}
+
+func (pxy *BaseProxy) GetLimiter() *rate.Limiter {
+	return pxy.limiter
+}
+
+func (pxy *BaseProxy) GetConfigurer() v1.ProxyConfigurer {
+	return pxy.configurer
+}
+
+func (pxy *BaseProxy) Close() {
+	log.Infof("[%s] proxy closing", pxy.name)
+	for _, l := range pxy.listeners {
+		l.Close()
+	}
+}
+
+// GetWorkConnFromPool 尝试从连接池中获取一个工作连接并发送 StartWorkConn 消息，发送失败时换一个连接重试。
+func (pxy *BaseProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn net.Conn, err error) {
+	for i := 0; i < pxy.poolCount+1; i++ {
+		if workConn, err = pxy.getWorkConnFn(); err != nil {
+			log.Warnf("[%s] failed to get work connection: %v", pxy.name, err)
+			return
+		}
+		log.Debugf("[%s] get a new work connection: [%s]", pxy.name, workConn.RemoteAddr())
+
+		var srcAddr, srcPort, dstAddr, dstPort string
+		if src != nil {
+			srcAddr, srcPort, _ = net.SplitHostPort(src.String())
+		}
+		if dst != nil {
+			dstAddr, dstPort, _ = net.SplitHostPort(dst.String())
+		}
+		err = msg.WriteMsg(workConn, &msg.StartWorkConn{
+			ProxyName: pxy.name,
+			SrcAddr:   srcAddr,
+			SrcPort:   srcPort,
+			DstAddr:   dstAddr,
+			DstPort:   dstPort,
+		})
+		if err == nil {
+			return
+		}
+		log.Warnf("[%s] failed to send message to work connection from pool: %v, times: %d", pxy.name, err, i)
+		workConn.Close()
+	}
+	log.Errorf("[%s] try to get work connection failed in the end", pxy.name)
+	return
+}
+
+// openWorkConn 获取一个工作连接，并按代理的配置进行加密和压缩，用于 frps 需要自己读写后端数据的场景，
+// 例如 http 代理转发请求和组的健康检查。
+func (pxy *BaseProxy) openWorkConn(src net.Addr) (net.Conn, error) {
+	workConn, err := pxy.GetWorkConnFromPool(src, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := pxy.configurer.GetBaseConfig()
+	var rwc io.ReadWriteCloser = workConn
+	if cfg.Transport.UseEncryption {
+		rwc, err = libio.WithEncryption(rwc, []byte(pxy.serverCfg.Auth.Token))
+		if err != nil {
+			workConn.Close()
+			return nil, fmt.Errorf("create encryption stream error: %v", err)
+		}
+	}
+	if cfg.Transport.UseCompression {
+		rwc = libio.WithCompression(rwc)
+	}
+	return netpkg.WrapReadWriteCloserToConn(rwc, workConn), nil
+}
+
+// startCommonTCPListenersHandler 为代理的每个侦听器启动一个协程，接受的用户连接交给 HandleUserTCPConnection 处理。
+// p 是嵌入了此 BaseProxy 的代理。
+func (pxy *BaseProxy) startCommonTCPListenersHandler(p Proxy) {
+	for _, listener := range pxy.listeners {
+		go func(l net.Listener) {
+			var tempDelay time.Duration
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					if err, ok := err.(interface{ Temporary() bool }); ok && err.Temporary() {
+						if tempDelay == 0 {
+							tempDelay = 5 * time.Millisecond
+						} else {
+							tempDelay *= 2
+						}
+						if maxTime := 1 * time.Second; tempDelay > maxTime {
+							tempDelay = maxTime
+						}
+						log.Infof("[%s] met temporary error: %s, sleep for %s ...", pxy.name, err, tempDelay)
+						time.Sleep(tempDelay)
+						continue
+					}
+					log.Warnf("[%s] listener is closed: %s", pxy.name, err)
+					return
+				}
+				log.Infof("[%s] get a user connection [%s]", pxy.name, c.RemoteAddr())
+				go HandleUserTCPConnection(p, c, pxy.serverCfg)
+			}
+		}(listener)
+	}
+}
+
+type Options struct {
+	UserInfo           plugin.UserInfo
+	LoginMsg           *msg.Login
+	PoolCount          int
+	ResourceController *controller.ResourceController
+	GetWorkConnFn      GetWorkConnFn
+	Configurer         v1.ProxyConfigurer
+	// ProxyMsg 是创建代理的 NewProxy 消息，访问控制、限速等只在 frps 端生效的设置从中读取
+	ProxyMsg  *msg.NewProxy
+	ServerCfg *v1.ServerConfig
+}
+
+var proxyFactoryRegistry = map[reflect.Type]func(*BaseProxy) Proxy{}
+
+func RegisterProxyFactory(proxyConfType reflect.Type, factory func(*BaseProxy) Proxy) {
+	proxyFactoryRegistry[proxyConfType] = factory
+}
+
+// NewProxy 按代理配置的类型创建代理，调用方需要调用返回代理的 Run 方法。
+func NewProxy(ctx context.Context, options *Options) (pxy Proxy, err error) {
+	configurer := options.Configurer
+	cfg := configurer.GetBaseConfig()
+
+	var limiter *rate.Limiter
+	limitBytes := cfg.Transport.BandwidthLimit.Bytes()
+	if limitBytes > 0 && cfg.Transport.BandwidthLimitMode == types.BandwidthLimitModeServer {
+		limiter = rate.NewLimiter(rate.Limit(float64(limitBytes)), int(limitBytes))
+	}
+
+	basePxy := &BaseProxy{
+		name:          cfg.Name,
+		rc:            options.ResourceController,
+		listeners:     make([]net.Listener, 0),
+		poolCount:     options.PoolCount,
+		getWorkConnFn: options.GetWorkConnFn,
+		serverCfg:     options.ServerCfg,
+		limiter:       limiter,
+		userInfo:      options.UserInfo,
+		loginMsg:      options.LoginMsg,
+		pxyMsg:        options.ProxyMsg,
+		configurer:    configurer,
+		ctx:           ctx,
+	}
+
+	if basePxy.sourceIPFilter, err = NewSourceIPFilter(options.ResourceController, options.ProxyMsg); err != nil {
+		return nil, err
+	}
+
+	factory := proxyFactoryRegistry[reflect.TypeOf(configurer)]
+	if factory == nil {
+		return nil, fmt.Errorf("proxy type [%s] is not supported", cfg.Type)
+	}
+	pxy = factory(basePxy)
+	if pxy == nil {
+		return nil, fmt.Errorf("proxy [%s] is not created", cfg.Name)
+	}
+	return pxy, nil
+}
+
 type Manager struct {
 	// 按代理名称索引的代理
 	pxys map[string]Proxy
@@ -37,3 +258,140 @@ func NewManager() *Manager {
 		pxys: make(map[string]Proxy),
 	}
 }
+
+// Add 在代理注册成功后调用，代理的流量从此计入其所属用户的配额，并通知订阅了 ProxyOpen 的 webhook。
+func (pm *Manager) Add(name string, pxy Proxy) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if _, ok := pm.pxys[name]; ok {
+		return fmt.Errorf("proxy name [%s] is already in use", name)
+	}
+	pm.pxys[name] = pxy
+	if rc := pxy.GetResourceController(); rc != nil {
+		if rc.QuotaManager != nil {
+			rc.QuotaManager.RegisterProxy(name, pxy.GetLoginMsg().User)
+		}
+		if rc.WebhookManager != nil {
+			rc.WebhookManager.Notify(webhook.EventProxyOpen, newProxyContent(pxy))
+		}
+	}
+	return nil
+}
+
+func (pm *Manager) Exist(name string) bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	_, ok := pm.pxys[name]
+	return ok
+}
+
+// Del 在代理关闭时调用，并通知订阅了 ProxyClose 的 webhook。
+func (pm *Manager) Del(name string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pxy, ok := pm.pxys[name]
+	if !ok {
+		return
+	}
+	delete(pm.pxys, name)
+	if rc := pxy.GetResourceController(); rc != nil {
+		if rc.QuotaManager != nil {
+			rc.QuotaManager.UnregisterProxy(name)
+		}
+		if rc.WebhookManager != nil {
+			rc.WebhookManager.Notify(webhook.EventProxyClose, newProxyContent(pxy))
+		}
+	}
+}
+
+func newProxyContent(pxy Proxy) *webhook.ProxyContent {
+	loginMsg := pxy.GetLoginMsg()
+	return &webhook.ProxyContent{
+		User:      loginMsg.User,
+		RunID:     loginMsg.RunID,
+		ProxyName: pxy.GetName(),
+		ProxyType: pxy.GetConfigurer().GetBaseConfig().Type,
+	}
+}
+
+func (pm *Manager) GetByName(name string) (pxy Proxy, ok bool) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	pxy, ok = pm.pxys[name]
+	return
+}
+
+// HandleUserTCPConnection 将用户连接与从连接池中获取的工作连接连接起来，直到任意一方关闭。
+// 流量配额已超出时，按配额的处理方式拒绝连接或对连接限速。
+func HandleUserTCPConnection(pxy Proxy, userConn net.Conn, serverCfg *v1.ServerConfig) {
+	defer userConn.Close()
+	name := pxy.GetName()
+	cfg := pxy.GetConfigurer().GetBaseConfig()
+	proxyType := cfg.Type
+
+	var quotaLimiters []*rate.Limiter
+	if rc := pxy.GetResourceController(); rc != nil && rc.QuotaManager != nil {
+		var err error
+		if quotaLimiters, err = rc.QuotaManager.CheckUserConn(name); err != nil {
+			log.Infof("[%s] reject user connection from [%s]: %v", name, userConn.RemoteAddr(), err)
+			metrics.Server.RejectConnection(name, proxyType, metrics.RejectReasonQuota)
+			return
+		}
+	}
+
+	workConn, err := pxy.GetWorkConnFromPool(userConn.RemoteAddr(), userConn.LocalAddr())
+	if err != nil {
+		return
+	}
+	defer workConn.Close()
+
+	var local io.ReadWriteCloser = workConn
+	if cfg.Transport.UseEncryption {
+		local, err = libio.WithEncryption(local, []byte(serverCfg.Auth.Token))
+		if err != nil {
+			log.Errorf("[%s] create encryption stream error: %v", name, err)
+			return
+		}
+	}
+	if cfg.Transport.UseCompression {
+		var recycleFn func()
+		local, recycleFn = libio.WithCompressionFromPool(local)
+		defer recycleFn()
+	}
+	for _, l := range append([]*rate.Limiter{pxy.GetLimiter()}, quotaLimiters...) {
+		if l == nil {
+			continue
+		}
+		rwc := local
+		local = libio.WrapReadWriteCloser(limit.NewReader(rwc, l), limit.NewWriter(rwc, l), rwc.Close)
+	}
+	// https 代理的 TLS 已在 frps 上终止时，按配置使用 TLS 重新加密发往客户端的流量
+	if tlsConn, ok := userConn.(*tls.Conn); ok {
+		if rc := pxy.GetResourceController(); rc != nil && rc.TLSTerminator != nil {
+			local = rc.TLSTerminator.WrapUpstream(netpkg.WrapReadWriteCloserToConn(local, workConn),
+				userConn, tlsConn.ConnectionState().ServerName)
+		}
+	}
+
+	log.Debugf("[%s] join connections, workConn(l[%s] r[%s]) userConn(l[%s] r[%s])", name,
+		workConn.LocalAddr(), workConn.RemoteAddr(), userConn.LocalAddr(), userConn.RemoteAddr())
+	startTime := time.Now()
+	metrics.Server.OpenConnection(name, proxyType)
+	inCount, outCount, _ := libio.Join(local, userConn)
+	metrics.Server.CloseConnection(name, proxyType)
+	metrics.Server.AddTrafficIn(name, proxyType, inCount)
+	metrics.Server.AddTrafficOut(name, proxyType, outCount)
+
+	if rc := pxy.GetResourceController(); rc != nil && rc.PluginManager != nil {
+		_ = rc.PluginManager.CloseUserConn(&plugin.CloseUserConnContent{
+			User:       pxy.GetUserInfo(),
+			ProxyName:  name,
+			ProxyType:  proxyType,
+			RemoteAddr: userConn.RemoteAddr().String(),
+			StartTime:  startTime.Unix(),
+			Duration:   time.Since(startTime).Milliseconds(),
+			BytesIn:    inCount,
+			BytesOut:   outCount,
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/msg"
+	"github.com/sunyihoo/frp/pkg/util/vhost"
+	"github.com/sunyihoo/frp/server/controller"
+	"math"
+	"strings"
+)
+
+// NewHTTPRequestLimiter 为 http 代理在 domain 上的路由创建请求限速。frps 为该域名配置的限速是上限，
+// 代理没有请求限速或请求的限速更宽松时使用 frps 的配置。两者都没有配置时返回 nil。
+func NewHTTPRequestLimiter(rc *controller.ResourceController, pxyMsg *msg.NewProxy, domain string) *vhost.RequestLimiter {
+	rps, burst := max(pxyMsg.RateLimitRPS, 0), max(pxyMsg.RateLimitBurst, 0)
+	ceiling, ok := matchVhostHTTPRateLimit(rc.VhostHTTPRateLimits, domain)
+	if ok && (rps == 0 || rps > ceiling.RequestsPerSecond) {
+		rps = ceiling.RequestsPerSecond
+	}
+	if rps == 0 {
+		return nil
+	}
+
+	// 代理只配置了速率时，突发请求数按代理的速率计算，而不是沿用 frps 的配置
+	if burst == 0 && pxyMsg.RateLimitRPS > 0 {
+		burst = int(math.Ceil(rps))
+	}
+	if ok {
+		ceilingBurst := ceiling.Burst
+		if ceilingBurst == 0 {
+			ceilingBurst = int(math.Ceil(ceiling.RequestsPerSecond))
+		}
+		if burst == 0 || burst > ceilingBurst {
+			burst = ceilingBurst
+		}
+	}
+	return vhost.NewRequestLimiter(rps, burst)
+}
+
+// matchVhostHTTPRateLimit 依次匹配完整域名、最长的通配符域名和 "*"。
+func matchVhostHTTPRateLimit(limits []v1.VhostHTTPRateLimitConfig, domain string) (v1.RequestRateLimitConfig, bool) {
+	domain = strings.ToLower(domain)
+	best, bestLen := v1.RequestRateLimitConfig{}, -1
+	for _, l := range limits {
+		pattern := strings.ToLower(l.Domain)
+		n := -1
+		switch {
+		case pattern == domain:
+			return l.RequestRateLimitConfig, true
+		case pattern == "*":
+			n = 0
+		case strings.HasPrefix(pattern, "*.") && strings.HasSuffix(domain, pattern[1:]):
+			n = len(pattern)
+		}
+		if n > bestLen {
+			best, bestLen = l.RequestRateLimitConfig, n
+		}
+	}
+	return best, bestLen >= 0
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/msg"
+	"github.com/sunyihoo/frp/server/controller"
+	"testing"
+)
+
+func rateLimit(domain string, rps float64, burst int) v1.VhostHTTPRateLimitConfig {
+	return v1.VhostHTTPRateLimitConfig{
+		Domain:                 domain,
+		RequestRateLimitConfig: v1.RequestRateLimitConfig{RequestsPerSecond: rps, Burst: burst},
+	}
+}
+
+func TestMatchVhostHTTPRateLimit(t *testing.T) {
+	limits := []v1.VhostHTTPRateLimitConfig{
+		rateLimit("*", 1, 0),
+		rateLimit("*.a.example.com", 3, 0),
+		rateLimit("*.example.com", 2, 0),
+		rateLimit("Exact.A.Example.com", 4, 0),
+	}
+	for _, tc := range []struct {
+		domain  string
+		wantRPS float64
+		wantOK  bool
+	}{
+		{"exact.a.example.com", 4, true},
+		{"EXACT.a.example.com", 4, true},
+		{"x.a.example.com", 3, true},
+		{"y.x.a.example.com", 3, true},
+		{"b.example.com", 2, true},
+		// 通配符域名不匹配自身的父域名
+		{"example.com", 1, true},
+		{"other.test", 1, true},
+	} {
+		got, ok := matchVhostHTTPRateLimit(limits, tc.domain)
+		if ok != tc.wantOK || got.RequestsPerSecond != tc.wantRPS {
+			t.Errorf("%s: got %v %v, want %v %v", tc.domain, got.RequestsPerSecond, ok, tc.wantRPS, tc.wantOK)
+		}
+	}
+
+	if _, ok := matchVhostHTTPRateLimit(limits[1:], "other.test"); ok {
+		t.Error("domain without a matching pattern should not match")
+	}
+	if _, ok := matchVhostHTTPRateLimit(nil, "a.example.com"); ok {
+		t.Error("empty limits should not match")
+	}
+}
+
+func TestNewHTTPRequestLimiter(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		ceiling   []v1.VhostHTTPRateLimitConfig
+		rps       float64
+		burst     int
+		wantNil   bool
+		wantRPS   float64
+		wantBurst int
+	}{
+		{name: "no limits", wantNil: true},
+		{name: "negative proxy limit", rps: -1, burst: -1, wantNil: true},
+		{name: "proxy only", rps: 2.5, wantRPS: 2.5, wantBurst: 3},
+		{name: "proxy only with burst", rps: 2, burst: 10, wantRPS: 2, wantBurst: 10},
+		{name: "ceiling only", ceiling: []v1.VhostHTTPRateLimitConfig{rateLimit("*", 5, 8)}, wantRPS: 5, wantBurst: 8},
+		{name: "ceiling default burst", ceiling: []v1.VhostHTTPRateLimitConfig{rateLimit("*", 4.2, 0)}, wantRPS: 4.2, wantBurst: 5},
+		{name: "proxy stricter", ceiling: []v1.VhostHTTPRateLimitConfig{rateLimit("*", 5, 8)}, rps: 2, burst: 4, wantRPS: 2, wantBurst: 4},
+		// 代理只配置速率时，突发请求数按代理的速率计算
+		{name: "proxy stricter rps only", ceiling: []v1.VhostHTTPRateLimitConfig{rateLimit("*", 5, 8)}, rps: 2, wantRPS: 2, wantBurst: 2},
+		{name: "proxy rps clamped", ceiling: []v1.VhostHTTPRateLimitConfig{rateLimit("*", 5, 8)}, rps: 10, burst: 4, wantRPS: 5, wantBurst: 4},
+		{name: "proxy burst clamped", ceiling: []v1.VhostHTTPRateLimitConfig{rateLimit("*", 5, 8)}, rps: 2, burst: 20, wantRPS: 2, wantBurst: 8},
+		{name: "both clamped", ceiling: []v1.VhostHTTPRateLimitConfig{rateLimit("*", 5, 0)}, rps: 10, burst: 20, wantRPS: 5, wantBurst: 5},
+		{name: "burst only uses ceiling rps", ceiling: []v1.VhostHTTPRateLimitConfig{rateLimit("*", 5, 8)}, burst: 3, wantRPS: 5, wantBurst: 3},
+		{name: "ceiling for other domain", ceiling: []v1.VhostHTTPRateLimitConfig{rateLimit("*.other.test", 1, 1)}, rps: 10, wantRPS: 10, wantBurst: 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rc := &controller.ResourceController{VhostHTTPRateLimits: tc.ceiling}
+			pxyMsg := &msg.NewProxy{RateLimitRPS: tc.rps, RateLimitBurst: tc.burst}
+			l := NewHTTPRequestLimiter(rc, pxyMsg, "a.example.com")
+			if tc.wantNil {
+				if l != nil {
+					t.Fatal("got a limiter, want nil")
+				}
+				return
+			}
+			if l == nil {
+				t.Fatal("got nil limiter")
+			}
+			if rps, burst := l.Limit(); rps != tc.wantRPS || burst != tc.wantBurst {
+				t.Fatalf("got rps %v burst %d, want rps %v burst %d", rps, burst, tc.wantRPS, tc.wantBurst)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"reflect"
+)
+
+func init() {
+	RegisterProxyFactory(reflect.TypeOf(&v1.STCPProxyConfig{}), NewSTCPProxy)
+}
+
+type STCPProxy struct {
+	*BaseProxy
+	cfg *v1.STCPProxyConfig
+}
+
+func NewSTCPProxy(baseProxy *BaseProxy) Proxy {
+	unwrapped, ok := baseProxy.GetConfigurer().(*v1.STCPProxyConfig)
+	if !ok {
+		return nil
+	}
+	return &STCPProxy{
+		BaseProxy: baseProxy,
+		cfg:       unwrapped,
+	}
+}
+
+func (pxy *STCPProxy) Run() (remoteAddr string, err error) {
+	allowUsers := pxy.cfg.AllowUsers
+	// allowUsers 为空时只允许与代理相同的用户访问
+	if len(allowUsers) == 0 {
+		allowUsers = []string{pxy.GetUserInfo().User}
+	}
+	listener, errRet := pxy.rc.VisitorManager.Listen(pxy.GetName(), pxy.cfg.Secretkey, allowUsers)
+	if errRet != nil {
+		err = errRet
+		return
+	}
+	pxy.listeners = append(pxy.listeners, listener)
+	log.Infof("[%s] stcp proxy custom listen success", pxy.name)
+
+	pxy.startCommonTCPListenersHandler(pxy)
+	return
+}
+
+func (pxy *STCPProxy) Close() {
+	pxy.BaseProxy.Close()
+	pxy.rc.VisitorManager.CloseListener(pxy.GetName())
+}
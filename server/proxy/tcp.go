@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"fmt"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	netpkg "github.com/sunyihoo/frp/pkg/util/net"
+	"net"
+	"reflect"
+	"strconv"
+)
+
+func init() {
+	RegisterProxyFactory(reflect.TypeOf(&v1.TCPProxyConfig{}), NewTCPProxy)
+}
+
+type TCPProxy struct {
+	*BaseProxy
+	cfg *v1.TCPProxyConfig
+
+	realBindPort int
+}
+
+func NewTCPProxy(baseProxy *BaseProxy) Proxy {
+	unwrapped, ok := baseProxy.GetConfigurer().(*v1.TCPProxyConfig)
+	if !ok {
+		return nil
+	}
+	baseProxy.usedPortsNum = 1
+	return &TCPProxy{
+		BaseProxy: baseProxy,
+		cfg:       unwrapped,
+	}
+}
+
+func (pxy *TCPProxy) Run() (remoteAddr string, err error) {
+	if pxy.cfg.LoadBalancer.Group != "" {
+		l, realBindPort, errRet := pxy.rc.TCPGroupCtl.Listen(pxy.name, pxy.cfg.LoadBalancer,
+			pxy.serverCfg.ProxyBindAddr, pxy.cfg.RemotePort, func() (net.Conn, error) {
+				return pxy.openWorkConn(nil)
+			})
+		if errRet != nil {
+			err = errRet
+			return
+		}
+		defer func() {
+			if err != nil {
+				l.Close()
+			}
+		}()
+		pxy.realBindPort = realBindPort
+		pxy.listeners = append(pxy.listeners, netpkg.NewFilterListener(l, pxy.sourceIPFilter))
+		log.Infof("[%s] tcp proxy listen port [%d] in group [%s]", pxy.name, realBindPort, pxy.cfg.LoadBalancer.Group)
+	} else {
+		pxy.realBindPort, err = pxy.rc.TCPPortManager.Acquire(pxy.name, pxy.cfg.RemotePort)
+		if err != nil {
+			return
+		}
+		defer func() {
+			if err != nil {
+				pxy.rc.TCPPortManager.Release(pxy.realBindPort)
+			}
+		}()
+		listener, errRet := net.Listen("tcp", net.JoinHostPort(pxy.serverCfg.ProxyBindAddr, strconv.Itoa(pxy.realBindPort)))
+		if errRet != nil {
+			err = errRet
+			return
+		}
+		pxy.listeners = append(pxy.listeners, netpkg.NewFilterListener(listener, pxy.sourceIPFilter))
+		log.Infof("[%s] tcp proxy listen port [%d]", pxy.name, pxy.realBindPort)
+	}
+
+	pxy.cfg.RemotePort = pxy.realBindPort
+	remoteAddr = fmt.Sprintf(":%d", pxy.realBindPort)
+	pxy.startCommonTCPListenersHandler(pxy)
+	return
+}
+
+func (pxy *TCPProxy) Close() {
+	pxy.BaseProxy.Close()
+	if pxy.cfg.LoadBalancer.Group == "" {
+		pxy.rc.TCPPortManager.Release(pxy.realBindPort)
+	}
+}
@@ -0,0 +1,402 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/util/log"
+	"golang.org/x/time/rate"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	ScopeUser  = "user"
+	ScopeProxy = "proxy"
+
+	PeriodDaily   = "daily"
+	PeriodMonthly = "monthly"
+
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+// ExceededEvent 描述一次配额超出，每个对象在每个周期内只会触发一次。
+type ExceededEvent struct {
+	Scope  string
+	Name   string
+	Period string
+	Used   int64
+	Limit  int64
+	Action string
+}
+
+// Usage 记录一个用户或代理在当前日和当前月的流量用量（入站加出站）。
+type Usage struct {
+	Day        string `json:"day"`
+	DayBytes   int64  `json:"dayBytes"`
+	Month      string `json:"month"`
+	MonthBytes int64  `json:"monthBytes"`
+
+	// 当前周期内是否已经发送过超出通知
+	DayNotified   bool `json:"dayNotified,omitempty"`
+	MonthNotified bool `json:"monthNotified,omitempty"`
+}
+
+// rotate 在跨天或跨月时清零对应的用量。
+func (u *Usage) rotate(now time.Time) {
+	day, month := now.Format(dayLayout), now.Format(monthLayout)
+	if u.Day != day {
+		u.Day = day
+		u.DayBytes = 0
+		u.DayNotified = false
+	}
+	if u.Month != month {
+		u.Month = month
+		u.MonthBytes = 0
+		u.MonthNotified = false
+	}
+}
+
+// Status 是配额状态，用于仪表板 API。
+type Status struct {
+	Scope        string `json:"scope"`
+	Name         string `json:"name"`
+	DailyUsed    int64  `json:"dailyUsed"`
+	DailyLimit   int64  `json:"dailyLimit"`
+	MonthlyUsed  int64  `json:"monthlyUsed"`
+	MonthlyLimit int64  `json:"monthlyLimit"`
+	Action       string `json:"action"`
+	Exceeded     bool   `json:"exceeded"`
+}
+
+type rule struct {
+	daily    int64
+	monthly  int64
+	action   string
+	fallback int64
+}
+
+func newRule(c v1.TrafficQuotaConfig) *rule {
+	return &rule{
+		daily:    c.Daily.Bytes(),
+		monthly:  c.Monthly.Bytes(),
+		action:   c.Action,
+		fallback: c.FallbackBandwidth.Bytes(),
+	}
+}
+
+func (r *rule) exceeded(u *Usage) bool {
+	return (r.daily > 0 && u.DayBytes >= r.daily) || (r.monthly > 0 && u.MonthBytes >= r.monthly)
+}
+
+// Manager 统计每个用户和每个代理的流量用量，并在超出配额后拒绝或限速新的用户连接。
+// 它实现了 metrics.ServerMetrics，因此与 AddTrafficIn 和 AddTrafficOut 共享同一条流量统计路径。
+type Manager struct {
+	userRules  map[string]*rule
+	proxyRules map[string]*rule
+
+	// 按 scope/name 编制索引的用量
+	usages map[string]*Usage
+	// 按代理名称编制索引的代理所属用户
+	proxyUsers map[string]string
+	// 按 scope/name 编制索引的限速器，同一用户或代理的所有连接共享同一个限速器
+	limiters map[string]*rate.Limiter
+
+	storeFile    string
+	syncInterval time.Duration
+	dirty        bool
+
+	onExceeded func(ExceededEvent)
+	nowFn      func() time.Time
+	mu         sync.Mutex
+}
+
+func NewManager(cfg v1.QuotaConfig) (*Manager, error) {
+	m := &Manager{
+		userRules:    make(map[string]*rule),
+		proxyRules:   make(map[string]*rule),
+		usages:       make(map[string]*Usage),
+		proxyUsers:   make(map[string]string),
+		limiters:     make(map[string]*rate.Limiter),
+		storeFile:    cfg.StoreFile,
+		syncInterval: time.Duration(cfg.SyncInterval) * time.Second,
+		nowFn:        time.Now,
+	}
+	for _, c := range cfg.Users {
+		m.userRules[c.User] = newRule(c.TrafficQuotaConfig)
+	}
+	for _, c := range cfg.Proxies {
+		m.proxyRules[c.Name] = newRule(c.TrafficQuotaConfig)
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SetExceededHandler 设置配额超出时的回调，例如通知插件。回调在独立的 goroutine 中执行。
+func (m *Manager) SetExceededHandler(fn func(ExceededEvent)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onExceeded = fn
+}
+
+// RegisterProxy 记录代理所属的用户，代理的流量同时计入该用户的用量。
+func (m *Manager) RegisterProxy(proxyName string, user string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.proxyUsers[proxyName] = user
+}
+
+func (m *Manager) UnregisterProxy(proxyName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.proxyUsers, proxyName)
+	delete(m.limiters, ScopeProxy+"/"+proxyName)
+}
+
+// CheckUserConn 在接受新的用户连接前调用。
+// 如果配额已超出且处理方式为 "reject"，则返回错误；
+// 如果处理方式为 "throttle"，则返回该连接应使用的限速器，代理和所属用户各自的限速器在其所有连接间共享。
+func (m *Manager) CheckUserConn(proxyName string) ([]*rate.Limiter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.nowFn()
+	var limiters []*rate.Limiter
+	check := func(scope, name string, r *rule) error {
+		if r == nil {
+			return nil
+		}
+		u := m.getUsageLocked(scope, name, now)
+		if !r.exceeded(u) {
+			return nil
+		}
+		if r.action == v1.QuotaActionReject {
+			return fmt.Errorf("traffic quota of %s [%s] exceeded", scope, name)
+		}
+		if r.fallback > 0 {
+			limiters = append(limiters, m.getLimiterLocked(scope, name, r.fallback))
+		}
+		return nil
+	}
+
+	if err := check(ScopeProxy, proxyName, m.proxyRules[proxyName]); err != nil {
+		return nil, err
+	}
+	if user, ok := m.proxyUsers[proxyName]; ok {
+		if err := check(ScopeUser, user, m.userRules[user]); err != nil {
+			return nil, err
+		}
+	}
+	return limiters, nil
+}
+
+func (m *Manager) getLimiterLocked(scope, name string, fallback int64) *rate.Limiter {
+	key := scope + "/" + name
+	l, ok := m.limiters[key]
+	if !ok {
+		l = rate.NewLimiter(rate.Limit(float64(fallback)), int(fallback))
+		m.limiters[key] = l
+	}
+	return l
+}
+
+// AddTraffic 将代理的流量计入代理及其所属用户的用量。
+func (m *Manager) AddTraffic(proxyName string, trafficBytes int64) {
+	if trafficBytes <= 0 {
+		return
+	}
+	events := make([]ExceededEvent, 0)
+
+	m.mu.Lock()
+	now := m.nowFn()
+	events = m.addLocked(ScopeProxy, proxyName, m.proxyRules[proxyName], trafficBytes, now, events)
+	if user, ok := m.proxyUsers[proxyName]; ok {
+		events = m.addLocked(ScopeUser, user, m.userRules[user], trafficBytes, now, events)
+	}
+	handler := m.onExceeded
+	m.mu.Unlock()
+
+	if handler == nil {
+		return
+	}
+	for _, ev := range events {
+		go handler(ev)
+	}
+}
+
+func (m *Manager) addLocked(scope, name string, r *rule, n int64, now time.Time, events []ExceededEvent) []ExceededEvent {
+	u := m.getUsageLocked(scope, name, now)
+	u.DayBytes += n
+	u.MonthBytes += n
+	m.dirty = true
+	if r == nil {
+		return events
+	}
+	if r.daily > 0 && u.DayBytes >= r.daily && !u.DayNotified {
+		u.DayNotified = true
+		events = append(events, ExceededEvent{Scope: scope, Name: name, Period: PeriodDaily, Used: u.DayBytes, Limit: r.daily, Action: r.action})
+	}
+	if r.monthly > 0 && u.MonthBytes >= r.monthly && !u.MonthNotified {
+		u.MonthNotified = true
+		events = append(events, ExceededEvent{Scope: scope, Name: name, Period: PeriodMonthly, Used: u.MonthBytes, Limit: r.monthly, Action: r.action})
+	}
+	return events
+}
+
+func (m *Manager) getUsageLocked(scope, name string, now time.Time) *Usage {
+	key := scope + "/" + name
+	u, ok := m.usages[key]
+	if !ok {
+		u = &Usage{}
+		m.usages[key] = u
+	}
+	u.rotate(now)
+	return u
+}
+
+// GetStatus 返回所有配置了配额的用户和代理的状态。
+func (m *Manager) GetStatus() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]Status, 0, len(m.userRules)+len(m.proxyRules))
+	for name, r := range m.userRules {
+		out = append(out, m.statusLocked(ScopeUser, name, r))
+	}
+	for name, r := range m.proxyRules {
+		out = append(out, m.statusLocked(ScopeProxy, name, r))
+	}
+	return out
+}
+
+// GetStatusByName 返回指定用户或代理的状态，即使它没有配置配额。
+func (m *Manager) GetStatusByName(scope, name string) Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var r *rule
+	if scope == ScopeUser {
+		r = m.userRules[name]
+	} else {
+		r = m.proxyRules[name]
+	}
+	return m.statusLocked(scope, name, r)
+}
+
+func (m *Manager) statusLocked(scope, name string, r *rule) Status {
+	// 查询不应为未知的名称创建用量记录
+	u, ok := m.usages[scope+"/"+name]
+	if !ok {
+		u = &Usage{}
+	}
+	u.rotate(m.nowFn())
+	s := Status{
+		Scope:       scope,
+		Name:        name,
+		DailyUsed:   u.DayBytes,
+		MonthlyUsed: u.MonthBytes,
+	}
+	if r != nil {
+		s.DailyLimit = r.daily
+		s.MonthlyLimit = r.monthly
+		s.Action = r.action
+		s.Exceeded = r.exceeded(u)
+	}
+	return s
+}
+
+// Run 定期将用量写入 StoreFile，直到 ctx 结束，结束前会再写入一次。
+func (m *Manager) Run(ctx context.Context) {
+	if m.storeFile == "" {
+		return
+	}
+	ticker := time.NewTicker(m.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.save(); err != nil {
+				log.Warnf("save quota usage to [%s] error: %v", m.storeFile, err)
+			}
+		case <-ctx.Done():
+			if err := m.save(); err != nil {
+				log.Warnf("save quota usage to [%s] error: %v", m.storeFile, err)
+			}
+			return
+		}
+	}
+}
+
+func (m *Manager) load() error {
+	if m.storeFile == "" {
+		return nil
+	}
+	buf, err := os.ReadFile(m.storeFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	usages := make(map[string]*Usage)
+	if err := json.Unmarshal(buf, &usages); err != nil {
+		return fmt.Errorf("parse quota store file [%s] error: %v", m.storeFile, err)
+	}
+	m.usages = usages
+	return nil
+}
+
+func (m *Manager) save() error {
+	m.mu.Lock()
+	if !m.dirty {
+		m.mu.Unlock()
+		return nil
+	}
+	buf, err := json.Marshal(m.usages)
+	m.dirty = false
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// 先写入临时文件再重命名，避免进程退出时留下不完整的文件
+	tmp, err := os.CreateTemp(filepath.Dir(m.storeFile), ".quota-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), m.storeFile)
+}
+
+// 以下方法实现 metrics.ServerMetrics，只有流量相关的方法会更新用量。
+
+func (m *Manager) NewClient()                                  {}
+func (m *Manager) CloseClient()                                {}
+func (m *Manager) NewProxy(string, string)                     {}
+func (m *Manager) CloseProxy(string, string)                   {}
+func (m *Manager) OpenConnection(string, string)               {}
+func (m *Manager) CloseConnection(string, string)              {}
+func (m *Manager) RejectConnection(string, string, string)     {}
+func (m *Manager) AddCountryConnection(string, string, string) {}
+
+func (m *Manager) AddTrafficIn(name string, _ string, trafficBytes int64) {
+	m.AddTraffic(name, trafficBytes)
+}
+
+func (m *Manager) AddTrafficOut(name string, _ string, trafficBytes int64) {
+	m.AddTraffic(name, trafficBytes)
+}
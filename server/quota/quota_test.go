@@ -0,0 +1,243 @@
+package quota
+
+import (
+	"github.com/sunyihoo/frp/pkg/config/types"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustTraffic(t *testing.T, s string) types.TrafficQuantity {
+	t.Helper()
+	q, err := types.NewTrafficQuantity(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func mustBandwidth(t *testing.T, s string) types.BandwidthWithQuantity {
+	t.Helper()
+	q, err := types.NewBandwidthQuantity(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestThrottleLimiterShared(t *testing.T) {
+	m, err := NewManager(v1.QuotaConfig{
+		Users: []v1.UserQuotaConfig{{
+			User: "alice",
+			TrafficQuotaConfig: v1.TrafficQuotaConfig{
+				Daily:             mustTraffic(t, "1KB"),
+				Action:            v1.QuotaActionThrottle,
+				FallbackBandwidth: mustBandwidth(t, "10KB"),
+			},
+		}},
+		Proxies: []v1.ProxyQuotaConfig{{
+			Name: "alice.a",
+			TrafficQuotaConfig: v1.TrafficQuotaConfig{
+				Daily:             mustTraffic(t, "1KB"),
+				Action:            v1.QuotaActionThrottle,
+				FallbackBandwidth: mustBandwidth(t, "5KB"),
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.RegisterProxy("alice.a", "alice")
+	m.RegisterProxy("alice.b", "alice")
+	m.AddTraffic("alice.a", 2048)
+
+	a1, err := m.CheckUserConn("alice.a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, _ := m.CheckUserConn("alice.a")
+	b, _ := m.CheckUserConn("alice.b")
+	// alice.a 同时受代理和用户的限速，alice.b 只受用户的限速
+	if len(a1) != 2 || len(a2) != 2 || len(b) != 1 {
+		t.Fatalf("got %d, %d and %d limiters, want 2, 2 and 1", len(a1), len(a2), len(b))
+	}
+	if a1[0] != a2[0] || a1[1] != a2[1] {
+		t.Error("connections of the same proxy should share limiters")
+	}
+	if a1[1] != b[0] {
+		t.Error("proxies of the same user should share the user limiter")
+	}
+	if a1[0].Burst() != 5*1024 || b[0].Burst() != 10*1024 {
+		t.Errorf("got bursts %d and %d, want %d and %d", a1[0].Burst(), b[0].Burst(), 5*1024, 10*1024)
+	}
+}
+
+// collectEvents 收集异步投递的超出通知，等待 want 个事件后再多等一小段时间以发现多余的事件。
+func collectEvents(ch chan ExceededEvent, want int) []string {
+	got := make([]string, 0)
+	timeout := time.Second
+	for i := 0; ; i++ {
+		if i >= want {
+			timeout = 20 * time.Millisecond
+		}
+		select {
+		case ev := <-ch:
+			got = append(got, ev.Scope+"/"+ev.Period)
+		case <-time.After(timeout):
+			sort.Strings(got)
+			return got
+		}
+	}
+}
+
+func TestUsageRotateAndNotify(t *testing.T) {
+	m, err := NewManager(v1.QuotaConfig{
+		Users: []v1.UserQuotaConfig{{
+			User: "alice",
+			TrafficQuotaConfig: v1.TrafficQuotaConfig{
+				Daily:   mustTraffic(t, "1KB"),
+				Monthly: mustTraffic(t, "3KB"),
+				Action:  v1.QuotaActionReject,
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var now time.Time
+	m.nowFn = func() time.Time { return now }
+	eventCh := make(chan ExceededEvent, 10)
+	m.SetExceededHandler(func(ev ExceededEvent) { eventCh <- ev })
+	m.RegisterProxy("alice.tcp", "alice")
+
+	jan30 := time.Date(2024, 1, 30, 23, 0, 0, 0, time.Local)
+	jan31 := time.Date(2024, 1, 31, 0, 30, 0, 0, time.Local)
+	feb1 := time.Date(2024, 2, 1, 0, 30, 0, 0, time.Local)
+	for _, tc := range []struct {
+		name       string
+		now        time.Time
+		add        int64
+		wantDay    int64
+		wantMonth  int64
+		wantEvents []string
+		wantReject bool
+	}{
+		{"within quota", jan30, 512, 512, 512, nil, false},
+		{"daily exceeded", jan30, 1024, 1536, 1536, []string{"user/daily"}, true},
+		{"notified once per day", jan30, 1024, 2560, 2560, nil, true},
+		{"next day", jan31, 256, 256, 2816, nil, false},
+		{"daily and monthly exceeded", jan31, 1024, 1280, 3840, []string{"user/daily", "user/monthly"}, true},
+		{"notified once per month", jan31, 100, 1380, 3940, nil, true},
+		{"next month", feb1, 100, 100, 100, nil, false},
+	} {
+		now = tc.now
+		m.AddTraffic("alice.tcp", tc.add)
+		if got := collectEvents(eventCh, len(tc.wantEvents)); strings.Join(got, ",") != strings.Join(tc.wantEvents, ",") {
+			t.Errorf("%s: got events %v, want %v", tc.name, got, tc.wantEvents)
+		}
+		s := m.GetStatusByName(ScopeUser, "alice")
+		if s.DailyUsed != tc.wantDay || s.MonthlyUsed != tc.wantMonth {
+			t.Errorf("%s: got usage %d/%d, want %d/%d", tc.name, s.DailyUsed, s.MonthlyUsed, tc.wantDay, tc.wantMonth)
+		}
+		if _, err := m.CheckUserConn("alice.tcp"); (err != nil) != tc.wantReject {
+			t.Errorf("%s: got reject error %v, want reject %v", tc.name, err, tc.wantReject)
+		}
+	}
+}
+
+func TestCheckUserConnAction(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		userAction   string
+		proxyAction  string
+		add          int64
+		wantErr      bool
+		wantLimiters int
+	}{
+		{"within quota", v1.QuotaActionReject, v1.QuotaActionReject, 512, false, 0},
+		{"user reject", v1.QuotaActionReject, v1.QuotaActionThrottle, 1024, true, 0},
+		{"proxy reject", v1.QuotaActionThrottle, v1.QuotaActionReject, 1024, true, 0},
+		{"both throttle", v1.QuotaActionThrottle, v1.QuotaActionThrottle, 1024, false, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			quota := func(action string) v1.TrafficQuotaConfig {
+				return v1.TrafficQuotaConfig{
+					Daily:             mustTraffic(t, "1KB"),
+					Action:            action,
+					FallbackBandwidth: mustBandwidth(t, "1KB"),
+				}
+			}
+			m, err := NewManager(v1.QuotaConfig{
+				Users:   []v1.UserQuotaConfig{{User: "alice", TrafficQuotaConfig: quota(tc.userAction)}},
+				Proxies: []v1.ProxyQuotaConfig{{Name: "alice.tcp", TrafficQuotaConfig: quota(tc.proxyAction)}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.RegisterProxy("alice.tcp", "alice")
+			m.AddTraffic("alice.tcp", tc.add)
+
+			limiters, err := m.CheckUserConn("alice.tcp")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tc.wantErr)
+			}
+			if len(limiters) != tc.wantLimiters {
+				t.Fatalf("got %d limiters, want %d", len(limiters), tc.wantLimiters)
+			}
+		})
+	}
+}
+
+func TestUsageStoreRoundTrip(t *testing.T) {
+	cfg := v1.QuotaConfig{
+		StoreFile:    filepath.Join(t.TempDir(), "quota.json"),
+		SyncInterval: 60,
+		Users: []v1.UserQuotaConfig{{
+			User:               "alice",
+			TrafficQuotaConfig: v1.TrafficQuotaConfig{Daily: mustTraffic(t, "1KB"), Action: v1.QuotaActionReject},
+		}},
+	}
+	now := time.Date(2024, 1, 30, 12, 0, 0, 0, time.Local)
+	nowFn := func() time.Time { return now }
+
+	m, err := NewManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.nowFn = nowFn
+	eventCh := make(chan ExceededEvent, 10)
+	m.SetExceededHandler(func(ev ExceededEvent) { eventCh <- ev })
+	m.RegisterProxy("alice.tcp", "alice")
+	m.AddTraffic("alice.tcp", 2048)
+	if got := collectEvents(eventCh, 1); len(got) != 1 {
+		t.Fatalf("got events %v, want one daily event", got)
+	}
+	if err := m.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后恢复用量，且同一周期内不会再次通知
+	m2, err := NewManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2.nowFn = nowFn
+	m2.SetExceededHandler(func(ev ExceededEvent) { eventCh <- ev })
+	m2.RegisterProxy("alice.tcp", "alice")
+	for _, scope := range []string{ScopeUser, ScopeProxy} {
+		name := map[string]string{ScopeUser: "alice", ScopeProxy: "alice.tcp"}[scope]
+		if got, want := m2.GetStatusByName(scope, name), m.GetStatusByName(scope, name); got != want {
+			t.Errorf("%s: got status %+v after reload, want %+v", scope, got, want)
+		}
+	}
+	if _, err := m2.CheckUserConn("alice.tcp"); err == nil {
+		t.Error("reloaded usage should still exceed the quota")
+	}
+	m2.AddTraffic("alice.tcp", 100)
+	if got := collectEvents(eventCh, 0); len(got) != 0 {
+		t.Errorf("got events %v after reload, want none", got)
+	}
+}
@@ -3,23 +3,48 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"github.com/fatedier/golib/net/mux"
 	quic "github.com/quic-go/quic-go"
+	"github.com/samber/lo"
 	"github.com/sunyihoo/frp/pkg/auth"
 	"github.com/sunyihoo/frp/pkg/auth/legacy"
 	v1 "github.com/sunyihoo/frp/pkg/config/v1"
 	modelmetrics "github.com/sunyihoo/frp/pkg/metrics"
+	"github.com/sunyihoo/frp/pkg/msg"
 	plugin "github.com/sunyihoo/frp/pkg/plugin/server"
 	"github.com/sunyihoo/frp/pkg/ssh"
 	"github.com/sunyihoo/frp/pkg/transport"
+	"github.com/sunyihoo/frp/pkg/util/accesslog"
+	"github.com/sunyihoo/frp/pkg/util/geoip"
 	httppkg "github.com/sunyihoo/frp/pkg/util/http"
+	"github.com/sunyihoo/frp/pkg/util/log"
 	netpkg "github.com/sunyihoo/frp/pkg/util/net"
+	"github.com/sunyihoo/frp/pkg/util/tcpmux"
+	"github.com/sunyihoo/frp/pkg/util/util"
+	"github.com/sunyihoo/frp/pkg/util/version"
 	"github.com/sunyihoo/frp/pkg/util/vhost"
+	"github.com/sunyihoo/frp/pkg/webhook"
 	"github.com/sunyihoo/frp/server/controller"
+	"github.com/sunyihoo/frp/server/group"
+	"github.com/sunyihoo/frp/server/metrics"
 	"github.com/sunyihoo/frp/server/ports"
 	"github.com/sunyihoo/frp/server/proxy"
+	"github.com/sunyihoo/frp/server/quota"
 	"github.com/sunyihoo/frp/server/visitor"
 	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// 读取新连接的第一条消息的超时时间
+	connReadTimeout = 10 * time.Second
+	// 终止 TLS 时与用户完成握手的超时时间
+	vhostTLSHandshakeTimeout = 10 * time.Second
+	// 从新连接中读取路由信息的超时时间
+	vhostReadWriteTimeout = 30 * time.Second
 )
 
 type Service struct {
@@ -56,6 +81,15 @@ type Service struct {
 	// HTTP 虚拟主机路由器
 	httpVhostRouter *vhost.Routers
 
+	// 按用户和按代理的流量配额
+	quotaManager *quota.Manager
+
+	// 事件 webhook
+	webhookManager *webhook.Manager
+
+	// 主备模式负载均衡组的状态和切换事件
+	failoverManager *group.FailoverManager
+
 	// 所有资源管理器和控制器
 	rc *controller.ResourceController
 
@@ -110,6 +144,8 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 			VisitorManager: visitor.NewManager(),
 			TCPPortManager: ports.NewManager("tcp", cfg.ProxyBindAddr, cfg.AllowPorts),
 			UDPPortManager: ports.NewManager("udp", cfg.ProxyBindAddr, cfg.AllowPorts),
+
+			VhostHTTPRateLimits: cfg.VhostHTTPRateLimits,
 		},
 		sshTunnelListener: netpkg.NewInternalListener(),
 		httpVhostRouter:   vhost.NewRouters(),
@@ -119,9 +155,459 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 		cfg:               cfg,
 		ctx:               context.Background(),
 	}
+
+	for _, p := range cfg.HTTPPlugins {
+		svr.pluginManager.Register(plugin.NewHTTPPluginOptions(p))
+		log.Infof("plugin [%s] has been registered", p.Name)
+	}
+	for _, p := range cfg.GRPCPlugins {
+		grpcPlugin, err := plugin.NewGRPCPluginOptions(p)
+		if err != nil {
+			return nil, err
+		}
+		svr.pluginManager.Register(grpcPlugin)
+		log.Infof("grpc plugin [%s] has been registered", p.Name)
+	}
+	for _, p := range cfg.WASMPlugins {
+		wasmPlugin, err := plugin.NewWASMPluginOptions(svr.ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		svr.pluginManager.Register(wasmPlugin)
+		log.Infof("wasm plugin [%s] has been registered", p.Name)
+	}
+	for _, p := range cfg.RulePlugins {
+		rulePlugin, err := plugin.NewRulePluginOptions(p)
+		if err != nil {
+			return nil, err
+		}
+		svr.pluginManager.Register(rulePlugin)
+		log.Infof("rule plugin [%s] has been registered", p.Name)
+	}
+	svr.rc.PluginManager = svr.pluginManager
+
+	// 创建按 SNI 和 SOCKS5 多路复用的 tcpmux 侦听器
+	if cfg.TCPMuxSNIPort > 0 {
+		address := net.JoinHostPort(cfg.ProxyBindAddr, strconv.Itoa(cfg.TCPMuxSNIPort))
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, fmt.Errorf("create server listener for tcpmux sni error, %v", err)
+		}
+		svr.rc.TCPMuxSNIController, err = tcpmux.NewSNITCPMuxer(l, vhostReadWriteTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("create vhost tcpMuxer for sni error, %v", err)
+		}
+		log.Infof("tcpmux sni multiplexer listen on %s", address)
+	}
+	if cfg.TCPMuxSOCKS5Port > 0 {
+		address := net.JoinHostPort(cfg.ProxyBindAddr, strconv.Itoa(cfg.TCPMuxSOCKS5Port))
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, fmt.Errorf("create server listener for tcpmux socks5 error, %v", err)
+		}
+		svr.rc.TCPMuxSOCKS5Controller, err = tcpmux.NewSOCKS5TCPMuxer(l, vhostReadWriteTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("create vhost tcpMuxer for socks5 error, %v", err)
+		}
+		log.Infof("tcpmux socks5 multiplexer listen on %s", address)
+	}
+
+	// 初始化组控制器
+	svr.rc.TCPGroupCtl = group.NewTCPGroupCtl(svr.rc.TCPPortManager)
+	svr.rc.TCPMuxGroupCtl = group.NewTCPMuxGroupCtl(svr.rc.TCPMuxController,
+		svr.rc.TCPMuxSNIController, svr.rc.TCPMuxSOCKS5Controller)
+	svr.rc.UDPGroupCtl = group.NewUDPGroupCtl(svr.rc.UDPPortManager)
+	svr.failoverManager = group.NewFailoverManager()
+	svr.rc.TCPGroupCtl.SetFailoverManager(svr.failoverManager)
+	svr.rc.TCPMuxGroupCtl.SetFailoverManager(svr.failoverManager)
+
+	// 初始化 HTTP 组控制器
+	svr.rc.HTTPGroupCtl = group.NewHTTPGroupController(svr.httpVhostRouter)
+	svr.rc.HTTPGroupCtl.SetFailoverManager(svr.failoverManager)
+
+	if cfg.VhostHTTPSPort > 0 && (cfg.VhostHTTPSTermination.CertDir != "" || cfg.ACME.Enable) {
+		c := cfg.VhostHTTPSTermination
+		var certStore *vhost.CertStore
+		if c.CertDir != "" {
+			certStore, err = vhost.NewCertStore(c.CertDir)
+			if err != nil {
+				return nil, fmt.Errorf("load https certificates error: %v", err)
+			}
+			if c.ReloadInterval > 0 {
+				go certStore.Run(svr.ctx, time.Duration(c.ReloadInterval)*time.Second)
+			}
+			log.Infof("https termination enabled, domains: %v", certStore.Domains())
+		}
+
+		var acmeManager *vhost.ACMEManager
+		if cfg.ACME.Enable {
+			acmeManager, err = vhost.NewACMEManager(vhost.ACMEOptions{
+				Email:           cfg.ACME.Email,
+				DirectoryURL:    cfg.ACME.DirectoryURL,
+				DirectoryCAFile: cfg.ACME.DirectoryCAFile,
+				CacheDir:        cfg.ACME.CacheDir,
+				Domains:         cfg.ACME.Domains,
+				RenewBefore:     time.Duration(cfg.ACME.RenewBeforeDays) * 24 * time.Hour,
+				Routed:          svr.isVhostDomainRouted,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("create acme manager error: %v", err)
+			}
+			svr.rc.ACMEManager = acmeManager
+			log.Infof("acme enabled, directory [%s], domains: %v", cfg.ACME.DirectoryURL, cfg.ACME.Domains)
+		}
+
+		svr.rc.TLSTerminator = vhost.NewTLSTerminator(certStore, acmeManager,
+			c.UpstreamProtocol == v1.HTTPSUpstreamProtocolHTTPS, c.UpstreamTLSVerify, vhostTLSHandshakeTimeout)
+	}
+
+	vhost.NotFoundPagePath = cfg.Custom404Page
+
+	svr.rc.VhostHTTPErrorPages = vhost.NewErrorPageRegistry()
+	for name, t := range cfg.VhostHTTPErrorPages.Templates {
+		pages, err := vhost.NewErrorPages(map[int]string{
+			http.StatusNotFound:           t.NotFound,
+			http.StatusBadGateway:         t.BadGateway,
+			http.StatusServiceUnavailable: t.ServiceUnavailable,
+			http.StatusGatewayTimeout:     t.GatewayTimeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("load vhost http error pages [%s] error: %v", name, err)
+		}
+		svr.rc.VhostHTTPErrorPages.Add(name, pages)
+	}
+	if cfg.VhostHTTPErrorPages.Default != "" {
+		if err := svr.rc.VhostHTTPErrorPages.SetDefault(cfg.VhostHTTPErrorPages.Default); err != nil {
+			return nil, err
+		}
+	}
+	for _, d := range cfg.VhostHTTPErrorPages.Domains {
+		if err := svr.rc.VhostHTTPErrorPages.SetDomain(d.Domain, d.Templates); err != nil {
+			return nil, err
+		}
+	}
+
+	svr.rc.HTPasswdFiles = make(map[string]*netpkg.Htpasswd, len(cfg.HTPasswd.Files))
+	for name, path := range cfg.HTPasswd.Files {
+		h, err := netpkg.LoadHtpasswd(path)
+		if err != nil {
+			return nil, fmt.Errorf("load htpasswd file [%s] error: %v", name, err)
+		}
+		svr.rc.HTPasswdFiles[name] = h.SetAuthFailDelay(time.Duration(cfg.HTPasswd.AuthFailDelay) * time.Millisecond)
+	}
+
+	if c := cfg.VhostHTTPOIDC; c.Issuer != "" {
+		svr.rc.VhostHTTPOIDCProvider, err = vhost.NewOIDCProvider(vhost.OIDCProviderOptions{
+			Issuer:       c.Issuer,
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			Scopes:       c.Scopes,
+			GroupsClaim:  c.GroupsClaim,
+			CookieSecret: []byte(c.CookieSecret),
+			SessionTTL:   time.Duration(c.SessionTTL) * time.Second,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create vhost http oidc provider error: %v", err)
+		}
+		log.Infof("vhost http oidc enabled, issuer [%s]", c.Issuer)
+	}
+
+	svr.rc.ProxySourceIPACL, err = netpkg.NewIPACL(cfg.ProxySourceIPACL.Allow, cfg.ProxySourceIPACL.Deny, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create proxy source ip acl error: %v", err)
+	}
+	if cfg.GeoIP.DBFile != "" {
+		svr.rc.GeoIPDatabase, err = geoip.NewDatabase(cfg.GeoIP.DBFile, time.Duration(cfg.GeoIP.ReloadInterval)*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("load geoip database error: %v", err)
+		}
+		svr.rc.ProxyCountryACL = geoip.NewCountryACL(cfg.GeoIP.ProxyCountryACL.Allow, cfg.GeoIP.ProxyCountryACL.Deny, nil)
+		go svr.rc.GeoIPDatabase.Run(svr.ctx)
+		log.Infof("geoip enabled, database [%s]", cfg.GeoIP.DBFile)
+	}
+
+	// 创建访问日志，未配置时为 nil
+	httpAccessLogger, err := accesslog.New(cfg.AccessLog.HTTP)
+	if err != nil {
+		return nil, fmt.Errorf("create http access logger error: %v", err)
+	}
+	httpsAccessLogger, err := accesslog.New(cfg.AccessLog.HTTPS)
+	if err != nil {
+		return nil, fmt.Errorf("create https access logger error: %v", err)
+	}
+	tcpMuxAccessLogger, err := accesslog.New(cfg.AccessLog.TCPMux)
+	if err != nil {
+		return nil, fmt.Errorf("create tcpmux access logger error: %v", err)
+	}
+	if svr.rc.GeoIPDatabase != nil {
+		for _, l := range []*accesslog.Logger{httpAccessLogger, httpsAccessLogger, tcpMuxAccessLogger} {
+			l.SetCountryFunc(svr.rc.GeoIPDatabase.Country)
+		}
+	}
+	if svr.rc.TCPMuxSNIController != nil {
+		svr.rc.TCPMuxSNIController.SetAccessLogger(tcpMuxAccessLogger)
+	}
+	if svr.rc.TCPMuxSOCKS5Controller != nil {
+		svr.rc.TCPMuxSOCKS5Controller.SetAccessLogger(tcpMuxAccessLogger)
+	}
+
+	// 创建 http vhost 反向代理
+	if cfg.VhostHTTPPort > 0 {
+		rp := vhost.NewHTTPReverseProxy(vhost.HTTPReverseProxyOptions{
+			ResponseHeaderTimeoutS: cfg.VhostHTTPTimeout,
+			EnableHTTP2:            cfg.EnableVhostHTTP2,
+		}, svr.httpVhostRouter)
+		rp.SetAccessLogger(httpAccessLogger)
+		rp.SetErrorPages(svr.rc.VhostHTTPErrorPages)
+		svr.rc.HTTPReverseProxy = rp
+
+		var handler http.Handler = rp
+		if svr.rc.ACMEManager != nil {
+			// HTTP-01 验证通过 vhostHTTPPort 完成
+			handler = svr.rc.ACMEManager.HTTPHandler(rp)
+		}
+		address := net.JoinHostPort(cfg.ProxyBindAddr, strconv.Itoa(cfg.VhostHTTPPort))
+		server := &http.Server{
+			Addr:              address,
+			Handler:           handler,
+			ReadHeaderTimeout: 60 * time.Second,
+		}
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, fmt.Errorf("create vhost http listener error, %v", err)
+		}
+		go func() {
+			_ = server.Serve(l)
+		}()
+		log.Infof("http service listen on %s", address)
+	}
+
+	// 创建 https vhost muxer
+	if cfg.VhostHTTPSPort > 0 {
+		address := net.JoinHostPort(cfg.ProxyBindAddr, strconv.Itoa(cfg.VhostHTTPSPort))
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, fmt.Errorf("create server listener error, %v", err)
+		}
+		svr.rc.VhostHTTPSMuxer, err = vhost.NewHTTPSMuxer(l, vhostReadWriteTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("create vhost httpsMuxer error, %v", err)
+		}
+		svr.rc.VhostHTTPSMuxer.SetAccessLogger(httpsAccessLogger)
+		if svr.rc.TLSTerminator != nil {
+			svr.rc.VhostHTTPSMuxer.SetTLSTerminator(svr.rc.TLSTerminator)
+		}
+		log.Infof("https service listen on %s", address)
+	}
+
+	webhookManager, err := webhook.NewManager(cfg.Webhooks)
+	if err != nil {
+		return nil, err
+	}
+	svr.webhookManager = webhookManager
+	svr.rc.WebhookManager = webhookManager
+	webhookManager.Run(svr.ctx)
+
+	quotaManager, err := quota.NewManager(cfg.Quota)
+	if err != nil {
+		return nil, fmt.Errorf("create quota manager error: %v", err)
+	}
+	quotaManager.SetExceededHandler(func(ev quota.ExceededEvent) {
+		log.Warnf("traffic quota of %s [%s] exceeded: %s used %d, limit %d, action %s",
+			ev.Scope, ev.Name, ev.Period, ev.Used, ev.Limit, ev.Action)
+		_ = svr.pluginManager.QuotaExceeded(&plugin.QuotaExceededContent{
+			Scope:  ev.Scope,
+			Name:   ev.Name,
+			Period: ev.Period,
+			Used:   ev.Used,
+			Limit:  ev.Limit,
+			Action: ev.Action,
+		})
+		svr.webhookManager.Notify(webhook.EventQuotaExceeded, &plugin.QuotaExceededContent{
+			Scope:  ev.Scope,
+			Name:   ev.Name,
+			Period: ev.Period,
+			Used:   ev.Used,
+			Limit:  ev.Limit,
+			Action: ev.Action,
+		})
+	})
+	svr.failoverManager.SetEventHandler(func(ev group.FailoverEvent) {
+		content := &plugin.GroupFailoverContent{
+			Type:      ev.Type,
+			Group:     ev.Group,
+			From:      ev.From,
+			To:        ev.To,
+			Reason:    ev.Reason,
+			Timestamp: ev.Time.Unix(),
+		}
+		_ = svr.pluginManager.GroupFailover(content)
+		svr.webhookManager.Notify(webhook.EventGroupFailover, content)
+	})
+
+	modelmetrics.AddServerMetrics(quotaManager)
+	svr.quotaManager = quotaManager
+	svr.rc.QuotaManager = quotaManager
+	go quotaManager.Run(svr.ctx)
+
 	if webServer != nil {
-		webServer.
+		webServer.RouteRegister(svr.registerRouteHandlers)
+	}
+
+	return svr, nil
+}
+
+// HandleListener 接受来自客户端的连接，直到 l 关闭。internal 表示连接来自 frps 内部，例如 ssh 隧道网关。
+func (svr *Service) HandleListener(l net.Listener, internal bool) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			log.Warnf("listener for incoming connections from client closed")
+			return
+		}
+		go svr.handleConnection(c, internal)
+	}
+}
+
+func (svr *Service) handleConnection(conn net.Conn, internal bool) {
+	_ = conn.SetReadDeadline(time.Now().Add(connReadTimeout))
+	rawMsg, err := msg.ReadMsg(conn)
+	if err != nil {
+		log.Tracef("failed to read message: %v", err)
+		conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	switch m := rawMsg.(type) {
+	case *msg.Login:
+		// 登录失败时在这里返回错误，成功的响应由控制器发送
+		if err := svr.RegisterControl(conn, m, internal); err != nil {
+			log.Warnf("register control error: %v", err)
+			_ = msg.WriteMsg(conn, &msg.LoginResp{
+				Version: version.Full(),
+				Error:   util.GenerateResponseErrorString("register control error", err, lo.FromPtr(svr.cfg.DetailedErrorsToClient)),
+			})
+			conn.Close()
+		}
+	case *msg.NewWorkConn:
+		if err := svr.RegisterWorkConn(conn, m); err != nil {
+			conn.Close()
+		}
+	case *msg.NewVisitorConn:
+		if err := svr.RegisterVisitorConn(conn, m); err != nil {
+			log.Warnf("register visitor conn error: %v", err)
+			_ = msg.WriteMsg(conn, &msg.NewVisitorConnResp{
+				ProxyName: m.ProxyName,
+				Error:     util.GenerateResponseErrorString("register visitor conn error", err, lo.FromPtr(svr.cfg.DetailedErrorsToClient)),
+			})
+			conn.Close()
+		} else {
+			_ = msg.WriteMsg(conn, &msg.NewVisitorConnResp{
+				ProxyName: m.ProxyName,
+			})
+		}
+	default:
+		log.Warnf("error message type for the new connection [%s]", conn.RemoteAddr())
+		conn.Close()
+	}
+}
+
+// RegisterControl 验证客户端的登录消息并为其创建控制器，具有相同运行 ID 的旧控制器会被替换。
+func (svr *Service) RegisterControl(ctlConn net.Conn, loginMsg *msg.Login, internal bool) error {
+	// 运行 ID 为空的是新的客户端，为其生成运行 ID
+	var err error
+	if loginMsg.RunID == "" {
+		loginMsg.RunID, err = util.RandID()
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil, nil
+	log.Infof("client login info: ip [%s] version [%s] hostname [%s] os [%s] arch [%s] run id [%s]",
+		ctlConn.RemoteAddr(), loginMsg.Version, loginMsg.Hostname, loginMsg.Os, loginMsg.Arch, loginMsg.RunID)
+
+	if !(internal && loginMsg.ClientSpec.AlwaysAuthPass) {
+		if err := svr.authVerifier.VerifyLogin(loginMsg); err != nil {
+			svr.webhookManager.Notify(webhook.EventAuthFailure, &webhook.AuthFailureContent{
+				User:       loginMsg.User,
+				RunID:      loginMsg.RunID,
+				RemoteAddr: ctlConn.RemoteAddr().String(),
+				Reason:     err.Error(),
+			})
+			return err
+		}
+	}
+
+	ctl := NewControl(svr.ctx, svr.rc, svr.pxyManager, svr.pluginManager, svr.authVerifier, ctlConn, loginMsg, svr.cfg)
+	if oldCtl := svr.ctlManager.Add(loginMsg.RunID, ctl); oldCtl != nil {
+		oldCtl.WaitClosed()
+	}
+
+	ctl.Start()
+	metrics.Server.NewClient()
+	svr.webhookManager.Notify(webhook.EventClientLogin, newClientContent(loginMsg, ctlConn))
+
+	go func() {
+		// 阻塞直到控制器关闭
+		ctl.WaitClosed()
+		svr.ctlManager.Del(loginMsg.RunID, ctl)
+	}()
+	return nil
+}
+
+// RegisterWorkConn 将客户端创建的工作连接放入对应控制器的连接池。
+func (svr *Service) RegisterWorkConn(workConn net.Conn, newMsg *msg.NewWorkConn) error {
+	ctl, exist := svr.ctlManager.GetByID(newMsg.RunID)
+	if !exist {
+		log.Warnf("no client control found for run id [%s]", newMsg.RunID)
+		return fmt.Errorf("no client control found for run id [%s]", newMsg.RunID)
+	}
+
+	if err := svr.authVerifier.VerifyNewWorkConn(newMsg); err != nil {
+		log.Warnf("invalid NewWorkConn with run id [%s]", newMsg.RunID)
+		svr.webhookManager.Notify(webhook.EventAuthFailure, &webhook.AuthFailureContent{
+			User:       ctl.loginMsg.User,
+			RunID:      newMsg.RunID,
+			RemoteAddr: workConn.RemoteAddr().String(),
+			Reason:     err.Error(),
+		})
+		_ = msg.WriteMsg(workConn, &msg.StartWorkConn{
+			Error: util.GenerateResponseErrorString("invalid NewWorkConn", err, lo.FromPtr(svr.cfg.DetailedErrorsToClient)),
+		})
+		return fmt.Errorf("invalid NewWorkConn with run id [%s]", newMsg.RunID)
+	}
+	return ctl.RegisterWorkConn(workConn)
+}
+
+// RegisterVisitorConn 将访问者的连接交给对应的 stcp 代理，连接先由插件审核。
+func (svr *Service) RegisterVisitorConn(visitorConn net.Conn, newMsg *msg.NewVisitorConn) error {
+	// 旧版本的访问者不携带运行 ID，此时访问者的用户为空
+	var userInfo plugin.UserInfo
+	if newMsg.RunID != "" {
+		ctl, exist := svr.ctlManager.GetByID(newMsg.RunID)
+		if !exist {
+			return fmt.Errorf("no client control found for run id [%s]", newMsg.RunID)
+		}
+		userInfo = ctl.userInfo()
+	}
+
+	retContent, err := svr.pluginManager.NewVisitorConn(&plugin.NewVisitorConnContent{
+		User:           userInfo,
+		NewVisitorConn: *newMsg,
+	})
+	if err != nil {
+		return err
+	}
+	newMsg = &retContent.NewVisitorConn
+	return svr.rc.VisitorManager.NewConn(newMsg.ProxyName, visitorConn, newMsg.Timestamp, newMsg.SignKey,
+		newMsg.UseEncryption, newMsg.UseCompression, userInfo.User)
+}
+
+// isVhostDomainRouted 返回是否有 http 或 https 代理使用该域名。
+func (svr *Service) isVhostDomainRouted(domain string) bool {
+	if svr.httpVhostRouter.Exist(domain) {
+		return true
+	}
+	return svr.rc.VhostHTTPSMuxer != nil && svr.rc.VhostHTTPSMuxer.Exist(domain)
 }
@@ -0,0 +1,1102 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/sunyihoo/frp/pkg/config/types"
+	v1 "github.com/sunyihoo/frp/pkg/config/v1"
+	"github.com/sunyihoo/frp/pkg/msg"
+	plugin "github.com/sunyihoo/frp/pkg/plugin/server"
+	httppkg "github.com/sunyihoo/frp/pkg/util/http"
+	netpkg "github.com/sunyihoo/frp/pkg/util/net"
+	"github.com/sunyihoo/frp/pkg/util/util"
+	"github.com/sunyihoo/frp/pkg/webhook"
+	"github.com/sunyihoo/frp/server/quota"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testToken = "test-token"
+
+// newTestService 创建 frps 并在随机端口上接受客户端连接，返回客户端连接的地址。
+func newTestService(t *testing.T, cfg *v1.ServerConfig) (*Service, string) {
+	t.Helper()
+	cfg.Auth.Token = testToken
+	cfg.ProxyBindAddr = "127.0.0.1"
+	cfg.Complete()
+	svr, err := NewService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go svr.HandleListener(l, false)
+	return svr, l.Addr().String()
+}
+
+// freePort 返回一个当前未被占用的 TCP 端口。
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// testClient 模拟 frpc：登录后为每个 ReqWorkConn 创建一个工作连接，收到 StartWorkConn 后交给 handler 处理。
+type testClient struct {
+	t       *testing.T
+	addr    string
+	conn    net.Conn
+	runID   string
+	handler func(net.Conn)
+
+	respCh    chan *msg.NewProxyResp
+	natHoleCh chan *msg.NatHoleResp
+}
+
+func newTestClient(t *testing.T, addr string, user string, handler func(net.Conn)) *testClient {
+	t.Helper()
+	c, resp, err := dialTestClient(addr, user, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != "" {
+		t.Fatalf("login error: %s", resp.Error)
+	}
+	c.t = t
+	c.handler = handler
+	t.Cleanup(func() { c.conn.Close() })
+	go c.run()
+	return c
+}
+
+func dialTestClient(addr string, user string, token string) (*testClient, *msg.LoginResp, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	ts := time.Now().Unix()
+	if err := msg.WriteMsg(conn, &msg.Login{
+		Version:      "test",
+		User:         user,
+		PrivilegeKey: util.GetAuthKey(token, ts),
+		Timestamp:    ts,
+	}); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	var resp msg.LoginResp
+	if err := msg.ReadMsgInto(conn, &resp); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return &testClient{
+		addr:      addr,
+		conn:      conn,
+		runID:     resp.RunID,
+		respCh:    make(chan *msg.NewProxyResp, 1),
+		natHoleCh: make(chan *msg.NatHoleResp, 1),
+	}, &resp, nil
+}
+
+func (c *testClient) run() {
+	for {
+		m, err := msg.ReadMsg(c.conn)
+		if err != nil {
+			return
+		}
+		switch m := m.(type) {
+		case *msg.ReqWorkConn:
+			go c.newWorkConn()
+		case *msg.NewProxyResp:
+			c.respCh <- m
+		case *msg.NatHoleResp:
+			c.natHoleCh <- m
+		}
+	}
+}
+
+func (c *testClient) newWorkConn() {
+	conn, err := net.Dial("tcp", c.addr)
+	if err != nil {
+		return
+	}
+	ts := time.Now().Unix()
+	if err := msg.WriteMsg(conn, &msg.NewWorkConn{
+		RunID:        c.runID,
+		PrivilegeKey: util.GetAuthKey(testToken, ts),
+		Timestamp:    ts,
+	}); err != nil {
+		conn.Close()
+		return
+	}
+	var start msg.StartWorkConn
+	if err := msg.ReadMsgInto(conn, &start); err != nil {
+		conn.Close()
+		return
+	}
+	c.handler(conn)
+}
+
+func (c *testClient) newProxy(m *msg.NewProxy) *msg.NewProxyResp {
+	c.t.Helper()
+	if err := msg.WriteMsg(c.conn, m); err != nil {
+		c.t.Fatal(err)
+	}
+	select {
+	case resp := <-c.respCh:
+		return resp
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("timeout waiting for NewProxyResp of [%s]", m.ProxyName)
+		return nil
+	}
+}
+
+// echoHandler 将工作连接上收到的数据原样写回。
+func echoHandler(conn net.Conn) {
+	defer conn.Close()
+	_, _ = io.Copy(conn, conn)
+}
+
+// httpHandler 在工作连接上运行 HTTP 服务。
+func httpHandler(h http.Handler) func(net.Conn) {
+	l := netpkg.NewInternalListener()
+	go func() {
+		_ = http.Serve(l, h)
+	}()
+	return func(conn net.Conn) {
+		_ = l.PutConn(conn)
+	}
+}
+
+func remotePort(t *testing.T, resp *msg.NewProxyResp) int {
+	t.Helper()
+	if resp.Error != "" {
+		t.Fatalf("new proxy [%s] error: %s", resp.ProxyName, resp.Error)
+	}
+	port, err := strconv.Atoi(strings.TrimPrefix(resp.RemoteAddr, ":"))
+	if err != nil {
+		t.Fatalf("invalid remote addr [%s]", resp.RemoteAddr)
+	}
+	return port
+}
+
+func TestQuotaRejectOverQuotaProxy(t *testing.T) {
+	daily, err := types.NewTrafficQuantity("1KB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vhostHTTPPort := freePort(t)
+	svr, addr := newTestService(t, &v1.ServerConfig{
+		VhostHTTPPort: vhostHTTPPort,
+		Quota: v1.QuotaConfig{
+			Users: []v1.UserQuotaConfig{{
+				User:               "quota-alice",
+				TrafficQuotaConfig: v1.TrafficQuotaConfig{Daily: daily},
+			}},
+		},
+	})
+
+	tcpClient := newTestClient(t, addr, "quota-alice", echoHandler)
+	port := remotePort(t, tcpClient.newProxy(&msg.NewProxy{ProxyName: "quota-alice.tcp", ProxyType: "tcp"}))
+
+	// 配额内的连接正常转发，流量在连接关闭后计入用户的用量
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("x"), 2048)
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("echo before quota exceeded: %v", err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !svr.quotaManager.GetStatusByName(quota.ScopeUser, "quota-alice").Exceeded {
+		if time.Now().After(deadline) {
+			t.Fatal("traffic through the tcp proxy was not counted in the user quota")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 超出配额后新的用户连接被直接关闭
+	conn, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _ = conn.Write([]byte("hello"))
+	if n, err := conn.Read(buf); err == nil {
+		t.Fatalf("over quota tcp connection got %q, want it closed", buf[:n])
+	}
+
+	// 同一用户的 http 代理也被拒绝
+	webClient := newTestClient(t, addr, "quota-alice", httpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})))
+	resp := webClient.newProxy(&msg.NewProxy{
+		ProxyName:     "quota-alice.web",
+		ProxyType:     "http",
+		CustomDomains: []string{"quota.example.com"},
+	})
+	if resp.Error != "" {
+		t.Fatalf("new http proxy error: %s", resp.Error)
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/", vhostHTTPPort), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "quota.example.com"
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("over quota http request got status %d, want %d", res.StatusCode, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHTTPProxyWithinQuota(t *testing.T) {
+	vhostHTTPPort := freePort(t)
+	svr, addr := newTestService(t, &v1.ServerConfig{VhostHTTPPort: vhostHTTPPort})
+
+	c := newTestClient(t, addr, "quota-bob", httpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello from backend")
+	})))
+	resp := c.newProxy(&msg.NewProxy{
+		ProxyName:     "quota-bob.web",
+		ProxyType:     "http",
+		CustomDomains: []string{"bob.example.com"},
+	})
+	if resp.Error != "" {
+		t.Fatalf("new http proxy error: %s", resp.Error)
+	}
+	if _, ok := svr.pxyManager.GetByName("quota-bob.web"); !ok {
+		t.Fatal("http proxy is not added to the proxy manager")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/", vhostHTTPPort), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "bob.example.com"
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(body) != "hello from backend" {
+		t.Fatalf("got %d %q", res.StatusCode, body)
+	}
+}
+
+// writeTestCert 在 dir 中生成域名为 domain 的自签名证书，文件名符合证书库的约定。
+func writeTestCert(t *testing.T, dir, domain string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(filepath.Join(dir, domain+".crt"), certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, domain+".key"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestHTTPSProxyReencrypt(t *testing.T) {
+	const domain = "secure.example.com"
+	for _, upstream := range []string{v1.HTTPSUpstreamProtocolHTTP, v1.HTTPSUpstreamProtocolHTTPS} {
+		t.Run(upstream, func(t *testing.T) {
+			dir := t.TempDir()
+			cert := writeTestCert(t, dir, domain)
+			vhostHTTPSPort := freePort(t)
+			_, addr := newTestService(t, &v1.ServerConfig{
+				VhostHTTPSPort: vhostHTTPSPort,
+				VhostHTTPSTermination: v1.HTTPSTerminationConfig{
+					CertDir:          dir,
+					ReloadInterval:   -1,
+					UpstreamProtocol: upstream,
+				},
+			})
+
+			// 后端按 upstreamProtocol 在工作连接上提供 https 或 http 服务，并返回它看到的 SNI
+			serve := httpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.TLS == nil {
+					fmt.Fprint(w, "plain")
+					return
+				}
+				fmt.Fprintf(w, "tls %s", r.TLS.ServerName)
+			}))
+			handler := serve
+			if upstream == v1.HTTPSUpstreamProtocolHTTPS {
+				handler = func(conn net.Conn) {
+					serve(tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}}))
+				}
+			}
+			c := newTestClient(t, addr, "tls-user", handler)
+			resp := c.newProxy(&msg.NewProxy{
+				ProxyName:     "tls-user.web",
+				ProxyType:     "https",
+				CustomDomains: []string{domain},
+			})
+			if resp.Error != "" {
+				t.Fatalf("new https proxy error: %s", resp.Error)
+			}
+
+			client := &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, net.JoinHostPort("127.0.0.1", strconv.Itoa(vhostHTTPSPort)))
+				},
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			}}
+			res, err := client.Get("https://" + domain + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			want := "plain"
+			if upstream == v1.HTTPSUpstreamProtocolHTTPS {
+				want = "tls " + domain
+			}
+			if string(body) != want {
+				t.Fatalf("backend got %q, want %q", body, want)
+			}
+		})
+	}
+}
+
+func TestWebhookClientEvents(t *testing.T) {
+	eventCh := make(chan webhook.Event, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev webhook.Event
+		if err := json.NewDecoder(r.Body).Decode(&ev); err == nil {
+			eventCh <- ev
+		}
+	}))
+	defer srv.Close()
+
+	cfg := &v1.ServerConfig{
+		Webhooks: []v1.WebhookConfig{{
+			Name:     "test",
+			URL:      srv.URL,
+			Events:   []string{webhook.EventClientLogin, webhook.EventClientLogout, webhook.EventAuthFailure},
+			QueueDir: t.TempDir(),
+		}},
+	}
+	cfg.Auth.AdditionalScopes = []v1.AuthScope{v1.AuthScopeHeartBeats, v1.AuthScopeNewWorkConns}
+	_, addr := newTestService(t, cfg)
+
+	expectEvent := func(eventType string, user string) map[string]interface{} {
+		t.Helper()
+		select {
+		case ev := <-eventCh:
+			content := make(map[string]interface{})
+			_ = json.Unmarshal(ev.Content, &content)
+			if ev.Type != eventType || content["user"] != user {
+				t.Fatalf("got event %s of user %v, want %s of user %s", ev.Type, content["user"], eventType, user)
+			}
+			return content
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for event %s", eventType)
+			return nil
+		}
+	}
+
+	// 登录失败
+	_, resp, err := dialTestClient(addr, "hook-user", "wrong-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error == "" {
+		t.Fatal("login with wrong token should fail")
+	}
+	if content := expectEvent(webhook.EventAuthFailure, "hook-user"); content["reason"] == "" {
+		t.Fatal("auth failure event should contain the reason")
+	}
+
+	// 登录成功
+	c := newTestClient(t, addr, "hook-user", echoHandler)
+	if content := expectEvent(webhook.EventClientLogin, "hook-user"); content["run_id"] != c.runID {
+		t.Fatalf("got run id %v, want %s", content["run_id"], c.runID)
+	}
+
+	// 工作连接校验失败
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := msg.WriteMsg(conn, &msg.NewWorkConn{RunID: c.runID, PrivilegeKey: "invalid", Timestamp: time.Now().Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	var start msg.StartWorkConn
+	if err := msg.ReadMsgInto(conn, &start); err != nil || start.Error == "" {
+		t.Fatalf("invalid NewWorkConn should be rejected, got %+v, %v", start, err)
+	}
+	expectEvent(webhook.EventAuthFailure, "hook-user")
+
+	// 心跳校验失败
+	if err := msg.WriteMsg(c.conn, &msg.Ping{PrivilegeKey: "invalid", Timestamp: time.Now().Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(webhook.EventAuthFailure, "hook-user")
+
+	// 客户端断开
+	c.conn.Close()
+	expectEvent(webhook.EventClientLogout, "hook-user")
+}
+
+// dialVisitor 模拟 stcp 访问者连接到 frps，返回连接和 frps 的响应。
+func dialVisitor(t *testing.T, addr string, runID string, proxyName string, sk string) (net.Conn, *msg.NewVisitorConnResp) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	ts := time.Now().Unix()
+	if err := msg.WriteMsg(conn, &msg.NewVisitorConn{
+		RunID:     runID,
+		ProxyName: proxyName,
+		SignKey:   util.GetAuthKey(sk, ts),
+		Timestamp: ts,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var resp msg.NewVisitorConnResp
+	if err := msg.ReadMsgInto(conn, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return conn, &resp
+}
+
+func TestPluginVisitorOps(t *testing.T) {
+	closeClientCh := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Op      string `json:"op"`
+			Content struct {
+				User      plugin.UserInfo `json:"user"`
+				ProxyName string          `json:"proxy_name"`
+			} `json:"content"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		res := plugin.Response{Unchange: true}
+		switch req.Op {
+		case plugin.OpNewVisitorConn:
+			if req.Content.User.User == "mallory" {
+				res = plugin.Response{Reject: true, RejectReason: "visitor mallory is banned"}
+			}
+		case plugin.OpNatHoleVisitor:
+			if req.Content.ProxyName == "owner.denied" {
+				res = plugin.Response{Reject: true, RejectReason: "xtcp to owner.denied is banned"}
+			}
+		case plugin.OpCloseClient:
+			closeClientCh <- req.Content.User.User
+		}
+		_ = json.NewEncoder(w).Encode(&res)
+	}))
+	defer srv.Close()
+
+	_, addr := newTestService(t, &v1.ServerConfig{
+		HTTPPlugins: []v1.HTTPPluginOptions{{
+			Name: "audit",
+			Addr: srv.Listener.Addr().String(),
+			Path: "/handler",
+			Ops:  []string{plugin.OpNewVisitorConn, plugin.OpNatHoleVisitor, plugin.OpCloseClient},
+		}},
+	})
+
+	owner := newTestClient(t, addr, "owner", echoHandler)
+	resp := owner.newProxy(&msg.NewProxy{
+		ProxyName:  "owner.secret",
+		ProxyType:  "stcp",
+		Sk:         "abc",
+		AllowUsers: []string{"*"},
+	})
+	if resp.Error != "" {
+		t.Fatalf("new stcp proxy error: %s", resp.Error)
+	}
+
+	// 插件放行的访问者可以连接到 stcp 代理
+	guest := newTestClient(t, addr, "guest", echoHandler)
+	conn, visitorResp := dialVisitor(t, addr, guest.runID, "owner.secret", "abc")
+	if visitorResp.Error != "" {
+		t.Fatalf("visitor of guest got error: %s", visitorResp.Error)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("visitor echo got %q, %v", buf, err)
+	}
+
+	// 签名错误和插件拒绝的访问者都会收到错误
+	if _, visitorResp := dialVisitor(t, addr, guest.runID, "owner.secret", "wrong"); visitorResp.Error == "" {
+		t.Fatal("visitor with wrong secret key should be rejected")
+	}
+	mallory := newTestClient(t, addr, "mallory", echoHandler)
+	_, visitorResp = dialVisitor(t, addr, mallory.runID, "owner.secret", "abc")
+	if !strings.Contains(visitorResp.Error, "visitor mallory is banned") {
+		t.Fatalf("got visitor error %q, want the plugin reject reason", visitorResp.Error)
+	}
+
+	// NAT 打洞请求先由插件审核
+	natHole := func(proxyName string) string {
+		t.Helper()
+		if err := msg.WriteMsg(guest.conn, &msg.NatHoleVisitor{TransactionID: proxyName, ProxyName: proxyName}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case resp := <-guest.natHoleCh:
+			if resp.TransactionID != proxyName {
+				t.Fatalf("got transaction id %q, want %q", resp.TransactionID, proxyName)
+			}
+			return resp.Error
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for NatHoleResp")
+			return ""
+		}
+	}
+	if errMsg := natHole("owner.denied"); !strings.Contains(errMsg, "xtcp to owner.denied is banned") {
+		t.Fatalf("got nat hole error %q, want the plugin reject reason", errMsg)
+	}
+	if errMsg := natHole("owner.secret"); strings.Contains(errMsg, "banned") {
+		t.Fatalf("nat hole allowed by plugin got reject reason %q", errMsg)
+	}
+
+	// 客户端断开时通知插件
+	owner.conn.Close()
+	select {
+	case user := <-closeClientCh:
+		if user != "owner" {
+			t.Fatalf("got CloseClient of user %q, want owner", user)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for CloseClient")
+	}
+}
+
+func TestHTTPProxyGRPC(t *testing.T) {
+	vhostHTTPPort := freePort(t)
+	_, addr := newTestService(t, &v1.ServerConfig{VhostHTTPPort: vhostHTTPPort, EnableVhostHTTP2: true})
+
+	// 后端在工作连接上直接提供 gRPC 服务，即 h2c
+	l := netpkg.NewInternalListener()
+	s := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("frp", grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(s, healthServer)
+	go func() {
+		_ = s.Serve(l)
+	}()
+	defer s.Stop()
+
+	c := newTestClient(t, addr, "grpc-user", func(conn net.Conn) { _ = l.PutConn(conn) })
+	resp := c.newProxy(&msg.NewProxy{
+		ProxyName:     "grpc-user.api",
+		ProxyType:     "http",
+		CustomDomains: []string{"grpc.example.com"},
+		BackendHTTP2:  true,
+	})
+	if resp.Error != "" {
+		t.Fatalf("new http proxy error: %s", resp.Error)
+	}
+
+	conn, err := grpc.NewClient(net.JoinHostPort("127.0.0.1", strconv.Itoa(vhostHTTPPort)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithAuthority("grpc.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := grpc_health_v1.NewHealthClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	checkResp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "frp"})
+	if err != nil || checkResp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("unary call got %v, %v", checkResp, err)
+	}
+	if _, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("unary call of unknown service got %v, want NotFound", err)
+	}
+
+	stream, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{Service: "frp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	watchResp, err := stream.Recv()
+	if err != nil || watchResp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("stream got %v, %v", watchResp, err)
+	}
+	healthServer.SetServingStatus("frp", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	watchResp, err = stream.Recv()
+	if err != nil || watchResp.Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("stream got %v, %v after status changed", watchResp, err)
+	}
+}
+
+func TestProxySourceIPACL(t *testing.T) {
+	vhostHTTPPort := freePort(t)
+	_, addr := newTestService(t, &v1.ServerConfig{
+		VhostHTTPPort:    vhostHTTPPort,
+		ProxySourceIPACL: v1.IPACLConfig{Deny: []string{"127.0.0.2"}},
+	})
+	c := newTestClient(t, addr, "acl-alice", echoHandler)
+
+	// 客户端的允许列表无法放宽 frps 的拒绝列表
+	port := remotePort(t, c.newProxy(&msg.NewProxy{
+		ProxyName:      "acl-alice.tcp",
+		ProxyType:      "tcp",
+		AllowSourceIPs: []string{"127.0.0.0/8"},
+		DenySourceIPs:  []string{"127.0.0.3"},
+	}))
+	for _, tc := range []struct {
+		source string
+		want   bool
+	}{
+		{"127.0.0.1", true},
+		{"127.0.0.2", false},
+		{"127.0.0.3", false},
+	} {
+		dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(tc.source)}}
+		conn, err := dialer.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			t.Skipf("dial from %s: %v", tc.source, err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, _ = conn.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		conn.Close()
+		if got := err == nil; got != tc.want {
+			t.Errorf("tcp from %s: got allowed %v (err %v), want %v", tc.source, got, err, tc.want)
+		}
+	}
+
+	// http 代理对被拒绝的来源返回 403
+	webClient := newTestClient(t, addr, "acl-alice", httpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})))
+	resp := webClient.newProxy(&msg.NewProxy{
+		ProxyName:     "acl-alice.web",
+		ProxyType:     "http",
+		CustomDomains: []string{"acl.example.com"},
+		DenySourceIPs: []string{"127.0.0.1"},
+	})
+	if resp.Error != "" {
+		t.Fatalf("new http proxy error: %s", resp.Error)
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/", vhostHTTPPort), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "acl.example.com"
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Fatalf("denied http request got status %d, want %d", res.StatusCode, http.StatusForbidden)
+	}
+
+	// 无效的来源列表使代理创建失败
+	resp = c.newProxy(&msg.NewProxy{
+		ProxyName:      "acl-alice.invalid",
+		ProxyType:      "tcp",
+		AllowSourceIPs: []string{"not-an-ip"},
+	})
+	if resp.Error == "" {
+		t.Fatal("proxy with invalid source ip list should be rejected")
+	}
+}
+
+func TestHTTPProxyRateLimit(t *testing.T) {
+	vhostHTTPPort := freePort(t)
+	_, addr := newTestService(t, &v1.ServerConfig{
+		VhostHTTPPort: vhostHTTPPort,
+		VhostHTTPRateLimits: []v1.VhostHTTPRateLimitConfig{{
+			Domain:                 "*.example.com",
+			RequestRateLimitConfig: v1.RequestRateLimitConfig{RequestsPerSecond: 0.5, Burst: 2},
+		}},
+	})
+	c := newTestClient(t, addr, "limit-alice", httpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})))
+	// 代理请求的突发请求数超过 frps 的上限，按 frps 的配置限速
+	resp := c.newProxy(&msg.NewProxy{
+		ProxyName:      "limit-alice.web",
+		ProxyType:      "http",
+		CustomDomains:  []string{"limit.example.com"},
+		Locations:      "/a,/b",
+		RateLimitBurst: 10,
+	})
+	if resp.Error != "" {
+		t.Fatalf("new http proxy error: %s", resp.Error)
+	}
+
+	// 同一域名的各个 location 共用请求限速
+	for i, tc := range []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/a", http.StatusOK},
+		{"/b", http.StatusOK},
+		{"/a", http.StatusTooManyRequests},
+		{"/b", http.StatusTooManyRequests},
+	} {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d%s", vhostHTTPPort, tc.path), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = "limit.example.com"
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.wantStatus {
+			t.Fatalf("request %d to %s got status %d, want %d", i, tc.path, res.StatusCode, tc.wantStatus)
+		}
+		if tc.wantStatus == http.StatusTooManyRequests && res.Header.Get("Retry-After") == "" {
+			t.Fatalf("request %d got 429 without Retry-After", i)
+		}
+	}
+}
+
+func TestHTTPProxyOIDC(t *testing.T) {
+	var issuer string
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 issuer,
+			"authorization_endpoint": issuer + "/authorize",
+			"token_endpoint":         issuer + "/token",
+			"jwks_uri":               issuer + "/jwks",
+		})
+	}))
+	defer idp.Close()
+	issuer = idp.URL
+
+	vhostHTTPPort := freePort(t)
+	_, addr := newTestService(t, &v1.ServerConfig{
+		VhostHTTPPort: vhostHTTPPort,
+		VhostHTTPOIDC: v1.VhostHTTPOIDCConfig{Issuer: issuer, ClientID: "frps"},
+	})
+	c := newTestClient(t, addr, "oidc-alice", httpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})))
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(host string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/", vhostHTTPPort), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = host
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	// 启用 OIDC 的代理将未登录的请求跳转到身份提供方
+	resp := c.newProxy(&msg.NewProxy{
+		ProxyName:     "oidc-alice.web",
+		ProxyType:     "http",
+		CustomDomains: []string{"oidc.example.com"},
+		OIDC:          &msg.HTTPOIDC{},
+	})
+	if resp.Error != "" {
+		t.Fatalf("new http proxy error: %s", resp.Error)
+	}
+	res := get("oidc.example.com")
+	if res.StatusCode != http.StatusFound || !strings.HasPrefix(res.Header.Get("Location"), issuer+"/authorize?") {
+		t.Fatalf("got %d to %q, want redirect to the identity provider", res.StatusCode, res.Header.Get("Location"))
+	}
+
+	// 未启用 OIDC 的代理不受影响
+	resp = c.newProxy(&msg.NewProxy{
+		ProxyName:     "oidc-alice.public",
+		ProxyType:     "http",
+		CustomDomains: []string{"public.example.com"},
+	})
+	if resp.Error != "" {
+		t.Fatalf("new http proxy error: %s", resp.Error)
+	}
+	if res := get("public.example.com"); res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d for proxy without oidc, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	// frps 没有配置身份提供方时拒绝启用 OIDC 的代理
+	_, addr = newTestService(t, &v1.ServerConfig{VhostHTTPPort: freePort(t)})
+	c = newTestClient(t, addr, "oidc-bob", echoHandler)
+	resp = c.newProxy(&msg.NewProxy{
+		ProxyName:     "oidc-bob.web",
+		ProxyType:     "http",
+		CustomDomains: []string{"bob.example.com"},
+		OIDC:          &msg.HTTPOIDC{},
+	})
+	if resp.Error == "" {
+		t.Fatal("oidc proxy should be rejected when vhostHTTPOIDC is not configured")
+	}
+}
+
+func TestHTTPProxyHTPasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.htpasswd")
+	if err := os.WriteFile(path, []byte("alice:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	vhostHTTPPort := freePort(t)
+	_, addr := newTestService(t, &v1.ServerConfig{
+		VhostHTTPPort: vhostHTTPPort,
+		HTPasswd:      v1.HTPasswdConfig{Files: map[string]string{"team": path}, AuthFailDelay: 1},
+	})
+	c := newTestClient(t, addr, "htpasswd-alice", httpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("X-Forwarded-User"))
+	})))
+	resp := c.newProxy(&msg.NewProxy{
+		ProxyName:     "htpasswd-alice.web",
+		ProxyType:     "http",
+		CustomDomains: []string{"htpasswd.example.com"},
+		HTPasswd:      "team",
+	})
+	if resp.Error != "" {
+		t.Fatalf("new http proxy error: %s", resp.Error)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		user       string
+		passwd     string
+		wantStatus int
+		wantBody   string
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized, ""},
+		{"wrong password", "alice", "wrong", http.StatusUnauthorized, ""},
+		{"unknown user", "bob", "secret", http.StatusUnauthorized, ""},
+		{"valid", "alice", "secret", http.StatusOK, "alice"},
+	} {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/", vhostHTTPPort), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = "htpasswd.example.com"
+		if tc.user != "" {
+			req.SetBasicAuth(tc.user, tc.passwd)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tc.wantStatus {
+			t.Fatalf("%s: got status %d, want %d", tc.name, res.StatusCode, tc.wantStatus)
+		}
+		if tc.wantStatus == http.StatusOK && string(body) != tc.wantBody {
+			t.Fatalf("%s: backend got user %q, want %q", tc.name, body, tc.wantBody)
+		}
+	}
+
+	// 引用 frps 中不存在的 htpasswd 时拒绝代理
+	resp = c.newProxy(&msg.NewProxy{
+		ProxyName:     "htpasswd-alice.unknown",
+		ProxyType:     "http",
+		CustomDomains: []string{"unknown.example.com"},
+		HTPasswd:      "missing",
+	})
+	if resp.Error == "" {
+		t.Fatal("proxy referencing an unknown htpasswd should be rejected")
+	}
+}
+
+func TestHTTPProxyErrorPagesAndMaintenance(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"proxy-502.html": "proxy {{.StatusCode}} {{.ProxyName}}",
+		"maint-503.html": "maintenance {{.Host}}",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vhostHTTPPort := freePort(t)
+	svr, addr := newTestService(t, &v1.ServerConfig{
+		VhostHTTPPort: vhostHTTPPort,
+		VhostHTTPErrorPages: v1.VhostHTTPErrorPagesConfig{
+			Templates: map[string]v1.ErrorPageTemplatesConfig{
+				"proxy": {BadGateway: filepath.Join(dir, "proxy-502.html")},
+				"maint": {ServiceUnavailable: filepath.Join(dir, "maint-503.html")},
+			},
+		},
+	})
+	router := mux.NewRouter()
+	svr.registerRouteHandlers(&httppkg.RouterRegisterHelper{
+		Router:         router,
+		AuthMiddleware: func(next http.Handler) http.Handler { return next },
+	})
+	api := httptest.NewServer(router)
+	defer api.Close()
+
+	// 工作连接立即关闭，请求返回 502
+	c := newTestClient(t, addr, "pages-alice", func(conn net.Conn) { conn.Close() })
+	resp := c.newProxy(&msg.NewProxy{
+		ProxyName:     "pages-alice.web",
+		ProxyType:     "http",
+		CustomDomains: []string{"pages.example.com"},
+		ErrorPages:    "proxy",
+	})
+	if resp.Error != "" {
+		t.Fatalf("new http proxy error: %s", resp.Error)
+	}
+	get := func() (int, string) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/", vhostHTTPPort), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = "pages.example.com"
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return res.StatusCode, string(body)
+	}
+	if status, body := get(); status != http.StatusBadGateway || body != "proxy 502 pages-alice.web" {
+		t.Fatalf("got %d %q, want the proxy 502 page", status, body)
+	}
+
+	callAPI := func(method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, api.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return res.StatusCode, string(buf)
+	}
+	for _, tc := range []struct {
+		method, path, body string
+		wantCode           int
+	}{
+		{http.MethodPut, "/api/maintenance/pages.example.com", `{"errorPages":"missing"}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/maintenance/pages.example.com", `{"errorPages":`, http.StatusBadRequest},
+		{http.MethodPut, "/api/maintenance/pages.example.com", `{"errorPages":"maint"}`, http.StatusOK},
+	} {
+		if code, body := callAPI(tc.method, tc.path, tc.body); code != tc.wantCode {
+			t.Fatalf("%s %s %s: got %d %q, want %d", tc.method, tc.path, tc.body, code, body, tc.wantCode)
+		}
+	}
+	if code, body := callAPI(http.MethodGet, "/api/maintenance", ""); code != http.StatusOK ||
+		body != `{"domains":[{"domain":"pages.example.com","errorPages":"maint"}]}` {
+		t.Fatalf("got %d %q for maintenance list", code, body)
+	}
+	if status, body := get(); status != http.StatusServiceUnavailable || body != "maintenance pages.example.com" {
+		t.Fatalf("got %d %q in maintenance mode, want the maintenance page", status, body)
+	}
+
+	if code, _ := callAPI(http.MethodDelete, "/api/maintenance/pages.example.com", ""); code != http.StatusOK {
+		t.Fatalf("clear maintenance got %d", code)
+	}
+	if code, _ := callAPI(http.MethodDelete, "/api/maintenance/pages.example.com", ""); code != http.StatusNotFound {
+		t.Fatalf("clear maintenance twice got %d, want %d", code, http.StatusNotFound)
+	}
+	if status, _ := get(); status != http.StatusBadGateway {
+		t.Fatalf("got %d after leaving maintenance mode, want %d", status, http.StatusBadGateway)
+	}
+
+	// 引用 frps 中不存在的错误页面时拒绝代理
+	resp = c.newProxy(&msg.NewProxy{
+		ProxyName:     "pages-alice.unknown",
+		ProxyType:     "http",
+		CustomDomains: []string{"unknown.example.com"},
+		ErrorPages:    "missing",
+	})
+	if resp.Error == "" {
+		t.Fatal("proxy referencing unknown error pages should be rejected")
+	}
+}
@@ -1,7 +1,13 @@
 package visitor
 
 import (
+	"fmt"
+	libio "github.com/fatedier/golib/io"
 	netpkg "github.com/sunyihoo/frp/pkg/util/net"
+	"github.com/sunyihoo/frp/pkg/util/util"
+	"io"
+	"net"
+	"slices"
 	"sync"
 )
 
@@ -11,6 +17,7 @@ type listenerBundle struct {
 	allowUsers []string
 }
 
+// Manager 管理 stcp 代理的内部侦听器，访问者的连接通过校验后放入对应代理的侦听器。
 type Manager struct {
 	listeners map[string]*listenerBundle
 
@@ -22,3 +29,57 @@ func NewManager() *Manager {
 		listeners: make(map[string]*listenerBundle),
 	}
 }
+
+func (vm *Manager) Listen(name string, sk string, allowUsers []string) (*netpkg.InternalListener, error) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	if _, ok := vm.listeners[name]; ok {
+		return nil, fmt.Errorf("custom listener for [%s] is repeated", name)
+	}
+
+	l := netpkg.NewInternalListener()
+	vm.listeners[name] = &listenerBundle{
+		l:          l,
+		sk:         sk,
+		allowUsers: allowUsers,
+	}
+	return l, nil
+}
+
+// NewConn 校验访问者的签名和用户，通过后将连接放入代理的侦听器。
+func (vm *Manager) NewConn(name string, conn net.Conn, timestamp int64, signKey string,
+	useEncryption bool, useCompression bool, visitorUser string,
+) (err error) {
+	vm.mu.RLock()
+	defer vm.mu.RUnlock()
+
+	l, ok := vm.listeners[name]
+	if !ok {
+		return fmt.Errorf("custom listener for [%s] doesn't exist", name)
+	}
+	if !util.ConstantTimeEqString(util.GetAuthKey(l.sk, timestamp), signKey) {
+		return fmt.Errorf("visitor connection of [%s] auth failed", name)
+	}
+	if !slices.Contains(l.allowUsers, visitorUser) && !slices.Contains(l.allowUsers, "*") {
+		return fmt.Errorf("visitor connection of [%s] user [%s] not allowed", name, visitorUser)
+	}
+
+	var rwc io.ReadWriteCloser = conn
+	if useEncryption {
+		if rwc, err = libio.WithEncryption(rwc, []byte(l.sk)); err != nil {
+			return fmt.Errorf("create encryption connection failed: %v", err)
+		}
+	}
+	if useCompression {
+		rwc = libio.WithCompression(rwc)
+	}
+	return l.l.PutConn(netpkg.WrapReadWriteCloserToConn(rwc, conn))
+}
+
+func (vm *Manager) CloseListener(name string) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	delete(vm.listeners, name)
+}